@@ -0,0 +1,228 @@
+// Package approval keeps the assistant from sending emails and changing calendars on its own. Task runs ask the server
+// to confirm every tool call, and the server holds the calls of the send-email, schedule and add-online-meeting
+// tools: it stores their action as pending, shows it in the task and waits for the user's decision. It runs the action
+// itself after the user approves it, as it is or edited, and drops it when they reject it. The tool never runs in a
+// task.
+package approval
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The tools that need approval, as named in copilot.gpt.
+const (
+	ToolSendEmail        = "send-email"
+	ToolSchedule         = "schedule"
+	ToolAddOnlineMeeting = "add-online-meeting"
+)
+
+// tools are the tools that need approval by the gem-copilot command they run.
+var tools = map[string]string{
+	"send-email":   ToolSendEmail,
+	"schedule":     ToolSchedule,
+	"update-event": ToolAddOnlineMeeting,
+}
+
+// TimeZone is the time zone of the events the schedule tool creates.
+const TimeZone = "Pacific Standard Time"
+
+// The states of a pending action, stored as pending_actions.status.
+const (
+	StatusPending   = "pending"
+	StatusApproved  = "approved"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusRejected  = "rejected"
+)
+
+// The environment TrackDraft reads the context of the task run from.
+const (
+	envUserID       = "APPROVAL_USER_ID"
+	envTaskID       = "APPROVAL_TASK_ID"
+	envConnectionID = "APPROVAL_CONNECTION_ID"
+)
+
+// Email is the email send-email wants to send, the arguments of its pending action.
+type Email struct {
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+	To      []string `json:"to"`
+	Cc      []string `json:"cc"`
+	Bcc     []string `json:"bcc"`
+}
+
+// Event is the event schedule wants to create, the arguments of its pending action. Attendees include the organizer.
+type Event struct {
+	Subject   string   `json:"subject"`
+	Content   string   `json:"content"`
+	Start     string   `json:"start"`
+	End       string   `json:"end"`
+	TimeZone  string   `json:"timeZone"`
+	Attendees []string `json:"attendees"`
+}
+
+// Meeting is the event add-online-meeting wants to turn into an online meeting, the arguments of its pending action.
+type Meeting struct {
+	EventID string `json:"eventId"`
+}
+
+// Action is what a tool call that needs approval would send or create.
+type Action struct {
+	Tool string
+	// Arguments is an Email, an Event or a Meeting.
+	Arguments any
+	// Summary describes the action in the notification of the user.
+	Summary string
+}
+
+// Env is the environment the tools of a task need to track drafts, for the user running the task with the mailbox
+// connection.
+func Env(userID, taskID, connectionID pgtype.UUID) []string {
+	return []string{
+		envUserID + "=" + uuid.UUID(userID.Bytes).String(),
+		envTaskID + "=" + uuid.UUID(taskID.Bytes).String(),
+		envConnectionID + "=" + uuid.UUID(connectionID.Bytes).String(),
+	}
+}
+
+// FromCall returns the action of a call of a tool, and whether it needs approval. instructions are those of the tool,
+// whose command tells what it does, input the arguments of the call as the assistant wrote them, and organizer the
+// address of the mailbox the task runs with, who is added to the attendees of events. Drafts need no approval, as
+// nothing is sent until the user sends them.
+func FromCall(instructions, input, organizer string) (Action, bool, error) {
+	tool, ok := tools[command(instructions)]
+	if !ok {
+		return Action{}, false, nil
+	}
+
+	var raw map[string]any
+	if strings.TrimSpace(input) != "" {
+		if err := json.Unmarshal([]byte(input), &raw); err != nil {
+			return Action{}, false, fmt.Errorf("invalid arguments of %v: %w", tool, err)
+		}
+	}
+	arg := func(name string) string {
+		switch v := raw[name].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+
+	switch tool {
+	case ToolAddOnlineMeeting:
+		m := Meeting{EventID: arg("event-id")}
+		return Action{
+			Tool:      tool,
+			Arguments: m,
+			Summary:   fmt.Sprintf("an online meeting for the event %v", m.EventID),
+		}, true, nil
+	case ToolSendEmail:
+		if draft, _ := strconv.ParseBool(arg("email-draft")); draft {
+			return Action{}, false, nil
+		}
+		e := Email{
+			Subject: arg("email-subject"),
+			Body:    arg("email-content"),
+			To:      splitList(arg("email-recipient-to")),
+			Cc:      splitList(arg("email-recipient-cc")),
+			Bcc:     splitList(arg("email-recipient-bcc")),
+		}
+		return Action{
+			Tool:      tool,
+			Arguments: e,
+			Summary:   fmt.Sprintf("the email %q to %v", e.Subject, strings.Join(e.To, ", ")),
+		}, true, nil
+	}
+
+	e := Event{
+		Subject:   arg("event-subject"),
+		Content:   arg("event-content"),
+		Start:     arg("start-time"),
+		End:       arg("end-time"),
+		TimeZone:  TimeZone,
+		Attendees: append(splitList(arg("email-recipient")), organizer),
+	}
+	return Action{
+		Tool:      tool,
+		Arguments: e,
+		Summary:   fmt.Sprintf("the event %q from %v to %v with %v", e.Subject, e.Start, e.End, strings.Join(e.Attendees, ", ")),
+	}, true, nil
+}
+
+// command returns the subcommand a tool runs, such as send-email for #!gem-copilot send-email.
+func command(instructions string) string {
+	line, _, _ := strings.Cut(instructions, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+func splitList(s string) []string {
+	var ret []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+// inTask reports whether the tool runs in a task, which Env sets up.
+func inTask() bool {
+	return os.Getenv(envTaskID) != ""
+}
+
+// run returns the user, task and mailbox connection of the task run from the environment set by Env.
+func run() (userID, taskID, connectionID pgtype.UUID, err error) {
+	for name, id := range map[string]*pgtype.UUID{
+		envUserID:       &userID,
+		envTaskID:       &taskID,
+		envConnectionID: &connectionID,
+	} {
+		if err := id.Scan(os.Getenv(name)); err != nil || !id.Valid {
+			return userID, taskID, connectionID, fmt.Errorf("invalid %v", name)
+		}
+	}
+	return userID, taskID, connectionID, nil
+}
+
+// LinkURL is the page of the notification link, where the action can be approved or rejected with the token.
+func LinkURL(id, token string) string {
+	return fmt.Sprintf("%v/api/approvals/%v/link?token=%v", strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"), id, url.QueryEscape(token))
+}
+
+// HashLinkToken returns the hash link tokens are stored and looked up by.
+func HashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewLinkToken returns a token for the one-time link of a pending action. Only its hash is stored.
+func NewLinkToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,84 @@
+package approval
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFromCall(t *testing.T) {
+	tests := []struct {
+		name         string
+		instructions string
+		input        string
+		want         any
+		needed       bool
+		err          bool
+	}{
+		{
+			name:         "email",
+			instructions: "#!gem-copilot send-email",
+			input:        `{"email-subject": "Sync", "email-content": "Hi", "email-recipient-to": "a@example.com, b@example.com", "email-recipient-cc": ""}`,
+			want:         Email{Subject: "Sync", Body: "Hi", To: []string{"a@example.com", "b@example.com"}},
+			needed:       true,
+		},
+		{
+			name:         "draft",
+			instructions: "#!gem-copilot send-email",
+			input:        `{"email-subject": "Sync", "email-recipient-to": "a@example.com", "email-draft": "true"}`,
+		},
+		{
+			name:         "draft as a boolean",
+			instructions: "#!gem-copilot send-email",
+			input:        `{"email-subject": "Sync", "email-draft": true}`,
+		},
+		{
+			name:         "event",
+			instructions: "#!gem-copilot schedule\n",
+			input:        `{"event-subject": "Sync", "email-recipient": "a@example.com", "start-time": "2024-07-01T10:00:00-07:00", "end-time": "2024-07-01T11:00:00-07:00"}`,
+			want: Event{
+				Subject:   "Sync",
+				Start:     "2024-07-01T10:00:00-07:00",
+				End:       "2024-07-01T11:00:00-07:00",
+				TimeZone:  TimeZone,
+				Attendees: []string{"a@example.com", "me@example.com"},
+			},
+			needed: true,
+		},
+		{
+			name:         "online meeting",
+			instructions: "#!gem-copilot update-event",
+			input:        `{"event-id": "AAMkAD"}`,
+			want:         Meeting{EventID: "AAMkAD"},
+			needed:       true,
+		},
+		{
+			name:         "other tool",
+			instructions: "#!gem-copilot check-schedule",
+			input:        `{"email-recipient": "a@example.com"}`,
+		},
+		{
+			name:         "no command",
+			instructions: "Send the email",
+		},
+		{
+			name:         "invalid arguments",
+			instructions: "#!gem-copilot send-email",
+			input:        `send it`,
+			err:          true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, needed, err := FromCall(tt.instructions, tt.input, "me@example.com")
+			if (err != nil) != tt.err {
+				t.Fatalf("FromCall() error = %v, want error %v", err, tt.err)
+			}
+			if needed != tt.needed {
+				t.Fatalf("FromCall() needs approval = %v, want %v", needed, tt.needed)
+			}
+			if needed && !reflect.DeepEqual(action.Arguments, tt.want) {
+				t.Errorf("FromCall() arguments = %#v, want %#v", action.Arguments, tt.want)
+			}
+		})
+	}
+}
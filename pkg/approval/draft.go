@@ -0,0 +1,63 @@
+package approval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The states of a task draft, stored as task_drafts.status.
+const (
+	DraftStatusDraft = "draft"
+	DraftStatusSent  = "sent"
+)
+
+// TrackDraft adds the draft send-email left in the mailbox to the drafts of the task run, where the user can edit,
+// send or discard it. Drafts don't need approval, as nothing is sent until the user sends them. Outside a task it does
+// nothing.
+func TrackDraft(ctx context.Context, draft provider.Message) error {
+	if !inTask() {
+		return nil
+	}
+	userID, taskID, connectionID, err := run()
+	if err != nil {
+		return err
+	}
+
+	conn, err := pgx.Connect(ctx, db.ConnString())
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := db.New(conn).CreateTaskDraft(ctx, db.CreateTaskDraftParams{
+		UserID:         userID,
+		TaskID:         taskID,
+		ConnectionID:   connectionID,
+		MessageID:      draft.ID,
+		ConversationID: value(draft.ConversationID),
+		WebLink:        value(draft.WebLink),
+		Subject:        draft.Subject,
+		Recipients:     Recipients(draft),
+	}); err != nil {
+		return fmt.Errorf("failed to track draft: %w", err)
+	}
+	return nil
+}
+
+// Recipients returns every recipient of the message, as stored in task_drafts.recipients.
+func Recipients(m provider.Message) []string {
+	return slices.Concat([]string{}, m.To, m.Cc, m.Bcc)
+}
+
+func value(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,120 @@
+// Package audit records the outbound actions taken on mailboxes, the emails sent, the events scheduled and the
+// messages moved, in the append-only audit_events table. The server records its own actions with Record. The tools a
+// task runs are separate processes, they record theirs with RecordFromEnv, from the context the server passes them
+// with Env.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"ethan/pkg/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Who took the action, stored as audit_events.actor.
+const (
+	ActorAssistant = "assistant"
+	ActorUser      = "user"
+)
+
+// The outcome of the action, stored as audit_events.status.
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// The environment RecordFromEnv reads the context of the action from.
+const (
+	envUserID  = "AUDIT_USER_ID"
+	envTaskID  = "AUDIT_TASK_ID"
+	envMailbox = "AUDIT_MAILBOX"
+)
+
+type Event struct {
+	// UserID is the user the action was taken for, the user who ran the task for the assistant's actions.
+	UserID  pgtype.UUID
+	Actor   string
+	TaskID  pgtype.UUID
+	Mailbox string
+	Tool    string
+	// Arguments are what the action was called with, stored as JSON.
+	Arguments any
+	// ResponseIDs are the IDs the mail provider answered with, such as the ID of the sent message.
+	ResponseIDs map[string]string
+	// Err is the error the action failed with, nil when it succeeded.
+	Err error
+}
+
+func Record(ctx context.Context, queries *db.Queries, e Event) error {
+	arguments, err := json.Marshal(e.Arguments)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit arguments: %w", err)
+	}
+	if e.ResponseIDs == nil {
+		e.ResponseIDs = map[string]string{}
+	}
+	responseIDs, err := json.Marshal(e.ResponseIDs)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit response IDs: %w", err)
+	}
+
+	status := StatusSucceeded
+	var message *string
+	if e.Err != nil {
+		status = StatusFailed
+		m := e.Err.Error()
+		message = &m
+	}
+	return queries.CreateAuditEvent(ctx, db.CreateAuditEventParams{
+		UserID:      e.UserID,
+		Actor:       e.Actor,
+		TaskID:      e.TaskID,
+		Mailbox:     e.Mailbox,
+		Tool:        e.Tool,
+		Arguments:   arguments,
+		ResponseIDs: responseIDs,
+		Status:      status,
+		Error:       message,
+	})
+}
+
+// Env is the environment the tools of a task need to record their actions, for the user running the task on the
+// mailbox.
+func Env(userID, taskID pgtype.UUID, mailbox string) []string {
+	return []string{
+		envUserID + "=" + uuid.UUID(userID.Bytes).String(),
+		envTaskID + "=" + uuid.UUID(taskID.Bytes).String(),
+		envMailbox + "=" + mailbox,
+	}
+}
+
+// RecordFromEnv records an action of the assistant taken by a tool, in the context set by Env, in the database of
+// the server. A tool run outside a task, without that context, records nothing.
+func RecordFromEnv(ctx context.Context, e Event) error {
+	userID := os.Getenv(envUserID)
+	if userID == "" {
+		return nil
+	}
+	if err := e.UserID.Scan(userID); err != nil {
+		return fmt.Errorf("invalid %v: %w", envUserID, err)
+	}
+	if taskID := os.Getenv(envTaskID); taskID != "" {
+		if err := e.TaskID.Scan(taskID); err != nil {
+			return fmt.Errorf("invalid %v: %w", envTaskID, err)
+		}
+	}
+	e.Actor = ActorAssistant
+	e.Mailbox = os.Getenv(envMailbox)
+
+	conn, err := pgx.Connect(ctx, db.ConnString())
+	if err != nil {
+		return fmt.Errorf("failed to connect to the audit database: %w", err)
+	}
+	defer conn.Close(ctx)
+	return Record(ctx, db.New(conn), e)
+}
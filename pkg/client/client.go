@@ -0,0 +1,85 @@
+// Package client is a Go client for the REST API of the assistant server. The types and operations in api.gen.go are
+// generated from the OpenAPI document of the server, run go generate after changing it. Requests are authenticated
+// with the bearer token the server issues at sign in.
+package client
+
+//go:generate go run ./gen -spec ../server/api/openapi.json -out api.gen.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type Client struct {
+	// BaseURL is the URL of the server, without /api.
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		HTTP:    http.DefaultClient,
+	}
+}
+
+// RequestError is a failed request, with the error body the server answered.
+type RequestError struct {
+	StatusCode int
+	Body       Error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%v: %v (%v)", e.StatusCode, e.Body.Message, e.Body.Code)
+}
+
+// do sends a request to path under /api. in is sent as the JSON body when not nil, and the JSON response is decoded
+// into out when not nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+"/api"+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &RequestError{StatusCode: resp.StatusCode}
+		data, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, &apiErr.Body); err != nil || apiErr.Body.Message == "" {
+			apiErr.Body.Code = ErrorCodeInternal
+			apiErr.Body.Message = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
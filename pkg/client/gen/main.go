@@ -0,0 +1,630 @@
+// Command gen writes the types and operations of the client from the OpenAPI document of the server. It only supports
+// the parts of OpenAPI the document uses. Operations that do not answer JSON, such as redirects, the websocket of a
+// task run, HTML pages and the Graph webhooks, are left out.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+func main() {
+	specPath := flag.String("spec", "../server/api/openapi.json", "OpenAPI document to read")
+	outPath := flag.String("out", "api.gen.go", "Go file to write")
+	pkg := flag.String("package", "client", "package of the Go file")
+	flag.Parse()
+
+	data, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		log.Fatalf("invalid OpenAPI document: %v", err)
+	}
+
+	g := &generator{doc: &doc, imports: map[string]bool{}, used: map[string]bool{}}
+	src, err := g.generate(*pkg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*outPath, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type document struct {
+	Security   []map[string][]string `json:"security"`
+	Paths      ordered[pathItem]     `json:"paths"`
+	Components struct {
+		Schemas ordered[*schema] `json:"schemas"`
+	} `json:"components"`
+}
+
+type pathItem struct {
+	Parameters []parameter
+	Operations ordered[*operation]
+}
+
+func (p *pathItem) UnmarshalJSON(data []byte) error {
+	var raw ordered[json.RawMessage]
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, key := range raw.Keys {
+		if key == "parameters" {
+			if err := json.Unmarshal(raw.Values[key], &p.Parameters); err != nil {
+				return err
+			}
+			continue
+		}
+		var op operation
+		if err := json.Unmarshal(raw.Values[key], &op); err != nil {
+			return fmt.Errorf("%v: %w", key, err)
+		}
+		p.Operations.add(key, &op)
+	}
+	return nil
+}
+
+type operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description"`
+	Parameters  []parameter           `json:"parameters"`
+	RequestBody *body                 `json:"requestBody"`
+	Responses   ordered[body]         `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Schema      *schema `json:"schema"`
+}
+
+type body struct {
+	Content map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"content"`
+}
+
+// schema is the part of a JSON Schema the document uses.
+type schema struct {
+	Ref                  string           `json:"$ref"`
+	Type                 schemaType       `json:"type"`
+	Format               string           `json:"format"`
+	Description          string           `json:"description"`
+	Enum                 []string         `json:"enum"`
+	Properties           ordered[*schema] `json:"properties"`
+	Required             []string         `json:"required"`
+	Items                *schema          `json:"items"`
+	AdditionalProperties *schema          `json:"additionalProperties"`
+	AllOf                []*schema        `json:"allOf"`
+	OneOf                []*schema        `json:"oneOf"`
+}
+
+// schemaType is the type keyword, a name or a list of names such as ["string", "null"].
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaType{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*t = names
+	return nil
+}
+
+// base is the type without null.
+func (t schemaType) base() string {
+	for _, name := range t {
+		if name != "null" {
+			return name
+		}
+	}
+	return ""
+}
+
+func (t schemaType) nullable() bool {
+	return slices.Contains(t, "null")
+}
+
+// ordered is a JSON object that remembers the order of its keys, so the output follows the document.
+type ordered[T any] struct {
+	Keys   []string
+	Values map[string]T
+}
+
+func (o *ordered[T]) add(key string, v T) {
+	if o.Values == nil {
+		o.Values = map[string]T{}
+	}
+	o.Keys = append(o.Keys, key)
+	o.Values[key] = v
+}
+
+func (o *ordered[T]) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		o.add(token.(string), v)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+type generator struct {
+	doc     *document
+	buf     bytes.Buffer
+	imports map[string]bool
+	// used are the schemas the generated operations need.
+	used map[string]bool
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) generate(pkg string) ([]byte, error) {
+	g.imports["context"] = true
+	g.imports["net/http"] = true
+
+	var ops bytes.Buffer
+	g.buf, ops = ops, g.buf
+	for _, path := range g.doc.Paths.Keys {
+		item := g.doc.Paths.Values[path]
+		for _, method := range item.Operations.Keys {
+			op := item.Operations.Values[method]
+			if !g.supported(op) {
+				continue
+			}
+			if err := g.operation(path, method, item.Parameters, op); err != nil {
+				return nil, fmt.Errorf("%v %v: %w", strings.ToUpper(method), path, err)
+			}
+		}
+	}
+	g.buf, ops = ops, g.buf
+
+	var types bytes.Buffer
+	g.buf, types = types, g.buf
+	for _, name := range g.doc.Components.Schemas.Keys {
+		if g.used[name] {
+			if err := g.schemaType(name, g.doc.Components.Schemas.Values[name]); err != nil {
+				return nil, fmt.Errorf("schema %v: %w", name, err)
+			}
+		}
+	}
+	g.buf, types = types, g.buf
+
+	g.printf("// Code generated by gen from the OpenAPI document of the server. DO NOT EDIT.\n\n")
+	g.printf("package %v\n\nimport (\n", pkg)
+	var imports []string
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	slices.Sort(imports)
+	for _, path := range imports {
+		g.printf("%q\n", path)
+	}
+	g.printf(")\n\n")
+	g.buf.Write(types.Bytes())
+	g.buf.Write(ops.Bytes())
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return g.buf.Bytes(), fmt.Errorf("generated code does not compile: %w", err)
+	}
+	return src, nil
+}
+
+// supported reports whether the operation is called with a bearer token, or without authentication, and answers
+// JSON or nothing.
+func (g *generator) supported(op *operation) bool {
+	security := op.Security
+	if security == nil {
+		security = g.doc.Security
+	}
+	if len(security) > 0 && !slices.ContainsFunc(security, func(requirement map[string][]string) bool {
+		_, bearer := requirement["bearerAuth"]
+		return len(requirement) == 0 || bearer
+	}) {
+		return false
+	}
+
+	if op.RequestBody != nil {
+		if _, ok := op.RequestBody.Content["application/json"]; !ok {
+			return false
+		}
+	}
+	for _, status := range op.Responses.Keys {
+		if status >= "400" {
+			continue
+		}
+		if status < "200" || status >= "300" {
+			return false
+		}
+		content := op.Responses.Values[status].Content
+		if _, ok := content["application/json"]; len(content) > 0 && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *generator) operation(path, method string, shared []parameter, op *operation) error {
+	name := exported(op.OperationID)
+
+	var pathParams, queryParams []parameter
+	for _, p := range append(slices.Clone(shared), op.Parameters...) {
+		switch p.In {
+		case "path":
+			pathParams = append(pathParams, p)
+		case "query":
+			queryParams = append(queryParams, p)
+		}
+	}
+
+	args := []string{"ctx context.Context"}
+	pathExpr := `"` + path + `"`
+	for _, p := range pathParams {
+		arg := unexported(p.Name)
+		args = append(args, arg+" string")
+		pathExpr = strings.Replace(pathExpr, "{"+p.Name+"}", `" + url.PathEscape(`+arg+`) + "`, 1)
+		g.imports["net/url"] = true
+	}
+	pathExpr = strings.TrimSuffix(strings.ReplaceAll(pathExpr, ` + ""`, ""), ` + ""`)
+
+	paramsType := name + "Params"
+	if len(queryParams) > 0 {
+		args = append(args, "params "+paramsType)
+		if err := g.queryParams(paramsType, name, queryParams); err != nil {
+			return err
+		}
+	}
+
+	in := "nil"
+	if op.RequestBody != nil {
+		typ, err := g.goType(op.RequestBody.Content["application/json"].Schema, true)
+		if err != nil {
+			return err
+		}
+		args = append(args, "req "+typ)
+		in = "req"
+	}
+
+	var out string
+	for _, status := range op.Responses.Keys {
+		content := op.Responses.Values[status].Content
+		if status >= "400" {
+			for _, c := range content {
+				g.use(c.Schema)
+			}
+			continue
+		}
+		if c, ok := content["application/json"]; ok && out == "" {
+			typ, err := g.goType(c.Schema, true)
+			if err != nil {
+				return err
+			}
+			out = typ
+		}
+	}
+
+	g.printf("// %v sends %v %v.", name, strings.ToUpper(method), path)
+	g.comment(op.Summary, op.Description)
+	httpMethod := "http.Method" + exported(strings.ToLower(method))
+	if out == "" {
+		g.printf("func (c *Client) %v(%v) error {\n", name, strings.Join(args, ", "))
+	} else {
+		g.printf("func (c *Client) %v(%v) (%v, error) {\nvar out %v\n", name, strings.Join(args, ", "), out, out)
+	}
+	if len(queryParams) > 0 {
+		g.printf("path := %v\nif query := params.query(); len(query) > 0 {\npath += \"?\" + query.Encode()\n}\n", pathExpr)
+		pathExpr = "path"
+	}
+	if out == "" {
+		g.printf("return c.do(ctx, %v, %v, %v, nil)\n}\n\n", httpMethod, pathExpr, in)
+	} else {
+		g.printf("return out, c.do(ctx, %v, %v, %v, &out)\n}\n\n", httpMethod, pathExpr, in)
+	}
+	return nil
+}
+
+// queryParams writes the struct of the query parameters of an operation, zero fields are left out of the query.
+func (g *generator) queryParams(typ, op string, params []parameter) error {
+	g.imports["net/url"] = true
+	g.printf("// %v are the query parameters of %v, zero fields are not sent.\ntype %v struct {\n", typ, op, typ)
+	for _, p := range params {
+		fieldType, err := g.goType(p.Schema, true)
+		if err != nil {
+			return err
+		}
+		g.fieldComment(p.Description, p.Schema.Enum)
+		g.printf("%v %v\n", exported(p.Name), fieldType)
+	}
+	g.printf("}\n\nfunc (p %v) query() url.Values {\nquery := url.Values{}\n", typ)
+	for _, p := range params {
+		field := "p." + exported(p.Name)
+		switch {
+		case p.Schema.Format == "date-time":
+			g.printf("if !%v.IsZero() {\nquery.Set(%q, %v.Format(time.RFC3339))\n}\n", field, p.Name, field)
+		case p.Schema.Type.base() == "integer":
+			g.imports["strconv"] = true
+			g.printf("if %v != 0 {\nquery.Set(%q, strconv.Itoa(%v))\n}\n", field, p.Name, field)
+		case p.Schema.Type.base() == "boolean":
+			g.printf("if %v {\nquery.Set(%q, \"true\")\n}\n", field, p.Name)
+		case p.Schema.Type.base() == "string":
+			g.printf("if %v != \"\" {\nquery.Set(%q, %v)\n}\n", field, p.Name, field)
+		default:
+			return fmt.Errorf("unsupported query parameter %v", p.Name)
+		}
+	}
+	g.printf("return query\n}\n\n")
+	return nil
+}
+
+// use marks the schema s refers to, and those it refers to in turn, as needed.
+func (g *generator) use(s *schema) {
+	if s == nil {
+		return
+	}
+	if s.Ref != "" {
+		name := refName(s.Ref)
+		if g.used[name] {
+			return
+		}
+		g.used[name] = true
+		g.use(g.doc.Components.Schemas.Values[name])
+		return
+	}
+	for _, key := range s.Properties.Keys {
+		g.use(s.Properties.Values[key])
+	}
+	g.use(s.Items)
+	g.use(s.AdditionalProperties)
+	for _, part := range append(slices.Clone(s.AllOf), s.OneOf...) {
+		g.use(part)
+	}
+}
+
+// goType is the Go type of values of the schema. Optional and nullable scalars and structs are pointers, slices and
+// maps are nil instead.
+func (g *generator) goType(s *schema, required bool) (string, error) {
+	g.use(s)
+	pointer := !required || s.Type.nullable()
+
+	if s.Ref != "" {
+		return prefix(pointer, refName(s.Ref)), nil
+	}
+	if len(s.AllOf) == 1 && len(s.Type) == 0 {
+		return g.goType(s.AllOf[0], required)
+	}
+	if len(s.Type) == 0 || len(s.OneOf) > 0 || len(s.AllOf) > 0 {
+		g.imports["encoding/json"] = true
+		return "json.RawMessage", nil
+	}
+
+	switch s.Type.base() {
+	case "string":
+		switch s.Format {
+		case "date-time":
+			g.imports["time"] = true
+			return prefix(pointer, "time.Time"), nil
+		case "byte":
+			return "[]byte", nil
+		}
+		return prefix(pointer, "string"), nil
+	case "integer":
+		return prefix(pointer, "int"), nil
+	case "number":
+		return prefix(pointer, "float64"), nil
+	case "boolean":
+		return prefix(pointer, "bool"), nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		item, err := g.goType(s.Items, true)
+		return "[]" + item, err
+	case "object":
+		if len(s.Properties.Keys) > 0 {
+			return "", fmt.Errorf("inline objects with properties are not supported, move them to a schema")
+		}
+		if s.AdditionalProperties != nil {
+			value, err := g.goType(s.AdditionalProperties, true)
+			return "map[string]" + value, err
+		}
+		g.imports["encoding/json"] = true
+		return "json.RawMessage", nil
+	}
+	return "", fmt.Errorf("unsupported type %v", s.Type)
+}
+
+func prefix(pointer bool, typ string) string {
+	if pointer {
+		return "*" + typ
+	}
+	return typ
+}
+
+func refName(ref string) string {
+	return strings.TrimPrefix(ref, "#/components/schemas/")
+}
+
+// schemaType writes the named type of a component schema, and constants for the enums of its properties.
+func (g *generator) schemaType(name string, s *schema) error {
+	g.printf("// %v is the %v schema of the API.", name, name)
+	g.comment(s.Description)
+
+	object := s.Type.base() == "object" && (len(s.Properties.Keys) > 0 || s.AdditionalProperties == nil)
+	if !object && len(s.AllOf) < 2 {
+		typ, err := g.goType(s, true)
+		if err != nil {
+			return err
+		}
+		g.printf("type %v %v\n\n", name, typ)
+		return nil
+	}
+
+	g.printf("type %v struct {\n", name)
+	properties := []*schema{s}
+	for _, part := range s.AllOf {
+		if part.Ref != "" {
+			g.use(part)
+			g.printf("%v\n", refName(part.Ref))
+			continue
+		}
+		properties = append(properties, part)
+	}
+	var enums []string
+	for _, part := range properties {
+		for _, key := range part.Properties.Keys {
+			property := part.Properties.Values[key]
+			required := slices.Contains(part.Required, key)
+			typ, err := g.goType(property, required)
+			if err != nil {
+				return fmt.Errorf("%v: %w", key, err)
+			}
+			tag := key
+			if !required {
+				tag += ",omitempty"
+			}
+			g.fieldComment(property.Description, nil)
+			g.printf("%v %v `json:%q`\n", exported(key), typ, tag)
+			for _, value := range property.Enum {
+				enums = append(enums, fmt.Sprintf("%v%v%v = %q", name, exported(key), exported(value), value))
+			}
+		}
+	}
+	g.printf("}\n\n")
+
+	if len(enums) > 0 {
+		g.printf("// The values of the enum properties of %v.\nconst (\n%v\n)\n\n", name, strings.Join(enums, "\n"))
+	}
+	return nil
+}
+
+// comment ends the first line of a doc comment with a paragraph of the given sentences. A period is added to
+// summaries so they are not taken for headings.
+func (g *generator) comment(sentences ...string) {
+	g.printf("\n")
+	var paragraph []string
+	for _, s := range sentences {
+		if s = strings.TrimSpace(s); s != "" {
+			if !strings.HasSuffix(s, ".") {
+				s += "."
+			}
+			paragraph = append(paragraph, s)
+		}
+	}
+	if len(paragraph) > 0 {
+		g.printf("//\n")
+		g.wrap(strings.Join(paragraph, " "), 120)
+	}
+}
+
+func (g *generator) fieldComment(description string, enum []string) {
+	if len(enum) > 0 {
+		description = strings.TrimSpace(description + " One of " + strings.Join(enum, ", ") + ".")
+	}
+	if description != "" {
+		g.wrap(description, 116)
+	}
+}
+
+// wrap writes text as comment lines of at most width characters.
+func (g *generator) wrap(text string, width int) {
+	line := "//"
+	for _, word := range strings.Fields(text) {
+		if len(line)+1+len(word) > width && line != "//" {
+			g.printf("%v\n", line)
+			line = "//"
+		}
+		line += " " + word
+	}
+	g.printf("%v\n", line)
+}
+
+// initialisms are written in capitals in Go names.
+var initialisms = map[string]string{
+	"api": "API", "id": "ID", "ids": "IDs", "imap": "IMAP", "json": "JSON", "smtp": "SMTP", "uid": "UID",
+	"url": "URL", "uuid": "UUID", "oidc": "OIDC", "html": "HTML", "http": "HTTP",
+}
+
+// exported turns a name of the document, such as contextIds, list-tasks or invalid_request, into a Go name.
+func exported(name string) string {
+	var out strings.Builder
+	for _, word := range words(name) {
+		if initialism, ok := initialisms[strings.ToLower(word)]; ok {
+			out.WriteString(initialism)
+			continue
+		}
+		r := []rune(word)
+		out.WriteString(string(unicode.ToUpper(r[0])) + string(r[1:]))
+	}
+	return out.String()
+}
+
+func unexported(name string) string {
+	w := words(name)
+	if len(w) == 0 {
+		return name
+	}
+	first := strings.ToLower(w[0])
+	return first + strings.TrimPrefix(exported(name), exported(w[0]))
+}
+
+// words splits a name at separators and where lower case changes to upper case. Runs of capitals, such as IMAP in
+// loginIMAP, stay one word.
+func words(name string) []string {
+	var result []string
+	var word []rune
+	r := []rune(name)
+	for i, c := range r {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			if len(word) > 0 {
+				result = append(result, string(word))
+			}
+			word = nil
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(c) {
+			prev := word[len(word)-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				result = append(result, string(word))
+				word = nil
+			}
+		}
+		word = append(word, c)
+	}
+	if len(word) > 0 {
+		result = append(result, string(word))
+	}
+	return result
+}
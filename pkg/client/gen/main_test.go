@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// TestGenerated fails when api.gen.go was not regenerated after a change to the OpenAPI document.
+func TestGenerated(t *testing.T) {
+	data, err := os.ReadFile("../../server/api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	g := &generator{doc: &doc, imports: map[string]bool{}, used: map[string]bool{}}
+	src, err := g.generate("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := os.ReadFile("../api.gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, current) {
+		t.Error("pkg/client/api.gen.go is out of date, run go generate ./pkg/client")
+	}
+}
+
+func TestExported(t *testing.T) {
+	for name, want := range map[string]string{
+		"contextIds":      "ContextIDs",
+		"invalid_request": "InvalidRequest",
+		"loginIMAP":       "LoginIMAP",
+		"APIToken":        "APIToken",
+		"expiresInDays":   "ExpiresInDays",
+		"draftId":         "DraftID",
+		"list-tasks":      "ListTasks",
+	} {
+		if got := exported(name); got != want {
+			t.Errorf("exported(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+// RunFrame is a message the server sends while a task runs, one for every call event. Messages about an email or event
+// waiting for the user to approve it only have Approval set, the run waits until it is decided with DecideApproval.
+type RunFrame struct {
+	ID       string                         `json:"id"`
+	Frame    gptscript.CallFrame            `json:"frame"`
+	State    map[string]gptscript.CallFrame `json:"state"`
+	Approval *PendingAction                 `json:"approval,omitempty"`
+}
+
+// Run is the chat connection of a task. The server resumes the chat from the state saved on the task.
+type Run struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+}
+
+// RunTask connects to the chat of a task.
+func (c *Client) RunTask(ctx context.Context, id string) (*Run, error) {
+	u, err := url.Parse(c.BaseURL + "/api/tasks/" + url.PathEscape(id) + "/run")
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+
+	header := http.Header{}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 {
+			apiErr := &RequestError{StatusCode: resp.StatusCode}
+			if json.NewDecoder(resp.Body).Decode(&apiErr.Body) == nil && apiErr.Body.Message != "" {
+				return nil, apiErr
+			}
+		}
+		return nil, err
+	}
+	return &Run{conn: conn}, nil
+}
+
+// Next blocks until the server sends the next frame. It returns an error once the connection is closed.
+func (r *Run) Next() (RunFrame, error) {
+	var frame RunFrame
+	for {
+		messageType, data, err := r.conn.ReadMessage()
+		if err != nil {
+			return frame, err
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		return frame, json.Unmarshal(data, &frame)
+	}
+}
+
+// Send sends a chat message from the user.
+func (r *Run) Send(message string) error {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+	return r.conn.WriteMessage(websocket.TextMessage, []byte(message))
+}
+
+func (r *Run) Close() error {
+	r.writeLock.Lock()
+	_ = r.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	r.writeLock.Unlock()
+	return r.conn.Close()
+}
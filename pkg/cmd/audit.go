@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"ethan/pkg/audit"
+)
+
+// record adds the action of the tool to the audit log of the task. The action already happened, so a failure to
+// record it is reported without failing the tool, which the model would otherwise retry.
+func record(ctx context.Context, tool string, arguments any, responseIDs map[string]string, err error) {
+	if recordErr := audit.RecordFromEnv(ctx, audit.Event{
+		Tool:        tool,
+		Arguments:   arguments,
+		ResponseIDs: responseIDs,
+		Err:         err,
+	}); recordErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to record %v in the audit log: %v\n", tool, recordErr)
+	}
+}
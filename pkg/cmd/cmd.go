@@ -15,6 +15,7 @@ func New() *cobra.Command {
 		new(CheckSchedule),
 		new(ListSubjects),
 		new(UpdateEvent),
+		NewCopilot(),
 	)
 }
 
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ethan/pkg/client"
+
+	"github.com/acorn-io/cmd"
+	"github.com/spf13/cobra"
+)
+
+// Copilot is the root of the commands that use the assistant server over its API, as opposed to the tools above that
+// talk to the mailbox directly.
+type Copilot struct {
+	Server string `usage:"URL of the assistant server" env:"COPILOT_SERVER" default:"http://localhost:8080"`
+	Token  string `usage:"Personal API token, or the jwt-token cookie set at sign in" env:"COPILOT_TOKEN"`
+	Output string `usage:"Output format, table or json" short:"o" env:"COPILOT_OUTPUT" default:"table"`
+}
+
+func NewCopilot() *cobra.Command {
+	c := &Copilot{}
+	return cmd.Command(c, cobra.Command{
+		Use:   "copilot",
+		Short: "Use the assistant server from a terminal",
+	},
+		cmd.Command(&CopilotTasks{}, cobra.Command{Use: "tasks", Short: "Manage tasks"},
+			&CopilotTasksList{copilot: c},
+			&CopilotTasksCreate{copilot: c},
+			&CopilotTasksDelete{copilot: c},
+			&CopilotTasksRun{copilot: c},
+		),
+		cmd.Command(&CopilotApprovals{}, cobra.Command{Use: "approvals", Short: "Approve, edit or reject the emails and events of the assistant"},
+			&CopilotApprovalsList{copilot: c},
+			&CopilotApprovalsApprove{copilot: c},
+			&CopilotApprovalsEdit{copilot: c},
+			&CopilotApprovalsReject{copilot: c},
+		),
+		cmd.Command(&CopilotContexts{}, cobra.Command{Use: "contexts", Short: "Manage contexts"},
+			&CopilotContextsList{copilot: c},
+			&CopilotContextsCreate{copilot: c},
+			&CopilotContextsDelete{copilot: c},
+		),
+		cmd.Command(&CopilotSpam{}, cobra.Command{Use: "spam", Short: "Review emails marked as spam"},
+			&CopilotSpamList{copilot: c},
+			&CopilotSpamRestore{copilot: c},
+			&CopilotSpamDelete{copilot: c},
+		),
+		cmd.Command(&CopilotInbox{copilot: c}, cobra.Command{Use: "inbox", Short: "Show unread notifications"},
+			&CopilotInboxRead{copilot: c},
+		),
+	)
+}
+
+func (c *Copilot) Run(cmd *cobra.Command, _ []string) error {
+	return cmd.Help()
+}
+
+// PersistentPre makes the subcommands read the environment variables of the flags above, they are only read when a
+// command of this struct runs.
+func (c *Copilot) PersistentPre(*cobra.Command, []string) error {
+	return nil
+}
+
+func (c *Copilot) client() (*client.Client, error) {
+	if c.Token == "" {
+		return nil, fmt.Errorf("no API token, pass --token or set COPILOT_TOKEN")
+	}
+	return client.New(c.Server, c.Token), nil
+}
+
+// print writes v as JSON, or as a table with one row per entry of rows when the output format is table.
+func (c *Copilot) print(v any, header []string, rows [][]string) error {
+	switch c.Output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", c.Output)
+	}
+}
+
+// truncate shortens s to one line of at most n runes for table cells.
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+// timestamp formats the times of the API for table cells.
+func timestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func value[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
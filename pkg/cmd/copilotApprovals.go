@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+type CopilotApprovals struct{}
+
+func (a *CopilotApprovals) Run(cmd *cobra.Command, _ []string) error {
+	return cmd.Help()
+}
+
+type CopilotApprovalsList struct {
+	copilot *Copilot
+	Task    string `usage:"Only list the actions of this task" env:"COPILOT_APPROVALS_TASK"`
+	Status  string `usage:"Only list the actions in this state: pending, approved, succeeded, failed or rejected" default:"pending" env:"COPILOT_APPROVALS_STATUS"`
+}
+
+func (l *CopilotApprovalsList) Customize(cmd *cobra.Command) {
+	cmd.Use = "list"
+	cmd.Aliases = []string{"ls"}
+	cmd.Short = "List the emails and events waiting for your approval"
+	cmd.Args = cobra.NoArgs
+}
+
+func (l *CopilotApprovalsList) Run(cmd *cobra.Command, _ []string) error {
+	c, err := l.copilot.client()
+	if err != nil {
+		return err
+	}
+	actions, err := c.ListApprovals(cmd.Context(), client.ListApprovalsParams{TaskID: l.Task, Status: l.Status})
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, a := range actions {
+		rows = append(rows, []string{a.ID, a.TaskID, a.Status, truncate(describeAction(a), 80), timestamp(a.CreatedAt)})
+	}
+	return l.copilot.print(actions, []string{"ID", "TASK", "STATUS", "ACTION", "CREATED"}, rows)
+}
+
+type CopilotApprovalsApprove struct {
+	copilot *Copilot
+}
+
+func (a *CopilotApprovalsApprove) Customize(cmd *cobra.Command) {
+	cmd.Use = "approve ID..."
+	cmd.Short = "Send the emails and create the events as the assistant wrote them"
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (a *CopilotApprovalsApprove) Run(cmd *cobra.Command, args []string) error {
+	return decide(cmd, a.copilot, args, client.ApprovalRequest{Decision: client.ApprovalRequestDecisionApprove})
+}
+
+type CopilotApprovalsReject struct {
+	copilot *Copilot
+}
+
+func (r *CopilotApprovalsReject) Customize(cmd *cobra.Command) {
+	cmd.Use = "reject ID..."
+	cmd.Short = "Drop the emails and events, nothing is sent or created"
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (r *CopilotApprovalsReject) Run(cmd *cobra.Command, args []string) error {
+	return decide(cmd, r.copilot, args, client.ApprovalRequest{Decision: client.ApprovalRequestDecisionReject})
+}
+
+// CopilotApprovalsEdit approves an action with changes. Flags left empty keep what the assistant wrote.
+type CopilotApprovalsEdit struct {
+	copilot   *Copilot
+	Subject   string   `usage:"Subject of the email or event"`
+	Body      string   `usage:"Body of the email, or content of the event"`
+	To        []string `usage:"Recipients of the email"`
+	Cc        []string `usage:"CC recipients of the email"`
+	Bcc       []string `usage:"BCC recipients of the email"`
+	Start     string   `usage:"Start of the event, RFC3339"`
+	End       string   `usage:"End of the event, RFC3339"`
+	Attendees []string `usage:"Attendees of the event, including the organizer"`
+}
+
+func (e *CopilotApprovalsEdit) Customize(cmd *cobra.Command) {
+	cmd.Use = "edit ID"
+	cmd.Short = "Send the email or create the event with your changes"
+	cmd.Args = cobra.ExactArgs(1)
+}
+
+func (e *CopilotApprovalsEdit) Run(cmd *cobra.Command, args []string) error {
+	c, err := e.copilot.client()
+	if err != nil {
+		return err
+	}
+	action, err := c.GetApproval(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	req := client.ApprovalRequest{Decision: client.ApprovalRequestDecisionEdit}
+	switch action.Tool {
+	case approval.ToolSendEmail:
+		var email client.ApprovalEmail
+		if err := json.Unmarshal(action.Arguments, &email); err != nil {
+			return fmt.Errorf("invalid email of %v: %w", action.ID, err)
+		}
+		set(&email.Subject, e.Subject)
+		set(&email.Body, e.Body)
+		setList(&email.To, e.To)
+		setList(&email.Cc, e.Cc)
+		setList(&email.Bcc, e.Bcc)
+		req.Email = &email
+	case approval.ToolSchedule:
+		var event client.ApprovalEvent
+		if err := json.Unmarshal(action.Arguments, &event); err != nil {
+			return fmt.Errorf("invalid event of %v: %w", action.ID, err)
+		}
+		set(&event.Subject, e.Subject)
+		if e.Body != "" {
+			event.Content = &e.Body
+		}
+		set(&event.Start, e.Start)
+		set(&event.End, e.End)
+		setList(&event.Attendees, e.Attendees)
+		req.Event = &event
+	default:
+		return fmt.Errorf("%v cannot be edited, approve or reject it", action.Tool)
+	}
+	return decide(cmd, e.copilot, args, req)
+}
+
+func set(s *string, v string) {
+	if v != "" {
+		*s = v
+	}
+}
+
+func setList(s *[]string, v []string) {
+	if len(v) > 0 {
+		*s = v
+	}
+}
+
+// decide sends the decision on each action and prints the outcome.
+func decide(cmd *cobra.Command, copilot *Copilot, ids []string, req client.ApprovalRequest) error {
+	c, err := copilot.client()
+	if err != nil {
+		return err
+	}
+	var actions []client.PendingAction
+	var rows [][]string
+	for _, id := range ids {
+		action, err := c.DecideApproval(cmd.Context(), id, req)
+		if err != nil {
+			return fmt.Errorf("failed to %v %v: %w", req.Decision, id, err)
+		}
+		actions = append(actions, action)
+		rows = append(rows, []string{action.ID, action.Status, value(action.Error)})
+	}
+	return copilot.print(actions, []string{"ID", "STATUS", "ERROR"}, rows)
+}
+
+// describeAction says what an action sends or creates, in one line.
+func describeAction(a client.PendingAction) string {
+	var args struct {
+		Subject   string   `json:"subject"`
+		To        []string `json:"to"`
+		Start     string   `json:"start"`
+		End       string   `json:"end"`
+		Attendees []string `json:"attendees"`
+		EventID   string   `json:"eventId"`
+	}
+	if err := json.Unmarshal(a.Arguments, &args); err != nil {
+		return a.Tool
+	}
+	switch a.Tool {
+	case approval.ToolSendEmail:
+		return fmt.Sprintf("Send the email %q to %v", args.Subject, strings.Join(args.To, ", "))
+	case approval.ToolSchedule:
+		return fmt.Sprintf("Create the event %q from %v to %v with %v", args.Subject, args.Start, args.End, strings.Join(args.Attendees, ", "))
+	case approval.ToolAddOnlineMeeting:
+		return fmt.Sprintf("Add an online meeting to the event %v", args.EventID)
+	default:
+		return a.Tool
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/client"
+
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+const (
+	reconnectDelay  = 5 * time.Second
+	waitingForModel = "⏳⏳⏳ Waiting for model response..."
+)
+
+// chat runs a chat REPL on the task. Lines read from stdin are sent to the assistant, and the assistant's output is
+// printed as it streams in. The server closes the connection when the task is updated, for example by a reply to its
+// email, so the connection is reopened until the user leaves. Emails and events the assistant wants to send or create
+// are printed for the user to approve or reject with /approve and /reject, the run waits until they do.
+func chat(ctx context.Context, c *client.Client, taskID string) error {
+	task, err := c.GetTask(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Chatting about %v, type /exit to leave.\n", task.Name)
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	p := &chatPrinter{client: c}
+	for {
+		run, err := c.RunTask(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		closed := make(chan error, 1)
+		go func() {
+			for {
+				frame, err := run.Next()
+				if err != nil {
+					closed <- err
+					return
+				}
+				p.print(frame)
+			}
+		}()
+
+		reconnect, err := p.converse(ctx, run, lines, closed)
+		_ = run.Close()
+		if !reconnect {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "\nConnection closed, reconnecting...")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// converse sends the user's lines until they leave or the connection closes. It reports whether to reconnect.
+func (p *chatPrinter) converse(ctx context.Context, run *client.Run, lines <-chan string, closed <-chan error) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-closed:
+			return true, nil
+		case line, ok := <-lines:
+			line = strings.TrimSpace(line)
+			if !ok || line == "/exit" {
+				return false, nil
+			}
+			if line == "" {
+				p.prompt()
+				continue
+			}
+			if command, id, ok := strings.Cut(line+" ", " "); ok && (command == "/approve" || command == "/reject") {
+				p.decide(ctx, strings.TrimPrefix(command, "/"), strings.TrimSpace(id))
+				continue
+			}
+			if err := run.Send(line); err != nil {
+				// The line is lost with the connection, the user has to send it again.
+				return true, nil
+			}
+		}
+	}
+}
+
+// chatPrinter prints the main output of a run like the chat of the UI: the latest content of the chat's calls, with
+// tool calls shown by name, and the actions waiting for approval.
+type chatPrinter struct {
+	client  *client.Client
+	callID  string
+	printed string
+
+	lock sync.Mutex
+	// waiting is the action the run waits for the user to decide, the one /approve and /reject decide by default.
+	waiting string
+	// shown are the actions printed already, the server sends them again after every turn until they are decided.
+	shown map[string]bool
+}
+
+func (p *chatPrinter) print(frame client.RunFrame) {
+	if frame.Approval != nil {
+		p.printApproval(*frame.Approval)
+		return
+	}
+	f := frame.Frame
+	if len(f.Output) == 0 || (f.ParentID != "" && !f.Tool.Chat) {
+		return
+	}
+	last := f.Output[len(f.Output)-1]
+	if len(last.SubCalls) > 0 || last.Content == "" {
+		return
+	}
+
+	content := last.Content
+	if content == waitingForModel {
+		return
+	}
+	if call, ok := strings.CutPrefix(content, "<tool call>"); ok {
+		tool, _, _ := strings.Cut(call, " -> ")
+		content = fmt.Sprintf("🛠️ Calling tool %v...", strings.TrimSpace(tool))
+	}
+
+	// The content of a call grows as the model streams, print only what is new.
+	if f.ID == p.callID && strings.HasPrefix(content, p.printed) {
+		fmt.Print(strings.TrimPrefix(content, p.printed))
+	} else {
+		if p.printed != "" {
+			fmt.Println()
+		}
+		fmt.Print(content)
+	}
+	p.callID, p.printed = f.ID, content
+
+	if f.Type == gptscript.EventTypeCallFinish {
+		fmt.Println()
+		p.callID, p.printed = "", ""
+		p.prompt()
+	}
+}
+
+func (p *chatPrinter) prompt() {
+	fmt.Print("> ")
+}
+
+func (p *chatPrinter) printApproval(action client.PendingAction) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.shown[action.ID] {
+		return
+	}
+	if p.shown == nil {
+		p.shown = map[string]bool{}
+	}
+	p.shown[action.ID] = true
+	p.waiting = action.ID
+
+	if p.printed != "" {
+		fmt.Println()
+		p.callID, p.printed = "", ""
+	}
+	fmt.Printf("✋ Waiting for your approval: %v\n", describeAction(action))
+	fmt.Printf("Type /approve or /reject, or change it with `copilot approvals edit %v`.\n", action.ID)
+	p.prompt()
+}
+
+// decide approves or rejects the action with the ID, or the one the run waits for. The run is told the outcome by the
+// server and goes on.
+func (p *chatPrinter) decide(ctx context.Context, decision, id string) {
+	p.lock.Lock()
+	if id == "" {
+		id = p.waiting
+	}
+	p.lock.Unlock()
+	if id == "" {
+		fmt.Println("Nothing is waiting for your approval.")
+		p.prompt()
+		return
+	}
+
+	action, err := p.client.DecideApproval(ctx, id, client.ApprovalRequest{Decision: decision})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %v %v: %v\n", decision, id, err)
+		p.prompt()
+		return
+	}
+	p.lock.Lock()
+	if p.waiting == id {
+		p.waiting = ""
+	}
+	p.lock.Unlock()
+	switch {
+	case action.Error != nil:
+		fmt.Printf("Approved, but it failed: %v\n", *action.Error)
+	case action.Status == approval.StatusRejected:
+		fmt.Println("Rejected, nothing was sent or created.")
+	default:
+		fmt.Println("Approved.")
+	}
+}
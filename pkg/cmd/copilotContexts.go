@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"ethan/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+type CopilotContexts struct{}
+
+func (c *CopilotContexts) Run(cmd *cobra.Command, _ []string) error {
+	return cmd.Help()
+}
+
+type CopilotContextsList struct {
+	copilot *Copilot
+}
+
+func (l *CopilotContextsList) Customize(cmd *cobra.Command) {
+	cmd.Use = "list"
+	cmd.Aliases = []string{"ls"}
+	cmd.Args = cobra.NoArgs
+}
+
+func (l *CopilotContextsList) Run(cmd *cobra.Command, _ []string) error {
+	c, err := l.copilot.client()
+	if err != nil {
+		return err
+	}
+	contexts, err := c.ListContexts(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, context := range contexts {
+		rows = append(rows, []string{context.ID, truncate(context.Name, 40), truncate(context.Content, 60), timestamp(context.CreatedAt)})
+	}
+	return l.copilot.print(contexts, []string{"ID", "NAME", "CONTENT", "CREATED"}, rows)
+}
+
+type CopilotContextsCreate struct {
+	copilot     *Copilot
+	Description string `usage:"Description of the context" short:"d" env:"COPILOT_CONTEXT_DESCRIPTION"`
+	Content     string `usage:"Content of the context, read from --file when empty" short:"c" env:"COPILOT_CONTEXT_CONTENT"`
+	File        string `usage:"File to read the content from, - for stdin" short:"f" env:"COPILOT_CONTEXT_FILE"`
+}
+
+func (c *CopilotContextsCreate) Customize(cmd *cobra.Command) {
+	cmd.Use = "create NAME"
+	cmd.Args = cobra.ExactArgs(1)
+}
+
+func (c *CopilotContextsCreate) Run(cmd *cobra.Command, args []string) error {
+	cl, err := c.copilot.client()
+	if err != nil {
+		return err
+	}
+
+	content := c.Content
+	if content == "" && c.File != "" {
+		var data []byte
+		if c.File == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(c.File)
+		}
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	}
+
+	context, err := cl.CreateContext(cmd.Context(), client.ContextRequest{
+		Name:        args[0],
+		Description: &c.Description,
+		Content:     &content,
+	})
+	if err != nil {
+		return err
+	}
+	return c.copilot.print(context, []string{"ID", "NAME"}, [][]string{{context.ID, context.Name}})
+}
+
+type CopilotContextsDelete struct {
+	copilot *Copilot
+}
+
+func (d *CopilotContextsDelete) Customize(cmd *cobra.Command) {
+	cmd.Use = "delete ID..."
+	cmd.Aliases = []string{"rm"}
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (d *CopilotContextsDelete) Run(cmd *cobra.Command, args []string) error {
+	c, err := d.copilot.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range args {
+		if err := c.DeleteContext(cmd.Context(), id); err != nil {
+			return fmt.Errorf("failed to delete context %v: %w", id, err)
+		}
+		fmt.Println(id)
+	}
+	return nil
+}
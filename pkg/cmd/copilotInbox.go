@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+
+	"ethan/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+// CopilotInbox lists the notifications the assistant left for the user, such as new tasks created from email and
+// emails marked as spam.
+type CopilotInbox struct {
+	copilot *Copilot
+	All     bool   `usage:"Include notifications that were already read" short:"a" env:"COPILOT_INBOX_ALL" local:"true"`
+	Task    string `usage:"Only show the notifications of this task" env:"COPILOT_INBOX_TASK" local:"true"`
+}
+
+func (i *CopilotInbox) Customize(cmd *cobra.Command) {
+	cmd.Args = cobra.NoArgs
+}
+
+func (i *CopilotInbox) Run(cmd *cobra.Command, _ []string) error {
+	c, err := i.copilot.client()
+	if err != nil {
+		return err
+	}
+	messages, err := c.ListMessages(cmd.Context(), client.ListMessagesParams{TaskID: i.Task})
+	if err != nil {
+		return err
+	}
+
+	shown := []client.Message{}
+	var rows [][]string
+	for _, m := range messages {
+		if m.Read && !i.All {
+			continue
+		}
+		shown = append(shown, m)
+		rows = append(rows, []string{m.ID, value(m.TaskID), truncate(m.Content, 70), timestamp(m.CreatedAt)})
+	}
+	return i.copilot.print(shown, []string{"ID", "TASK", "CONTENT", "CREATED"}, rows)
+}
+
+type CopilotInboxRead struct {
+	copilot *Copilot
+}
+
+func (r *CopilotInboxRead) Customize(cmd *cobra.Command) {
+	cmd.Use = "read ID..."
+	cmd.Short = "Mark notifications as read"
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (r *CopilotInboxRead) Run(cmd *cobra.Command, args []string) error {
+	c, err := r.copilot.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range args {
+		if err := c.MarkMessageRead(cmd.Context(), id); err != nil {
+			return fmt.Errorf("failed to mark %v as read: %w", id, err)
+		}
+		fmt.Println(id)
+	}
+	return nil
+}
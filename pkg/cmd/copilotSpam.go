@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+type CopilotSpam struct{}
+
+func (s *CopilotSpam) Run(cmd *cobra.Command, _ []string) error {
+	return cmd.Help()
+}
+
+type CopilotSpamList struct {
+	copilot *Copilot
+}
+
+func (l *CopilotSpamList) Customize(cmd *cobra.Command) {
+	cmd.Use = "list"
+	cmd.Aliases = []string{"ls"}
+	cmd.Args = cobra.NoArgs
+}
+
+func (l *CopilotSpamList) Run(cmd *cobra.Command, _ []string) error {
+	c, err := l.copilot.client()
+	if err != nil {
+		return err
+	}
+	spams, err := c.ListSpams(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, s := range spams {
+		rows = append(rows, []string{s.ID, truncate(s.Subject, 60), timestamp(s.CreatedAt)})
+	}
+	return l.copilot.print(spams, []string{"ID", "SUBJECT", "CREATED"}, rows)
+}
+
+type CopilotSpamRestore struct {
+	copilot *Copilot
+}
+
+func (r *CopilotSpamRestore) Customize(cmd *cobra.Command) {
+	cmd.Use = "restore ID..."
+	cmd.Short = "Move emails marked as spam back to the inbox"
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (r *CopilotSpamRestore) Run(cmd *cobra.Command, args []string) error {
+	c, err := r.copilot.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range args {
+		if err := c.RestoreSpam(cmd.Context(), id); err != nil {
+			return fmt.Errorf("failed to restore %v: %w", id, err)
+		}
+		fmt.Println(id)
+	}
+	return nil
+}
+
+type CopilotSpamDelete struct {
+	copilot *Copilot
+}
+
+func (d *CopilotSpamDelete) Customize(cmd *cobra.Command) {
+	cmd.Use = "delete ID..."
+	cmd.Aliases = []string{"rm"}
+	cmd.Short = "Forget emails marked as spam, they stay in the Cold Email folder"
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (d *CopilotSpamDelete) Run(cmd *cobra.Command, args []string) error {
+	c, err := d.copilot.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range args {
+		if err := c.DeleteSpam(cmd.Context(), id); err != nil {
+			return fmt.Errorf("failed to delete %v: %w", id, err)
+		}
+		fmt.Println(id)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"ethan/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+type CopilotTasks struct{}
+
+func (c *CopilotTasks) Run(cmd *cobra.Command, _ []string) error {
+	return cmd.Help()
+}
+
+type CopilotTasksList struct {
+	copilot *Copilot
+}
+
+func (l *CopilotTasksList) Customize(cmd *cobra.Command) {
+	cmd.Use = "list"
+	cmd.Aliases = []string{"ls"}
+	cmd.Args = cobra.NoArgs
+}
+
+func (l *CopilotTasksList) Run(cmd *cobra.Command, _ []string) error {
+	c, err := l.copilot.client()
+	if err != nil {
+		return err
+	}
+	tasks, err := c.ListTasks(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, t := range tasks {
+		rows = append(rows, []string{t.ID, truncate(t.Name, 40), truncate(t.Description, 60), timestamp(t.CreatedAt)})
+	}
+	return l.copilot.print(tasks, []string{"ID", "NAME", "DESCRIPTION", "CREATED"}, rows)
+}
+
+type CopilotTasksCreate struct {
+	copilot     *Copilot
+	Description string   `usage:"Description of the task" short:"d" env:"COPILOT_TASK_DESCRIPTION"`
+	Context     string   `usage:"Rules for the assistant" env:"COPILOT_TASK_CONTEXT"`
+	ContextIDs  []string `usage:"IDs of contexts to add to the task" name:"context-id" env:"COPILOT_TASK_CONTEXT_IDS"`
+}
+
+func (c *CopilotTasksCreate) Customize(cmd *cobra.Command) {
+	cmd.Use = "create NAME"
+	cmd.Args = cobra.ExactArgs(1)
+}
+
+func (c *CopilotTasksCreate) Run(cmd *cobra.Command, args []string) error {
+	cl, err := c.copilot.client()
+	if err != nil {
+		return err
+	}
+
+	req := client.TaskRequest{
+		Name:        args[0],
+		Description: &c.Description,
+		ContextIDs:  c.ContextIDs,
+	}
+	if c.Context != "" {
+		req.Context = &c.Context
+	}
+	task, err := cl.CreateTask(cmd.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.copilot.print(task, []string{"ID", "NAME"}, [][]string{{task.ID, task.Name}})
+}
+
+type CopilotTasksDelete struct {
+	copilot *Copilot
+}
+
+func (d *CopilotTasksDelete) Customize(cmd *cobra.Command) {
+	cmd.Use = "delete ID..."
+	cmd.Aliases = []string{"rm"}
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (d *CopilotTasksDelete) Run(cmd *cobra.Command, args []string) error {
+	c, err := d.copilot.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range args {
+		if err := c.DeleteTask(cmd.Context(), id); err != nil {
+			return fmt.Errorf("failed to delete task %v: %w", id, err)
+		}
+		fmt.Println(id)
+	}
+	return nil
+}
+
+type CopilotTasksRun struct {
+	copilot *Copilot
+}
+
+func (r *CopilotTasksRun) Customize(cmd *cobra.Command) {
+	cmd.Use = "run ID"
+	cmd.Short = "Chat with the assistant about a task"
+	cmd.Long = strings.TrimSpace(`
+Chat with the assistant about a task. Every line you type is sent as a message, the assistant's answers are
+streamed as they are generated. Type /exit or press Ctrl-D to leave, the chat can be resumed later.`)
+	cmd.Args = cobra.ExactArgs(1)
+}
+
+func (r *CopilotTasksRun) Run(cmd *cobra.Command, args []string) error {
+	c, err := r.copilot.client()
+	if err != nil {
+		return err
+	}
+	return chat(cmd.Context(), c, args[0])
+}
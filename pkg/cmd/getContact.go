@@ -6,11 +6,7 @@ import (
 	"os"
 	"strings"
 
-	"ethan/pkg/mstoken"
 	"github.com/sirupsen/logrus"
-
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	"github.com/microsoftgraph/msgraph-sdk-go/users"
 	"github.com/spf13/cobra"
 )
 
@@ -22,58 +18,40 @@ type output struct {
 type GetContact struct{}
 
 func (c *GetContact) Run(cmd *cobra.Command, args []string) error {
-	cred := mstoken.NewStaticTokenCredential(os.Getenv("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN"))
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	p, err := mailProvider()
 	if err != nil {
 		return err
 	}
 
 	var ret []output
 	for _, name := range strings.Split(os.Getenv("EMAIL_RECIPIENT_NAMES"), ",") {
-		config := &users.ItemPeopleRequestBuilderGetRequestConfiguration{
-			QueryParameters: &users.ItemPeopleRequestBuilderGetQueryParameters{
-				Search: &name,
-			},
-		}
-		people, err := client.Me().People().Get(cmd.Context(), config)
+		people, err := p.SearchPeople(cmd.Context(), name)
 		if err != nil {
-			logrus.Errorf("Failed to get people from MS Graph: %v", err)
+			logrus.Errorf("Failed to get people from mail provider: %v", err)
 			continue
 		}
-		contacts := people.GetValue()
-		for _, contact := range contacts {
-			if contact.GetDisplayName() != nil {
-				var emails []string
-				for _, email := range contact.GetScoredEmailAddresses() {
-					if email.GetAddress() != nil {
-						emails = append(emails, *email.GetAddress())
-					}
-				}
-				ret = append(ret, output{
-					Name:  *contact.GetDisplayName(),
-					Email: strings.Join(emails, ","),
-				})
-			}
+		for _, person := range people {
+			ret = append(ret, output{
+				Name:  person.Name,
+				Email: strings.Join(person.Emails, ","),
+			})
 		}
 	}
 
-	result, err := client.Me().Contacts().Get(cmd.Context(), nil)
+	contacts, err := p.ListContacts(cmd.Context())
 	if err != nil {
 		return err
 	}
-	for _, contact := range result.GetValue() {
-		var displayName string
-		if contact.GetDisplayName() != nil && *contact.GetDisplayName() != "" {
-			displayName = *contact.GetDisplayName()
-		} else if contact.GetGivenName() != nil && contact.GetSurname() != nil {
-			displayName = fmt.Sprintf("%v %v", *contact.GetGivenName(), *contact.GetSurname())
+	for _, contact := range contacts {
+		if len(contact.Emails) == 0 {
+			continue
 		}
 
 		for _, name := range strings.Split(os.Getenv("EMAIL_RECIPIENT_NAMES"), ",") {
-			if strings.Contains(strings.ToLower(displayName), strings.ToLower(strings.TrimSpace(name))) {
+			if strings.Contains(strings.ToLower(contact.Name), strings.ToLower(strings.TrimSpace(name))) {
 				ret = append(ret, output{
-					Name:  displayName,
-					Email: *contact.GetEmailAddresses()[0].GetAddress(),
+					Name:  contact.Name,
+					Email: contact.Emails[0],
 				})
 				break
 			}
@@ -3,13 +3,9 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 
-	"ethan/pkg/mstoken"
+	"ethan/pkg/provider"
 
-	abstractions "github.com/microsoft/kiota-abstractions-go"
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	graphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
 	"github.com/spf13/cobra"
 )
 
@@ -20,32 +16,22 @@ type contactOutput struct {
 type ListSubjects struct{}
 
 func (l *ListSubjects) Run(cmd *cobra.Command, args []string) error {
-	cred := mstoken.NewStaticTokenCredential(os.Getenv("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN"))
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	p, err := mailProvider()
 	if err != nil {
 		return err
 	}
 
-	headers := abstractions.NewRequestHeaders()
-	headers.Add("Prefer", "outlook.body-content-type=text")
-	requestParameters := &graphusers.ItemMessagesRequestBuilderGetQueryParameters{
-		Select: []string{"sender", "subject", "body"},
-		Top:    &[]int32{100}[0],
-	}
-	configuration := &graphusers.ItemMessagesRequestBuilderGetRequestConfiguration{
-		QueryParameters: requestParameters,
-		Headers:         headers,
-	}
-
-	messages, err := client.Me().Messages().Get(cmd.Context(), configuration)
+	messages, err := p.ListMessages(cmd.Context(), provider.ListOptions{
+		Top: 100,
+	})
 	if err != nil {
 		return err
 	}
 
 	var contacts []contactOutput
-	for _, m := range messages.GetValue() {
+	for _, m := range messages {
 		contacts = append(contacts, contactOutput{
-			Subject: *m.GetSubject(),
+			Subject: m.Subject,
 		})
 	}
 
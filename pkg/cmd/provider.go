@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/provider"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/provider/imap"
+)
+
+// timeZone is the time zone events and availability are reported in.
+const timeZone = approval.TimeZone
+
+// providers builds the mail provider every tool talks to. It can be swapped for a fake in tests.
+var providers = provider.Registry{
+	graph.Name: graph.New,
+	imap.Name:  imap.New,
+}
+
+// mailProvider builds the provider from the environment set by provider.Env. A delegate's tools work on the mailbox of
+// the principal, within the permissions they were granted.
+func mailProvider() (provider.Provider, error) {
+	return providers.NewDelegated(os.Getenv("MAIL_PROVIDER"), os.Getenv("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN"), provider.Delegation{
+		Mailbox:     os.Getenv("MAIL_DELEGATED_MAILBOX"),
+		Permissions: splitList(os.Getenv("MAIL_DELEGATED_PERMISSIONS")),
+	})
+}
+
+// splitList splits a comma separated env value, dropping empty entries.
+func splitList(s string) []string {
+	var ret []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
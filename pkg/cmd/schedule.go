@@ -1,17 +1,13 @@
 package cmd
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
 
-	"ethan/pkg/mstoken"
+	"ethan/pkg/approval"
+	"ethan/pkg/provider"
 
-	abstractions "github.com/microsoft/kiota-abstractions-go"
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/spf13/cobra"
 )
 
@@ -27,76 +23,47 @@ type eventOutput struct {
 }
 
 func (s *Schedule) Run(cmd *cobra.Command, _ []string) error {
-	cred := mstoken.NewStaticTokenCredential(os.Getenv("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN"))
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	p, err := mailProvider()
 	if err != nil {
 		return err
 	}
 
-	me, err := client.Me().Get(cmd.Context(), nil)
+	me, err := p.Me(cmd.Context())
 	if err != nil {
 		return err
 	}
 
-	headers := abstractions.NewRequestHeaders()
-	headers.Add("Prefer", "outlook.timezone=\"Pacific Standard Time\"")
-
-	eventRequestBody := graphmodels.NewEvent()
-	subject := os.Getenv("EVENT_SUBJECT")
-	eventRequestBody.SetSubject(&subject)
-	body := graphmodels.NewItemBody()
-	contentType := graphmodels.HTML_BODYTYPE
-	body.SetContentType(&contentType)
-	content := os.Getenv("EVENT_CONTENT")
-	body.SetContent(&content)
-	eventRequestBody.SetBody(body)
-	start := graphmodels.NewDateTimeTimeZone()
-	startTime := os.Getenv("START_TIME")
-	endTime := os.Getenv("END_TIME")
-	timeZone := "Pacific Standard Time"
-	start.SetDateTime(&startTime)
-	start.SetTimeZone(&timeZone)
-	eventRequestBody.SetStart(start)
-	end := graphmodels.NewDateTimeTimeZone()
-	end.SetDateTime(&endTime)
-	end.SetTimeZone(&timeZone)
-	eventRequestBody.SetEnd(end)
-
-	var attendees []graphmodels.Attendeeable
-	for _, addr := range strings.Split(os.Getenv("EMAIL_RECIPIENT"), ",") {
-		email := strings.TrimSpace(addr)
-		attendee := graphmodels.NewAttendee()
-		emailAddress := graphmodels.NewEmailAddress()
-		emailAddress.SetAddress(&email)
-		attendee.SetEmailAddress(emailAddress)
-		attendees = append(attendees, attendee)
+	e := provider.Event{
+		Subject:   os.Getenv("EVENT_SUBJECT"),
+		Content:   os.Getenv("EVENT_CONTENT"),
+		Start:     os.Getenv("START_TIME"),
+		End:       os.Getenv("END_TIME"),
+		TimeZone:  timeZone,
+		Attendees: append(splitList(os.Getenv("EMAIL_RECIPIENT")), me.Email),
 	}
-
-	attendee := graphmodels.NewAttendee()
-	emailAddress := graphmodels.NewEmailAddress()
-	emailAddress.SetAddress(me.GetMail())
-	attendee.SetEmailAddress(emailAddress)
-	attendees = append(attendees, attendee)
-
-	eventRequestBody.SetAttendees(attendees)
-
-	event, err := client.Me().Calendar().Events().Post(context.Background(), eventRequestBody, nil)
+	arguments := approval.Event{
+		Subject:   e.Subject,
+		Content:   e.Content,
+		Start:     e.Start,
+		End:       e.End,
+		TimeZone:  e.TimeZone,
+		Attendees: e.Attendees,
+	}
+	// In a task this does not run: the server holds the event for the user to approve and creates it itself.
+	event, err := p.CreateEvent(cmd.Context(), e)
 	if err != nil {
+		record(cmd.Context(), approval.ToolSchedule, arguments, nil, err)
 		return err
 	}
-
-	var emails []string
-	for _, attendee := range event.GetAttendees() {
-		emails = append(emails, *attendee.GetEmailAddress().GetAddress())
-	}
+	record(cmd.Context(), approval.ToolSchedule, arguments, map[string]string{"eventId": event.ID}, nil)
 
 	o := eventOutput{
-		Subject:   *event.GetSubject(),
-		StartTime: *event.GetStart().GetDateTime(),
-		EndTime:   *event.GetEnd().GetDateTime(),
-		Organizer: *event.GetOrganizer().GetEmailAddress().GetName(),
-		EventID:   *event.GetId(),
-		Emails:    emails,
+		Subject:   event.Subject,
+		StartTime: event.Start,
+		EndTime:   event.End,
+		Organizer: event.Organizer,
+		EventID:   event.ID,
+		Emails:    event.Attendees,
 	}
 
 	data, err := json.MarshalIndent(o, "", "  ")
@@ -1,89 +1,96 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"strconv"
 
-	"ethan/pkg/mstoken"
+	"ethan/pkg/approval"
+	"ethan/pkg/provider"
 
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/spf13/cobra"
 )
 
 type SendEmail struct{}
 
 type emailOutput struct {
-	MessageID      string `json:"messageId"`
-	ConversationID string `json:"conversationId"`
+	MessageID         string `json:"messageId"`
+	ConversationID    string `json:"conversationId"`
+	InternetMessageID string `json:"internetMessageId"`
+	// WebLink opens a draft in the mail client, where the user can edit and send it.
+	WebLink string `json:"webLink,omitempty"`
+	Draft   bool   `json:"draft,omitempty"`
 }
 
 func (s *SendEmail) Run(cmd *cobra.Command, args []string) error {
-	cred := mstoken.NewStaticTokenCredential(os.Getenv("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN"))
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	p, err := mailProvider()
 	if err != nil {
 		return err
 	}
 
-	requestBody := graphmodels.NewMessage()
-	subject := os.Getenv("EMAIL_SUBJECT")
-	requestBody.SetSubject(&subject)
-	body := graphmodels.NewItemBody()
-	contentType := graphmodels.TEXT_BODYTYPE
-	body.SetContentType(&contentType)
-	content := os.Getenv("EMAIL_CONTENT")
-	body.SetContent(&content)
-	requestBody.SetBody(body)
-
-	var toRecipients []graphmodels.Recipientable
-	for _, r := range strings.Split(os.Getenv("EMAIL_RECIPIENT_TO"), ",") {
-		emailAddress := strings.TrimSpace(r)
-		rep := graphmodels.NewRecipient()
-		addr := graphmodels.NewEmailAddress()
-		addr.SetAddress(&emailAddress)
-		rep.SetEmailAddress(addr)
-		toRecipients = append(toRecipients, rep)
+	m := provider.Message{
+		Subject: os.Getenv("EMAIL_SUBJECT"),
+		Body:    os.Getenv("EMAIL_CONTENT"),
+		To:      splitList(os.Getenv("EMAIL_RECIPIENT_TO")),
+		Cc:      splitList(os.Getenv("EMAIL_RECIPIENT_CC")),
+		Bcc:     splitList(os.Getenv("EMAIL_RECIPIENT_BCC")),
 	}
-	requestBody.SetToRecipients(toRecipients)
-
-	var ccRecipients []graphmodels.Recipientable
-	for _, r := range strings.Split(os.Getenv("EMAIL_RECIPIENT_CC"), ",") {
-		emailAddress := strings.TrimSpace(r)
-		rep := graphmodels.NewRecipient()
-		addr := graphmodels.NewEmailAddress()
-		addr.SetAddress(&emailAddress)
-		rep.SetEmailAddress(addr)
-		ccRecipients = append(ccRecipients, rep)
+	arguments := approval.Email{
+		Subject: m.Subject,
+		Body:    m.Body,
+		To:      m.To,
+		Cc:      m.Cc,
+		Bcc:     m.Bcc,
 	}
-	requestBody.SetCcRecipients(ccRecipients)
-
-	var bccRecipients []graphmodels.Recipientable
-	for _, r := range strings.Split(os.Getenv("EMAIL_RECIPIENT_BCC"), ",") {
-		emailAddress := strings.TrimSpace(r)
-		rep := graphmodels.NewRecipient()
-		addr := graphmodels.NewEmailAddress()
-		addr.SetAddress(&emailAddress)
-		rep.SetEmailAddress(addr)
-		bccRecipients = append(bccRecipients, rep)
+	// A draft is left in the mailbox for the user to send. In a task it is the only way the tool runs, the server holds
+	// the emails it would send for the user to approve and sends them itself.
+	if draft, _ := strconv.ParseBool(os.Getenv("EMAIL_DRAFT")); draft {
+		return s.draft(cmd.Context(), p, m)
 	}
-	requestBody.SetBccRecipients(bccRecipients)
-
-	message, err := client.Me().Messages().Post(cmd.Context(), requestBody, nil)
+	message, err := p.SendMessage(cmd.Context(), m)
 	if err != nil {
+		record(cmd.Context(), approval.ToolSendEmail, arguments, nil, err)
 		return err
 	}
+	record(cmd.Context(), approval.ToolSendEmail, arguments, map[string]string{
+		"messageId":         message.ID,
+		"conversationId":    message.ConversationID,
+		"internetMessageId": message.InternetMessageID,
+	}, nil)
+
+	return printEmail(emailOutput{
+		MessageID:         message.ID,
+		ConversationID:    message.ConversationID,
+		InternetMessageID: message.InternetMessageID,
+	})
+}
 
-	if err := client.Me().Messages().ByMessageId(*message.GetId()).Send().Post(cmd.Context(), nil); err != nil {
+// draft saves the email in the Drafts folder of the mailbox and adds it to the drafts of the task.
+func (s *SendEmail) draft(ctx context.Context, p provider.Provider, m provider.Message) error {
+	drafter, ok := p.(provider.Drafter)
+	if !ok {
+		return provider.ErrDraftsUnsupported
+	}
+	message, err := drafter.CreateDraft(ctx, m)
+	if err != nil {
 		return err
 	}
-
-	o := emailOutput{
-		MessageID:      *message.GetId(),
-		ConversationID: *message.GetConversationId(),
+	if err := approval.TrackDraft(ctx, message); err != nil {
+		return err
 	}
 
+	return printEmail(emailOutput{
+		MessageID:         message.ID,
+		ConversationID:    message.ConversationID,
+		InternetMessageID: message.InternetMessageID,
+		WebLink:           message.WebLink,
+		Draft:             true,
+	})
+}
+
+func printEmail(o emailOutput) error {
 	data, err := json.Marshal(o)
 	if err != nil {
 		return err
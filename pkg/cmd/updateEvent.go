@@ -5,9 +5,6 @@ import (
 	"fmt"
 	"os"
 
-	"ethan/pkg/mstoken"
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/spf13/cobra"
 )
 
@@ -20,35 +17,28 @@ type meetingOutput struct {
 }
 
 func (u *UpdateEvent) Run(cmd *cobra.Command, _ []string) error {
-	cred := mstoken.NewStaticTokenCredential(os.Getenv("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN"))
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	p, err := mailProvider()
 	if err != nil {
 		return err
 	}
 
 	eventID := os.Getenv("EVENT_ID")
-	requestBody := graphmodels.NewEvent()
-	isOnlineMeeting := true
-	requestBody.SetIsOnlineMeeting(&isOnlineMeeting)
-	onlineMeetingProvider := graphmodels.TEAMSFORBUSINESS_ONLINEMEETINGPROVIDERTYPE
-	requestBody.SetOnlineMeetingProvider(&onlineMeetingProvider)
-	if _, err := client.Me().Events().ByEventId(eventID).Patch(cmd.Context(), requestBody, nil); err != nil {
-		return err
-	}
-
-	event, err := client.Me().Events().ByEventId(eventID).Get(cmd.Context(), nil)
+	arguments := map[string]any{"eventId": eventID}
+	meeting, err := p.AddOnlineMeeting(cmd.Context(), eventID)
 	if err != nil {
+		record(cmd.Context(), "add-online-meeting", arguments, nil, err)
 		return err
 	}
-	if event.GetOnlineMeeting() != nil {
+	responseIDs := map[string]string{"eventId": eventID}
+	if meeting != nil {
+		responseIDs["conferenceId"] = meeting.ConferenceID
+	}
+	record(cmd.Context(), "add-online-meeting", arguments, responseIDs, nil)
+	if meeting != nil {
 		meetingOutput := meetingOutput{
-			URL:          *event.GetOnlineMeeting().GetJoinUrl(),
-		}
-		if event.GetOnlineMeeting().GetTollNumber() != nil {
-			meetingOutput.TollNumber = *event.GetOnlineMeeting().GetTollNumber()
-		}
-		if event.GetOnlineMeeting().GetConferenceId() != nil {
-			meetingOutput.ConferenceID = *event.GetOnlineMeeting().GetConferenceId()
+			URL:          meeting.URL,
+			TollNumber:   meeting.TollNumber,
+			ConferenceID: meeting.ConferenceID,
 		}
 		data, err := json.MarshalIndent(meetingOutput, "", "  ")
 		if err != nil {
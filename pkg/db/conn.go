@@ -0,0 +1,17 @@
+package db
+
+import (
+	"fmt"
+	"os"
+)
+
+// ConnString is the connection string of the database, from the PG_* environment. The server and the tools it runs
+// share it.
+func ConnString() string {
+	s := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
+		os.Getenv("PG_HOST"), 5432, os.Getenv("PG_USER"), os.Getenv("PG_DBNAME"))
+	if password := os.Getenv("PG_PASSWORD"); password != "" {
+		s += " password=" + password
+	}
+	return s
+}
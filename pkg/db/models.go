@@ -5,18 +5,72 @@
 package db
 
 import (
+	"ethan/pkg/secret"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-type Context struct {
+type ApiToken struct {
+	ID         pgtype.UUID
+	UserID     pgtype.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpireAt   pgtype.Timestamptz
+	LastUsedAt pgtype.Timestamptz
+	CreatedAt  pgtype.Timestamptz
+}
+
+type AuditEvent struct {
 	ID          pgtype.UUID
-	Name        *string
-	Description *string
-	Content     *string
 	UserID      pgtype.UUID
+	Actor       string
+	TaskID      pgtype.UUID
+	Mailbox     string
+	Tool        string
+	Arguments   []byte
+	ResponseIDs []byte
+	Status      string
+	Error       *string
+	CreatedAt   pgtype.Timestamptz
+}
+
+type Context struct {
+	ID             pgtype.UUID
+	Name           *string
+	Description    *string
+	Content        *string
+	UserID         pgtype.UUID
+	CreatedAt      pgtype.Timestamptz
+	OrganizationID pgtype.UUID
+}
+
+type DelegationGrant struct {
+	ID          pgtype.UUID
+	PrincipalID pgtype.UUID
+	DelegateID  pgtype.UUID
+	Mailbox     string
+	Permissions []string
 	CreatedAt   pgtype.Timestamptz
 }
 
+type MailboxConnection struct {
+	ID                      pgtype.UUID
+	UserID                  pgtype.UUID
+	Provider                string
+	Email                   string
+	Token                   secret.String
+	RefreshToken            *secret.String
+	ExpireAt                pgtype.Timestamptz
+	SubscriptionDisabled    bool
+	SubscriptionClientState *string
+	CheckSpam               bool
+	InboxDeltaToken         *string
+	CreatedAt               pgtype.Timestamptz
+	DelegationID            pgtype.UUID
+	CredentialID            pgtype.UUID
+	DelegatedPermissions    []string
+}
+
 type Message struct {
 	ID        pgtype.UUID
 	MessageID *string
@@ -25,17 +79,100 @@ type Message struct {
 	UserID    pgtype.UUID
 	CreatedAt pgtype.Timestamptz
 	Read      *bool
+	Approval  []byte
 }
 
-type SpamEmail struct {
+type OauthState struct {
+	State        string
+	ExpireAt     pgtype.Timestamptz
+	Provider     *string
+	CodeVerifier *string
+	Nonce        *string
+	UserID       pgtype.UUID
+}
+
+type Organization struct {
 	ID        pgtype.UUID
-	MessageID *string
-	Subject   *string
-	EmailBody *string
+	Name      string
+	CreatedAt pgtype.Timestamptz
+}
+
+type OrganizationInvitation struct {
+	ID             pgtype.UUID
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+	Role           string
+	InvitedBy      pgtype.UUID
+	CreatedAt      pgtype.Timestamptz
+}
+
+type OrganizationMember struct {
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+	Role           string
+	CreatedAt      pgtype.Timestamptz
+}
+
+type PendingAction struct {
+	ID            pgtype.UUID
+	UserID        pgtype.UUID
+	TaskID        pgtype.UUID
+	ConnectionID  pgtype.UUID
+	Tool          string
+	Arguments     []byte
+	Status        string
+	Result        []byte
+	Error         *string
+	LinkTokenHash string
+	Reported      bool
+	CreatedAt     pgtype.Timestamptz
+	DecidedAt     pgtype.Timestamptz
+}
+
+type Session struct {
+	ID                       pgtype.UUID
+	UserID                   pgtype.UUID
+	RefreshTokenHash         string
+	PreviousRefreshTokenHash *string
+	UserAgent                *string
+	ExpireAt                 pgtype.Timestamptz
+	RefreshedAt              pgtype.Timestamptz
+	RevokedAt                pgtype.Timestamptz
+	CreatedAt                pgtype.Timestamptz
+}
+
+type SpamCheckSkip struct {
 	UserID    pgtype.UUID
+	MessageID string
 	CreatedAt pgtype.Timestamptz
 }
 
+type SpamEmail struct {
+	ID                pgtype.UUID
+	MessageID         *string
+	Subject           *string
+	EmailBody         *string
+	UserID            pgtype.UUID
+	CreatedAt         pgtype.Timestamptz
+	InternetMessageID *string
+	ConnectionID      pgtype.UUID
+	InboxMessageID    *string
+}
+
+type Subscription struct {
+	ID                   string
+	UserID               pgtype.UUID
+	Kind                 string
+	ExpireAt             pgtype.Timestamptz
+	CreatedAt            pgtype.Timestamptz
+	Status               string
+	LastError            *string
+	RenewedAt            pgtype.Timestamptz
+	LastLifecycleEvent   *string
+	LastLifecycleEventAt pgtype.Timestamptz
+	ConnectionID         pgtype.UUID
+}
+
 type Task struct {
 	ID             pgtype.UUID
 	Name           string
@@ -49,17 +186,64 @@ type Task struct {
 	ConversationID *string
 	ContextIds     []pgtype.UUID
 	State          []byte
+	ConnectionID   pgtype.UUID
+	AssigneeID     pgtype.UUID
+	OrganizationID pgtype.UUID
+}
+
+type TaskDraft struct {
+	ID             pgtype.UUID
+	UserID         pgtype.UUID
+	TaskID         pgtype.UUID
+	ConnectionID   pgtype.UUID
+	MessageID      string
+	ConversationID *string
+	WebLink        *string
+	Subject        string
+	Recipients     []string
+	Status         string
+	CreatedAt      pgtype.Timestamptz
+	UpdatedAt      pgtype.Timestamptz
+	SentAt         pgtype.Timestamptz
 }
 
 type User struct {
-	ID                   pgtype.UUID
-	Name                 string
-	Email                string
-	Token                string
-	RefreshToken         *string
-	SubscriptionID       *string
-	SubscriptionExpireAt pgtype.Timestamptz
-	SubscriptionDisabled *bool
-	ExpireAt             pgtype.Timestamptz
-	CheckSpam            *bool
+	ID                      pgtype.UUID
+	Name                    string
+	Email                   string
+	Token                   secret.String
+	RefreshToken            *secret.String
+	SubscriptionID          *string
+	SubscriptionExpireAt    pgtype.Timestamptz
+	SubscriptionDisabled    *bool
+	ExpireAt                pgtype.Timestamptz
+	CheckSpam               *bool
+	Provider                string
+	SubscriptionClientState *string
+	InboxDeltaToken         *string
+}
+
+type UserIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      pgtype.UUID
+	Email       *string
+	LastLoginAt pgtype.Timestamptz
+	CreatedAt   pgtype.Timestamptz
+}
+
+type WebhookJob struct {
+	ID           pgtype.UUID
+	UserID       pgtype.UUID
+	MessageID    string
+	Status       string
+	Attempts     int32
+	LastError    *string
+	RunAt        pgtype.Timestamptz
+	LockedUntil  pgtype.Timestamptz
+	CreatedAt    pgtype.Timestamptz
+	UpdatedAt    pgtype.Timestamptz
+	Kind         string
+	ChangeType   string
+	ConnectionID pgtype.UUID
 }
@@ -8,325 +8,3021 @@ package db
 import (
 	"context"
 
+	"ethan/pkg/secret"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const createContext = `-- name: CreateContext :one
-INSERT INTO contexts (
-    name, description, content, user_id
-) VALUES (
-    $1, $2, $3, $4
+const acceptOrganizationInvitation = `-- name: AcceptOrganizationInvitation :one
+WITH invitation AS (
+    DELETE FROM organization_invitations
+    WHERE organization_invitations.id = $1 AND organization_invitations.user_id = $2
+    RETURNING organization_invitations.organization_id, organization_invitations.user_id, organization_invitations.role
 )
-RETURNING id, name, description, content, user_id, created_at
+INSERT INTO organization_members (organization_id, user_id, role)
+SELECT invitation.organization_id, invitation.user_id, invitation.role FROM invitation
+ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role
+RETURNING organization_id, user_id, role, created_at
 `
 
-type CreateContextParams struct {
-	Name        *string
-	Description *string
-	Content     *string
-	UserID      pgtype.UUID
+type AcceptOrganizationInvitationParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
 }
 
-func (q *Queries) CreateContext(ctx context.Context, arg CreateContextParams) (Context, error) {
-	row := q.db.QueryRow(ctx, createContext,
-		arg.Name,
-		arg.Description,
-		arg.Content,
-		arg.UserID,
-	)
-	var i Context
+// The invited user becomes a member with the role of the invitation, which is used up.
+func (q *Queries) AcceptOrganizationInvitation(ctx context.Context, arg AcceptOrganizationInvitationParams) (OrganizationMember, error) {
+	row := q.db.QueryRow(ctx, acceptOrganizationInvitation, arg.ID, arg.UserID)
+	var i OrganizationMember
 	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.Content,
+		&i.OrganizationID,
 		&i.UserID,
+		&i.Role,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const createMessage = `-- name: CreateMessage :exec
-INSERT INTO messages (
-    message_id, task_id, content, user_id
-) VALUES (
-    $1, $2, $3, $4
+const addTaskReply = `-- name: AddTaskReply :execrows
+WITH notification AS (
+    INSERT INTO messages (message_id, task_id, content, user_id)
+    VALUES ($2, $3, $4, $5)
+    ON CONFLICT (message_id) DO NOTHING
+    RETURNING messages.task_id
 )
-RETURNING id, message_id, task_id, content, user_id, created_at, read
+UPDATE tasks SET state = $1
+FROM notification WHERE tasks.id = notification.task_id
 `
 
-type CreateMessageParams struct {
+type AddTaskReplyParams struct {
+	State     []byte
 	MessageID *string
 	TaskID    pgtype.UUID
 	Content   *string
 	UserID    pgtype.UUID
 }
 
-func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) error {
-	_, err := q.db.Exec(ctx, createMessage,
+func (q *Queries) AddTaskReply(ctx context.Context, arg AddTaskReplyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addTaskReply,
+		arg.State,
 		arg.MessageID,
 		arg.TaskID,
 		arg.Content,
 		arg.UserID,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const createSpamEmailRecord = `-- name: CreateSpamEmailRecord :exec
-INSERT INTO spam_emails (
-    subject, email_body, user_id, message_id
-) VALUES (
-    $1, $2, $3, $4
-)
+const assignTask = `-- name: AssignTask :execrows
+UPDATE tasks
+SET assignee_id = $3,
+    organization_id = $4
+WHERE id = $1 AND user_id = $2
 `
 
-type CreateSpamEmailRecordParams struct {
-	Subject   *string
-	EmailBody *string
-	UserID    pgtype.UUID
-	MessageID *string
+type AssignTaskParams struct {
+	ID             pgtype.UUID
+	UserID         pgtype.UUID
+	AssigneeID     pgtype.UUID
+	OrganizationID pgtype.UUID
 }
 
-func (q *Queries) CreateSpamEmailRecord(ctx context.Context, arg CreateSpamEmailRecordParams) error {
-	_, err := q.db.Exec(ctx, createSpamEmailRecord,
-		arg.Subject,
-		arg.EmailBody,
+func (q *Queries) AssignTask(ctx context.Context, arg AssignTaskParams) (int64, error) {
+	result, err := q.db.Exec(ctx, assignTask,
+		arg.ID,
 		arg.UserID,
-		arg.MessageID,
+		arg.AssigneeID,
+		arg.OrganizationID,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const createTask = `-- name: CreateTask :one
-INSERT INTO tasks (
-    user_id, name, state, description, tool_definition, context, message_id, message_body, context_ids
-) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9
-)
-RETURNING id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state
+const cancelWebhookJob = `-- name: CancelWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'done',
+    updated_at = now()
+WHERE connection_id = $1 AND kind = $2 AND change_type = $3 AND message_id = $4 AND status = 'pending'
 `
 
-type CreateTaskParams struct {
-	UserID         pgtype.UUID
-	Name           string
-	State          []byte
-	Description    string
-	ToolDefinition *string
-	Context        *string
-	MessageID      *string
-	MessageBody    *string
-	ContextIds     []pgtype.UUID
+type CancelWebhookJobParams struct {
+	ConnectionID pgtype.UUID
+	Kind         string
+	ChangeType   string
+	MessageID    string
 }
 
-func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) (Task, error) {
-	row := q.db.QueryRow(ctx, createTask,
-		arg.UserID,
-		arg.Name,
-		arg.State,
-		arg.Description,
-		arg.ToolDefinition,
-		arg.Context,
+func (q *Queries) CancelWebhookJob(ctx context.Context, arg CancelWebhookJobParams) error {
+	_, err := q.db.Exec(ctx, cancelWebhookJob,
+		arg.ConnectionID,
+		arg.Kind,
+		arg.ChangeType,
 		arg.MessageID,
-		arg.MessageBody,
-		arg.ContextIds,
 	)
-	var i Task
+	return err
+}
+
+const claimWebhookJob = `-- name: ClaimWebhookJob :one
+UPDATE webhook_jobs
+SET status = 'running',
+    attempts = attempts + 1,
+    locked_until = $1,
+    updated_at = now()
+WHERE id = (
+    SELECT id FROM webhook_jobs
+    WHERE (status = 'pending' AND run_at <= now())
+       OR (status = 'running' AND locked_until < now())
+    ORDER BY run_at
+    FOR UPDATE SKIP LOCKED
+    LIMIT 1
+)
+RETURNING id, user_id, message_id, status, attempts, last_error, run_at, locked_until, created_at, updated_at, kind, change_type, connection_id
+`
+
+func (q *Queries) ClaimWebhookJob(ctx context.Context, lockedUntil pgtype.Timestamptz) (WebhookJob, error) {
+	row := q.db.QueryRow(ctx, claimWebhookJob, lockedUntil)
+	var i WebhookJob
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.ToolDefinition,
-		&i.Context,
-		&i.CreatedAt,
 		&i.UserID,
 		&i.MessageID,
-		&i.MessageBody,
-		&i.ConversationID,
-		&i.ContextIds,
-		&i.State,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.RunAt,
+		&i.LockedUntil,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Kind,
+		&i.ChangeType,
+		&i.ConnectionID,
 	)
 	return i, err
 }
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (
-    name, token, refresh_token, email, expire_at
-) VALUES (
-             $1, $2, $3, $4, $5
-         )
-RETURNING id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam
+const clearMailboxConnectionRefreshTokens = `-- name: ClearMailboxConnectionRefreshTokens :exec
+UPDATE mailbox_connections SET refresh_token = NULL WHERE user_id = $1
 `
 
-type CreateUserParams struct {
-	Name         string
-	Token        string
-	RefreshToken *string
-	Email        string
-	ExpireAt     pgtype.Timestamptz
+func (q *Queries) ClearMailboxConnectionRefreshTokens(ctx context.Context, userID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, clearMailboxConnectionRefreshTokens, userID)
+	return err
 }
 
-func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRow(ctx, createUser,
-		arg.Name,
-		arg.Token,
-		arg.RefreshToken,
-		arg.Email,
-		arg.ExpireAt,
-	)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Email,
-		&i.Token,
-		&i.RefreshToken,
-		&i.SubscriptionID,
-		&i.SubscriptionExpireAt,
-		&i.SubscriptionDisabled,
-		&i.ExpireAt,
-		&i.CheckSpam,
-	)
-	return i, err
+const clearMessagesMessageID = `-- name: ClearMessagesMessageID :exec
+UPDATE messages
+SET message_id = NULL
+WHERE user_id = $1 AND message_id = $2
+`
+
+type ClearMessagesMessageIDParams struct {
+	UserID    pgtype.UUID
+	MessageID *string
 }
 
-const deleteContext = `-- name: DeleteContext :exec
-DELETE FROM contexts
-WHERE id = $1
+func (q *Queries) ClearMessagesMessageID(ctx context.Context, arg ClearMessagesMessageIDParams) error {
+	_, err := q.db.Exec(ctx, clearMessagesMessageID, arg.UserID, arg.MessageID)
+	return err
+}
+
+const clearTaskAssignmentsForMember = `-- name: ClearTaskAssignmentsForMember :exec
+UPDATE tasks
+SET assignee_id = NULL,
+    organization_id = NULL
+WHERE organization_id = $1 AND (user_id = $2 OR assignee_id = $2)
 `
 
-func (q *Queries) DeleteContext(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, deleteContext, id)
+type ClearTaskAssignmentsForMemberParams struct {
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+}
+
+func (q *Queries) ClearTaskAssignmentsForMember(ctx context.Context, arg ClearTaskAssignmentsForMemberParams) error {
+	_, err := q.db.Exec(ctx, clearTaskAssignmentsForMember, arg.OrganizationID, arg.UserID)
 	return err
 }
 
-const deleteSpamEmail = `-- name: DeleteSpamEmail :exec
-DELETE FROM spam_emails WHERE id = $1
+const clearTaskMessageID = `-- name: ClearTaskMessageID :exec
+UPDATE tasks
+SET message_id = NULL
+WHERE id = $1
 `
 
-func (q *Queries) DeleteSpamEmail(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, deleteSpamEmail, id)
+func (q *Queries) ClearTaskMessageID(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, clearTaskMessageID, id)
 	return err
 }
 
-const deleteTask = `-- name: DeleteTask :exec
-DELETE FROM tasks
+const completePendingAction = `-- name: CompletePendingAction :one
+UPDATE pending_actions
+SET status = $2,
+    result = $3,
+    error = $4
 WHERE id = $1
+RETURNING id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at
+`
+
+type CompletePendingActionParams struct {
+	ID     pgtype.UUID
+	Status string
+	Result []byte
+	Error  *string
+}
+
+func (q *Queries) CompletePendingAction(ctx context.Context, arg CompletePendingActionParams) (PendingAction, error) {
+	row := q.db.QueryRow(ctx, completePendingAction,
+		arg.ID,
+		arg.Status,
+		arg.Result,
+		arg.Error,
+	)
+	var i PendingAction
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.Tool,
+		&i.Arguments,
+		&i.Status,
+		&i.Result,
+		&i.Error,
+		&i.LinkTokenHash,
+		&i.Reported,
+		&i.CreatedAt,
+		&i.DecidedAt,
+	)
+	return i, err
+}
+
+const completeSpamEmail = `-- name: CompleteSpamEmail :exec
+WITH spam AS (
+    UPDATE spam_emails SET message_id = $2
+    WHERE spam_emails.id = $3
+    RETURNING spam_emails.user_id, spam_emails.message_id
+)
+INSERT INTO messages (message_id, content, user_id)
+SELECT spam.message_id, $1, spam.user_id FROM spam
 `
 
-func (q *Queries) DeleteTask(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, deleteTask, id)
+type CompleteSpamEmailParams struct {
+	Content   *string
+	MessageID *string
+	ID        pgtype.UUID
+}
+
+func (q *Queries) CompleteSpamEmail(ctx context.Context, arg CompleteSpamEmailParams) error {
+	_, err := q.db.Exec(ctx, completeSpamEmail, arg.Content, arg.MessageID, arg.ID)
 	return err
 }
 
-const deleteUser = `-- name: DeleteUser :exec
-DELETE FROM users
+const completeWebhookJob = `-- name: CompleteWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'done',
+    locked_until = null,
+    updated_at = now()
 WHERE id = $1
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, deleteUser, id)
+func (q *Queries) CompleteWebhookJob(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, completeWebhookJob, id)
 	return err
 }
 
-const getContext = `-- name: GetContext :one
-SELECT id, name, description, content, user_id, created_at FROM contexts
-WHERE id = $1 LIMIT 1
+const consumeOAuthState = `-- name: ConsumeOAuthState :one
+DELETE FROM oauth_states WHERE state = $1 AND expire_at > now()
+RETURNING state, expire_at, provider, code_verifier, nonce, user_id
 `
 
-func (q *Queries) GetContext(ctx context.Context, id pgtype.UUID) (Context, error) {
-	row := q.db.QueryRow(ctx, getContext, id)
-	var i Context
+func (q *Queries) ConsumeOAuthState(ctx context.Context, state string) (OauthState, error) {
+	row := q.db.QueryRow(ctx, consumeOAuthState, state)
+	var i OauthState
 	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.Content,
+		&i.State,
+		&i.ExpireAt,
+		&i.Provider,
+		&i.CodeVerifier,
+		&i.Nonce,
 		&i.UserID,
-		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const getMessageFromMessageID = `-- name: GetMessageFromMessageID :one
-SELECT id, message_id, task_id, content, user_id, created_at, read FROM messages
-WHERE message_id = $1 LIMIT 1
+const countContextsForUser = `-- name: CountContextsForUser :one
+SELECT count(*) FROM contexts
+WHERE id = ANY($2::uuid[]) AND (
+    contexts.user_id = $1 OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $1)
+)
 `
 
-func (q *Queries) GetMessageFromMessageID(ctx context.Context, messageID *string) (Message, error) {
-	row := q.db.QueryRow(ctx, getMessageFromMessageID, messageID)
-	var i Message
+type CountContextsForUserParams struct {
+	UserID pgtype.UUID
+	Ids    []pgtype.UUID
+}
+
+func (q *Queries) CountContextsForUser(ctx context.Context, arg CountContextsForUserParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countContextsForUser, arg.UserID, arg.Ids)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countOrganizationOwners = `-- name: CountOrganizationOwners :one
+SELECT count(*) FROM organization_members WHERE organization_id = $1 AND role = 'owner'
+`
+
+func (q *Queries) CountOrganizationOwners(ctx context.Context, organizationID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countOrganizationOwners, organizationID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserIdentities = `-- name: CountUserIdentities :one
+SELECT COUNT(*) FROM user_identities WHERE user_id = $1
+`
+
+func (q *Queries) CountUserIdentities(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countUserIdentities, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAPIToken = `-- name: CreateAPIToken :one
+INSERT INTO api_tokens (
+    user_id, name, token_hash, scopes, expire_at
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+RETURNING id, user_id, name, token_hash, scopes, expire_at, last_used_at, created_at
+`
+
+type CreateAPITokenParams struct {
+	UserID    pgtype.UUID
+	Name      string
+	TokenHash string
+	Scopes    []string
+	ExpireAt  pgtype.Timestamptz
+}
+
+func (q *Queries) CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) (ApiToken, error) {
+	row := q.db.QueryRow(ctx, createAPIToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Scopes,
+		arg.ExpireAt,
+	)
+	var i ApiToken
 	err := row.Scan(
 		&i.ID,
-		&i.MessageID,
-		&i.TaskID,
-		&i.Content,
 		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.ExpireAt,
+		&i.LastUsedAt,
 		&i.CreatedAt,
-		&i.Read,
 	)
 	return i, err
 }
 
-const getMessageFromUserID = `-- name: GetMessageFromUserID :many
-SELECT id, message_id, task_id, content, user_id, created_at, read FROM messages
-WHERE user_id = $1 ORDER BY created_at DESC
+const createApprovalMessage = `-- name: CreateApprovalMessage :exec
+INSERT INTO messages (
+    task_id, content, user_id, approval
+) VALUES (
+    $1, $2, $3, $4
+)
 `
 
-func (q *Queries) GetMessageFromUserID(ctx context.Context, userID pgtype.UUID) ([]Message, error) {
-	rows, err := q.db.Query(ctx, getMessageFromUserID, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Message
-	for rows.Next() {
-		var i Message
-		if err := rows.Scan(
-			&i.ID,
-			&i.MessageID,
-			&i.TaskID,
-			&i.Content,
-			&i.UserID,
-			&i.CreatedAt,
-			&i.Read,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+type CreateApprovalMessageParams struct {
+	TaskID   pgtype.UUID
+	Content  *string
+	UserID   pgtype.UUID
+	Approval []byte
 }
 
-const getMessageFromUserIDAndTaskID = `-- name: GetMessageFromUserIDAndTaskID :many
-SELECT id, message_id, task_id, content, user_id, created_at, read FROM messages
-WHERE user_id = $1 and task_id = $2 ORDER BY created_at DESC
+func (q *Queries) CreateApprovalMessage(ctx context.Context, arg CreateApprovalMessageParams) error {
+	_, err := q.db.Exec(ctx, createApprovalMessage,
+		arg.TaskID,
+		arg.Content,
+		arg.UserID,
+		arg.Approval,
+	)
+	return err
+}
+
+const createAuditEvent = `-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (user_id, actor, task_id, mailbox, tool, arguments, response_ids, status, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 `
 
-type GetMessageFromUserIDAndTaskIDParams struct {
-	UserID pgtype.UUID
-	TaskID pgtype.UUID
+type CreateAuditEventParams struct {
+	UserID      pgtype.UUID
+	Actor       string
+	TaskID      pgtype.UUID
+	Mailbox     string
+	Tool        string
+	Arguments   []byte
+	ResponseIDs []byte
+	Status      string
+	Error       *string
 }
 
-func (q *Queries) GetMessageFromUserIDAndTaskID(ctx context.Context, arg GetMessageFromUserIDAndTaskIDParams) ([]Message, error) {
-	rows, err := q.db.Query(ctx, getMessageFromUserIDAndTaskID, arg.UserID, arg.TaskID)
-	if err != nil {
+func (q *Queries) CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error {
+	_, err := q.db.Exec(ctx, createAuditEvent,
+		arg.UserID,
+		arg.Actor,
+		arg.TaskID,
+		arg.Mailbox,
+		arg.Tool,
+		arg.Arguments,
+		arg.ResponseIDs,
+		arg.Status,
+		arg.Error,
+	)
+	return err
+}
+
+const createContext = `-- name: CreateContext :one
+INSERT INTO contexts (
+    name, description, content, user_id, organization_id
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+RETURNING id, name, description, content, user_id, created_at, organization_id
+`
+
+type CreateContextParams struct {
+	Name           *string
+	Description    *string
+	Content        *string
+	UserID         pgtype.UUID
+	OrganizationID pgtype.UUID
+}
+
+func (q *Queries) CreateContext(ctx context.Context, arg CreateContextParams) (Context, error) {
+	row := q.db.QueryRow(ctx, createContext,
+		arg.Name,
+		arg.Description,
+		arg.Content,
+		arg.UserID,
+		arg.OrganizationID,
+	)
+	var i Context
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Content,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const createDelegatedMailboxConnection = `-- name: CreateDelegatedMailboxConnection :one
+INSERT INTO mailbox_connections (
+    user_id, provider, email, token, expire_at, delegation_id, credential_id, delegated_permissions
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8
+)
+ON CONFLICT (email) WHERE delegation_id IS NOT NULL DO UPDATE
+SET token = excluded.token,
+    expire_at = excluded.expire_at,
+    credential_id = excluded.credential_id,
+    delegated_permissions = excluded.delegated_permissions
+WHERE mailbox_connections.delegation_id = excluded.delegation_id
+RETURNING id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions
+`
+
+type CreateDelegatedMailboxConnectionParams struct {
+	UserID               pgtype.UUID
+	Provider             string
+	Email                string
+	Token                secret.String
+	ExpireAt             pgtype.Timestamptz
+	DelegationID         pgtype.UUID
+	CredentialID         pgtype.UUID
+	DelegatedPermissions []string
+}
+
+func (q *Queries) CreateDelegatedMailboxConnection(ctx context.Context, arg CreateDelegatedMailboxConnectionParams) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, createDelegatedMailboxConnection,
+		arg.UserID,
+		arg.Provider,
+		arg.Email,
+		arg.Token,
+		arg.ExpireAt,
+		arg.DelegationID,
+		arg.CredentialID,
+		arg.DelegatedPermissions,
+	)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const createMailboxConnection = `-- name: CreateMailboxConnection :one
+INSERT INTO mailbox_connections (
+    user_id, provider, email, token, refresh_token, expire_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+ON CONFLICT (email) WHERE delegation_id IS NULL DO UPDATE
+SET provider = excluded.provider,
+    token = excluded.token,
+    refresh_token = excluded.refresh_token,
+    expire_at = excluded.expire_at
+WHERE mailbox_connections.user_id = excluded.user_id
+RETURNING id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions
+`
+
+type CreateMailboxConnectionParams struct {
+	UserID       pgtype.UUID
+	Provider     string
+	Email        string
+	Token        secret.String
+	RefreshToken *secret.String
+	ExpireAt     pgtype.Timestamptz
+}
+
+func (q *Queries) CreateMailboxConnection(ctx context.Context, arg CreateMailboxConnectionParams) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, createMailboxConnection,
+		arg.UserID,
+		arg.Provider,
+		arg.Email,
+		arg.Token,
+		arg.RefreshToken,
+		arg.ExpireAt,
+	)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const createMessage = `-- name: CreateMessage :exec
+INSERT INTO messages (
+    message_id, task_id, content, user_id
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING id, message_id, task_id, content, user_id, created_at, read, approval
+`
+
+type CreateMessageParams struct {
+	MessageID *string
+	TaskID    pgtype.UUID
+	Content   *string
+	UserID    pgtype.UUID
+}
+
+func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) error {
+	_, err := q.db.Exec(ctx, createMessage,
+		arg.MessageID,
+		arg.TaskID,
+		arg.Content,
+		arg.UserID,
+	)
+	return err
+}
+
+const createOAuthState = `-- name: CreateOAuthState :exec
+INSERT INTO oauth_states (
+    state, expire_at, provider, code_verifier, nonce, user_id
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+`
+
+type CreateOAuthStateParams struct {
+	State        string
+	ExpireAt     pgtype.Timestamptz
+	Provider     *string
+	CodeVerifier *string
+	Nonce        *string
+	UserID       pgtype.UUID
+}
+
+func (q *Queries) CreateOAuthState(ctx context.Context, arg CreateOAuthStateParams) error {
+	_, err := q.db.Exec(ctx, createOAuthState,
+		arg.State,
+		arg.ExpireAt,
+		arg.Provider,
+		arg.CodeVerifier,
+		arg.Nonce,
+		arg.UserID,
+	)
+	return err
+}
+
+const createOrganization = `-- name: CreateOrganization :one
+INSERT INTO organizations (name) VALUES ($1) RETURNING id, name, created_at
+`
+
+func (q *Queries) CreateOrganization(ctx context.Context, name string) (Organization, error) {
+	row := q.db.QueryRow(ctx, createOrganization, name)
+	var i Organization
+	err := row.Scan(&i.ID, &i.Name, &i.CreatedAt)
+	return i, err
+}
+
+const createPendingAction = `-- name: CreatePendingAction :one
+INSERT INTO pending_actions (user_id, task_id, connection_id, tool, arguments, link_token_hash)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at
+`
+
+type CreatePendingActionParams struct {
+	UserID        pgtype.UUID
+	TaskID        pgtype.UUID
+	ConnectionID  pgtype.UUID
+	Tool          string
+	Arguments     []byte
+	LinkTokenHash string
+}
+
+func (q *Queries) CreatePendingAction(ctx context.Context, arg CreatePendingActionParams) (PendingAction, error) {
+	row := q.db.QueryRow(ctx, createPendingAction,
+		arg.UserID,
+		arg.TaskID,
+		arg.ConnectionID,
+		arg.Tool,
+		arg.Arguments,
+		arg.LinkTokenHash,
+	)
+	var i PendingAction
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.Tool,
+		&i.Arguments,
+		&i.Status,
+		&i.Result,
+		&i.Error,
+		&i.LinkTokenHash,
+		&i.Reported,
+		&i.CreatedAt,
+		&i.DecidedAt,
+	)
+	return i, err
+}
+
+const createSession = `-- name: CreateSession :one
+INSERT INTO sessions (
+    user_id, refresh_token_hash, user_agent, expire_at
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING id, user_id, refresh_token_hash, previous_refresh_token_hash, user_agent, expire_at, refreshed_at, revoked_at, created_at
+`
+
+type CreateSessionParams struct {
+	UserID           pgtype.UUID
+	RefreshTokenHash string
+	UserAgent        *string
+	ExpireAt         pgtype.Timestamptz
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, createSession,
+		arg.UserID,
+		arg.RefreshTokenHash,
+		arg.UserAgent,
+		arg.ExpireAt,
+	)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshTokenHash,
+		&i.PreviousRefreshTokenHash,
+		&i.UserAgent,
+		&i.ExpireAt,
+		&i.RefreshedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createSpamCheckSkip = `-- name: CreateSpamCheckSkip :exec
+INSERT INTO spam_check_skips (
+    user_id, message_id
+) VALUES (
+    $1, $2
+)
+ON CONFLICT DO NOTHING
+`
+
+type CreateSpamCheckSkipParams struct {
+	UserID    pgtype.UUID
+	MessageID string
+}
+
+func (q *Queries) CreateSpamCheckSkip(ctx context.Context, arg CreateSpamCheckSkipParams) error {
+	_, err := q.db.Exec(ctx, createSpamCheckSkip, arg.UserID, arg.MessageID)
+	return err
+}
+
+const createSpamEmailRecord = `-- name: CreateSpamEmailRecord :one
+INSERT INTO spam_emails (
+    subject, email_body, user_id, message_id, internet_message_id, connection_id, inbox_message_id
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+)
+RETURNING id, message_id, subject, email_body, user_id, created_at, internet_message_id, connection_id, inbox_message_id
+`
+
+type CreateSpamEmailRecordParams struct {
+	Subject           *string
+	EmailBody         *string
+	UserID            pgtype.UUID
+	MessageID         *string
+	InternetMessageID *string
+	ConnectionID      pgtype.UUID
+	InboxMessageID    *string
+}
+
+func (q *Queries) CreateSpamEmailRecord(ctx context.Context, arg CreateSpamEmailRecordParams) (SpamEmail, error) {
+	row := q.db.QueryRow(ctx, createSpamEmailRecord,
+		arg.Subject,
+		arg.EmailBody,
+		arg.UserID,
+		arg.MessageID,
+		arg.InternetMessageID,
+		arg.ConnectionID,
+		arg.InboxMessageID,
+	)
+	var i SpamEmail
+	err := row.Scan(
+		&i.ID,
+		&i.MessageID,
+		&i.Subject,
+		&i.EmailBody,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.InternetMessageID,
+		&i.ConnectionID,
+		&i.InboxMessageID,
+	)
+	return i, err
+}
+
+const createTask = `-- name: CreateTask :one
+INSERT INTO tasks (
+    user_id, name, state, description, tool_definition, context, message_id, message_body, context_ids, connection_id
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state, connection_id, assignee_id, organization_id
+`
+
+type CreateTaskParams struct {
+	UserID         pgtype.UUID
+	Name           string
+	State          []byte
+	Description    string
+	ToolDefinition *string
+	Context        *string
+	MessageID      *string
+	MessageBody    *string
+	ContextIds     []pgtype.UUID
+	ConnectionID   pgtype.UUID
+}
+
+func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) (Task, error) {
+	row := q.db.QueryRow(ctx, createTask,
+		arg.UserID,
+		arg.Name,
+		arg.State,
+		arg.Description,
+		arg.ToolDefinition,
+		arg.Context,
+		arg.MessageID,
+		arg.MessageBody,
+		arg.ContextIds,
+		arg.ConnectionID,
+	)
+	var i Task
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.ToolDefinition,
+		&i.Context,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.MessageID,
+		&i.MessageBody,
+		&i.ConversationID,
+		&i.ContextIds,
+		&i.State,
+		&i.ConnectionID,
+		&i.AssigneeID,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const createTaskDraft = `-- name: CreateTaskDraft :one
+INSERT INTO task_drafts (user_id, task_id, connection_id, message_id, conversation_id, web_link, subject, recipients)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, user_id, task_id, connection_id, message_id, conversation_id, web_link, subject, recipients, status, created_at, updated_at, sent_at
+`
+
+type CreateTaskDraftParams struct {
+	UserID         pgtype.UUID
+	TaskID         pgtype.UUID
+	ConnectionID   pgtype.UUID
+	MessageID      string
+	ConversationID *string
+	WebLink        *string
+	Subject        string
+	Recipients     []string
+}
+
+func (q *Queries) CreateTaskDraft(ctx context.Context, arg CreateTaskDraftParams) (TaskDraft, error) {
+	row := q.db.QueryRow(ctx, createTaskDraft,
+		arg.UserID,
+		arg.TaskID,
+		arg.ConnectionID,
+		arg.MessageID,
+		arg.ConversationID,
+		arg.WebLink,
+		arg.Subject,
+		arg.Recipients,
+	)
+	var i TaskDraft
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.MessageID,
+		&i.ConversationID,
+		&i.WebLink,
+		&i.Subject,
+		&i.Recipients,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const createTaskFromMessage = `-- name: CreateTaskFromMessage :exec
+WITH task AS (
+    INSERT INTO tasks (
+        user_id, name, description, tool_definition, message_id, message_body, connection_id
+    ) VALUES (
+        $2, $3, $4, $5,
+        $6, $7, $8
+    )
+    RETURNING tasks.id, tasks.message_id, tasks.user_id
+)
+INSERT INTO messages (message_id, task_id, content, user_id)
+SELECT task.message_id, task.id, $1, task.user_id FROM task
+`
+
+type CreateTaskFromMessageParams struct {
+	Content        *string
+	UserID         pgtype.UUID
+	Name           string
+	Description    string
+	ToolDefinition *string
+	MessageID      *string
+	MessageBody    *string
+	ConnectionID   pgtype.UUID
+}
+
+func (q *Queries) CreateTaskFromMessage(ctx context.Context, arg CreateTaskFromMessageParams) error {
+	_, err := q.db.Exec(ctx, createTaskFromMessage,
+		arg.Content,
+		arg.UserID,
+		arg.Name,
+		arg.Description,
+		arg.ToolDefinition,
+		arg.MessageID,
+		arg.MessageBody,
+		arg.ConnectionID,
+	)
+	return err
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (
+    name, email
+) VALUES (
+    $1, $2
+)
+RETURNING id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam, provider, subscription_client_state, inbox_delta_token
+`
+
+type CreateUserParams struct {
+	Name  string
+	Email string
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, createUser, arg.Name, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.SubscriptionID,
+		&i.SubscriptionExpireAt,
+		&i.SubscriptionDisabled,
+		&i.ExpireAt,
+		&i.CheckSpam,
+		&i.Provider,
+		&i.SubscriptionClientState,
+		&i.InboxDeltaToken,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (
+    issuer, subject, user_id, email, last_login_at
+) VALUES (
+    $1, $2, $3, $4, now()
+)
+`
+
+type CreateUserIdentityParams struct {
+	Issuer  string
+	Subject string
+	UserID  pgtype.UUID
+	Email   *string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error {
+	_, err := q.db.Exec(ctx, createUserIdentity,
+		arg.Issuer,
+		arg.Subject,
+		arg.UserID,
+		arg.Email,
+	)
+	return err
+}
+
+const decidePendingAction = `-- name: DecidePendingAction :one
+UPDATE pending_actions
+SET status = $3,
+    arguments = COALESCE($4, arguments),
+    decided_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND status = 'pending'
+RETURNING id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at
+`
+
+type DecidePendingActionParams struct {
+	ID        pgtype.UUID
+	UserID    pgtype.UUID
+	Status    string
+	Arguments []byte
+}
+
+// Only a pending action can be decided, so concurrent decisions run it once.
+func (q *Queries) DecidePendingAction(ctx context.Context, arg DecidePendingActionParams) (PendingAction, error) {
+	row := q.db.QueryRow(ctx, decidePendingAction,
+		arg.ID,
+		arg.UserID,
+		arg.Status,
+		arg.Arguments,
+	)
+	var i PendingAction
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.Tool,
+		&i.Arguments,
+		&i.Status,
+		&i.Result,
+		&i.Error,
+		&i.LinkTokenHash,
+		&i.Reported,
+		&i.CreatedAt,
+		&i.DecidedAt,
+	)
+	return i, err
+}
+
+const deleteAPIToken = `-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens WHERE id = $1 AND user_id = $2
+`
+
+type DeleteAPITokenParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteAPIToken(ctx context.Context, arg DeleteAPITokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteAPIToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteContext = `-- name: DeleteContext :execrows
+DELETE FROM contexts
+WHERE id = $1 AND (
+    contexts.user_id = $2
+    OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $2 AND m.role = 'owner')
+)
+`
+
+type DeleteContextParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteContext(ctx context.Context, arg DeleteContextParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteContext, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteDelegationGrant = `-- name: DeleteDelegationGrant :execrows
+DELETE FROM delegation_grants WHERE id = $1 AND (principal_id = $2 OR delegate_id = $2)
+`
+
+type DeleteDelegationGrantParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteDelegationGrant(ctx context.Context, arg DeleteDelegationGrantParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteDelegationGrant, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredOAuthStates = `-- name: DeleteExpiredOAuthStates :exec
+DELETE FROM oauth_states WHERE expire_at < now()
+`
+
+func (q *Queries) DeleteExpiredOAuthStates(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredOAuthStates)
+	return err
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions WHERE expire_at < now() - interval '7 days'
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredSessions)
+	return err
+}
+
+const deleteFinishedWebhookJobs = `-- name: DeleteFinishedWebhookJobs :exec
+DELETE FROM webhook_jobs
+WHERE status = 'done' AND updated_at < $1
+`
+
+func (q *Queries) DeleteFinishedWebhookJobs(ctx context.Context, updatedAt pgtype.Timestamptz) error {
+	_, err := q.db.Exec(ctx, deleteFinishedWebhookJobs, updatedAt)
+	return err
+}
+
+const deleteMailboxConnection = `-- name: DeleteMailboxConnection :execrows
+DELETE FROM mailbox_connections WHERE id = $1 AND user_id = $2
+`
+
+type DeleteMailboxConnectionParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteMailboxConnection(ctx context.Context, arg DeleteMailboxConnectionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteMailboxConnection, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteMovedBackSpamEmail = `-- name: DeleteMovedBackSpamEmail :exec
+WITH spam AS (
+    DELETE FROM spam_emails WHERE spam_emails.id = $2
+    RETURNING spam_emails.user_id
+), skip AS (
+    INSERT INTO spam_check_skips (user_id, message_id)
+    SELECT spam.user_id, $3 FROM spam
+    ON CONFLICT DO NOTHING
+)
+INSERT INTO messages (content, user_id)
+SELECT $1, spam.user_id FROM spam
+`
+
+type DeleteMovedBackSpamEmailParams struct {
+	Content   *string
+	ID        pgtype.UUID
+	MessageID string
+}
+
+func (q *Queries) DeleteMovedBackSpamEmail(ctx context.Context, arg DeleteMovedBackSpamEmailParams) error {
+	_, err := q.db.Exec(ctx, deleteMovedBackSpamEmail, arg.Content, arg.ID, arg.MessageID)
+	return err
+}
+
+const deleteOrganization = `-- name: DeleteOrganization :execrows
+DELETE FROM organizations WHERE id = $1
+`
+
+func (q *Queries) DeleteOrganization(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOrganization, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOrganizationInvitation = `-- name: DeleteOrganizationInvitation :execrows
+DELETE FROM organization_invitations WHERE id = $1 AND organization_id = $2
+`
+
+type DeleteOrganizationInvitationParams struct {
+	ID             pgtype.UUID
+	OrganizationID pgtype.UUID
+}
+
+func (q *Queries) DeleteOrganizationInvitation(ctx context.Context, arg DeleteOrganizationInvitationParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOrganizationInvitation, arg.ID, arg.OrganizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOrganizationInvitationForUser = `-- name: DeleteOrganizationInvitationForUser :execrows
+DELETE FROM organization_invitations WHERE id = $1 AND user_id = $2
+`
+
+type DeleteOrganizationInvitationForUserParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteOrganizationInvitationForUser(ctx context.Context, arg DeleteOrganizationInvitationForUserParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOrganizationInvitationForUser, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOrganizationMember = `-- name: DeleteOrganizationMember :execrows
+DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2
+`
+
+type DeleteOrganizationMemberParams struct {
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+}
+
+func (q *Queries) DeleteOrganizationMember(ctx context.Context, arg DeleteOrganizationMemberParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOrganizationMember, arg.OrganizationID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSpamCheckSkipsBefore = `-- name: DeleteSpamCheckSkipsBefore :exec
+DELETE FROM spam_check_skips WHERE created_at < $1
+`
+
+func (q *Queries) DeleteSpamCheckSkipsBefore(ctx context.Context, createdAt pgtype.Timestamptz) error {
+	_, err := q.db.Exec(ctx, deleteSpamCheckSkipsBefore, createdAt)
+	return err
+}
+
+const deleteSpamEmail = `-- name: DeleteSpamEmail :execrows
+DELETE FROM spam_emails WHERE id = $1 AND user_id = $2
+`
+
+type DeleteSpamEmailParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteSpamEmail(ctx context.Context, arg DeleteSpamEmailParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSpamEmail, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSpamEmailFromMessageID = `-- name: DeleteSpamEmailFromMessageID :exec
+DELETE FROM spam_emails WHERE connection_id = $1 AND message_id = $2
+`
+
+type DeleteSpamEmailFromMessageIDParams struct {
+	ConnectionID pgtype.UUID
+	MessageID    *string
+}
+
+func (q *Queries) DeleteSpamEmailFromMessageID(ctx context.Context, arg DeleteSpamEmailFromMessageIDParams) error {
+	_, err := q.db.Exec(ctx, deleteSpamEmailFromMessageID, arg.ConnectionID, arg.MessageID)
+	return err
+}
+
+const deleteSubscription = `-- name: DeleteSubscription :exec
+DELETE FROM subscriptions WHERE id = $1
+`
+
+func (q *Queries) DeleteSubscription(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, deleteSubscription, id)
+	return err
+}
+
+const deleteTask = `-- name: DeleteTask :execrows
+DELETE FROM tasks
+WHERE id = $1 AND user_id = $2
+`
+
+type DeleteTaskParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteTask(ctx context.Context, arg DeleteTaskParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteTask, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteTaskDraft = `-- name: DeleteTaskDraft :exec
+DELETE FROM task_drafts WHERE id = $1
+`
+
+func (q *Queries) DeleteTaskDraft(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteTaskDraft, id)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteUser, id)
+	return err
+}
+
+const enqueueWebhookJob = `-- name: EnqueueWebhookJob :exec
+INSERT INTO webhook_jobs (
+    user_id, connection_id, message_id, kind, change_type
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+ON CONFLICT (connection_id, kind, change_type, message_id) DO UPDATE
+SET status = 'pending',
+    attempts = 0,
+    run_at = now(),
+    last_error = null,
+    updated_at = now()
+WHERE $6::boolean AND webhook_jobs.status IN ('done', 'dead')
+`
+
+type EnqueueWebhookJobParams struct {
+	UserID       pgtype.UUID
+	ConnectionID pgtype.UUID
+	MessageID    string
+	Kind         string
+	ChangeType   string
+	Rearm        bool
+}
+
+func (q *Queries) EnqueueWebhookJob(ctx context.Context, arg EnqueueWebhookJobParams) error {
+	_, err := q.db.Exec(ctx, enqueueWebhookJob,
+		arg.UserID,
+		arg.ConnectionID,
+		arg.MessageID,
+		arg.Kind,
+		arg.ChangeType,
+		arg.Rearm,
+	)
+	return err
+}
+
+const failWebhookJob = `-- name: FailWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'dead',
+    last_error = $2,
+    locked_until = null,
+    updated_at = now()
+WHERE id = $1
+`
+
+type FailWebhookJobParams struct {
+	ID        pgtype.UUID
+	LastError *string
+}
+
+func (q *Queries) FailWebhookJob(ctx context.Context, arg FailWebhookJobParams) error {
+	_, err := q.db.Exec(ctx, failWebhookJob, arg.ID, arg.LastError)
+	return err
+}
+
+const getAPITokenUser = `-- name: GetAPITokenUser :one
+SELECT api_tokens.id AS token_id, api_tokens.scopes, users.id, users.name, users.email
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+WHERE api_tokens.token_hash = $1 AND api_tokens.expire_at > now()
+`
+
+type GetAPITokenUserRow struct {
+	TokenID pgtype.UUID
+	Scopes  []string
+	ID      pgtype.UUID
+	Name    string
+	Email   string
+}
+
+func (q *Queries) GetAPITokenUser(ctx context.Context, tokenHash string) (GetAPITokenUserRow, error) {
+	row := q.db.QueryRow(ctx, getAPITokenUser, tokenHash)
+	var i GetAPITokenUserRow
+	err := row.Scan(
+		&i.TokenID,
+		&i.Scopes,
+		&i.ID,
+		&i.Name,
+		&i.Email,
+	)
+	return i, err
+}
+
+const getActiveSession = `-- name: GetActiveSession :one
+SELECT id, user_id, refresh_token_hash, previous_refresh_token_hash, user_agent, expire_at, refreshed_at, revoked_at, created_at FROM sessions
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expire_at > now()
+`
+
+type GetActiveSessionParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) GetActiveSession(ctx context.Context, arg GetActiveSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, getActiveSession, arg.ID, arg.UserID)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshTokenHash,
+		&i.PreviousRefreshTokenHash,
+		&i.UserAgent,
+		&i.ExpireAt,
+		&i.RefreshedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getContext = `-- name: GetContext :one
+SELECT id, name, description, content, user_id, created_at, organization_id FROM contexts
+WHERE id = $1 AND (
+    contexts.user_id = $2 OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $2)
+) LIMIT 1
+`
+
+type GetContextParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) GetContext(ctx context.Context, arg GetContextParams) (Context, error) {
+	row := q.db.QueryRow(ctx, getContext, arg.ID, arg.UserID)
+	var i Context
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Content,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const getDefaultGraphMailboxConnection = `-- name: GetDefaultGraphMailboxConnection :one
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections
+WHERE user_id = $1 AND provider = 'graph' AND delegation_id IS NULL
+ORDER BY created_at, id LIMIT 1
+`
+
+func (q *Queries) GetDefaultGraphMailboxConnection(ctx context.Context, userID pgtype.UUID) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, getDefaultGraphMailboxConnection, userID)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const getDefaultMailboxConnection = `-- name: GetDefaultMailboxConnection :one
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections WHERE user_id = $1 ORDER BY created_at, id LIMIT 1
+`
+
+func (q *Queries) GetDefaultMailboxConnection(ctx context.Context, userID pgtype.UUID) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, getDefaultMailboxConnection, userID)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const getDelegatedMailboxConnectionFromEmail = `-- name: GetDelegatedMailboxConnectionFromEmail :one
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections WHERE email = $1 AND delegation_id IS NOT NULL
+`
+
+func (q *Queries) GetDelegatedMailboxConnectionFromEmail(ctx context.Context, email string) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, getDelegatedMailboxConnectionFromEmail, email)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const getDelegationGrant = `-- name: GetDelegationGrant :one
+SELECT delegation_grants.id, delegation_grants.principal_id, delegation_grants.delegate_id, delegation_grants.mailbox, delegation_grants.permissions, delegation_grants.created_at, principals.name AS principal_name, delegates.name AS delegate_name,
+       delegates.email AS delegate_email, mailbox_connections.id AS mailbox_id
+FROM delegation_grants
+JOIN users principals ON principals.id = delegation_grants.principal_id
+JOIN users delegates ON delegates.id = delegation_grants.delegate_id
+LEFT JOIN mailbox_connections ON mailbox_connections.delegation_id = delegation_grants.id
+WHERE delegation_grants.id = $1
+  AND (delegation_grants.principal_id = $2 OR delegation_grants.delegate_id = $2)
+`
+
+type GetDelegationGrantParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+type GetDelegationGrantRow struct {
+	DelegationGrant DelegationGrant
+	PrincipalName   string
+	DelegateName    string
+	DelegateEmail   string
+	MailboxID       pgtype.UUID
+}
+
+func (q *Queries) GetDelegationGrant(ctx context.Context, arg GetDelegationGrantParams) (GetDelegationGrantRow, error) {
+	row := q.db.QueryRow(ctx, getDelegationGrant, arg.ID, arg.UserID)
+	var i GetDelegationGrantRow
+	err := row.Scan(
+		&i.DelegationGrant.ID,
+		&i.DelegationGrant.PrincipalID,
+		&i.DelegationGrant.DelegateID,
+		&i.DelegationGrant.Mailbox,
+		&i.DelegationGrant.Permissions,
+		&i.DelegationGrant.CreatedAt,
+		&i.PrincipalName,
+		&i.DelegateName,
+		&i.DelegateEmail,
+		&i.MailboxID,
+	)
+	return i, err
+}
+
+const getMailboxConnection = `-- name: GetMailboxConnection :one
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections WHERE id = $1
+`
+
+func (q *Queries) GetMailboxConnection(ctx context.Context, id pgtype.UUID) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, getMailboxConnection, id)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const getMailboxConnectionForUser = `-- name: GetMailboxConnectionForUser :one
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections WHERE id = $1 AND user_id = $2
+`
+
+type GetMailboxConnectionForUserParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) GetMailboxConnectionForUser(ctx context.Context, arg GetMailboxConnectionForUserParams) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, getMailboxConnectionForUser, arg.ID, arg.UserID)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const getMailboxConnectionFromEmail = `-- name: GetMailboxConnectionFromEmail :one
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections WHERE email = $1 AND delegation_id IS NULL
+`
+
+// The connection of the mailbox's owner, not one a delegate has for it.
+func (q *Queries) GetMailboxConnectionFromEmail(ctx context.Context, email string) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, getMailboxConnectionFromEmail, email)
+	var i MailboxConnection
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
+		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
+	)
+	return i, err
+}
+
+const getMessageFromMessageID = `-- name: GetMessageFromMessageID :one
+SELECT id, message_id, task_id, content, user_id, created_at, read, approval FROM messages
+WHERE message_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetMessageFromMessageID(ctx context.Context, messageID *string) (Message, error) {
+	row := q.db.QueryRow(ctx, getMessageFromMessageID, messageID)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.MessageID,
+		&i.TaskID,
+		&i.Content,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.Read,
+		&i.Approval,
+	)
+	return i, err
+}
+
+const getMessageFromUserID = `-- name: GetMessageFromUserID :many
+SELECT id, message_id, task_id, content, user_id, created_at, read, approval FROM messages
+WHERE user_id = $1 ORDER BY created_at DESC
+`
+
+func (q *Queries) GetMessageFromUserID(ctx context.Context, userID pgtype.UUID) ([]Message, error) {
+	rows, err := q.db.Query(ctx, getMessageFromUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.TaskID,
+			&i.Content,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.Read,
+			&i.Approval,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessageFromUserIDAndTaskID = `-- name: GetMessageFromUserIDAndTaskID :many
+SELECT id, message_id, task_id, content, user_id, created_at, read, approval FROM messages
+WHERE user_id = $1 and task_id = $2 ORDER BY created_at DESC
+`
+
+type GetMessageFromUserIDAndTaskIDParams struct {
+	UserID pgtype.UUID
+	TaskID pgtype.UUID
+}
+
+func (q *Queries) GetMessageFromUserIDAndTaskID(ctx context.Context, arg GetMessageFromUserIDAndTaskIDParams) ([]Message, error) {
+	rows, err := q.db.Query(ctx, getMessageFromUserIDAndTaskID, arg.UserID, arg.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.TaskID,
+			&i.Content,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.Read,
+			&i.Approval,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrganizationForMember = `-- name: GetOrganizationForMember :one
+SELECT organizations.id, organizations.name, organizations.created_at, organization_members.role FROM organizations
+JOIN organization_members ON organization_members.organization_id = organizations.id
+WHERE organizations.id = $1 AND organization_members.user_id = $2
+`
+
+type GetOrganizationForMemberParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+type GetOrganizationForMemberRow struct {
+	Organization Organization
+	Role         string
+}
+
+func (q *Queries) GetOrganizationForMember(ctx context.Context, arg GetOrganizationForMemberParams) (GetOrganizationForMemberRow, error) {
+	row := q.db.QueryRow(ctx, getOrganizationForMember, arg.ID, arg.UserID)
+	var i GetOrganizationForMemberRow
+	err := row.Scan(
+		&i.Organization.ID,
+		&i.Organization.Name,
+		&i.Organization.CreatedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const getOrganizationMember = `-- name: GetOrganizationMember :one
+SELECT organization_id, user_id, role, created_at FROM organization_members WHERE organization_id = $1 AND user_id = $2
+`
+
+type GetOrganizationMemberParams struct {
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+}
+
+func (q *Queries) GetOrganizationMember(ctx context.Context, arg GetOrganizationMemberParams) (OrganizationMember, error) {
+	row := q.db.QueryRow(ctx, getOrganizationMember, arg.OrganizationID, arg.UserID)
+	var i OrganizationMember
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.UserID,
+		&i.Role,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getPendingAction = `-- name: GetPendingAction :one
+SELECT id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at FROM pending_actions WHERE id = $1 AND user_id = $2
+`
+
+type GetPendingActionParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) GetPendingAction(ctx context.Context, arg GetPendingActionParams) (PendingAction, error) {
+	row := q.db.QueryRow(ctx, getPendingAction, arg.ID, arg.UserID)
+	var i PendingAction
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.Tool,
+		&i.Arguments,
+		&i.Status,
+		&i.Result,
+		&i.Error,
+		&i.LinkTokenHash,
+		&i.Reported,
+		&i.CreatedAt,
+		&i.DecidedAt,
+	)
+	return i, err
+}
+
+const getPendingActionByLinkToken = `-- name: GetPendingActionByLinkToken :one
+SELECT id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at FROM pending_actions WHERE id = $1 AND link_token_hash = $2
+`
+
+type GetPendingActionByLinkTokenParams struct {
+	ID            pgtype.UUID
+	LinkTokenHash string
+}
+
+func (q *Queries) GetPendingActionByLinkToken(ctx context.Context, arg GetPendingActionByLinkTokenParams) (PendingAction, error) {
+	row := q.db.QueryRow(ctx, getPendingActionByLinkToken, arg.ID, arg.LinkTokenHash)
+	var i PendingAction
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.Tool,
+		&i.Arguments,
+		&i.Status,
+		&i.Result,
+		&i.Error,
+		&i.LinkTokenHash,
+		&i.Reported,
+		&i.CreatedAt,
+		&i.DecidedAt,
+	)
+	return i, err
+}
+
+const getSessionByPreviousRefreshToken = `-- name: GetSessionByPreviousRefreshToken :one
+SELECT id, user_id, refresh_token_hash, previous_refresh_token_hash, user_agent, expire_at, refreshed_at, revoked_at, created_at FROM sessions WHERE previous_refresh_token_hash = $1::text
+`
+
+func (q *Queries) GetSessionByPreviousRefreshToken(ctx context.Context, hash string) (Session, error) {
+	row := q.db.QueryRow(ctx, getSessionByPreviousRefreshToken, hash)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshTokenHash,
+		&i.PreviousRefreshTokenHash,
+		&i.UserAgent,
+		&i.ExpireAt,
+		&i.RefreshedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getSpamEmail = `-- name: GetSpamEmail :one
+SELECT id, message_id, subject, email_body, user_id, created_at, internet_message_id, connection_id, inbox_message_id FROM spam_emails WHERE id = $1 AND user_id = $2
+`
+
+type GetSpamEmailParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) GetSpamEmail(ctx context.Context, arg GetSpamEmailParams) (SpamEmail, error) {
+	row := q.db.QueryRow(ctx, getSpamEmail, arg.ID, arg.UserID)
+	var i SpamEmail
+	err := row.Scan(
+		&i.ID,
+		&i.MessageID,
+		&i.Subject,
+		&i.EmailBody,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.InternetMessageID,
+		&i.ConnectionID,
+		&i.InboxMessageID,
+	)
+	return i, err
+}
+
+const getSpamEmailFromInboxMessageID = `-- name: GetSpamEmailFromInboxMessageID :one
+SELECT id, message_id, subject, email_body, user_id, created_at, internet_message_id, connection_id, inbox_message_id FROM spam_emails WHERE connection_id = $1 AND inbox_message_id = $2
+`
+
+type GetSpamEmailFromInboxMessageIDParams struct {
+	ConnectionID   pgtype.UUID
+	InboxMessageID *string
+}
+
+func (q *Queries) GetSpamEmailFromInboxMessageID(ctx context.Context, arg GetSpamEmailFromInboxMessageIDParams) (SpamEmail, error) {
+	row := q.db.QueryRow(ctx, getSpamEmailFromInboxMessageID, arg.ConnectionID, arg.InboxMessageID)
+	var i SpamEmail
+	err := row.Scan(
+		&i.ID,
+		&i.MessageID,
+		&i.Subject,
+		&i.EmailBody,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.InternetMessageID,
+		&i.ConnectionID,
+		&i.InboxMessageID,
+	)
+	return i, err
+}
+
+const getSpamEmailFromInternetMessageID = `-- name: GetSpamEmailFromInternetMessageID :one
+SELECT id, message_id, subject, email_body, user_id, created_at, internet_message_id, connection_id, inbox_message_id FROM spam_emails
+WHERE connection_id = $1 AND internet_message_id = $2 AND message_id IS NOT NULL LIMIT 1
+`
+
+type GetSpamEmailFromInternetMessageIDParams struct {
+	ConnectionID      pgtype.UUID
+	InternetMessageID *string
+}
+
+func (q *Queries) GetSpamEmailFromInternetMessageID(ctx context.Context, arg GetSpamEmailFromInternetMessageIDParams) (SpamEmail, error) {
+	row := q.db.QueryRow(ctx, getSpamEmailFromInternetMessageID, arg.ConnectionID, arg.InternetMessageID)
+	var i SpamEmail
+	err := row.Scan(
+		&i.ID,
+		&i.MessageID,
+		&i.Subject,
+		&i.EmailBody,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.InternetMessageID,
+		&i.ConnectionID,
+		&i.InboxMessageID,
+	)
+	return i, err
+}
+
+const getSubscription = `-- name: GetSubscription :one
+SELECT id, user_id, kind, expire_at, created_at, status, last_error, renewed_at, last_lifecycle_event, last_lifecycle_event_at, connection_id FROM subscriptions WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetSubscription(ctx context.Context, id string) (Subscription, error) {
+	row := q.db.QueryRow(ctx, getSubscription, id)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Kind,
+		&i.ExpireAt,
+		&i.CreatedAt,
+		&i.Status,
+		&i.LastError,
+		&i.RenewedAt,
+		&i.LastLifecycleEvent,
+		&i.LastLifecycleEventAt,
+		&i.ConnectionID,
+	)
+	return i, err
+}
+
+const getTask = `-- name: GetTask :one
+SELECT id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state, connection_id, assignee_id, organization_id FROM tasks
+WHERE id = $1 AND (user_id = $2 OR (assignee_id = $2 AND organization_id IS NOT NULL)) LIMIT 1
+`
+
+type GetTaskParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) GetTask(ctx context.Context, arg GetTaskParams) (Task, error) {
+	row := q.db.QueryRow(ctx, getTask, arg.ID, arg.UserID)
+	var i Task
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.ToolDefinition,
+		&i.Context,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.MessageID,
+		&i.MessageBody,
+		&i.ConversationID,
+		&i.ContextIds,
+		&i.State,
+		&i.ConnectionID,
+		&i.AssigneeID,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const getTaskDraft = `-- name: GetTaskDraft :one
+SELECT id, user_id, task_id, connection_id, message_id, conversation_id, web_link, subject, recipients, status, created_at, updated_at, sent_at FROM task_drafts WHERE id = $1 AND task_id = $2
+`
+
+type GetTaskDraftParams struct {
+	ID     pgtype.UUID
+	TaskID pgtype.UUID
+}
+
+func (q *Queries) GetTaskDraft(ctx context.Context, arg GetTaskDraftParams) (TaskDraft, error) {
+	row := q.db.QueryRow(ctx, getTaskDraft, arg.ID, arg.TaskID)
+	var i TaskDraft
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.MessageID,
+		&i.ConversationID,
+		&i.WebLink,
+		&i.Subject,
+		&i.Recipients,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const getTaskFromConversationID = `-- name: GetTaskFromConversationID :one
+SELECT id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state, connection_id, assignee_id, organization_id FROM tasks
+WHERE conversation_id = $1 AND connection_id = $2 ORDER BY created_at DESC LIMIT 1
+`
+
+type GetTaskFromConversationIDParams struct {
+	ConversationID *string
+	ConnectionID   pgtype.UUID
+}
+
+func (q *Queries) GetTaskFromConversationID(ctx context.Context, arg GetTaskFromConversationIDParams) (Task, error) {
+	row := q.db.QueryRow(ctx, getTaskFromConversationID, arg.ConversationID, arg.ConnectionID)
+	var i Task
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.ToolDefinition,
+		&i.Context,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.MessageID,
+		&i.MessageBody,
+		&i.ConversationID,
+		&i.ContextIds,
+		&i.State,
+		&i.ConnectionID,
+		&i.AssigneeID,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const getTaskFromMessageID = `-- name: GetTaskFromMessageID :one
+SELECT id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state, connection_id, assignee_id, organization_id FROM tasks
+WHERE connection_id = $1 AND message_id = $2 LIMIT 1
+`
+
+type GetTaskFromMessageIDParams struct {
+	ConnectionID pgtype.UUID
+	MessageID    *string
+}
+
+func (q *Queries) GetTaskFromMessageID(ctx context.Context, arg GetTaskFromMessageIDParams) (Task, error) {
+	row := q.db.QueryRow(ctx, getTaskFromMessageID, arg.ConnectionID, arg.MessageID)
+	var i Task
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.ToolDefinition,
+		&i.Context,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.MessageID,
+		&i.MessageBody,
+		&i.ConversationID,
+		&i.ContextIds,
+		&i.State,
+		&i.ConnectionID,
+		&i.AssigneeID,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const getTaskFromUserID = `-- name: GetTaskFromUserID :many
+SELECT id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state, connection_id, assignee_id, organization_id FROM tasks
+WHERE user_id = $1 OR (assignee_id = $1 AND organization_id IS NOT NULL) ORDER BY created_at DESC
+`
+
+func (q *Queries) GetTaskFromUserID(ctx context.Context, userID pgtype.UUID) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTaskFromUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.ToolDefinition,
+			&i.Context,
+			&i.CreatedAt,
+			&i.UserID,
+			&i.MessageID,
+			&i.MessageBody,
+			&i.ConversationID,
+			&i.ContextIds,
+			&i.State,
+			&i.ConnectionID,
+			&i.AssigneeID,
+			&i.OrganizationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam, provider, subscription_client_state, inbox_delta_token FROM users
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id pgtype.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.SubscriptionID,
+		&i.SubscriptionExpireAt,
+		&i.SubscriptionDisabled,
+		&i.ExpireAt,
+		&i.CheckSpam,
+		&i.Provider,
+		&i.SubscriptionClientState,
+		&i.InboxDeltaToken,
+	)
+	return i, err
+}
+
+const getUserFromEmail = `-- name: GetUserFromEmail :one
+SELECT id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam, provider, subscription_client_state, inbox_delta_token FROM users
+WHERE email = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserFromEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserFromEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.SubscriptionID,
+		&i.SubscriptionExpireAt,
+		&i.SubscriptionDisabled,
+		&i.ExpireAt,
+		&i.CheckSpam,
+		&i.Provider,
+		&i.SubscriptionClientState,
+		&i.InboxDeltaToken,
+	)
+	return i, err
+}
+
+const getUserFromSubscriptionID = `-- name: GetUserFromSubscriptionID :one
+SELECT id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam, provider, subscription_client_state, inbox_delta_token FROM users
+WHERE subscription_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserFromSubscriptionID(ctx context.Context, subscriptionID *string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserFromSubscriptionID, subscriptionID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Token,
+		&i.RefreshToken,
+		&i.SubscriptionID,
+		&i.SubscriptionExpireAt,
+		&i.SubscriptionDisabled,
+		&i.ExpireAt,
+		&i.CheckSpam,
+		&i.Provider,
+		&i.SubscriptionClientState,
+		&i.InboxDeltaToken,
+	)
+	return i, err
+}
+
+const getUserIdentity = `-- name: GetUserIdentity :one
+SELECT issuer, subject, user_id, email, last_login_at, created_at FROM user_identities WHERE issuer = $1 AND subject = $2
+`
+
+type GetUserIdentityParams struct {
+	Issuer  string
+	Subject string
+}
+
+func (q *Queries) GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, getUserIdentity, arg.Issuer, arg.Subject)
+	var i UserIdentity
+	err := row.Scan(
+		&i.Issuer,
+		&i.Subject,
+		&i.UserID,
+		&i.Email,
+		&i.LastLoginAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const hasOwnerMailboxConnection = `-- name: HasOwnerMailboxConnection :one
+SELECT EXISTS (SELECT 1 FROM mailbox_connections WHERE email = $1 AND delegation_id IS NULL)
+`
+
+func (q *Queries) HasOwnerMailboxConnection(ctx context.Context, email string) (bool, error) {
+	row := q.db.QueryRow(ctx, hasOwnerMailboxConnection, email)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const isMessageProcessed = `-- name: IsMessageProcessed :one
+SELECT (EXISTS (
+    SELECT 1 FROM webhook_jobs j
+    WHERE j.connection_id = $1 AND j.kind = 'inbox' AND j.change_type = 'created' AND j.message_id = $2::text
+) OR EXISTS (
+    SELECT 1 FROM messages m WHERE m.user_id = $3 AND m.message_id = $2::text
+) OR EXISTS (
+    SELECT 1 FROM tasks t WHERE t.connection_id = $1 AND t.message_id = $2::text
+) OR EXISTS (
+    SELECT 1 FROM spam_emails s WHERE s.connection_id = $1
+        AND (s.message_id = $2::text OR s.inbox_message_id = $2::text)
+))::boolean AS processed
+`
+
+type IsMessageProcessedParams struct {
+	ConnectionID pgtype.UUID
+	MessageID    string
+	UserID       pgtype.UUID
+}
+
+func (q *Queries) IsMessageProcessed(ctx context.Context, arg IsMessageProcessedParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isMessageProcessed, arg.ConnectionID, arg.MessageID, arg.UserID)
+	var processed bool
+	err := row.Scan(&processed)
+	return processed, err
+}
+
+const isSpamCheckSkipped = `-- name: IsSpamCheckSkipped :one
+SELECT EXISTS (
+    SELECT 1 FROM spam_check_skips WHERE user_id = $1 AND message_id = $2
+)
+`
+
+type IsSpamCheckSkippedParams struct {
+	UserID    pgtype.UUID
+	MessageID string
+}
+
+func (q *Queries) IsSpamCheckSkipped(ctx context.Context, arg IsSpamCheckSkippedParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isSpamCheckSkipped, arg.UserID, arg.MessageID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listAPITokensForUser = `-- name: ListAPITokensForUser :many
+SELECT id, user_id, name, token_hash, scopes, expire_at, last_used_at, created_at FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC
+`
+
+func (q *Queries) ListAPITokensForUser(ctx context.Context, userID pgtype.UUID) ([]ApiToken, error) {
+	rows, err := q.db.Query(ctx, listAPITokensForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiToken
+	for rows.Next() {
+		var i ApiToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.TokenHash,
+			&i.Scopes,
+			&i.ExpireAt,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listActiveSessionsForUser = `-- name: ListActiveSessionsForUser :many
+SELECT id, user_id, refresh_token_hash, previous_refresh_token_hash, user_agent, expire_at, refreshed_at, revoked_at, created_at FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expire_at > now()
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListActiveSessionsForUser(ctx context.Context, userID pgtype.UUID) ([]Session, error) {
+	rows, err := q.db.Query(ctx, listActiveSessionsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.RefreshTokenHash,
+			&i.PreviousRefreshTokenHash,
+			&i.UserAgent,
+			&i.ExpireAt,
+			&i.RefreshedAt,
+			&i.RevokedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+SELECT id, user_id, actor, task_id, mailbox, tool, arguments, response_ids, status, error, created_at FROM audit_events
+WHERE (audit_events.user_id = $1
+       OR audit_events.mailbox IN (
+           SELECT users.email FROM users WHERE users.id = $1
+           UNION
+           SELECT mailbox_connections.email FROM mailbox_connections
+           WHERE mailbox_connections.user_id = $1 AND mailbox_connections.delegation_id IS NULL)
+       OR audit_events.task_id IN (SELECT tasks.id FROM tasks WHERE tasks.user_id = $1))
+  AND ($2::uuid IS NULL OR audit_events.task_id = $2)
+  AND ($3::text IS NULL OR audit_events.tool = $3)
+  AND ($4::text IS NULL OR audit_events.actor = $4)
+  AND ($5::text IS NULL OR audit_events.mailbox = $5)
+  AND ($6::timestamptz IS NULL OR audit_events.created_at >= $6)
+  AND ($7::timestamptz IS NULL OR audit_events.created_at < $7)
+ORDER BY audit_events.created_at DESC, audit_events.id
+LIMIT $8
+`
+
+type ListAuditEventsParams struct {
+	UserID    pgtype.UUID
+	TaskID    pgtype.UUID
+	Tool      *string
+	Actor     *string
+	Mailbox   *string
+	Since     pgtype.Timestamptz
+	Until     pgtype.Timestamptz
+	MaxEvents int32
+}
+
+// A user sees the actions taken for them, those taken on their own mailboxes, such as by a delegate, and those of
+// the runs of their tasks.
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.Query(ctx, listAuditEvents,
+		arg.UserID,
+		arg.TaskID,
+		arg.Tool,
+		arg.Actor,
+		arg.Mailbox,
+		arg.Since,
+		arg.Until,
+		arg.MaxEvents,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Actor,
+			&i.TaskID,
+			&i.Mailbox,
+			&i.Tool,
+			&i.Arguments,
+			&i.ResponseIDs,
+			&i.Status,
+			&i.Error,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listContextsForUser = `-- name: ListContextsForUser :many
+SELECT id, name, description, content, user_id, created_at, organization_id FROM contexts
+WHERE contexts.user_id = $1 OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $1)
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListContextsForUser(ctx context.Context, userID pgtype.UUID) ([]Context, error) {
+	rows, err := q.db.Query(ctx, listContextsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Context
+	for rows.Next() {
+		var i Context
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Content,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.OrganizationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDelegationGrantsForUser = `-- name: ListDelegationGrantsForUser :many
+SELECT delegation_grants.id, delegation_grants.principal_id, delegation_grants.delegate_id, delegation_grants.mailbox, delegation_grants.permissions, delegation_grants.created_at, principals.name AS principal_name, delegates.name AS delegate_name,
+       delegates.email AS delegate_email, mailbox_connections.id AS mailbox_id
+FROM delegation_grants
+JOIN users principals ON principals.id = delegation_grants.principal_id
+JOIN users delegates ON delegates.id = delegation_grants.delegate_id
+LEFT JOIN mailbox_connections ON mailbox_connections.delegation_id = delegation_grants.id
+WHERE delegation_grants.principal_id = $1 OR delegation_grants.delegate_id = $1
+ORDER BY delegation_grants.created_at, delegation_grants.id
+`
+
+type ListDelegationGrantsForUserRow struct {
+	DelegationGrant DelegationGrant
+	PrincipalName   string
+	DelegateName    string
+	DelegateEmail   string
+	MailboxID       pgtype.UUID
+}
+
+func (q *Queries) ListDelegationGrantsForUser(ctx context.Context, userID pgtype.UUID) ([]ListDelegationGrantsForUserRow, error) {
+	rows, err := q.db.Query(ctx, listDelegationGrantsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDelegationGrantsForUserRow
+	for rows.Next() {
+		var i ListDelegationGrantsForUserRow
+		if err := rows.Scan(
+			&i.DelegationGrant.ID,
+			&i.DelegationGrant.PrincipalID,
+			&i.DelegationGrant.DelegateID,
+			&i.DelegationGrant.Mailbox,
+			&i.DelegationGrant.Permissions,
+			&i.DelegationGrant.CreatedAt,
+			&i.PrincipalName,
+			&i.DelegateName,
+			&i.DelegateEmail,
+			&i.MailboxID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMailboxConnectionRawTokens = `-- name: ListMailboxConnectionRawTokens :many
+SELECT id, token::text AS raw_token, COALESCE(refresh_token, '')::text AS raw_refresh_token FROM mailbox_connections
+`
+
+type ListMailboxConnectionRawTokensRow struct {
+	ID              pgtype.UUID
+	RawToken        string
+	RawRefreshToken string
+}
+
+func (q *Queries) ListMailboxConnectionRawTokens(ctx context.Context) ([]ListMailboxConnectionRawTokensRow, error) {
+	rows, err := q.db.Query(ctx, listMailboxConnectionRawTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMailboxConnectionRawTokensRow
+	for rows.Next() {
+		var i ListMailboxConnectionRawTokensRow
+		if err := rows.Scan(&i.ID, &i.RawToken, &i.RawRefreshToken); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMailboxConnections = `-- name: ListMailboxConnections :many
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections ORDER BY created_at, id
+`
+
+func (q *Queries) ListMailboxConnections(ctx context.Context) ([]MailboxConnection, error) {
+	rows, err := q.db.Query(ctx, listMailboxConnections)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MailboxConnection
+	for rows.Next() {
+		var i MailboxConnection
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Provider,
+			&i.Email,
+			&i.Token,
+			&i.RefreshToken,
+			&i.ExpireAt,
+			&i.SubscriptionDisabled,
+			&i.SubscriptionClientState,
+			&i.CheckSpam,
+			&i.InboxDeltaToken,
+			&i.CreatedAt,
+			&i.DelegationID,
+			&i.CredentialID,
+			&i.DelegatedPermissions,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMailboxConnectionsForUser = `-- name: ListMailboxConnectionsForUser :many
+SELECT id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions FROM mailbox_connections WHERE user_id = $1 ORDER BY created_at, id
+`
+
+func (q *Queries) ListMailboxConnectionsForUser(ctx context.Context, userID pgtype.UUID) ([]MailboxConnection, error) {
+	rows, err := q.db.Query(ctx, listMailboxConnectionsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MailboxConnection
+	for rows.Next() {
+		var i MailboxConnection
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Provider,
+			&i.Email,
+			&i.Token,
+			&i.RefreshToken,
+			&i.ExpireAt,
+			&i.SubscriptionDisabled,
+			&i.SubscriptionClientState,
+			&i.CheckSpam,
+			&i.InboxDeltaToken,
+			&i.CreatedAt,
+			&i.DelegationID,
+			&i.CredentialID,
+			&i.DelegatedPermissions,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationInvitations = `-- name: ListOrganizationInvitations :many
+SELECT organization_invitations.id, organization_invitations.organization_id, organization_invitations.user_id, organization_invitations.role, organization_invitations.invited_by, organization_invitations.created_at, organizations.name AS organization_name, users.name, users.email,
+       COALESCE(inviters.name, '') AS inviter_name
+FROM organization_invitations
+JOIN organizations ON organizations.id = organization_invitations.organization_id
+JOIN users ON users.id = organization_invitations.user_id
+LEFT JOIN users inviters ON inviters.id = organization_invitations.invited_by
+WHERE organization_invitations.organization_id = $1
+ORDER BY organization_invitations.created_at, users.email
+`
+
+type ListOrganizationInvitationsRow struct {
+	OrganizationInvitation OrganizationInvitation
+	OrganizationName       string
+	Name                   string
+	Email                  string
+	InviterName            string
+}
+
+func (q *Queries) ListOrganizationInvitations(ctx context.Context, organizationID pgtype.UUID) ([]ListOrganizationInvitationsRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationInvitations, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrganizationInvitationsRow
+	for rows.Next() {
+		var i ListOrganizationInvitationsRow
+		if err := rows.Scan(
+			&i.OrganizationInvitation.ID,
+			&i.OrganizationInvitation.OrganizationID,
+			&i.OrganizationInvitation.UserID,
+			&i.OrganizationInvitation.Role,
+			&i.OrganizationInvitation.InvitedBy,
+			&i.OrganizationInvitation.CreatedAt,
+			&i.OrganizationName,
+			&i.Name,
+			&i.Email,
+			&i.InviterName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationInvitationsForUser = `-- name: ListOrganizationInvitationsForUser :many
+SELECT organization_invitations.id, organization_invitations.organization_id, organization_invitations.user_id, organization_invitations.role, organization_invitations.invited_by, organization_invitations.created_at, organizations.name AS organization_name, users.name, users.email,
+       COALESCE(inviters.name, '') AS inviter_name
+FROM organization_invitations
+JOIN organizations ON organizations.id = organization_invitations.organization_id
+JOIN users ON users.id = organization_invitations.user_id
+LEFT JOIN users inviters ON inviters.id = organization_invitations.invited_by
+WHERE organization_invitations.user_id = $1
+ORDER BY organization_invitations.created_at DESC, organization_invitations.id
+`
+
+type ListOrganizationInvitationsForUserRow struct {
+	OrganizationInvitation OrganizationInvitation
+	OrganizationName       string
+	Name                   string
+	Email                  string
+	InviterName            string
+}
+
+func (q *Queries) ListOrganizationInvitationsForUser(ctx context.Context, userID pgtype.UUID) ([]ListOrganizationInvitationsForUserRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationInvitationsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrganizationInvitationsForUserRow
+	for rows.Next() {
+		var i ListOrganizationInvitationsForUserRow
+		if err := rows.Scan(
+			&i.OrganizationInvitation.ID,
+			&i.OrganizationInvitation.OrganizationID,
+			&i.OrganizationInvitation.UserID,
+			&i.OrganizationInvitation.Role,
+			&i.OrganizationInvitation.InvitedBy,
+			&i.OrganizationInvitation.CreatedAt,
+			&i.OrganizationName,
+			&i.Name,
+			&i.Email,
+			&i.InviterName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationMembers = `-- name: ListOrganizationMembers :many
+SELECT organization_members.organization_id, organization_members.user_id, organization_members.role, organization_members.created_at, users.name, users.email FROM organization_members
+JOIN users ON users.id = organization_members.user_id
+WHERE organization_members.organization_id = $1
+ORDER BY organization_members.created_at, users.email
+`
+
+type ListOrganizationMembersRow struct {
+	OrganizationMember OrganizationMember
+	Name               string
+	Email              string
+}
+
+func (q *Queries) ListOrganizationMembers(ctx context.Context, organizationID pgtype.UUID) ([]ListOrganizationMembersRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationMembers, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrganizationMembersRow
+	for rows.Next() {
+		var i ListOrganizationMembersRow
+		if err := rows.Scan(
+			&i.OrganizationMember.OrganizationID,
+			&i.OrganizationMember.UserID,
+			&i.OrganizationMember.Role,
+			&i.OrganizationMember.CreatedAt,
+			&i.Name,
+			&i.Email,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationsForUser = `-- name: ListOrganizationsForUser :many
+SELECT organizations.id, organizations.name, organizations.created_at, organization_members.role FROM organizations
+JOIN organization_members ON organization_members.organization_id = organizations.id
+WHERE organization_members.user_id = $1
+ORDER BY organizations.created_at, organizations.id
+`
+
+type ListOrganizationsForUserRow struct {
+	Organization Organization
+	Role         string
+}
+
+func (q *Queries) ListOrganizationsForUser(ctx context.Context, userID pgtype.UUID) ([]ListOrganizationsForUserRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrganizationsForUserRow
+	for rows.Next() {
+		var i ListOrganizationsForUserRow
+		if err := rows.Scan(
+			&i.Organization.ID,
+			&i.Organization.Name,
+			&i.Organization.CreatedAt,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingActions = `-- name: ListPendingActions :many
+SELECT id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at FROM pending_actions
+WHERE user_id = $1
+  AND ($2::uuid IS NULL OR task_id = $2)
+  AND ($3::text IS NULL OR status = $3)
+ORDER BY created_at DESC, id
+`
+
+type ListPendingActionsParams struct {
+	UserID pgtype.UUID
+	TaskID pgtype.UUID
+	Status *string
+}
+
+func (q *Queries) ListPendingActions(ctx context.Context, arg ListPendingActionsParams) ([]PendingAction, error) {
+	rows, err := q.db.Query(ctx, listPendingActions, arg.UserID, arg.TaskID, arg.Status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PendingAction
+	for rows.Next() {
+		var i PendingAction
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.TaskID,
+			&i.ConnectionID,
+			&i.Tool,
+			&i.Arguments,
+			&i.Status,
+			&i.Result,
+			&i.Error,
+			&i.LinkTokenHash,
+			&i.Reported,
+			&i.CreatedAt,
+			&i.DecidedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingActionsForTask = `-- name: ListPendingActionsForTask :many
+SELECT id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at FROM pending_actions WHERE task_id = $1 AND status = 'pending' ORDER BY created_at, id
+`
+
+func (q *Queries) ListPendingActionsForTask(ctx context.Context, taskID pgtype.UUID) ([]PendingAction, error) {
+	rows, err := q.db.Query(ctx, listPendingActionsForTask, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PendingAction
+	for rows.Next() {
+		var i PendingAction
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.TaskID,
+			&i.ConnectionID,
+			&i.Tool,
+			&i.Arguments,
+			&i.Status,
+			&i.Result,
+			&i.Error,
+			&i.LinkTokenHash,
+			&i.Reported,
+			&i.CreatedAt,
+			&i.DecidedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSpamEmails = `-- name: ListSpamEmails :many
+SELECT id, message_id, subject, email_body, user_id, created_at, internet_message_id, connection_id, inbox_message_id FROM spam_emails WHERE user_id = $1
+`
+
+func (q *Queries) ListSpamEmails(ctx context.Context, userID pgtype.UUID) ([]SpamEmail, error) {
+	rows, err := q.db.Query(ctx, listSpamEmails, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SpamEmail
+	for rows.Next() {
+		var i SpamEmail
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.Subject,
+			&i.EmailBody,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.InternetMessageID,
+			&i.ConnectionID,
+			&i.InboxMessageID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSubscriptionsForConnection = `-- name: ListSubscriptionsForConnection :many
+SELECT id, user_id, kind, expire_at, created_at, status, last_error, renewed_at, last_lifecycle_event, last_lifecycle_event_at, connection_id FROM subscriptions WHERE connection_id = $1 ORDER BY kind
+`
+
+func (q *Queries) ListSubscriptionsForConnection(ctx context.Context, connectionID pgtype.UUID) ([]Subscription, error) {
+	rows, err := q.db.Query(ctx, listSubscriptionsForConnection, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Subscription
+	for rows.Next() {
+		var i Subscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Kind,
+			&i.ExpireAt,
+			&i.CreatedAt,
+			&i.Status,
+			&i.LastError,
+			&i.RenewedAt,
+			&i.LastLifecycleEvent,
+			&i.LastLifecycleEventAt,
+			&i.ConnectionID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSubscriptionsForUser = `-- name: ListSubscriptionsForUser :many
+SELECT id, user_id, kind, expire_at, created_at, status, last_error, renewed_at, last_lifecycle_event, last_lifecycle_event_at, connection_id FROM subscriptions WHERE user_id = $1 ORDER BY kind
+`
+
+func (q *Queries) ListSubscriptionsForUser(ctx context.Context, userID pgtype.UUID) ([]Subscription, error) {
+	rows, err := q.db.Query(ctx, listSubscriptionsForUser, userID)
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Message
+	var items []Subscription
 	for rows.Next() {
-		var i Message
+		var i Subscription
 		if err := rows.Scan(
 			&i.ID,
-			&i.MessageID,
-			&i.TaskID,
-			&i.Content,
 			&i.UserID,
+			&i.Kind,
+			&i.ExpireAt,
 			&i.CreatedAt,
-			&i.Read,
+			&i.Status,
+			&i.LastError,
+			&i.RenewedAt,
+			&i.LastLifecycleEvent,
+			&i.LastLifecycleEventAt,
+			&i.ConnectionID,
 		); err != nil {
 			return nil, err
 		}
@@ -338,101 +3034,172 @@ func (q *Queries) GetMessageFromUserIDAndTaskID(ctx context.Context, arg GetMess
 	return items, nil
 }
 
-const getSpamEmail = `-- name: GetSpamEmail :one
-SELECT id, message_id, subject, email_body, user_id, created_at FROM spam_emails WHERE id = $1
+const listTaskDrafts = `-- name: ListTaskDrafts :many
+SELECT id, user_id, task_id, connection_id, message_id, conversation_id, web_link, subject, recipients, status, created_at, updated_at, sent_at FROM task_drafts WHERE task_id = $1 ORDER BY created_at, id
 `
 
-func (q *Queries) GetSpamEmail(ctx context.Context, id pgtype.UUID) (SpamEmail, error) {
-	row := q.db.QueryRow(ctx, getSpamEmail, id)
-	var i SpamEmail
-	err := row.Scan(
-		&i.ID,
-		&i.MessageID,
-		&i.Subject,
-		&i.EmailBody,
-		&i.UserID,
-		&i.CreatedAt,
-	)
-	return i, err
+func (q *Queries) ListTaskDrafts(ctx context.Context, taskID pgtype.UUID) ([]TaskDraft, error) {
+	rows, err := q.db.Query(ctx, listTaskDrafts, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskDraft
+	for rows.Next() {
+		var i TaskDraft
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.TaskID,
+			&i.ConnectionID,
+			&i.MessageID,
+			&i.ConversationID,
+			&i.WebLink,
+			&i.Subject,
+			&i.Recipients,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getTask = `-- name: GetTask :one
-SELECT id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state FROM tasks
-WHERE id = $1 LIMIT 1
+const markTaskDraftSent = `-- name: MarkTaskDraftSent :one
+UPDATE task_drafts
+SET status = 'sent',
+    sent_at = CURRENT_TIMESTAMP,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND status = 'draft'
+RETURNING id, user_id, task_id, connection_id, message_id, conversation_id, web_link, subject, recipients, status, created_at, updated_at, sent_at
 `
 
-func (q *Queries) GetTask(ctx context.Context, id pgtype.UUID) (Task, error) {
-	row := q.db.QueryRow(ctx, getTask, id)
-	var i Task
+// Only a draft can be marked sent, so concurrent sends of the same draft send it once.
+func (q *Queries) MarkTaskDraftSent(ctx context.Context, id pgtype.UUID) (TaskDraft, error) {
+	row := q.db.QueryRow(ctx, markTaskDraftSent, id)
+	var i TaskDraft
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.ToolDefinition,
-		&i.Context,
-		&i.CreatedAt,
 		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
 		&i.MessageID,
-		&i.MessageBody,
 		&i.ConversationID,
-		&i.ContextIds,
-		&i.State,
+		&i.WebLink,
+		&i.Subject,
+		&i.Recipients,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.SentAt,
 	)
 	return i, err
 }
 
-const getTaskFromConversationID = `-- name: GetTaskFromConversationID :one
-SELECT id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state FROM tasks
-WHERE conversation_id = $1 LIMIT 1
+const notifyCloseConn = `-- name: NotifyCloseConn :exec
+SELECT pg_notify('close_conn', $1::text)
 `
 
-func (q *Queries) GetTaskFromConversationID(ctx context.Context, conversationID *string) (Task, error) {
-	row := q.db.QueryRow(ctx, getTaskFromConversationID, conversationID)
-	var i Task
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.ToolDefinition,
-		&i.Context,
-		&i.CreatedAt,
-		&i.UserID,
-		&i.MessageID,
-		&i.MessageBody,
-		&i.ConversationID,
-		&i.ContextIds,
-		&i.State,
-	)
-	return i, err
+func (q *Queries) NotifyCloseConn(ctx context.Context, taskID string) error {
+	_, err := q.db.Exec(ctx, notifyCloseConn, taskID)
+	return err
 }
 
-const getTaskFromUserID = `-- name: GetTaskFromUserID :many
-SELECT id, name, description, tool_definition, context, created_at, user_id, message_id, message_body, conversation_id, context_ids, state FROM tasks
-WHERE user_id = $1 ORDER BY created_at DESC
+const notifyPendingActionDecided = `-- name: NotifyPendingActionDecided :exec
+SELECT pg_notify('pending_action_decided', $1::text)
 `
 
-func (q *Queries) GetTaskFromUserID(ctx context.Context, userID pgtype.UUID) ([]Task, error) {
-	rows, err := q.db.Query(ctx, getTaskFromUserID, userID)
+func (q *Queries) NotifyPendingActionDecided(ctx context.Context, taskID string) error {
+	_, err := q.db.Exec(ctx, notifyPendingActionDecided, taskID)
+	return err
+}
+
+const recordSubscriptionLifecycleEvent = `-- name: RecordSubscriptionLifecycleEvent :exec
+UPDATE subscriptions
+SET last_lifecycle_event = $2,
+    last_lifecycle_event_at = now()
+WHERE id = $1
+`
+
+type RecordSubscriptionLifecycleEventParams struct {
+	ID                 string
+	LastLifecycleEvent *string
+}
+
+func (q *Queries) RecordSubscriptionLifecycleEvent(ctx context.Context, arg RecordSubscriptionLifecycleEventParams) error {
+	_, err := q.db.Exec(ctx, recordSubscriptionLifecycleEvent, arg.ID, arg.LastLifecycleEvent)
+	return err
+}
+
+const renewSubscription = `-- name: RenewSubscription :exec
+UPDATE subscriptions
+SET expire_at = $2,
+    renewed_at = now(),
+    status = 'active',
+    last_error = null
+WHERE id = $1
+`
+
+type RenewSubscriptionParams struct {
+	ID       string
+	ExpireAt pgtype.Timestamptz
+}
+
+func (q *Queries) RenewSubscription(ctx context.Context, arg RenewSubscriptionParams) error {
+	_, err := q.db.Exec(ctx, renewSubscription, arg.ID, arg.ExpireAt)
+	return err
+}
+
+const reportPendingAction = `-- name: ReportPendingAction :exec
+UPDATE pending_actions
+SET reported = true
+WHERE id = $1
+`
+
+// Marks the decided action as passed on to the assistant by the run that waited for it.
+func (q *Queries) ReportPendingAction(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, reportPendingAction, id)
+	return err
+}
+
+const reportPendingActions = `-- name: ReportPendingActions :many
+UPDATE pending_actions
+SET reported = true
+WHERE task_id = $1 AND status IN ('succeeded', 'failed', 'rejected') AND NOT reported
+RETURNING id, user_id, task_id, connection_id, tool, arguments, status, result, error, link_token_hash, reported, created_at, decided_at
+`
+
+// Marks the decided actions of the task as passed on to the assistant and returns them.
+func (q *Queries) ReportPendingActions(ctx context.Context, taskID pgtype.UUID) ([]PendingAction, error) {
+	rows, err := q.db.Query(ctx, reportPendingActions, taskID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Task
+	var items []PendingAction
 	for rows.Next() {
-		var i Task
+		var i PendingAction
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.ToolDefinition,
-			&i.Context,
-			&i.CreatedAt,
 			&i.UserID,
-			&i.MessageID,
-			&i.MessageBody,
-			&i.ConversationID,
-			&i.ContextIds,
-			&i.State,
+			&i.TaskID,
+			&i.ConnectionID,
+			&i.Tool,
+			&i.Arguments,
+			&i.Status,
+			&i.Result,
+			&i.Error,
+			&i.LinkTokenHash,
+			&i.Reported,
+			&i.CreatedAt,
+			&i.DecidedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -444,240 +3211,442 @@ func (q *Queries) GetTaskFromUserID(ctx context.Context, userID pgtype.UUID) ([]
 	return items, nil
 }
 
-const getUser = `-- name: GetUser :one
-SELECT id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam FROM users
-WHERE id = $1 LIMIT 1
+const retryWebhookJob = `-- name: RetryWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'pending',
+    run_at = $2,
+    last_error = $3,
+    locked_until = null,
+    updated_at = now()
+WHERE id = $1
+`
+
+type RetryWebhookJobParams struct {
+	ID        pgtype.UUID
+	RunAt     pgtype.Timestamptz
+	LastError *string
+}
+
+func (q *Queries) RetryWebhookJob(ctx context.Context, arg RetryWebhookJobParams) error {
+	_, err := q.db.Exec(ctx, retryWebhookJob, arg.ID, arg.RunAt, arg.LastError)
+	return err
+}
+
+const revertTaskDraftSent = `-- name: RevertTaskDraftSent :exec
+UPDATE task_drafts
+SET status = 'draft',
+    sent_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) RevertTaskDraftSent(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, revertTaskDraftSent, id)
+	return err
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE sessions SET revoked_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeSessionParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeSessionByRefreshToken = `-- name: RevokeSessionByRefreshToken :exec
+UPDATE sessions SET revoked_at = now()
+WHERE (refresh_token_hash = $1 OR previous_refresh_token_hash = $1) AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeSessionByRefreshToken(ctx context.Context, refreshTokenHash string) error {
+	_, err := q.db.Exec(ctx, revokeSessionByRefreshToken, refreshTokenHash)
+	return err
+}
+
+const revokeSessionsForUser = `-- name: RevokeSessionsForUser :exec
+UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeSessionsForUser(ctx context.Context, userID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, revokeSessionsForUser, userID)
+	return err
+}
+
+const rotateSession = `-- name: RotateSession :one
+UPDATE sessions
+SET previous_refresh_token_hash = refresh_token_hash,
+    refresh_token_hash = $1,
+    expire_at = $2,
+    refreshed_at = now()
+WHERE refresh_token_hash = $3 AND revoked_at IS NULL AND expire_at > now()
+RETURNING id, user_id, refresh_token_hash, previous_refresh_token_hash, user_agent, expire_at, refreshed_at, revoked_at, created_at
+`
+
+type RotateSessionParams struct {
+	NewHash  string
+	ExpireAt pgtype.Timestamptz
+	OldHash  string
+}
+
+func (q *Queries) RotateSession(ctx context.Context, arg RotateSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, rotateSession, arg.NewHash, arg.ExpireAt, arg.OldHash)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshTokenHash,
+		&i.PreviousRefreshTokenHash,
+		&i.UserAgent,
+		&i.ExpireAt,
+		&i.RefreshedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const setContextOrganization = `-- name: SetContextOrganization :execrows
+UPDATE contexts
+SET organization_id = $3
+WHERE id = $1 AND user_id = $2
+`
+
+type SetContextOrganizationParams struct {
+	ID             pgtype.UUID
+	UserID         pgtype.UUID
+	OrganizationID pgtype.UUID
+}
+
+func (q *Queries) SetContextOrganization(ctx context.Context, arg SetContextOrganizationParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setContextOrganization, arg.ID, arg.UserID, arg.OrganizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setTaskConversationIDIfUnset = `-- name: SetTaskConversationIDIfUnset :exec
+UPDATE tasks
+SET conversation_id = $2
+WHERE id = $1 AND conversation_id IS NULL
+`
+
+type SetTaskConversationIDIfUnsetParams struct {
+	ID             pgtype.UUID
+	ConversationID *string
+}
+
+func (q *Queries) SetTaskConversationIDIfUnset(ctx context.Context, arg SetTaskConversationIDIfUnsetParams) error {
+	_, err := q.db.Exec(ctx, setTaskConversationIDIfUnset, arg.ID, arg.ConversationID)
+	return err
+}
+
+const touchAPIToken = `-- name: TouchAPIToken :exec
+UPDATE api_tokens SET last_used_at = now()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+`
+
+func (q *Queries) TouchAPIToken(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, touchAPIToken, id)
+	return err
+}
+
+const touchUserIdentity = `-- name: TouchUserIdentity :exec
+UPDATE user_identities SET last_login_at = now(), email = $3
+WHERE issuer = $1 AND subject = $2
+`
+
+type TouchUserIdentityParams struct {
+	Issuer  string
+	Subject string
+	Email   *string
+}
+
+func (q *Queries) TouchUserIdentity(ctx context.Context, arg TouchUserIdentityParams) error {
+	_, err := q.db.Exec(ctx, touchUserIdentity, arg.Issuer, arg.Subject, arg.Email)
+	return err
+}
+
+const unshareContextsOfMember = `-- name: UnshareContextsOfMember :exec
+UPDATE contexts SET organization_id = NULL WHERE organization_id = $1 AND user_id = $2
+`
+
+type UnshareContextsOfMemberParams struct {
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+}
+
+func (q *Queries) UnshareContextsOfMember(ctx context.Context, arg UnshareContextsOfMemberParams) error {
+	_, err := q.db.Exec(ctx, unshareContextsOfMember, arg.OrganizationID, arg.UserID)
+	return err
+}
+
+const updateContext = `-- name: UpdateContext :execrows
+UPDATE contexts
+SET name = $3,
+    description = $4,
+    content = $5
+WHERE id = $1 AND (
+    contexts.user_id = $2
+    OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $2 AND m.role = 'owner')
+)
+`
+
+type UpdateContextParams struct {
+	ID          pgtype.UUID
+	UserID      pgtype.UUID
+	Name        *string
+	Description *string
+	Content     *string
+}
+
+func (q *Queries) UpdateContext(ctx context.Context, arg UpdateContextParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateContext,
+		arg.ID,
+		arg.UserID,
+		arg.Name,
+		arg.Description,
+		arg.Content,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateDelegatedMailboxConnectionTokens = `-- name: UpdateDelegatedMailboxConnectionTokens :exec
+UPDATE mailbox_connections
+SET token = $2,
+    expire_at = $3
+WHERE credential_id = $1
+`
+
+type UpdateDelegatedMailboxConnectionTokensParams struct {
+	CredentialID pgtype.UUID
+	Token        secret.String
+	ExpireAt     pgtype.Timestamptz
+}
+
+func (q *Queries) UpdateDelegatedMailboxConnectionTokens(ctx context.Context, arg UpdateDelegatedMailboxConnectionTokensParams) error {
+	_, err := q.db.Exec(ctx, updateDelegatedMailboxConnectionTokens, arg.CredentialID, arg.Token, arg.ExpireAt)
+	return err
+}
+
+const updateMailboxConnectionInboxDeltaToken = `-- name: UpdateMailboxConnectionInboxDeltaToken :exec
+UPDATE mailbox_connections
+SET inbox_delta_token = $2
+WHERE id = $1
+`
+
+type UpdateMailboxConnectionInboxDeltaTokenParams struct {
+	ID              pgtype.UUID
+	InboxDeltaToken *string
+}
+
+func (q *Queries) UpdateMailboxConnectionInboxDeltaToken(ctx context.Context, arg UpdateMailboxConnectionInboxDeltaTokenParams) error {
+	_, err := q.db.Exec(ctx, updateMailboxConnectionInboxDeltaToken, arg.ID, arg.InboxDeltaToken)
+	return err
+}
+
+const updateMailboxConnectionSettings = `-- name: UpdateMailboxConnectionSettings :one
+UPDATE mailbox_connections
+SET subscription_disabled = $3,
+    check_spam = $4
+WHERE id = $1 AND user_id = $2
+RETURNING id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state, check_spam, inbox_delta_token, created_at, delegation_id, credential_id, delegated_permissions
 `
 
-func (q *Queries) GetUser(ctx context.Context, id pgtype.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, getUser, id)
-	var i User
+type UpdateMailboxConnectionSettingsParams struct {
+	ID                   pgtype.UUID
+	UserID               pgtype.UUID
+	SubscriptionDisabled bool
+	CheckSpam            bool
+}
+
+func (q *Queries) UpdateMailboxConnectionSettings(ctx context.Context, arg UpdateMailboxConnectionSettingsParams) (MailboxConnection, error) {
+	row := q.db.QueryRow(ctx, updateMailboxConnectionSettings,
+		arg.ID,
+		arg.UserID,
+		arg.SubscriptionDisabled,
+		arg.CheckSpam,
+	)
+	var i MailboxConnection
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
+		&i.UserID,
+		&i.Provider,
 		&i.Email,
 		&i.Token,
 		&i.RefreshToken,
-		&i.SubscriptionID,
-		&i.SubscriptionExpireAt,
-		&i.SubscriptionDisabled,
 		&i.ExpireAt,
+		&i.SubscriptionDisabled,
+		&i.SubscriptionClientState,
 		&i.CheckSpam,
+		&i.InboxDeltaToken,
+		&i.CreatedAt,
+		&i.DelegationID,
+		&i.CredentialID,
+		&i.DelegatedPermissions,
 	)
 	return i, err
 }
 
-const getUserFromEmail = `-- name: GetUserFromEmail :one
-SELECT id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam FROM users
-WHERE email = $1 LIMIT 1
+const updateMailboxConnectionSubscriptionClientState = `-- name: UpdateMailboxConnectionSubscriptionClientState :exec
+UPDATE mailbox_connections
+SET subscription_client_state = $2
+WHERE id = $1
 `
 
-func (q *Queries) GetUserFromEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRow(ctx, getUserFromEmail, email)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Email,
-		&i.Token,
-		&i.RefreshToken,
-		&i.SubscriptionID,
-		&i.SubscriptionExpireAt,
-		&i.SubscriptionDisabled,
-		&i.ExpireAt,
-		&i.CheckSpam,
-	)
-	return i, err
+type UpdateMailboxConnectionSubscriptionClientStateParams struct {
+	ID                      pgtype.UUID
+	SubscriptionClientState *string
 }
 
-const getUserFromSubscriptionID = `-- name: GetUserFromSubscriptionID :one
-SELECT id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam FROM users
-WHERE subscription_id = $1 LIMIT 1
+func (q *Queries) UpdateMailboxConnectionSubscriptionClientState(ctx context.Context, arg UpdateMailboxConnectionSubscriptionClientStateParams) error {
+	_, err := q.db.Exec(ctx, updateMailboxConnectionSubscriptionClientState, arg.ID, arg.SubscriptionClientState)
+	return err
+}
+
+const updateMailboxConnectionToken = `-- name: UpdateMailboxConnectionToken :exec
+UPDATE mailbox_connections
+SET token = $2,
+    refresh_token = $3,
+    expire_at = $4
+WHERE id = $1
 `
 
-func (q *Queries) GetUserFromSubscriptionID(ctx context.Context, subscriptionID *string) (User, error) {
-	row := q.db.QueryRow(ctx, getUserFromSubscriptionID, subscriptionID)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Email,
-		&i.Token,
-		&i.RefreshToken,
-		&i.SubscriptionID,
-		&i.SubscriptionExpireAt,
-		&i.SubscriptionDisabled,
-		&i.ExpireAt,
-		&i.CheckSpam,
+type UpdateMailboxConnectionTokenParams struct {
+	ID           pgtype.UUID
+	Token        secret.String
+	RefreshToken *secret.String
+	ExpireAt     pgtype.Timestamptz
+}
+
+func (q *Queries) UpdateMailboxConnectionToken(ctx context.Context, arg UpdateMailboxConnectionTokenParams) error {
+	_, err := q.db.Exec(ctx, updateMailboxConnectionToken,
+		arg.ID,
+		arg.Token,
+		arg.RefreshToken,
+		arg.ExpireAt,
 	)
-	return i, err
+	return err
 }
 
-const listContextsForUser = `-- name: ListContextsForUser :many
-SELECT id, name, description, content, user_id, created_at FROM contexts WHERE user_id = $1 ORDER BY created_at DESC
+const updateMailboxConnectionTokens = `-- name: UpdateMailboxConnectionTokens :exec
+UPDATE mailbox_connections
+SET token = $2,
+    refresh_token = $3
+WHERE id = $1
 `
 
-func (q *Queries) ListContextsForUser(ctx context.Context, userID pgtype.UUID) ([]Context, error) {
-	rows, err := q.db.Query(ctx, listContextsForUser, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Context
-	for rows.Next() {
-		var i Context
-		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.Content,
-			&i.UserID,
-			&i.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+type UpdateMailboxConnectionTokensParams struct {
+	ID           pgtype.UUID
+	Token        secret.String
+	RefreshToken *secret.String
 }
 
-const listSpamEmails = `-- name: ListSpamEmails :many
-SELECT id, message_id, subject, email_body, user_id, created_at FROM spam_emails WHERE user_id = $1
-`
-
-func (q *Queries) ListSpamEmails(ctx context.Context, userID pgtype.UUID) ([]SpamEmail, error) {
-	rows, err := q.db.Query(ctx, listSpamEmails, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []SpamEmail
-	for rows.Next() {
-		var i SpamEmail
-		if err := rows.Scan(
-			&i.ID,
-			&i.MessageID,
-			&i.Subject,
-			&i.EmailBody,
-			&i.UserID,
-			&i.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+func (q *Queries) UpdateMailboxConnectionTokens(ctx context.Context, arg UpdateMailboxConnectionTokensParams) error {
+	_, err := q.db.Exec(ctx, updateMailboxConnectionTokens, arg.ID, arg.Token, arg.RefreshToken)
+	return err
 }
 
-const listUsers = `-- name: ListUsers :many
-SELECT id, name, email, token, refresh_token, subscription_id, subscription_expire_at, subscription_disabled, expire_at, check_spam FROM users
-ORDER BY name
+const updateMessageRead = `-- name: UpdateMessageRead :execrows
+UPDATE messages
+set read = $3
+WHERE id = $1 AND user_id = $2
 `
 
-func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
-	rows, err := q.db.Query(ctx, listUsers)
+type UpdateMessageReadParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+	Read   *bool
+}
+
+func (q *Queries) UpdateMessageRead(ctx context.Context, arg UpdateMessageReadParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateMessageRead, arg.ID, arg.UserID, arg.Read)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []User
-	for rows.Next() {
-		var i User
-		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.Email,
-			&i.Token,
-			&i.RefreshToken,
-			&i.SubscriptionID,
-			&i.SubscriptionExpireAt,
-			&i.SubscriptionDisabled,
-			&i.ExpireAt,
-			&i.CheckSpam,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected(), nil
 }
 
-const updateContext = `-- name: UpdateContext :exec
-UPDATE contexts
-SET name = $2,
-    description = $3,
-    content = $4
-WHERE id = $1
+const updateOrganization = `-- name: UpdateOrganization :one
+UPDATE organizations SET name = $2 WHERE id = $1 RETURNING id, name, created_at
 `
 
-type UpdateContextParams struct {
-	ID          pgtype.UUID
-	Name        *string
-	Description *string
-	Content     *string
+type UpdateOrganizationParams struct {
+	ID   pgtype.UUID
+	Name string
 }
 
-func (q *Queries) UpdateContext(ctx context.Context, arg UpdateContextParams) error {
-	_, err := q.db.Exec(ctx, updateContext,
-		arg.ID,
-		arg.Name,
-		arg.Description,
-		arg.Content,
-	)
-	return err
+func (q *Queries) UpdateOrganization(ctx context.Context, arg UpdateOrganizationParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, updateOrganization, arg.ID, arg.Name)
+	var i Organization
+	err := row.Scan(&i.ID, &i.Name, &i.CreatedAt)
+	return i, err
 }
 
-const updateMessageRead = `-- name: UpdateMessageRead :exec
-UPDATE messages
-set read = $2
+const updateSubscriptionStatus = `-- name: UpdateSubscriptionStatus :exec
+UPDATE subscriptions
+SET status = $2,
+    last_error = $3
 WHERE id = $1
 `
 
-type UpdateMessageReadParams struct {
-	ID   pgtype.UUID
-	Read *bool
+type UpdateSubscriptionStatusParams struct {
+	ID        string
+	Status    string
+	LastError *string
 }
 
-func (q *Queries) UpdateMessageRead(ctx context.Context, arg UpdateMessageReadParams) error {
-	_, err := q.db.Exec(ctx, updateMessageRead, arg.ID, arg.Read)
+func (q *Queries) UpdateSubscriptionStatus(ctx context.Context, arg UpdateSubscriptionStatusParams) error {
+	_, err := q.db.Exec(ctx, updateSubscriptionStatus, arg.ID, arg.Status, arg.LastError)
 	return err
 }
 
-const updateTask = `-- name: UpdateTask :exec
+const updateTask = `-- name: UpdateTask :execrows
 UPDATE tasks
-SET name = $2,
-    description = $3,
-    context = $4,
-    context_ids = $5
-WHERE id = $1
+SET name = $3,
+    description = $4,
+    context = $5,
+    context_ids = $6,
+    connection_id = COALESCE($7, connection_id)
+WHERE id = $1 AND user_id = $2
 `
 
 type UpdateTaskParams struct {
-	ID          pgtype.UUID
-	Name        string
-	Description string
-	Context     *string
-	ContextIds  []pgtype.UUID
+	ID           pgtype.UUID
+	UserID       pgtype.UUID
+	Name         string
+	Description  string
+	Context      *string
+	ContextIds   []pgtype.UUID
+	ConnectionID pgtype.UUID
 }
 
-func (q *Queries) UpdateTask(ctx context.Context, arg UpdateTaskParams) error {
-	_, err := q.db.Exec(ctx, updateTask,
+func (q *Queries) UpdateTask(ctx context.Context, arg UpdateTaskParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateTask,
 		arg.ID,
+		arg.UserID,
 		arg.Name,
 		arg.Description,
 		arg.Context,
 		arg.ContextIds,
+		arg.ConnectionID,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const updateTaskConversationID = `-- name: UpdateTaskConversationID :exec
@@ -696,6 +3665,65 @@ func (q *Queries) UpdateTaskConversationID(ctx context.Context, arg UpdateTaskCo
 	return err
 }
 
+const updateTaskDraft = `-- name: UpdateTaskDraft :one
+UPDATE task_drafts
+SET subject = $2,
+    recipients = $3,
+    web_link = COALESCE($4, web_link),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND status = 'draft'
+RETURNING id, user_id, task_id, connection_id, message_id, conversation_id, web_link, subject, recipients, status, created_at, updated_at, sent_at
+`
+
+type UpdateTaskDraftParams struct {
+	ID         pgtype.UUID
+	Subject    string
+	Recipients []string
+	WebLink    *string
+}
+
+func (q *Queries) UpdateTaskDraft(ctx context.Context, arg UpdateTaskDraftParams) (TaskDraft, error) {
+	row := q.db.QueryRow(ctx, updateTaskDraft,
+		arg.ID,
+		arg.Subject,
+		arg.Recipients,
+		arg.WebLink,
+	)
+	var i TaskDraft
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TaskID,
+		&i.ConnectionID,
+		&i.MessageID,
+		&i.ConversationID,
+		&i.WebLink,
+		&i.Subject,
+		&i.Recipients,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const updateTaskMessageBody = `-- name: UpdateTaskMessageBody :exec
+UPDATE tasks
+set message_body = $2
+WHERE id = $1
+`
+
+type UpdateTaskMessageBodyParams struct {
+	ID          pgtype.UUID
+	MessageBody *string
+}
+
+func (q *Queries) UpdateTaskMessageBody(ctx context.Context, arg UpdateTaskMessageBodyParams) error {
+	_, err := q.db.Exec(ctx, updateTaskMessageBody, arg.ID, arg.MessageBody)
+	return err
+}
+
 const updateTaskState = `-- name: UpdateTaskState :exec
 UPDATE tasks
 set state = $2
@@ -723,39 +3751,125 @@ func (q *Queries) UpdateTaskStateToNull(ctx context.Context, id pgtype.UUID) err
 	return err
 }
 
-const updateUser = `-- name: UpdateUser :exec
-UPDATE users
-set token = $2,
-    refresh_token = $3,
-    expire_at = $4,
-    subscription_id = $5,
-    subscription_expire_at = $6,
-    subscription_disabled = $7,
-    check_spam = $8
-WHERE id = $1
+const upsertDelegationGrant = `-- name: UpsertDelegationGrant :one
+INSERT INTO delegation_grants (principal_id, delegate_id, mailbox, permissions) VALUES ($1, $2, $3, $4)
+ON CONFLICT (principal_id, delegate_id, mailbox) DO UPDATE SET permissions = EXCLUDED.permissions
+RETURNING id, principal_id, delegate_id, mailbox, permissions, created_at
 `
 
-type UpdateUserParams struct {
-	ID                   pgtype.UUID
-	Token                string
-	RefreshToken         *string
-	ExpireAt             pgtype.Timestamptz
-	SubscriptionID       *string
-	SubscriptionExpireAt pgtype.Timestamptz
-	SubscriptionDisabled *bool
-	CheckSpam            *bool
+type UpsertDelegationGrantParams struct {
+	PrincipalID pgtype.UUID
+	DelegateID  pgtype.UUID
+	Mailbox     string
+	Permissions []string
+}
+
+func (q *Queries) UpsertDelegationGrant(ctx context.Context, arg UpsertDelegationGrantParams) (DelegationGrant, error) {
+	row := q.db.QueryRow(ctx, upsertDelegationGrant,
+		arg.PrincipalID,
+		arg.DelegateID,
+		arg.Mailbox,
+		arg.Permissions,
+	)
+	var i DelegationGrant
+	err := row.Scan(
+		&i.ID,
+		&i.PrincipalID,
+		&i.DelegateID,
+		&i.Mailbox,
+		&i.Permissions,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const upsertOrganizationInvitation = `-- name: UpsertOrganizationInvitation :one
+INSERT INTO organization_invitations (organization_id, user_id, role, invited_by) VALUES ($1, $2, $3, $4)
+ON CONFLICT (organization_id, user_id) DO UPDATE
+SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, created_at = CURRENT_TIMESTAMP
+RETURNING id, organization_id, user_id, role, invited_by, created_at
+`
+
+type UpsertOrganizationInvitationParams struct {
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+	Role           string
+	InvitedBy      pgtype.UUID
+}
+
+func (q *Queries) UpsertOrganizationInvitation(ctx context.Context, arg UpsertOrganizationInvitationParams) (OrganizationInvitation, error) {
+	row := q.db.QueryRow(ctx, upsertOrganizationInvitation,
+		arg.OrganizationID,
+		arg.UserID,
+		arg.Role,
+		arg.InvitedBy,
+	)
+	var i OrganizationInvitation
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.UserID,
+		&i.Role,
+		&i.InvitedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const upsertOrganizationMember = `-- name: UpsertOrganizationMember :one
+INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)
+ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role
+RETURNING organization_id, user_id, role, created_at
+`
+
+type UpsertOrganizationMemberParams struct {
+	OrganizationID pgtype.UUID
+	UserID         pgtype.UUID
+	Role           string
+}
+
+func (q *Queries) UpsertOrganizationMember(ctx context.Context, arg UpsertOrganizationMemberParams) (OrganizationMember, error) {
+	row := q.db.QueryRow(ctx, upsertOrganizationMember, arg.OrganizationID, arg.UserID, arg.Role)
+	var i OrganizationMember
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.UserID,
+		&i.Role,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const upsertSubscription = `-- name: UpsertSubscription :exec
+INSERT INTO subscriptions (
+    id, user_id, connection_id, kind, expire_at
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+ON CONFLICT (connection_id, kind) DO UPDATE
+SET id = excluded.id,
+    expire_at = excluded.expire_at,
+    created_at = CURRENT_TIMESTAMP,
+    renewed_at = null,
+    status = 'active',
+    last_error = null
+`
+
+type UpsertSubscriptionParams struct {
+	ID           string
+	UserID       pgtype.UUID
+	ConnectionID pgtype.UUID
+	Kind         string
+	ExpireAt     pgtype.Timestamptz
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
-	_, err := q.db.Exec(ctx, updateUser,
+func (q *Queries) UpsertSubscription(ctx context.Context, arg UpsertSubscriptionParams) error {
+	_, err := q.db.Exec(ctx, upsertSubscription,
 		arg.ID,
-		arg.Token,
-		arg.RefreshToken,
+		arg.UserID,
+		arg.ConnectionID,
+		arg.Kind,
 		arg.ExpireAt,
-		arg.SubscriptionID,
-		arg.SubscriptionExpireAt,
-		arg.SubscriptionDisabled,
-		arg.CheckSpam,
 	)
 	return err
 }
@@ -0,0 +1,150 @@
+package graphfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type getScheduleRequest struct {
+	Schedules []string         `json:"schedules"`
+	StartTime DateTimeTimeZone `json:"startTime"`
+	EndTime   DateTimeTimeZone `json:"endTime"`
+}
+
+// getSchedule returns every event and seeded schedule item of the requested mailboxes. Addresses without a mailbox on
+// this server come back free. Items are not filtered by the requested window and availabilityView is left empty.
+func (s *Server) getSchedule(w http.ResponseWriter, r *http.Request) {
+	var req getScheduleRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([]ScheduleInformation, 0, len(req.Schedules))
+	for _, email := range req.Schedules {
+		info := ScheduleInformation{
+			ScheduleID:    email,
+			ScheduleItems: []ScheduleItem{},
+		}
+		if m, ok := s.mailboxes[strings.ToLower(strings.TrimSpace(email))]; ok {
+			for _, event := range m.Events {
+				info.ScheduleItems = append(info.ScheduleItems, ScheduleItem{
+					Status:  "busy",
+					Subject: event.Subject,
+					Start:   event.Start,
+					End:     event.End,
+				})
+			}
+			info.ScheduleItems = append(info.ScheduleItems, m.ScheduleItems...)
+		}
+		ret = append(ret, info)
+	}
+	writeJSON(w, http.StatusOK, collection[ScheduleInformation]{Value: ret})
+}
+
+func (s *Server) createEvent(w http.ResponseWriter, r *http.Request) {
+	var event Event
+	if !decode(w, r, &event) {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m := s.mailbox(r)
+	event.ID = newID()
+	event.Organizer = &Recipient{EmailAddress: EmailAddress{Name: m.User.DisplayName, Address: m.User.Mail}}
+	setOnlineMeeting(&event)
+	m.Events = append(m.Events, event)
+	writeJSON(w, http.StatusCreated, event)
+}
+
+func (s *Server) getEvent(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	event := findEvent(s.mailbox(r), mux.Vars(r)["id"])
+	if event == nil {
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	writeJSON(w, http.StatusOK, event)
+}
+
+// updateEvent applies the fields present in the body on top of the stored event, like a Graph PATCH.
+func (s *Server) updateEvent(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	event := findEvent(s.mailbox(r), mux.Vars(r)["id"])
+	if event == nil {
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+
+	updated := *event
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	updated.ID = event.ID
+	updated.Organizer = event.Organizer
+	setOnlineMeeting(&updated)
+	*event = updated
+	writeJSON(w, http.StatusOK, event)
+}
+
+func (s *Server) listPeople(w http.ResponseWriter, r *http.Request) {
+	search := strings.ToLower(strings.Trim(r.URL.Query().Get("$search"), `"`))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := []Person{}
+	for _, person := range s.mailbox(r).People {
+		if search == "" || strings.Contains(strings.ToLower(person.DisplayName), search) {
+			ret = append(ret, person)
+		}
+	}
+	writeJSON(w, http.StatusOK, collection[Person]{Value: ret})
+}
+
+func (s *Server) listContacts(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, collection[Contact]{Value: s.mailbox(r).Contacts})
+}
+
+func setOnlineMeeting(event *Event) {
+	if !event.IsOnlineMeeting {
+		event.OnlineMeeting = nil
+		return
+	}
+	if event.OnlineMeetingProvider == "" {
+		event.OnlineMeetingProvider = "teamsForBusiness"
+	}
+	if event.OnlineMeeting == nil {
+		event.OnlineMeeting = &OnlineMeetingInfo{
+			JoinURL:      "https://teams.example.com/l/meetup-join/" + event.ID,
+			TollNumber:   "+1 555-0100",
+			ConferenceID: fmt.Sprintf("%09d", crc32.ChecksumIEEE([]byte(event.ID))%1000000000),
+		}
+	}
+}
+
+func findEvent(m *Mailbox, id string) *Event {
+	for i := range m.Events {
+		if m.Events[i].ID == id {
+			return &m.Events[i]
+		}
+	}
+	return nil
+}
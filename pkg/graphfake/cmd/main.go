@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"ethan/pkg/graphfake"
+
+	"github.com/sirupsen/logrus"
+)
+
+// graphfake serves the mailboxes in GRAPHFAKE_FIXTURE, a JSON list of graphfake.Mailbox, and prints an access token
+// for each of them.
+func main() {
+	addr := os.Getenv("GRAPHFAKE_ADDR")
+	if addr == "" {
+		addr = ":8090"
+	}
+
+	var mailboxes []graphfake.Mailbox
+	if fixture := os.Getenv("GRAPHFAKE_FIXTURE"); fixture != "" {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &mailboxes); err != nil {
+			log.Fatalf("invalid fixture %v: %v", fixture, err)
+		}
+	}
+
+	server := graphfake.New()
+	for _, mailbox := range mailboxes {
+		user := server.AddMailbox(mailbox)
+		token, err := server.Token(user.Mail)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logrus.Infof("Mailbox %v token: %v", user.Mail, token)
+	}
+
+	logrus.Infof("Graph fake listening on %v", addr)
+	log.Fatal(http.ListenAndServe(addr, server))
+}
@@ -0,0 +1,385 @@
+package graphfake
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultPageSize = 10
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, s.mailbox(r).User)
+}
+
+func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) {
+	top := defaultPageSize
+	if v := r.URL.Query().Get("$top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("invalid $top %q", v))
+			return
+		}
+		top = n
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	messages := append([]Message(nil), s.mailbox(r).Messages...)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].ReceivedDateTime.After(messages[j].ReceivedDateTime)
+	})
+	if len(messages) > top {
+		messages = messages[:top]
+	}
+	writeJSON(w, http.StatusOK, collection[Message]{Value: messages})
+}
+
+// createMessage saves a draft, the first half of the create then send flow the graph provider uses.
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
+	var msg Message
+	if !decode(w, r, &msg) {
+		return
+	}
+
+	s.lock.Lock()
+	m := s.mailbox(r)
+	msg.ID = newID()
+	msg.WebLink = webLink(msg.ID)
+	if msg.ConversationID == "" {
+		msg.ConversationID = newID()
+	}
+	msg.ParentFolderID = findFolder(m, "drafts").ID
+	msg.InternetMessageID = newInternetMessageID()
+	msg.IsDraft = true
+	msg.ReceivedDateTime = time.Now()
+	msg.seq = s.nextSeq()
+	from := &Recipient{EmailAddress: EmailAddress{Name: m.User.DisplayName, Address: m.User.Mail}}
+	msg.From, msg.Sender = from, from
+	m.Messages = append(m.Messages, msg)
+	notifications := s.notifications(m, "created", msg)
+	s.lock.Unlock()
+
+	s.notifyAsync(notifications)
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	msg := findMessage(s.mailbox(r), mux.Vars(r)["id"])
+	if msg == nil {
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+// updateMessage changes the fields of a draft that are in the request body.
+func (s *Server) updateMessage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	msg := findMessage(s.mailbox(r), mux.Vars(r)["id"])
+	if msg == nil {
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	if !msg.IsDraft {
+		writeError(w, http.StatusBadRequest, "ErrorInvalidOperation", "Only drafts can be updated.")
+		return
+	}
+
+	updated := *msg
+	if !decode(w, r, &updated) {
+		return
+	}
+	updated.ID, updated.WebLink, updated.IsDraft = msg.ID, msg.WebLink, true
+	*msg = updated
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *Server) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	m := s.mailbox(r)
+	i := slices.IndexFunc(m.Messages, func(msg Message) bool {
+		return msg.ID == mux.Vars(r)["id"]
+	})
+	if i < 0 {
+		s.lock.Unlock()
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	deleted := m.Messages[i]
+	m.Messages = slices.Delete(m.Messages, i, i+1)
+	notifications := s.notifications(m, "deleted", deleted)
+	s.lock.Unlock()
+
+	s.notifyAsync(notifications)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendMessage moves the draft to Sent Items and delivers a copy to every recipient that has a mailbox on this server.
+func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	m := s.mailbox(r)
+	msg := findMessage(m, mux.Vars(r)["id"])
+	if msg == nil {
+		s.lock.Unlock()
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	if !msg.IsDraft {
+		s.lock.Unlock()
+		writeError(w, http.StatusBadRequest, "ErrorInvalidOperation", "The message has already been sent.")
+		return
+	}
+	// Sending removes the draft and creates a new item in Sent Items.
+	draft := *msg
+	msg.ID = newID()
+	msg.WebLink = webLink(msg.ID)
+	msg.IsDraft = false
+	msg.ParentFolderID = findFolder(m, "sentitems").ID
+	msg.ReceivedDateTime = time.Now()
+	msg.seq = s.nextSeq()
+	sent := *msg
+
+	notifications := append(s.notifications(m, "deleted", draft), s.notifications(m, "created", sent)...)
+	var recipients []Recipient
+	recipients = append(recipients, sent.ToRecipients...)
+	recipients = append(recipients, sent.CcRecipients...)
+	recipients = append(recipients, sent.BccRecipients...)
+	delivered := map[string]struct{}{}
+	for _, recipient := range recipients {
+		key := strings.ToLower(recipient.EmailAddress.Address)
+		rm, ok := s.mailboxes[key]
+		if _, seen := delivered[key]; !ok || seen {
+			continue
+		}
+		delivered[key] = struct{}{}
+		notifications = append(notifications, s.deliver(rm, sent)...)
+	}
+	s.lock.Unlock()
+
+	s.notifyAsync(notifications)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type moveRequest struct {
+	DestinationID string `json:"destinationId"`
+}
+
+// moveMessage gives the message a new ID, as Graph does when an item changes folders.
+func (s *Server) moveMessage(w http.ResponseWriter, r *http.Request) {
+	var req moveRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.lock.Lock()
+	m := s.mailbox(r)
+	msg := findMessage(m, mux.Vars(r)["id"])
+	if msg == nil {
+		s.lock.Unlock()
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	folder := findFolder(m, req.DestinationID)
+	if folder == nil {
+		s.lock.Unlock()
+		writeError(w, http.StatusBadRequest, "ErrorInvalidIdMalformed", fmt.Sprintf("Folder %q does not exist.", req.DestinationID))
+		return
+	}
+
+	old := *msg
+	msg.ID = newID()
+	msg.WebLink = webLink(msg.ID)
+	msg.ParentFolderID = folder.ID
+	msg.seq = s.nextSeq()
+	moved := *msg
+	notifications := append(s.notifications(m, "deleted", old), s.notifications(m, "created", moved)...)
+	s.lock.Unlock()
+
+	s.notifyAsync(notifications)
+	writeJSON(w, http.StatusCreated, moved)
+}
+
+type deltaCollection struct {
+	Value     []Message `json:"value"`
+	DeltaLink string    `json:"@odata.deltaLink"`
+}
+
+// messagesDelta returns the messages added to a folder since the $deltatoken, or received since the $filter when
+// there is no token. Messages that left the folder are not reported.
+func (s *Server) messagesDelta(w http.ResponseWriter, r *http.Request) {
+	var (
+		since    time.Time
+		afterSeq uint64
+	)
+	if token := r.URL.Query().Get("$deltatoken"); token != "" {
+		n, err := strconv.ParseUint(token, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusGone, "SyncStateNotFound", "The sync state generation is not found.")
+			return
+		}
+		afterSeq = n
+	} else if filter := r.URL.Query().Get("$filter"); filter != "" {
+		value, ok := strings.CutPrefix(filter, "receivedDateTime ge ")
+		t, err := time.Parse(time.RFC3339, value)
+		if !ok || err != nil {
+			writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("unsupported $filter %q", filter))
+			return
+		}
+		since = t
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m := s.mailbox(r)
+	folder := findFolder(m, mux.Vars(r)["folder"])
+	if folder == nil {
+		writeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified folder was not found in the store.")
+		return
+	}
+
+	messages := []Message{}
+	for _, msg := range m.Messages {
+		if msg.ParentFolderID == folder.ID && msg.seq > afterSeq && !msg.ReceivedDateTime.Before(since) {
+			messages = append(messages, msg)
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	deltaLink := url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawQuery: "$deltatoken=" + strconv.FormatUint(s.seq, 10),
+	}
+	writeJSON(w, http.StatusOK, deltaCollection{Value: messages, DeltaLink: deltaLink.String()})
+}
+
+func (s *Server) listFolders(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, collection[MailFolder]{Value: s.mailbox(r).Folders})
+}
+
+func (s *Server) createFolder(w http.ResponseWriter, r *http.Request) {
+	var folder MailFolder
+	if !decode(w, r, &folder) {
+		return
+	}
+	if folder.DisplayName == "" {
+		writeError(w, http.StatusBadRequest, "ErrorInvalidParameter", "displayName is required.")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m := s.mailbox(r)
+	for _, existing := range m.Folders {
+		if strings.EqualFold(existing.DisplayName, folder.DisplayName) {
+			writeError(w, http.StatusConflict, "ErrorFolderExists", "A folder with the specified name already exists.")
+			return
+		}
+	}
+	folder.ID = newID()
+	folder.WellKnownName = ""
+	m.Folders = append(m.Folders, folder)
+	writeJSON(w, http.StatusCreated, folder)
+}
+
+// Deliver puts a message into the inbox of the mailbox for email and sends the change notifications for it. Unlike
+// mail sent through the API, notifications are sent before Deliver returns so tests can wait on the webhook.
+func (s *Server) Deliver(ctx context.Context, email string, msg Message) (Message, error) {
+	s.lock.Lock()
+	m, ok := s.mailboxes[strings.ToLower(email)]
+	if !ok {
+		s.lock.Unlock()
+		return Message{}, fmt.Errorf("no mailbox for %v", email)
+	}
+	notifications := s.deliver(m, msg)
+	delivered := m.Messages[len(m.Messages)-1]
+	s.lock.Unlock()
+
+	return delivered, s.notify(ctx, notifications)
+}
+
+// deliver adds a received copy of msg to the inbox of m. The caller must hold s.lock.
+func (s *Server) deliver(m *Mailbox, msg Message) []notification {
+	msg.ID = newID()
+	if msg.ConversationID == "" {
+		msg.ConversationID = newID()
+	}
+	if msg.InternetMessageID == "" {
+		msg.InternetMessageID = newInternetMessageID()
+	}
+	msg.ParentFolderID = findFolder(m, "inbox").ID
+	msg.IsDraft = false
+	msg.BccRecipients = nil
+	if msg.ReceivedDateTime.IsZero() {
+		msg.ReceivedDateTime = time.Now()
+	}
+	if msg.Sender == nil {
+		msg.Sender = msg.From
+	}
+	msg.seq = s.nextSeq()
+	m.Messages = append(m.Messages, msg)
+	return s.notifications(m, "created", msg)
+}
+
+func (s *Server) notifyAsync(notifications []notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	go func() {
+		if err := s.notify(context.Background(), notifications); err != nil {
+			logrus.Errorf("graphfake: %v", err)
+		}
+	}()
+}
+
+// nextSeq must be called with s.lock held.
+func (s *Server) nextSeq() uint64 {
+	s.seq++
+	return s.seq
+}
+
+func newInternetMessageID() string {
+	return "<" + newID() + "@graphfake>"
+}
+
+// webLink mimics the Outlook on the web link Graph returns for a message.
+func webLink(id string) string {
+	return "https://outlook.office365.com/owa/?ItemID=" + url.QueryEscape(id) + "&exvsurl=1&viewmodel=ReadMessageItem"
+}
+
+func findMessage(m *Mailbox, id string) *Message {
+	for i := range m.Messages {
+		if m.Messages[i].ID == id {
+			return &m.Messages[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package graphfake
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// signingKeyID is the kid of the key ID tokens are signed with.
+const signingKeyID = "graphfake"
+
+// authCode is an authorization code waiting to be exchanged.
+type authCode struct {
+	key   string
+	scope string
+	// clientID, nonce and challenge are from the authorize request, ID tokens are issued to the client with the
+	// nonce, and the code is only exchanged with the verifier of the PKCE challenge.
+	clientID  string
+	nonce     string
+	challenge string
+}
+
+// issuer returns the issuer URL of the tenant, as seen by the client of the request.
+func issuer(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/" + mux.Vars(r)["tenant"] + "/v2.0"
+}
+
+func (s *Server) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	base := strings.TrimSuffix(issuer(r), "/v2.0")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                issuer(r),
+		"authorization_endpoint":                base + "/oauth2/v2.0/authorize",
+		"token_endpoint":                        base + "/oauth2/v2.0/token",
+		"jwks_uri":                              base + "/discovery/v2.0/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.SigningMethodEdDSA.Alg()},
+		"scopes_supported":                      []string{"openid", "profile", "email", "offline_access"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": signingKeyID,
+			"use": "sig",
+			"alg": jwt.SigningMethodEdDSA.Alg(),
+			"x":   base64.RawURLEncoding.EncodeToString(s.signingKey.Public().(ed25519.PublicKey)),
+		}},
+	})
+}
+
+// verifyChallenge checks the PKCE code verifier of a token request against the challenge of its code. Codes issued
+// without a challenge are exchanged without a verifier.
+func verifyChallenge(code authCode, verifier string) bool {
+	if code.challenge == "" {
+		return true
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == code.challenge
+}
+
+// idToken signs an ID token for the mailbox when the code was requested with the openid scope.
+func (s *Server) idToken(r *http.Request, code authCode) (string, error) {
+	if !slices.Contains(strings.Fields(code.scope), "openid") {
+		return "", nil
+	}
+
+	clientID := code.clientID
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID = id
+	}
+	mailbox := s.mailboxes[code.key]
+	user := mailbox.User
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                issuer(r),
+		"sub":                user.ID,
+		"aud":                clientID,
+		"email":              user.Mail,
+		"email_verified":     !mailbox.EmailUnverified,
+		"name":               user.DisplayName,
+		"preferred_username": user.UserPrincipalName,
+		"iat":                now.Unix(),
+		"exp":                now.Add(tokenLifetime).Unix(),
+	}
+	if code.nonce != "" {
+		claims["nonce"] = code.nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = signingKeyID
+	return token.SignedString(s.signingKey)
+}
@@ -0,0 +1,459 @@
+// Package graphfake is an in-memory stand-in for the subset of Microsoft Graph and the Microsoft identity platform
+// this project uses. Point GRAPH_BASE_URL at {server}/v1.0 and MICROSOFT_LOGIN_URL at the server root to run the
+// app, its webhooks and the gem-copilot tools without a Microsoft 365 tenant. It is also an OpenID Connect issuer at
+// {server}/{tenant}/v2.0 for testing OIDC sign in.
+package graphfake
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const tokenLifetime = time.Hour
+
+var wellKnownFolders = []MailFolder{
+	{DisplayName: "Inbox", WellKnownName: "inbox"},
+	{DisplayName: "Drafts", WellKnownName: "drafts"},
+	{DisplayName: "Sent Items", WellKnownName: "sentitems"},
+	{DisplayName: "Deleted Items", WellKnownName: "deleteditems"},
+	{DisplayName: "Junk Email", WellKnownName: "junkemail"},
+}
+
+type Server struct {
+	// Client delivers change notifications and subscription validation requests.
+	Client *http.Client
+
+	lock          sync.Mutex
+	router        *mux.Router
+	mailboxes     map[string]*Mailbox
+	order         []string
+	tokens        map[string]string
+	refreshTokens map[string]string
+	codes         map[string]authCode
+	subscriptions map[string]*Subscription
+	// seq orders message changes for delta queries.
+	seq uint64
+	// signingKey signs ID tokens.
+	signingKey ed25519.PrivateKey
+}
+
+func New() *Server {
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{
+		Client:        &http.Client{Timeout: 30 * time.Second},
+		mailboxes:     map[string]*Mailbox{},
+		tokens:        map[string]string{},
+		refreshTokens: map[string]string{},
+		codes:         map[string]authCode{},
+		subscriptions: map[string]*Subscription{},
+		signingKey:    signingKey,
+	}
+	s.router = s.routes()
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) routes() *mux.Router {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/{tenant}/oauth2/v2.0/authorize", s.authorize).Methods("GET")
+	r.HandleFunc("/{tenant}/oauth2/v2.0/token", s.token).Methods("POST")
+	r.HandleFunc("/{tenant}/v2.0/.well-known/openid-configuration", s.openIDConfiguration).Methods("GET")
+	r.HandleFunc("/{tenant}/discovery/v2.0/keys", s.keys).Methods("GET")
+
+	api := r.PathPrefix("/v1.0").Subrouter()
+	api.Use(s.authenticate, decompress)
+	api.HandleFunc("/me", s.me).Methods("GET")
+	mailboxRoutes(api.PathPrefix("/me").Subrouter(), s)
+	users := api.PathPrefix("/users/{user}").Subrouter()
+	users.Use(s.delegate)
+	users.HandleFunc("", s.me).Methods("GET")
+	mailboxRoutes(users, s)
+	api.HandleFunc("/subscriptions", s.listSubscriptions).Methods("GET")
+	api.HandleFunc("/subscriptions", s.createSubscription).Methods("POST")
+	api.HandleFunc("/subscriptions/{id}", s.getSubscription).Methods("GET")
+	api.HandleFunc("/subscriptions/{id}", s.updateSubscription).Methods("PATCH")
+	api.HandleFunc("/subscriptions/{id}", s.deleteSubscription).Methods("DELETE")
+	api.HandleFunc("/subscriptions/{id}/reauthorize", s.reauthorizeSubscription).Methods("POST")
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "UnknownResource", fmt.Sprintf("%v %v is not supported by graphfake", r.Method, r.URL.Path))
+	})
+	return r
+}
+
+// mailboxRoutes registers the routes that work on one mailbox. They are served under /me for the signed in user's
+// mailbox and under /users/{user} for mailboxes the user is a delegate of.
+func mailboxRoutes(r *mux.Router, s *Server) {
+	r.HandleFunc("/messages", s.listMessages).Methods("GET")
+	r.HandleFunc("/messages", s.createMessage).Methods("POST")
+	r.HandleFunc("/messages/{id}", s.getMessage).Methods("GET")
+	r.HandleFunc("/messages/{id}", s.updateMessage).Methods("PATCH")
+	r.HandleFunc("/messages/{id}", s.deleteMessage).Methods("DELETE")
+	r.HandleFunc("/messages/{id}/send", s.sendMessage).Methods("POST")
+	r.HandleFunc("/messages/{id}/move", s.moveMessage).Methods("POST")
+	r.HandleFunc("/mailFolders", s.listFolders).Methods("GET")
+	r.HandleFunc("/mailFolders", s.createFolder).Methods("POST")
+	r.HandleFunc("/mailFolders/{folder}/messages/delta()", s.messagesDelta).Methods("GET")
+	r.HandleFunc("/calendar/getSchedule", s.getSchedule).Methods("POST")
+	r.HandleFunc("/calendar/events", s.createEvent).Methods("POST")
+	r.HandleFunc("/events", s.createEvent).Methods("POST")
+	r.HandleFunc("/events/{id}", s.getEvent).Methods("GET")
+	r.HandleFunc("/events/{id}", s.updateEvent).Methods("PATCH")
+	r.HandleFunc("/people", s.listPeople).Methods("GET")
+	r.HandleFunc("/contacts", s.listContacts).Methods("GET")
+}
+
+// AddMailbox seeds a mailbox. Missing IDs are generated and the well-known mail folders are created if absent.
+func (s *Server) AddMailbox(m Mailbox) User {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if m.User.ID == "" {
+		m.User.ID = uuid.NewString()
+	}
+	if m.User.UserPrincipalName == "" {
+		m.User.UserPrincipalName = m.User.Mail
+	}
+	for _, wellKnown := range wellKnownFolders {
+		if folder := findFolder(&m, wellKnown.WellKnownName); folder == nil {
+			wellKnown.ID = newID()
+			m.Folders = append(m.Folders, wellKnown)
+		}
+	}
+	for i := range m.Folders {
+		if m.Folders[i].ID == "" {
+			m.Folders[i].ID = newID()
+		}
+	}
+	inbox := findFolder(&m, "inbox")
+	for i := range m.Messages {
+		msg := &m.Messages[i]
+		if msg.ID == "" {
+			msg.ID = newID()
+		}
+		if msg.ConversationID == "" {
+			msg.ConversationID = newID()
+		}
+		if msg.InternetMessageID == "" {
+			msg.InternetMessageID = newInternetMessageID()
+		}
+		if msg.ParentFolderID == "" {
+			msg.ParentFolderID = inbox.ID
+		} else if folder := findFolder(&m, msg.ParentFolderID); folder != nil {
+			msg.ParentFolderID = folder.ID
+		}
+		if msg.ReceivedDateTime.IsZero() {
+			msg.ReceivedDateTime = time.Now()
+		}
+		msg.seq = s.nextSeq()
+	}
+	for i := range m.Events {
+		if m.Events[i].ID == "" {
+			m.Events[i].ID = newID()
+		}
+	}
+	for i := range m.People {
+		if m.People[i].ID == "" {
+			m.People[i].ID = newID()
+		}
+	}
+	for i := range m.Contacts {
+		if m.Contacts[i].ID == "" {
+			m.Contacts[i].ID = newID()
+		}
+	}
+
+	key := strings.ToLower(m.User.Mail)
+	if _, ok := s.mailboxes[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.mailboxes[key] = &m
+	return m.User
+}
+
+// Mailbox returns a copy of the mailbox for the given address, for asserting on what the app did.
+func (s *Server) Mailbox(email string) (Mailbox, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m, ok := s.mailboxes[strings.ToLower(email)]
+	if !ok {
+		return Mailbox{}, false
+	}
+	ret := *m
+	ret.Folders = append([]MailFolder(nil), m.Folders...)
+	ret.Messages = append([]Message(nil), m.Messages...)
+	ret.Events = append([]Event(nil), m.Events...)
+	ret.People = append([]Person(nil), m.People...)
+	ret.Contacts = append([]Contact(nil), m.Contacts...)
+	ret.ScheduleItems = append([]ScheduleItem(nil), m.ScheduleItems...)
+	return ret, true
+}
+
+// Token issues an access token for the mailbox, skipping the OAuth flow.
+func (s *Server) Token(email string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := strings.ToLower(email)
+	if _, ok := s.mailboxes[key]; !ok {
+		return "", fmt.Errorf("no mailbox for %v", email)
+	}
+	token := newID()
+	s.tokens[token] = key
+	return token, nil
+}
+
+type tokenResponse struct {
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int64  `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// authorize signs in as login_hint, or the first mailbox, and immediately redirects back with an authorization code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURL.String() == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "redirect_uri is required")
+		return
+	}
+	if method := query.Get("code_challenge_method"); query.Get("code_challenge") != "" && method != "S256" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "only the S256 code_challenge_method is supported")
+		return
+	}
+
+	s.lock.Lock()
+	key := strings.ToLower(query.Get("login_hint"))
+	if _, ok := s.mailboxes[key]; !ok && len(s.order) > 0 {
+		key = s.order[0]
+	}
+	_, ok := s.mailboxes[key]
+	code := newID()
+	if ok {
+		s.codes[code] = authCode{
+			key:       key,
+			scope:     query.Get("scope"),
+			clientID:  query.Get("client_id"),
+			nonce:     query.Get("nonce"),
+			challenge: query.Get("code_challenge"),
+		}
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_request", "no mailbox to sign in as")
+		return
+	}
+
+	redirectQuery := redirectURL.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirectURL.RawQuery = redirectQuery.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		key     string
+		ok      bool
+		idToken string
+	)
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		var code authCode
+		code, ok = s.codes[r.PostForm.Get("code")]
+		delete(s.codes, r.PostForm.Get("code"))
+		if ok && !verifyChallenge(code, r.PostForm.Get("code_verifier")) {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "the code_verifier does not match the code_challenge")
+			return
+		}
+		if ok {
+			key = code.key
+			token, err := s.idToken(r, code)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+				return
+			}
+			idToken = token
+		}
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		key, ok = s.refreshTokens[refreshToken]
+		delete(s.refreshTokens, refreshToken)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code and refresh_token grants are supported")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "the code or refresh token is invalid or was already used")
+		return
+	}
+
+	resp := tokenResponse{
+		TokenType:    "Bearer",
+		Scope:        r.PostForm.Get("scope"),
+		ExpiresIn:    int64(tokenLifetime.Seconds()),
+		AccessToken:  newID(),
+		RefreshToken: newID(),
+		IDToken:      idToken,
+	}
+	s.tokens[resp.AccessToken] = key
+	s.refreshTokens[resp.RefreshToken] = key
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// decompress undoes the gzip content encoding the Graph SDK applies to request bodies.
+func decompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("invalid gzip body: %v", err))
+				return
+			}
+			defer body.Close()
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type mailboxKey struct{}
+
+// authenticate resolves the bearer token to a mailbox and stores its key in the request context.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.lock.Lock()
+		key, valid := s.tokens[token]
+		s.lock.Unlock()
+		if !ok || !valid {
+			writeError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "Access token is empty or invalid.")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), mailboxKey{}, key)))
+	})
+}
+
+// mailbox returns the mailbox of the authenticated request. The caller must hold s.lock.
+func (s *Server) mailbox(r *http.Request) *Mailbox {
+	key, _ := r.Context().Value(mailboxKey{}).(string)
+	return s.mailboxes[key]
+}
+
+// delegate resolves /users/{user} to the mailbox it names, which the signed in user must own or be a delegate of.
+func (s *Server) delegate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		key, target := s.findMailbox(mux.Vars(r)["user"])
+		allowed := target != nil && canAccess(s.mailbox(r), target)
+		s.lock.Unlock()
+		if target == nil {
+			writeError(w, http.StatusNotFound, "ErrorInvalidUser", fmt.Sprintf("The requested user '%v' is invalid.", mux.Vars(r)["user"]))
+			return
+		}
+		if !allowed {
+			writeError(w, http.StatusForbidden, "ErrorAccessDenied", "Access is denied. Check credentials and try again.")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), mailboxKey{}, key)))
+	})
+}
+
+// findMailbox returns the mailbox with the given address, ID or user principal name. The caller must hold s.lock.
+func (s *Server) findMailbox(user string) (string, *Mailbox) {
+	if m, ok := s.mailboxes[strings.ToLower(user)]; ok {
+		return strings.ToLower(user), m
+	}
+	for key, m := range s.mailboxes {
+		if m.User.ID == user || strings.EqualFold(m.User.UserPrincipalName, user) {
+			return key, m
+		}
+	}
+	return "", nil
+}
+
+func canAccess(signedIn, m *Mailbox) bool {
+	if signedIn == m {
+		return true
+	}
+	for _, delegate := range m.Delegates {
+		if strings.EqualFold(delegate, signedIn.User.Mail) {
+			return true
+		}
+	}
+	return false
+}
+
+func findFolder(m *Mailbox, idOrName string) *MailFolder {
+	for i, folder := range m.Folders {
+		if folder.ID == idOrName || (folder.WellKnownName != "" && strings.EqualFold(folder.WellKnownName, idOrName)) {
+			return &m.Folders[i]
+		}
+	}
+	return nil
+}
+
+func newID() string {
+	return strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+type collection[T any] struct {
+	Value []T `json:"value"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the OData format Graph uses, which the SDK surfaces as the error message.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]string{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,358 @@
+package graphfake
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSubscriptionLifetime is the longest Graph allows a subscription on mail resources to live.
+const maxSubscriptionLifetime = 10080 * time.Minute
+
+type notification struct {
+	url  string
+	body changeNotification
+}
+
+type changeNotification struct {
+	SubscriptionID                 string        `json:"subscriptionId"`
+	SubscriptionExpirationDateTime time.Time     `json:"subscriptionExpirationDateTime"`
+	ChangeType                     string        `json:"changeType,omitempty"`
+	LifecycleEvent                 string        `json:"lifecycleEvent,omitempty"`
+	Resource                       string        `json:"resource,omitempty"`
+	ResourceData                   *resourceData `json:"resourceData,omitempty"`
+	ClientState                    string        `json:"clientState,omitempty"`
+	TenantID                       string        `json:"tenantId"`
+}
+
+type resourceData struct {
+	ODataType string `json:"@odata.type"`
+	ODataID   string `json:"@odata.id"`
+	ID        string `json:"id"`
+}
+
+func (s *Server) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	owner := s.mailbox(r).User.Mail
+	var ret []Subscription
+	for _, sub := range s.subscriptions {
+		if sub.Owner == owner {
+			ret = append(ret, *sub)
+		}
+	}
+	writeJSON(w, http.StatusOK, collection[Subscription]{Value: ret})
+}
+
+// createSubscription runs the validation handshake against notificationUrl before the subscription is stored.
+func (s *Server) createSubscription(w http.ResponseWriter, r *http.Request) {
+	var sub Subscription
+	if !decode(w, r, &sub) {
+		return
+	}
+	if sub.ChangeType == "" || sub.Resource == "" || sub.NotificationURL == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "changeType, resource and notificationUrl are required.")
+		return
+	}
+	if err := checkExpiration(sub.ExpirationDateTime); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+	if err := s.validate(r.Context(), sub.NotificationURL); err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", fmt.Sprintf("Subscription validation request failed. %v", err))
+		return
+	}
+	if sub.LifecycleNotificationURL != "" {
+		if err := s.validate(r.Context(), sub.LifecycleNotificationURL); err != nil {
+			writeError(w, http.StatusBadRequest, "ValidationError", fmt.Sprintf("Subscription validation request failed. %v", err))
+			return
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	signedIn := s.mailbox(r)
+	watched := signedIn
+	if after, ok := strings.CutPrefix(strings.TrimPrefix(strings.ToLower(sub.Resource), "/"), "users/"); ok {
+		user, _, _ := strings.Cut(after, "/")
+		if _, watched = s.findMailbox(user); watched == nil || !canAccess(signedIn, watched) {
+			writeError(w, http.StatusForbidden, "ExtensionError", "Operation: Create; Exception: [Status Code: Forbidden; Reason: Access is denied.]")
+			return
+		}
+	}
+
+	sub.ID = newID()
+	sub.Owner = signedIn.User.Mail
+	sub.Mailbox = watched.User.Mail
+	s.subscriptions[sub.ID] = &sub
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (s *Server) getSubscription(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sub := s.ownSubscription(r)
+	if sub == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "The object was not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, sub)
+}
+
+type subscriptionUpdate struct {
+	ExpirationDateTime time.Time `json:"expirationDateTime"`
+}
+
+func (s *Server) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	var update subscriptionUpdate
+	if !decode(w, r, &update) {
+		return
+	}
+	if err := checkExpiration(update.ExpirationDateTime); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sub := s.ownSubscription(r)
+	if sub == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "The object was not found.")
+		return
+	}
+	sub.ExpirationDateTime = update.ExpirationDateTime
+	writeJSON(w, http.StatusOK, sub)
+}
+
+func (s *Server) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sub := s.ownSubscription(r)
+	if sub == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "The object was not found.")
+		return
+	}
+	delete(s.subscriptions, sub.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reauthorizeSubscription accepts every request, the fake never lets a subscription lose its authorization.
+func (s *Server) reauthorizeSubscription(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ownSubscription(r) == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "The object was not found.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SendLifecycleEvent sends a lifecycle event such as reauthorizationRequired, subscriptionRemoved or missed for the
+// subscription, to its lifecycleNotificationUrl or, without one, its notificationUrl. A removed subscription is
+// deleted before the event is sent.
+func (s *Server) SendLifecycleEvent(ctx context.Context, subscriptionID, event string) error {
+	s.lock.Lock()
+	sub, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		s.lock.Unlock()
+		return fmt.Errorf("no subscription %v", subscriptionID)
+	}
+	if event == "subscriptionRemoved" {
+		delete(s.subscriptions, subscriptionID)
+	}
+	target := sub.LifecycleNotificationURL
+	if target == "" {
+		target = sub.NotificationURL
+	}
+	n := notification{
+		url: target,
+		body: changeNotification{
+			SubscriptionID:                 sub.ID,
+			SubscriptionExpirationDateTime: sub.ExpirationDateTime,
+			LifecycleEvent:                 event,
+			ClientState:                    sub.ClientState,
+			TenantID:                       "graphfake",
+		},
+	}
+	s.lock.Unlock()
+
+	return s.notify(ctx, []notification{n})
+}
+
+// Subscriptions returns every active subscription across all mailboxes.
+func (s *Server) Subscriptions() []Subscription {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var ret []Subscription
+	for _, sub := range s.subscriptions {
+		ret = append(ret, *sub)
+	}
+	return ret
+}
+
+// ownSubscription returns the subscription in the request path if it belongs to the caller. The caller must hold
+// s.lock.
+func (s *Server) ownSubscription(r *http.Request) *Subscription {
+	sub, ok := s.subscriptions[mux.Vars(r)["id"]]
+	if !ok || sub.Owner != s.mailbox(r).User.Mail {
+		return nil
+	}
+	return sub
+}
+
+func checkExpiration(expiration time.Time) error {
+	if expiration.Before(time.Now()) {
+		return errors.New("expirationDateTime must be in the future.")
+	}
+	if expiration.After(time.Now().Add(maxSubscriptionLifetime)) {
+		return fmt.Errorf("expirationDateTime must be within %v minutes from now.", maxSubscriptionLifetime.Minutes())
+	}
+	return nil
+}
+
+// validate sends the validation token to notificationURL and expects it echoed back, the same handshake Graph does.
+func (s *Server) validate(ctx context.Context, notificationURL string) error {
+	u, err := url.Parse(notificationURL)
+	if err != nil {
+		return err
+	}
+	token := newID()
+	query := u.Query()
+	query.Set("validationToken", token)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notification endpoint returned %v", resp.StatusCode)
+	}
+	if string(body) != token {
+		return errors.New("notification endpoint did not echo the validation token")
+	}
+	return nil
+}
+
+// notifications returns the change notifications owed for msg in m. The caller must hold s.lock.
+func (s *Server) notifications(m *Mailbox, changeType string, msg Message) []notification {
+	var ret []notification
+	for _, sub := range s.subscriptions {
+		if sub.Mailbox != m.User.Mail || sub.ExpirationDateTime.Before(time.Now()) || !hasChangeType(sub.ChangeType, changeType) {
+			continue
+		}
+		if !matchesResource(m, sub.Resource, msg) {
+			continue
+		}
+		resource := fmt.Sprintf("Users/%v/Messages/%v", m.User.ID, msg.ID)
+		ret = append(ret, notification{
+			url: sub.NotificationURL,
+			body: changeNotification{
+				SubscriptionID:                 sub.ID,
+				SubscriptionExpirationDateTime: sub.ExpirationDateTime,
+				ChangeType:                     changeType,
+				Resource:                       resource,
+				ResourceData: &resourceData{
+					ODataType: "#Microsoft.Graph.Message",
+					ODataID:   resource,
+					ID:        msg.ID,
+				},
+				ClientState: sub.ClientState,
+				TenantID:    "graphfake",
+			},
+		})
+	}
+	return ret
+}
+
+// notify posts each notification and returns the first failure, after trying them all.
+func (s *Server) notify(ctx context.Context, notifications []notification) error {
+	var errs []error
+	for _, n := range notifications {
+		data, err := json.Marshal(collection[changeNotification]{Value: []changeNotification{n.body}})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to send notification to %v: %w", n.url, err))
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			errs = append(errs, fmt.Errorf("notification endpoint %v returned %v", n.url, resp.StatusCode))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func hasChangeType(changeTypes, changeType string) bool {
+	for _, t := range strings.Split(changeTypes, ",") {
+		if strings.TrimSpace(t) == changeType {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesResource supports the two message resource forms the project subscribes to, me/messages and
+// me/mailFolders('{folder}')/messages, where folder is a well-known name, display name or ID.
+func matchesResource(m *Mailbox, resource string, msg Message) bool {
+	resource = strings.ToLower(strings.TrimPrefix(resource, "/"))
+	resource, _, _ = strings.Cut(resource, "?")
+	if after, ok := strings.CutPrefix(resource, "me/"); ok {
+		resource = after
+	} else if after, ok := strings.CutPrefix(resource, "users/"); ok {
+		_, resource, _ = strings.Cut(after, "/")
+	}
+
+	if resource == "messages" {
+		return true
+	}
+	name, ok := strings.CutPrefix(resource, "mailfolders('")
+	if !ok {
+		return false
+	}
+	name, ok = strings.CutSuffix(name, "')/messages")
+	if !ok {
+		return false
+	}
+	for _, folder := range m.Folders {
+		if folder.ID != msg.ParentFolderID {
+			continue
+		}
+		return strings.EqualFold(folder.ID, name) || strings.EqualFold(folder.WellKnownName, name) || strings.EqualFold(folder.DisplayName, name)
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package graphfake
+
+import "time"
+
+// The types below mirror the JSON shapes Graph uses for the resources this project touches. Only the fields the
+// project reads or writes are included.
+
+type User struct {
+	ID                string `json:"id"`
+	DisplayName       string `json:"displayName"`
+	Mail              string `json:"mail"`
+	UserPrincipalName string `json:"userPrincipalName"`
+}
+
+type EmailAddress struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+type Recipient struct {
+	EmailAddress EmailAddress `json:"emailAddress"`
+}
+
+type ItemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type Message struct {
+	ID                string      `json:"id"`
+	ConversationID    string      `json:"conversationId"`
+	InternetMessageID string      `json:"internetMessageId"`
+	ParentFolderID    string      `json:"parentFolderId"`
+	Subject           string      `json:"subject"`
+	Body              ItemBody    `json:"body"`
+	From              *Recipient  `json:"from,omitempty"`
+	Sender            *Recipient  `json:"sender,omitempty"`
+	ToRecipients      []Recipient `json:"toRecipients"`
+	CcRecipients      []Recipient `json:"ccRecipients"`
+	BccRecipients     []Recipient `json:"bccRecipients"`
+	IsDraft           bool        `json:"isDraft"`
+	ReceivedDateTime  time.Time   `json:"receivedDateTime"`
+	WebLink           string      `json:"webLink"`
+
+	// seq is the Server.seq of the last time the message was added to a folder.
+	seq uint64
+}
+
+type MailFolder struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	IsHidden    bool   `json:"isHidden"`
+	// WellKnownName lets callers address the folder by names such as inbox or sentitems, the same as Graph.
+	WellKnownName string `json:"-"`
+}
+
+type DateTimeTimeZone struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type Attendee struct {
+	EmailAddress EmailAddress `json:"emailAddress"`
+	Type         string       `json:"type,omitempty"`
+}
+
+type OnlineMeetingInfo struct {
+	JoinURL      string `json:"joinUrl,omitempty"`
+	TollNumber   string `json:"tollNumber,omitempty"`
+	ConferenceID string `json:"conferenceId,omitempty"`
+}
+
+type Event struct {
+	ID                    string             `json:"id"`
+	Subject               string             `json:"subject"`
+	Body                  ItemBody           `json:"body"`
+	Start                 DateTimeTimeZone   `json:"start"`
+	End                   DateTimeTimeZone   `json:"end"`
+	Attendees             []Attendee         `json:"attendees"`
+	Organizer             *Recipient         `json:"organizer,omitempty"`
+	IsOnlineMeeting       bool               `json:"isOnlineMeeting"`
+	OnlineMeetingProvider string             `json:"onlineMeetingProvider,omitempty"`
+	OnlineMeeting         *OnlineMeetingInfo `json:"onlineMeeting,omitempty"`
+}
+
+type ScheduleItem struct {
+	Status  string           `json:"status"`
+	Subject string           `json:"subject,omitempty"`
+	Start   DateTimeTimeZone `json:"start"`
+	End     DateTimeTimeZone `json:"end"`
+}
+
+type ScheduleInformation struct {
+	ScheduleID       string         `json:"scheduleId"`
+	AvailabilityView string         `json:"availabilityView"`
+	ScheduleItems    []ScheduleItem `json:"scheduleItems"`
+}
+
+type ScoredEmailAddress struct {
+	Address        string  `json:"address"`
+	RelevanceScore float64 `json:"relevanceScore,omitempty"`
+}
+
+type Person struct {
+	ID                   string               `json:"id"`
+	DisplayName          string               `json:"displayName"`
+	ScoredEmailAddresses []ScoredEmailAddress `json:"scoredEmailAddresses"`
+}
+
+type Contact struct {
+	ID             string         `json:"id"`
+	DisplayName    string         `json:"displayName"`
+	GivenName      string         `json:"givenName,omitempty"`
+	Surname        string         `json:"surname,omitempty"`
+	EmailAddresses []EmailAddress `json:"emailAddresses"`
+}
+
+type Subscription struct {
+	ID              string `json:"id"`
+	ChangeType      string `json:"changeType"`
+	NotificationURL string `json:"notificationUrl"`
+	// LifecycleNotificationURL receives lifecycle events, see Server.SendLifecycleEvent.
+	LifecycleNotificationURL string    `json:"lifecycleNotificationUrl,omitempty"`
+	Resource                 string    `json:"resource"`
+	ExpirationDateTime       time.Time `json:"expirationDateTime"`
+	ClientState              string    `json:"clientState,omitempty"`
+	// Owner is the mail address of the signed in user who created the subscription, Mailbox that of the mailbox it
+	// watches, which differ for subscriptions a delegate created on /users/{user}.
+	Owner   string `json:"-"`
+	Mailbox string `json:"-"`
+}
+
+// Mailbox is everything the fake knows about one user. It doubles as the fixture format, where any missing IDs and
+// the default mail folders are filled in when the mailbox is added.
+type Mailbox struct {
+	User     User         `json:"user"`
+	Folders  []MailFolder `json:"folders,omitempty"`
+	Messages []Message    `json:"messages,omitempty"`
+	Events   []Event      `json:"events,omitempty"`
+	People   []Person     `json:"people,omitempty"`
+	Contacts []Contact    `json:"contacts,omitempty"`
+	// ScheduleItems are returned by getSchedule in addition to the mailbox's events.
+	ScheduleItems []ScheduleItem `json:"scheduleItems,omitempty"`
+	// Delegates are the addresses of users who can work on the mailbox through /users/{user}, like delegate access
+	// in Exchange.
+	Delegates []string `json:"delegates,omitempty"`
+	// EmailUnverified issues ID tokens with email_verified false, like an identity provider that lets users sign up
+	// with any address.
+	EmailUnverified bool `json:"emailUnverified,omitempty"`
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"ethan/pkg/llmfake"
+
+	"github.com/sirupsen/logrus"
+)
+
+// llmfake serves the fixtures in LLMFAKE_FIXTURES, a JSON file or a directory of them, as an OpenAI compatible API.
+func main() {
+	addr := os.Getenv("LLMFAKE_ADDR")
+	if addr == "" {
+		addr = ":8091"
+	}
+
+	var fixtures []llmfake.Fixture
+	if path := os.Getenv("LLMFAKE_FIXTURES"); path != "" {
+		var err error
+		fixtures, err = llmfake.Load(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	logrus.Infof("LLM fake listening on %v with %v fixtures", addr, len(fixtures))
+	log.Fatal(http.ListenAndServe(addr, llmfake.New(fixtures...)))
+}
@@ -0,0 +1,129 @@
+package llmfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Fixture is one canned completion. The first fixture, in the order they were added, that matches a request and is
+// not used up answers it.
+type Fixture struct {
+	Name  string `json:"name,omitempty"`
+	Match Match  `json:"match"`
+	// Times is how many requests the fixture answers before it is used up. Zero means no limit.
+	Times    int      `json:"times,omitempty"`
+	Response Response `json:"response"`
+}
+
+// Match describes the requests a fixture answers. Every condition that is set must hold, and an empty Match answers
+// everything.
+type Match struct {
+	Model string `json:"model,omitempty"`
+	// Contains must all appear somewhere in the conversation, including the system prompt.
+	Contains []string `json:"contains,omitempty"`
+	// LastContains must all appear in the last message of the conversation.
+	LastContains []string `json:"lastContains,omitempty"`
+	// LastRole is the role of the last message, such as user or tool.
+	LastRole string `json:"lastRole,omitempty"`
+	// Tool requires the request to offer a tool with this name. gptscript offers tools in camel case, such as
+	// checkAvailability for check-availability.
+	Tool string `json:"tool,omitempty"`
+}
+
+type Response struct {
+	Content   string     `json:"content,omitempty"`
+	ToolCalls []ToolCall `json:"toolCalls,omitempty"`
+	// Error fails the request with this message and Status, which defaults to 500.
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+type ToolCall struct {
+	// Name is the tool as offered to the model, in camel case for gptscript tools.
+	Name string `json:"name"`
+	// Arguments is either a JSON string that is passed through as is, or any other JSON value which is sent encoded.
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+func (t ToolCall) arguments() string {
+	var s string
+	if err := json.Unmarshal(t.Arguments, &s); err == nil {
+		return s
+	}
+	if len(t.Arguments) == 0 {
+		return "{}"
+	}
+	return string(t.Arguments)
+}
+
+func (m Match) matches(req chatRequest) bool {
+	if m.Model != "" && m.Model != req.Model {
+		return false
+	}
+	if m.Tool != "" && !req.hasTool(m.Tool) {
+		return false
+	}
+
+	var last message
+	if len(req.Messages) > 0 {
+		last = req.Messages[len(req.Messages)-1]
+	}
+	if m.LastRole != "" && m.LastRole != last.Role {
+		return false
+	}
+	for _, s := range m.LastContains {
+		if !strings.Contains(last.text(), s) {
+			return false
+		}
+	}
+
+	if len(m.Contains) > 0 {
+		var all strings.Builder
+		for _, msg := range req.Messages {
+			all.WriteString(msg.text())
+			all.WriteString("\n")
+		}
+		for _, s := range m.Contains {
+			if !strings.Contains(all.String(), s) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Load reads fixtures from a JSON file holding a list of fixtures, or from every .json file in a directory in name
+// order.
+func Load(path string) ([]Fixture, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	var ret []Fixture
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var fixtures []Fixture
+		if err := json.Unmarshal(data, &fixtures); err != nil {
+			return nil, fmt.Errorf("invalid fixture file %v: %w", file, err)
+		}
+		ret = append(ret, fixtures...)
+	}
+	return ret, nil
+}
@@ -0,0 +1,352 @@
+// Package llmfake is a scripted OpenAI compatible chat completion server. It replays canned completions and tool
+// calls from fixtures so task runs and webhook classification can run without a model. Point OPENAI_BASE_URL at
+// {server}/v1 to use it.
+package llmfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultModel = "gpt-4o"
+
+type Server struct {
+	lock     sync.Mutex
+	router   *mux.Router
+	models   []string
+	fixtures []*fixture
+	requests []Request
+}
+
+type fixture struct {
+	Fixture
+	used int
+}
+
+// Request is a chat completion request the server received, kept for asserting on the prompts the app sent.
+type Request struct {
+	Model    string
+	Messages []Message
+	Tools    []string
+	// Fixture is the name of the fixture that answered, empty when none matched.
+	Fixture string
+}
+
+type Message struct {
+	Role    string
+	Content string
+}
+
+func New(fixtures ...Fixture) *Server {
+	s := &Server{
+		models: []string{DefaultModel},
+	}
+	s.Add(fixtures...)
+
+	s.router = mux.NewRouter()
+	s.router.HandleFunc("/v1/models", s.listModels).Methods("GET")
+	s.router.HandleFunc("/v1/chat/completions", s.chatCompletions).Methods("POST")
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Add appends fixtures after the ones already loaded. Models they name are added to the model list.
+func (s *Server) Add(fixtures ...Fixture) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, f := range fixtures {
+		s.fixtures = append(s.fixtures, &fixture{Fixture: f})
+		if f.Match.Model != "" && !contains(s.models, f.Match.Model) {
+			s.models = append(s.models, f.Match.Model)
+		}
+	}
+}
+
+// Requests returns every chat completion request received so far.
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// Pending returns the names of fixtures with a Times limit that have not been used up, which usually means a
+// conversation ended earlier than the test expected.
+func (s *Server) Pending() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var ret []string
+	for _, f := range s.fixtures {
+		if f.Times > 0 && f.used < f.Times {
+			ret = append(ret, f.Name)
+		}
+	}
+	return ret
+}
+
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func (s *Server) listModels(w http.ResponseWriter, _ *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var data []model
+	for _, m := range s.models {
+		data = append(data, model{ID: m, Object: "model", OwnedBy: "llmfake"})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+type chatRequest struct {
+	Model    string    `json:"model"`
+	Messages []message `json:"messages"`
+	Tools    []tool    `json:"tools"`
+	Stream   bool      `json:"stream"`
+}
+
+type message struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the message content, which is either a string or a list of content parts.
+func (m message) text() string {
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+type tool struct {
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+func (r chatRequest) hasTool(name string) bool {
+	for _, t := range r.Tools {
+		if t.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	resp, name, ok := s.match(req)
+	if !ok {
+		var last string
+		if len(req.Messages) > 0 {
+			last = req.Messages[len(req.Messages)-1].text()
+		}
+		logrus.Warnf("llmfake: no fixture matches request, last message: %q", last)
+		writeError(w, http.StatusBadRequest, "llmfake: no fixture matches the request")
+		return
+	}
+	if resp.Error != "" {
+		status := resp.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, resp.Error)
+		return
+	}
+
+	if req.Model == "" {
+		req.Model = DefaultModel
+	}
+	completion := newCompletion(req.Model, resp)
+	logrus.Debugf("llmfake: fixture %q answered", name)
+	if req.Stream {
+		stream(w, completion)
+		return
+	}
+	writeJSON(w, http.StatusOK, completion)
+}
+
+// match records the request and returns the response of the first matching fixture.
+func (s *Server) match(req chatRequest) (Response, string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	recorded := Request{Model: req.Model}
+	for _, msg := range req.Messages {
+		recorded.Messages = append(recorded.Messages, Message{Role: msg.Role, Content: msg.text()})
+	}
+	for _, t := range req.Tools {
+		recorded.Tools = append(recorded.Tools, t.Function.Name)
+	}
+	defer func() {
+		s.requests = append(s.requests, recorded)
+	}()
+
+	for _, f := range s.fixtures {
+		if f.Times > 0 && f.used >= f.Times {
+			continue
+		}
+		if !f.Match.matches(req) {
+			continue
+		}
+		f.used++
+		recorded.Fixture = f.Name
+		return f.Response, f.Name, true
+	}
+	return Response{}, "", false
+}
+
+type completion struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+	Usage   *usage   `json:"usage,omitempty"`
+}
+
+type choice struct {
+	Index        int            `json:"index"`
+	Message      *choiceMessage `json:"message,omitempty"`
+	Delta        *choiceMessage `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type choiceMessage struct {
+	Role      string         `json:"role,omitempty"`
+	Content   string         `json:"content"`
+	ToolCalls []toolCallJSON `json:"tool_calls,omitempty"`
+}
+
+type toolCallJSON struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func newCompletion(model string, resp Response) completion {
+	finishReason := "stop"
+	msg := &choiceMessage{
+		Role:    "assistant",
+		Content: resp.Content,
+	}
+	for i, call := range resp.ToolCalls {
+		index := i
+		tc := toolCallJSON{
+			Index: &index,
+			ID:    "call_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:24],
+			Type:  "function",
+		}
+		tc.Function.Name = call.Name
+		tc.Function.Arguments = call.arguments()
+		msg.ToolCalls = append(msg.ToolCalls, tc)
+		finishReason = "tool_calls"
+	}
+
+	return completion{
+		ID:      "chatcmpl-" + uuid.NewString(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []choice{{Message: msg, FinishReason: &finishReason}},
+		Usage:   &usage{},
+	}
+}
+
+// stream sends the completion as server-sent events, one chunk with the whole message and one with the finish
+// reason.
+func stream(w http.ResponseWriter, c completion) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	finishReason := c.Choices[0].FinishReason
+	chunks := []completion{
+		{Choices: []choice{{Delta: c.Choices[0].Message}}},
+		{Choices: []choice{{Delta: &choiceMessage{}, FinishReason: finishReason}}, Usage: c.Usage},
+	}
+	for _, chunk := range chunks {
+		chunk.ID, chunk.Created, chunk.Model = c.ID, c.Created, c.Model
+		chunk.Object = "chat.completion.chunk"
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the OpenAI format, which clients surface as the error message.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    "invalid_request_error",
+		},
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
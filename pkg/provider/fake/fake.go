@@ -0,0 +1,256 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ethan/pkg/provider"
+
+	"github.com/google/uuid"
+)
+
+// Provider is an in-memory provider.Provider for tests. Every mailbox operation is recorded on the exported fields.
+type Provider struct {
+	lock sync.Mutex
+
+	User     provider.User
+	Messages map[string]provider.Message
+	// Folders maps message IDs to the folder they are filed in.
+	Folders  map[string]string
+	Sent     []provider.Message
+	Events   map[string]provider.Event
+	Schedule []provider.ScheduleItem
+	People   []provider.Person
+	Contacts []provider.Person
+}
+
+const draftsFolder = "drafts"
+
+func New(user provider.User) *Provider {
+	return &Provider{
+		User:     user,
+		Messages: map[string]provider.Message{},
+		Folders:  map[string]string{},
+		Events:   map[string]provider.Event{},
+	}
+}
+
+// Factory returns a provider.Factory that always hands out p, regardless of the token.
+func (p *Provider) Factory() provider.Factory {
+	return func(string) (provider.Provider, error) {
+		return p, nil
+	}
+}
+
+// Deliver puts a message into the inbox and returns it with IDs filled in.
+func (p *Provider) Deliver(message provider.Message) provider.Message {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if message.ID == "" {
+		message.ID = uuid.NewString()
+	}
+	if message.ConversationID == "" {
+		message.ConversationID = uuid.NewString()
+	}
+	if message.InternetMessageID == "" {
+		message.InternetMessageID = "<" + uuid.NewString() + "@fake>"
+	}
+	p.Messages[message.ID] = message
+	p.Folders[message.ID] = provider.FolderInbox
+	return message
+}
+
+func (p *Provider) Me(context.Context) (provider.User, error) {
+	return p.User, nil
+}
+
+func (p *Provider) SendMessage(_ context.Context, message provider.Message) (provider.Message, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	message.ID = uuid.NewString()
+	if message.ConversationID == "" {
+		message.ConversationID = uuid.NewString()
+	}
+	if message.InternetMessageID == "" {
+		message.InternetMessageID = "<" + uuid.NewString() + "@fake>"
+	}
+	message.SenderName = p.User.Name
+	message.SenderAddress = p.User.Email
+	p.Sent = append(p.Sent, message)
+	return message, nil
+}
+
+// CreateDraft files the message in the "drafts" folder. Sending it moves it to Sent.
+func (p *Provider) CreateDraft(_ context.Context, message provider.Message) (provider.Message, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	message.ID = uuid.NewString()
+	if message.ConversationID == "" {
+		message.ConversationID = uuid.NewString()
+	}
+	message.SenderName = p.User.Name
+	message.SenderAddress = p.User.Email
+	message.WebLink = "https://fake/drafts/" + message.ID
+	p.Messages[message.ID] = message
+	p.Folders[message.ID] = draftsFolder
+	return message, nil
+}
+
+func (p *Provider) UpdateDraft(_ context.Context, id string, message provider.Message) (provider.Message, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	m, err := p.draft(id)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	m.Subject, m.Body, m.To, m.Cc, m.Bcc = message.Subject, message.Body, message.To, message.Cc, message.Bcc
+	p.Messages[id] = m
+	return m, nil
+}
+
+func (p *Provider) SendDraft(_ context.Context, id string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	m, err := p.draft(id)
+	if err != nil {
+		return err
+	}
+	delete(p.Messages, id)
+	delete(p.Folders, id)
+	m.InternetMessageID = "<" + uuid.NewString() + "@fake>"
+	p.Sent = append(p.Sent, m)
+	return nil
+}
+
+func (p *Provider) DeleteDraft(_ context.Context, id string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, err := p.draft(id); err != nil {
+		return err
+	}
+	delete(p.Messages, id)
+	delete(p.Folders, id)
+	return nil
+}
+
+func (p *Provider) draft(id string) (provider.Message, error) {
+	m, ok := p.Messages[id]
+	if !ok || p.Folders[id] != draftsFolder {
+		return provider.Message{}, fmt.Errorf("draft %v not found", id)
+	}
+	return m, nil
+}
+
+func (p *Provider) ListMessages(_ context.Context, opts provider.ListOptions) ([]provider.Message, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var ret []provider.Message
+	for _, m := range p.Messages {
+		if opts.Top > 0 && len(ret) >= int(opts.Top) {
+			break
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+func (p *Provider) GetMessage(_ context.Context, id string) (provider.Message, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	m, ok := p.Messages[id]
+	if !ok {
+		return provider.Message{}, fmt.Errorf("message %v not found", id)
+	}
+	return m, nil
+}
+
+// MoveMessage mimics Graph, which hands out a new ID for a moved message.
+func (p *Provider) MoveMessage(_ context.Context, id string, folder string) (provider.Message, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	m, ok := p.Messages[id]
+	if !ok {
+		return provider.Message{}, fmt.Errorf("message %v not found", id)
+	}
+	delete(p.Messages, id)
+	delete(p.Folders, id)
+
+	m.ID = uuid.NewString()
+	p.Messages[m.ID] = m
+	p.Folders[m.ID] = folder
+	return m, nil
+}
+
+func (p *Provider) EnsureFolder(context.Context, string) error {
+	return nil
+}
+
+func (p *Provider) GetSchedule(_ context.Context, emails []string, _, _ time.Time, _ string) ([]provider.ScheduleItem, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var ret []provider.ScheduleItem
+	for _, item := range p.Schedule {
+		for _, email := range emails {
+			if strings.EqualFold(item.Email, strings.TrimSpace(email)) {
+				ret = append(ret, item)
+				break
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (p *Provider) CreateEvent(_ context.Context, event provider.Event) (provider.Event, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	event.ID = uuid.NewString()
+	event.Organizer = p.User.Name
+	p.Events[event.ID] = event
+	return event, nil
+}
+
+func (p *Provider) AddOnlineMeeting(_ context.Context, eventID string) (*provider.OnlineMeeting, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.Events[eventID]; !ok {
+		return nil, fmt.Errorf("event %v not found", eventID)
+	}
+	return &provider.OnlineMeeting{
+		URL: fmt.Sprintf("https://meeting.example.com/%v", eventID),
+	}, nil
+}
+
+func (p *Provider) SearchPeople(_ context.Context, query string) ([]provider.Person, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var ret []provider.Person
+	for _, person := range p.People {
+		if strings.Contains(strings.ToLower(person.Name), strings.ToLower(strings.TrimSpace(query))) {
+			ret = append(ret, person)
+		}
+	}
+	return ret, nil
+}
+
+func (p *Provider) ListContacts(context.Context) ([]provider.Person, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]provider.Person(nil), p.Contacts...), nil
+}
@@ -0,0 +1,512 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"ethan/pkg/mstoken"
+	"ethan/pkg/provider"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+	graphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+const Name = "graph"
+
+// Provider implements provider.Provider on top of Microsoft Graph.
+type Provider struct {
+	client *msgraphsdk.GraphServiceClient
+	// user is the address of the mailbox a delegate works on. Empty is the signed in user's own mailbox.
+	user string
+}
+
+// NewClient returns a Graph client authenticated with a static bearer token. Setting GRAPH_BASE_URL points the
+// client at another Graph compatible server, such as graphfake.
+func NewClient(token string) (*msgraphsdk.GraphServiceClient, error) {
+	cred := mstoken.NewStaticTokenCredential(token)
+	baseURL := os.Getenv("GRAPH_BASE_URL")
+	if baseURL == "" {
+		return msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GRAPH_BASE_URL %q: %w", baseURL, err)
+	}
+	client, err := msgraphsdk.NewGraphServiceClientWithCredentialsAndHosts(cred, []string{}, []string{u.Hostname()})
+	if err != nil {
+		return nil, err
+	}
+	client.GetAdapter().SetBaseUrl(strings.TrimSuffix(baseURL, "/"))
+	return client, nil
+}
+
+func New(token string) (provider.Provider, error) {
+	client, err := NewClient(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{client: client}, nil
+}
+
+// Delegate returns a provider that works on the mailbox and calendar of user through /users/{user}, which needs the
+// signed in user to be a delegate of that mailbox in Exchange.
+func (p *Provider) Delegate(user string) provider.Provider {
+	return &Provider{client: p.client, user: user}
+}
+
+// mailbox is the request builder for the mailbox the provider works on.
+func (p *Provider) mailbox() *graphusers.UserItemRequestBuilder {
+	if p.user == "" {
+		return p.client.Me()
+	}
+	return p.client.Users().ByUserId(p.user)
+}
+
+func (p *Provider) Me(ctx context.Context) (provider.User, error) {
+	me, err := p.mailbox().Get(ctx, nil)
+	if err != nil {
+		return provider.User{}, err
+	}
+	return provider.User{
+		Name:  deref(me.GetDisplayName()),
+		Email: deref(me.GetMail()),
+	}, nil
+}
+
+func (p *Provider) SendMessage(ctx context.Context, message provider.Message) (provider.Message, error) {
+	m, err := p.CreateDraft(ctx, message)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	if err := p.SendDraft(ctx, m.ID); err != nil {
+		return provider.Message{}, err
+	}
+	return m, nil
+}
+
+// CreateDraft saves the message in the Drafts folder of the mailbox.
+func (p *Provider) CreateDraft(ctx context.Context, message provider.Message) (provider.Message, error) {
+	requestBody := newMessage(message)
+	if p.user != "" {
+		// Sent on behalf of the mailbox owner, Graph sets the delegate as the sender.
+		requestBody.SetFrom(recipients([]string{p.user})[0])
+	}
+
+	m, err := p.mailbox().Messages().Post(ctx, requestBody, nil)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	return toMessage(m), nil
+}
+
+func (p *Provider) UpdateDraft(ctx context.Context, id string, message provider.Message) (provider.Message, error) {
+	m, err := p.mailbox().Messages().ByMessageId(id).Patch(ctx, newMessage(message), nil)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	return toMessage(m), nil
+}
+
+func (p *Provider) SendDraft(ctx context.Context, id string) error {
+	return p.mailbox().Messages().ByMessageId(id).Send().Post(ctx, nil)
+}
+
+func (p *Provider) DeleteDraft(ctx context.Context, id string) error {
+	return p.mailbox().Messages().ByMessageId(id).Delete(ctx, nil)
+}
+
+func (p *Provider) ListMessages(ctx context.Context, opts provider.ListOptions) ([]provider.Message, error) {
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("Prefer", "outlook.body-content-type=text")
+	configuration := &graphusers.ItemMessagesRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+	}
+	if opts.Top > 0 {
+		configuration.QueryParameters = &graphusers.ItemMessagesRequestBuilderGetQueryParameters{
+			Top: &opts.Top,
+		}
+	}
+
+	messages, err := p.mailbox().Messages().Get(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []provider.Message
+	for _, m := range messages.GetValue() {
+		ret = append(ret, toMessage(m))
+	}
+	return ret, nil
+}
+
+func (p *Provider) GetMessage(ctx context.Context, id string) (provider.Message, error) {
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("Prefer", "outlook.body-content-type=text")
+	configuration := &graphusers.ItemMessagesMessageItemRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+	}
+	m, err := p.mailbox().Messages().ByMessageId(id).Get(ctx, configuration)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	return toMessage(m), nil
+}
+
+func (p *Provider) MoveMessage(ctx context.Context, id string, folder string) (provider.Message, error) {
+	folderID, err := p.folderID(ctx, folder)
+	if err != nil {
+		return provider.Message{}, err
+	}
+
+	requestBody := graphusers.NewItemMessagesItemMovePostRequestBody()
+	requestBody.SetDestinationId(&folderID)
+	m, err := p.mailbox().Messages().ByMessageId(id).Move().Post(ctx, requestBody, nil)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	return toMessage(m), nil
+}
+
+// SyncInbox runs a delta query on the inbox. The token is the delta link Graph returned for the previous sync.
+func (p *Provider) SyncInbox(ctx context.Context, token string, since time.Time) ([]string, string, error) {
+	builder := p.mailbox().MailFolders().ByMailFolderId(provider.FolderInbox).Messages().Delta()
+	var configuration *graphusers.ItemMailfoldersItemMessagesDeltaRequestBuilderGetRequestConfiguration
+	if token != "" {
+		builder = builder.WithUrl(token)
+	} else {
+		filter := "receivedDateTime ge " + since.UTC().Format(time.RFC3339)
+		configuration = &graphusers.ItemMailfoldersItemMessagesDeltaRequestBuilderGetRequestConfiguration{
+			QueryParameters: &graphusers.ItemMailfoldersItemMessagesDeltaRequestBuilderGetQueryParameters{
+				Filter: &filter,
+				Select: []string{"id"},
+			},
+		}
+	}
+
+	var ids []string
+	for {
+		page, err := builder.GetAsDeltaGetResponse(ctx, configuration)
+		if err != nil {
+			var e *odataerrors.ODataError
+			if errors.As(err, &e) && e.ResponseStatusCode == http.StatusGone {
+				return nil, "", provider.ErrSyncTokenExpired
+			}
+			return nil, "", err
+		}
+		for _, m := range page.GetValue() {
+			// Messages that left the inbox are returned with an @removed annotation.
+			if _, removed := m.GetAdditionalData()["@removed"]; removed || m.GetId() == nil {
+				continue
+			}
+			ids = append(ids, *m.GetId())
+		}
+
+		if next := page.GetOdataNextLink(); next != nil && *next != "" {
+			builder = builder.WithUrl(*next)
+			configuration = nil
+			continue
+		}
+		if deltaLink := page.GetOdataDeltaLink(); deltaLink != nil && *deltaLink != "" {
+			return ids, *deltaLink, nil
+		}
+		return nil, "", errors.New("delta response has neither a next nor a delta link")
+	}
+}
+
+func (p *Provider) EnsureFolder(ctx context.Context, name string) error {
+	requestBody := graphmodels.NewMailFolder()
+	requestBody.SetDisplayName(&name)
+	isHidden := false
+	requestBody.SetIsHidden(&isHidden)
+
+	if _, err := p.mailbox().MailFolders().Post(ctx, requestBody, nil); err != nil {
+		if !strings.Contains(err.Error(), "already exists") {
+			return err
+		}
+	}
+	return nil
+}
+
+// folderID resolves a folder display name to its ID. Well-known names such as inbox are passed through.
+func (p *Provider) folderID(ctx context.Context, folder string) (string, error) {
+	if folder == provider.FolderInbox {
+		return folder, nil
+	}
+
+	folders, err := p.mailbox().MailFolders().Get(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get folder list: %w", err)
+	}
+	for _, f := range folders.GetValue() {
+		if f.GetDisplayName() != nil && *f.GetDisplayName() == folder {
+			return *f.GetId(), nil
+		}
+	}
+	return "", fmt.Errorf("failed to find folder %q", folder)
+}
+
+func (p *Provider) GetSchedule(ctx context.Context, emails []string, start, end time.Time, timeZone string) ([]provider.ScheduleItem, error) {
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("Prefer", fmt.Sprintf("outlook.body-content-type=text,outlook.timezone=%q", timeZone))
+	configuration := &graphusers.ItemCalendarGetscheduleGetScheduleRequestBuilderPostRequestConfiguration{
+		Headers: headers,
+	}
+
+	requestBody := graphusers.NewItemCalendarGetscheduleGetSchedulePostRequestBody()
+	requestBody.SetSchedules(emails)
+	startString := start.Format(time.RFC3339)
+	endString := end.Format(time.RFC3339)
+	startTime := graphmodels.NewDateTimeTimeZone()
+	startTime.SetDateTime(&startString)
+	startTime.SetTimeZone(&timeZone)
+	requestBody.SetStartTime(startTime)
+	endTime := graphmodels.NewDateTimeTimeZone()
+	endTime.SetDateTime(&endString)
+	endTime.SetTimeZone(&timeZone)
+	requestBody.SetEndTime(endTime)
+	availabilityViewInterval := int32(60)
+	requestBody.SetAvailabilityViewInterval(&availabilityViewInterval)
+
+	schedules, err := p.mailbox().Calendar().GetSchedule().PostAsGetSchedulePostResponse(ctx, requestBody, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []provider.ScheduleItem
+	for _, s := range schedules.GetValue() {
+		for _, item := range s.GetScheduleItems() {
+			status := provider.FreeBusyUnknown
+			if item.GetStatus() != nil {
+				status = provider.FreeBusyStatus(item.GetStatus().String())
+			}
+			ret = append(ret, provider.ScheduleItem{
+				Email:    deref(s.GetScheduleId()),
+				Status:   status,
+				Start:    deref(item.GetStart().GetDateTime()),
+				End:      deref(item.GetEnd().GetDateTime()),
+				TimeZone: timeZone,
+				Subject:  deref(item.GetSubject()),
+			})
+		}
+	}
+	return ret, nil
+}
+
+func (p *Provider) CreateEvent(ctx context.Context, event provider.Event) (provider.Event, error) {
+	eventRequestBody := graphmodels.NewEvent()
+	eventRequestBody.SetSubject(&event.Subject)
+	body := graphmodels.NewItemBody()
+	contentType := graphmodels.HTML_BODYTYPE
+	body.SetContentType(&contentType)
+	body.SetContent(&event.Content)
+	eventRequestBody.SetBody(body)
+	start := graphmodels.NewDateTimeTimeZone()
+	start.SetDateTime(&event.Start)
+	start.SetTimeZone(&event.TimeZone)
+	eventRequestBody.SetStart(start)
+	end := graphmodels.NewDateTimeTimeZone()
+	end.SetDateTime(&event.End)
+	end.SetTimeZone(&event.TimeZone)
+	eventRequestBody.SetEnd(end)
+
+	var attendees []graphmodels.Attendeeable
+	for _, addr := range event.Attendees {
+		email := addr
+		attendee := graphmodels.NewAttendee()
+		emailAddress := graphmodels.NewEmailAddress()
+		emailAddress.SetAddress(&email)
+		attendee.SetEmailAddress(emailAddress)
+		attendees = append(attendees, attendee)
+	}
+	eventRequestBody.SetAttendees(attendees)
+
+	created, err := p.mailbox().Calendar().Events().Post(ctx, eventRequestBody, nil)
+	if err != nil {
+		return provider.Event{}, err
+	}
+	return toEvent(created), nil
+}
+
+func (p *Provider) AddOnlineMeeting(ctx context.Context, eventID string) (*provider.OnlineMeeting, error) {
+	requestBody := graphmodels.NewEvent()
+	isOnlineMeeting := true
+	requestBody.SetIsOnlineMeeting(&isOnlineMeeting)
+	onlineMeetingProvider := graphmodels.TEAMSFORBUSINESS_ONLINEMEETINGPROVIDERTYPE
+	requestBody.SetOnlineMeetingProvider(&onlineMeetingProvider)
+	if _, err := p.mailbox().Events().ByEventId(eventID).Patch(ctx, requestBody, nil); err != nil {
+		return nil, err
+	}
+
+	event, err := p.mailbox().Events().ByEventId(eventID).Get(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if event.GetOnlineMeeting() == nil {
+		return nil, nil
+	}
+	return &provider.OnlineMeeting{
+		URL:          deref(event.GetOnlineMeeting().GetJoinUrl()),
+		TollNumber:   deref(event.GetOnlineMeeting().GetTollNumber()),
+		ConferenceID: deref(event.GetOnlineMeeting().GetConferenceId()),
+	}, nil
+}
+
+func (p *Provider) SearchPeople(ctx context.Context, query string) ([]provider.Person, error) {
+	config := &graphusers.ItemPeopleRequestBuilderGetRequestConfiguration{
+		QueryParameters: &graphusers.ItemPeopleRequestBuilderGetQueryParameters{
+			Search: &query,
+		},
+	}
+	people, err := p.mailbox().People().Get(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []provider.Person
+	for _, contact := range people.GetValue() {
+		if contact.GetDisplayName() == nil {
+			continue
+		}
+		var emails []string
+		for _, email := range contact.GetScoredEmailAddresses() {
+			if email.GetAddress() != nil {
+				emails = append(emails, *email.GetAddress())
+			}
+		}
+		ret = append(ret, provider.Person{
+			Name:   *contact.GetDisplayName(),
+			Emails: emails,
+		})
+	}
+	return ret, nil
+}
+
+func (p *Provider) ListContacts(ctx context.Context) ([]provider.Person, error) {
+	result, err := p.mailbox().Contacts().Get(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []provider.Person
+	for _, contact := range result.GetValue() {
+		var displayName string
+		if contact.GetDisplayName() != nil && *contact.GetDisplayName() != "" {
+			displayName = *contact.GetDisplayName()
+		} else if contact.GetGivenName() != nil && contact.GetSurname() != nil {
+			displayName = fmt.Sprintf("%v %v", *contact.GetGivenName(), *contact.GetSurname())
+		}
+
+		var emails []string
+		for _, email := range contact.GetEmailAddresses() {
+			if email.GetAddress() != nil {
+				emails = append(emails, *email.GetAddress())
+			}
+		}
+		ret = append(ret, provider.Person{
+			Name:   displayName,
+			Emails: emails,
+		})
+	}
+	return ret, nil
+}
+
+func recipients(addresses []string) []graphmodels.Recipientable {
+	var ret []graphmodels.Recipientable
+	for _, r := range addresses {
+		emailAddress := r
+		rep := graphmodels.NewRecipient()
+		addr := graphmodels.NewEmailAddress()
+		addr.SetAddress(&emailAddress)
+		rep.SetEmailAddress(addr)
+		ret = append(ret, rep)
+	}
+	return ret
+}
+
+func newMessage(message provider.Message) graphmodels.Messageable {
+	requestBody := graphmodels.NewMessage()
+	requestBody.SetSubject(&message.Subject)
+	body := graphmodels.NewItemBody()
+	contentType := graphmodels.TEXT_BODYTYPE
+	body.SetContentType(&contentType)
+	body.SetContent(&message.Body)
+	requestBody.SetBody(body)
+	requestBody.SetToRecipients(recipients(message.To))
+	requestBody.SetCcRecipients(recipients(message.Cc))
+	requestBody.SetBccRecipients(recipients(message.Bcc))
+	return requestBody
+}
+
+func toMessage(m graphmodels.Messageable) provider.Message {
+	message := provider.Message{
+		ID:                deref(m.GetId()),
+		ConversationID:    deref(m.GetConversationId()),
+		InternetMessageID: deref(m.GetInternetMessageId()),
+		Subject:           deref(m.GetSubject()),
+		WebLink:           deref(m.GetWebLink()),
+	}
+	if m.GetBody() != nil {
+		message.Body = deref(m.GetBody().GetContent())
+	}
+	if m.GetSender() != nil && m.GetSender().GetEmailAddress() != nil {
+		message.SenderName = deref(m.GetSender().GetEmailAddress().GetName())
+		message.SenderAddress = deref(m.GetSender().GetEmailAddress().GetAddress())
+	}
+	message.To = addresses(m.GetToRecipients())
+	message.Cc = addresses(m.GetCcRecipients())
+	message.Bcc = addresses(m.GetBccRecipients())
+	return message
+}
+
+func toEvent(e graphmodels.Eventable) provider.Event {
+	event := provider.Event{
+		ID:      deref(e.GetId()),
+		Subject: deref(e.GetSubject()),
+	}
+	if e.GetBody() != nil {
+		event.Content = deref(e.GetBody().GetContent())
+	}
+	if e.GetStart() != nil {
+		event.Start = deref(e.GetStart().GetDateTime())
+		event.TimeZone = deref(e.GetStart().GetTimeZone())
+	}
+	if e.GetEnd() != nil {
+		event.End = deref(e.GetEnd().GetDateTime())
+	}
+	if e.GetOrganizer() != nil && e.GetOrganizer().GetEmailAddress() != nil {
+		event.Organizer = deref(e.GetOrganizer().GetEmailAddress().GetName())
+	}
+	for _, attendee := range e.GetAttendees() {
+		if attendee.GetEmailAddress() != nil && attendee.GetEmailAddress().GetAddress() != nil {
+			event.Attendees = append(event.Attendees, *attendee.GetEmailAddress().GetAddress())
+		}
+	}
+	return event
+}
+
+func addresses(recipients []graphmodels.Recipientable) []string {
+	var ret []string
+	for _, r := range recipients {
+		if r.GetEmailAddress() != nil && r.GetEmailAddress().GetAddress() != nil {
+			ret = append(ret, *r.GetEmailAddress().GetAddress())
+		}
+	}
+	return ret
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
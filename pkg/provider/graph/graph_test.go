@@ -0,0 +1,297 @@
+package graph
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/graphfake"
+	"ethan/pkg/provider"
+)
+
+const (
+	aliceEmail = "alice@example.com"
+	bobEmail   = "bob@example.com"
+)
+
+// newTestProvider points the Graph client at a graphfake server with the mailboxes of Alice and Bob, and returns the
+// server with a provider signed in as Alice.
+func newTestProvider(t *testing.T) (*graphfake.Server, provider.Provider) {
+	t.Helper()
+	fake := graphfake.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	t.Setenv("GRAPH_BASE_URL", srv.URL+"/v1.0")
+
+	fake.AddMailbox(graphfake.Mailbox{User: graphfake.User{DisplayName: "Alice", Mail: aliceEmail}})
+	fake.AddMailbox(graphfake.Mailbox{User: graphfake.User{DisplayName: "Bob", Mail: bobEmail}, Delegates: []string{aliceEmail}})
+	return fake, signIn(t, fake, aliceEmail)
+}
+
+func signIn(t *testing.T, fake *graphfake.Server, email string) provider.Provider {
+	t.Helper()
+	token, err := fake.Token(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// folder returns the messages of the mailbox in the folder with the given well-known or display name.
+func folder(t *testing.T, fake *graphfake.Server, email, name string) []graphfake.Message {
+	t.Helper()
+	m, _ := fake.Mailbox(email)
+	var folderID string
+	for _, f := range m.Folders {
+		if f.WellKnownName == name || f.DisplayName == name {
+			folderID = f.ID
+		}
+	}
+	var messages []graphfake.Message
+	for _, msg := range m.Messages {
+		if msg.ParentFolderID == folderID {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+func TestMe(t *testing.T) {
+	_, p := newTestProvider(t)
+
+	me, err := p.Me(context.Background())
+	if err != nil {
+		t.Fatalf("Me() = %v", err)
+	}
+	if me != (provider.User{Name: "Alice", Email: aliceEmail}) {
+		t.Errorf("Me() = %+v", me)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	fake, p := newTestProvider(t)
+	ctx := context.Background()
+
+	sent, err := p.SendMessage(ctx, provider.Message{
+		Subject: "Lunch",
+		Body:    "Are you free on Friday?",
+		To:      []string{bobEmail},
+		Cc:      []string{"cc@example.org"},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage() = %v", err)
+	}
+	if sent.ID == "" || sent.ConversationID == "" {
+		t.Errorf("SendMessage() IDs = %q, %q, want both set", sent.ID, sent.ConversationID)
+	}
+	if got := folder(t, fake, aliceEmail, "sentitems"); len(got) != 1 || got[0].Subject != "Lunch" {
+		t.Errorf("Sent Items of Alice = %+v, want the message", got)
+	}
+	if got := folder(t, fake, aliceEmail, "drafts"); len(got) != 0 {
+		t.Errorf("Drafts of Alice = %+v, want none", got)
+	}
+
+	inbox := folder(t, fake, bobEmail, "inbox")
+	if len(inbox) != 1 {
+		t.Fatalf("inbox of Bob = %+v, want the message", inbox)
+	}
+	received, err := signIn(t, fake, bobEmail).GetMessage(ctx, inbox[0].ID)
+	if err != nil {
+		t.Fatalf("GetMessage() = %v", err)
+	}
+	if received.Subject != "Lunch" || received.Body != "Are you free on Friday?" || received.SenderAddress != aliceEmail || received.SenderName != "Alice" {
+		t.Errorf("GetMessage() = %+v", received)
+	}
+	if received.ConversationID != sent.ConversationID {
+		t.Errorf("GetMessage() conversation = %q, want %q of the sent message", received.ConversationID, sent.ConversationID)
+	}
+	if !slices.Equal(received.To, []string{bobEmail}) || !slices.Equal(received.Cc, []string{"cc@example.org"}) {
+		t.Errorf("GetMessage() recipients = to %v cc %v", received.To, received.Cc)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	fake, p := newTestProvider(t)
+	d := p.(provider.Drafter)
+	ctx := context.Background()
+
+	draft, err := d.CreateDraft(ctx, provider.Message{Subject: "Offsite", Body: "Which dates work?", To: []string{bobEmail}})
+	if err != nil {
+		t.Fatalf("CreateDraft() = %v", err)
+	}
+	if draft.WebLink == "" {
+		t.Error("CreateDraft() returned no web link")
+	}
+	updated, err := d.UpdateDraft(ctx, draft.ID, provider.Message{Subject: "Team offsite", Body: "Which dates work for you?", To: []string{bobEmail}})
+	if err != nil {
+		t.Fatalf("UpdateDraft() = %v", err)
+	}
+	if updated.ID != draft.ID || updated.Subject != "Team offsite" {
+		t.Errorf("UpdateDraft() = %+v", updated)
+	}
+	if err := d.SendDraft(ctx, draft.ID); err != nil {
+		t.Fatalf("SendDraft() = %v", err)
+	}
+	if got := folder(t, fake, bobEmail, "inbox"); len(got) != 1 || got[0].Subject != "Team offsite" {
+		t.Errorf("inbox of Bob = %+v, want the edited draft", got)
+	}
+
+	discarded, err := d.CreateDraft(ctx, provider.Message{Subject: "Never mind", To: []string{bobEmail}})
+	if err != nil {
+		t.Fatalf("CreateDraft() = %v", err)
+	}
+	if err := d.DeleteDraft(ctx, discarded.ID); err != nil {
+		t.Fatalf("DeleteDraft() = %v", err)
+	}
+	if got := folder(t, fake, aliceEmail, "drafts"); len(got) != 0 {
+		t.Errorf("Drafts of Alice = %+v, want none", got)
+	}
+}
+
+func TestMoveMessage(t *testing.T) {
+	fake, p := newTestProvider(t)
+	ctx := context.Background()
+	delivered, err := fake.Deliver(ctx, aliceEmail, graphfake.Message{
+		Subject: "Buy now",
+		From:    &graphfake.Recipient{EmailAddress: graphfake.EmailAddress{Address: "cold@example.org"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.EnsureFolder(ctx, provider.FolderColdEmails); err != nil {
+		t.Fatalf("EnsureFolder() = %v", err)
+	}
+	// A second call finds the folder.
+	if err := p.EnsureFolder(ctx, provider.FolderColdEmails); err != nil {
+		t.Fatalf("EnsureFolder() again = %v", err)
+	}
+	moved, err := p.MoveMessage(ctx, delivered.ID, provider.FolderColdEmails)
+	if err != nil {
+		t.Fatalf("MoveMessage() = %v", err)
+	}
+	if moved.ID == delivered.ID || moved.InternetMessageID != delivered.InternetMessageID {
+		t.Errorf("MoveMessage() IDs = %q, %q, want a new ID and the same Message-ID", moved.ID, moved.InternetMessageID)
+	}
+	if got := folder(t, fake, aliceEmail, "inbox"); len(got) != 0 {
+		t.Errorf("inbox of Alice = %+v, want it empty", got)
+	}
+	if got := folder(t, fake, aliceEmail, provider.FolderColdEmails); len(got) != 1 || got[0].ID != moved.ID {
+		t.Errorf("%v of Alice = %+v, want the moved message", provider.FolderColdEmails, got)
+	}
+
+	// Moving it back to the inbox uses the well-known name.
+	back, err := p.MoveMessage(ctx, moved.ID, provider.FolderInbox)
+	if err != nil {
+		t.Fatalf("MoveMessage() back = %v", err)
+	}
+	if _, err := p.GetMessage(ctx, back.ID); err != nil {
+		t.Errorf("GetMessage() of the message moved back = %v", err)
+	}
+}
+
+func TestSyncInbox(t *testing.T) {
+	fake, p := newTestProvider(t)
+	syncer := p.(provider.InboxSyncer)
+	ctx := context.Background()
+	since := time.Now().Add(-time.Minute)
+
+	first, err := fake.Deliver(ctx, aliceEmail, graphfake.Message{Subject: "First"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, token, err := syncer.SyncInbox(ctx, "", since)
+	if err != nil {
+		t.Fatalf("SyncInbox() = %v", err)
+	}
+	if !slices.Equal(ids, []string{first.ID}) || token == "" {
+		t.Errorf("SyncInbox() = %v, %q, want the first message and a token", ids, token)
+	}
+
+	second, err := fake.Deliver(ctx, aliceEmail, graphfake.Message{Subject: "Second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, next, err := syncer.SyncInbox(ctx, token, since)
+	if err != nil {
+		t.Fatalf("SyncInbox() with a token = %v", err)
+	}
+	if !slices.Equal(ids, []string{second.ID}) || next == "" {
+		t.Errorf("SyncInbox() with a token = %v, %q, want only the second message", ids, next)
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	fake, p := newTestProvider(t)
+	ctx := context.Background()
+
+	event, err := p.CreateEvent(ctx, provider.Event{
+		Subject:   "Sync",
+		Content:   "Weekly sync",
+		Start:     "2024-07-01T10:00:00",
+		End:       "2024-07-01T11:00:00",
+		TimeZone:  "Pacific Standard Time",
+		Attendees: []string{bobEmail},
+	})
+	if err != nil {
+		t.Fatalf("CreateEvent() = %v", err)
+	}
+	if event.ID == "" || event.Subject != "Sync" || !slices.Equal(event.Attendees, []string{bobEmail}) {
+		t.Errorf("CreateEvent() = %+v", event)
+	}
+	meeting, err := p.AddOnlineMeeting(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("AddOnlineMeeting() = %v", err)
+	}
+	if meeting == nil || meeting.URL == "" {
+		t.Errorf("AddOnlineMeeting() = %+v, want a join URL", meeting)
+	}
+
+	start := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	items, err := p.GetSchedule(ctx, []string{aliceEmail, "nobody@example.org"}, start, start.Add(24*time.Hour), "Pacific Standard Time")
+	if err != nil {
+		t.Fatalf("GetSchedule() = %v", err)
+	}
+	if len(items) != 1 || items[0].Email != aliceEmail || !items[0].Status.Busy() || !strings.HasPrefix(items[0].Start, "2024-07-01T10:00:00") {
+		t.Errorf("GetSchedule() = %+v, want Alice busy during the event", items)
+	}
+	if m, _ := fake.Mailbox(aliceEmail); len(m.Events) != 1 || !m.Events[0].IsOnlineMeeting {
+		t.Errorf("events of Alice = %+v, want the online meeting", m.Events)
+	}
+}
+
+func TestDelegate(t *testing.T) {
+	fake, p := newTestProvider(t)
+	ctx := context.Background()
+	bob := p.(provider.Delegator).Delegate(bobEmail)
+
+	me, err := bob.Me(ctx)
+	if err != nil {
+		t.Fatalf("Me() of the delegated mailbox = %v", err)
+	}
+	if me.Email != bobEmail {
+		t.Errorf("Me() of the delegated mailbox = %+v, want Bob", me)
+	}
+	if _, err := bob.SendMessage(ctx, provider.Message{Subject: "On behalf of Bob", To: []string{aliceEmail}}); err != nil {
+		t.Fatalf("SendMessage() on behalf of Bob = %v", err)
+	}
+	if got := folder(t, fake, bobEmail, "sentitems"); len(got) != 1 {
+		t.Errorf("Sent Items of Bob = %+v, want the message", got)
+	}
+	inbox := folder(t, fake, aliceEmail, "inbox")
+	if len(inbox) != 1 || inbox[0].From == nil || inbox[0].From.EmailAddress.Address != bobEmail {
+		t.Errorf("inbox of Alice = %+v, want the message from Bob", inbox)
+	}
+
+	// Bob is not a delegate of Alice's mailbox.
+	if _, err := signIn(t, fake, bobEmail).(provider.Delegator).Delegate(aliceEmail).Me(ctx); err == nil {
+		t.Error("Me() of a mailbox that was not shared succeeded")
+	}
+}
@@ -0,0 +1,188 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ethan/pkg/provider"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/google/uuid"
+)
+
+const calendarDateTimeFormat = "2006-01-02T15:04:05"
+
+var errNoCalendar = errors.New("no caldav calendar is configured for this mailbox")
+
+// windowsTimeZones maps the Windows time zone names the tools use with Graph onto IANA names.
+var windowsTimeZones = map[string]string{
+	"Pacific Standard Time":  "America/Los_Angeles",
+	"Mountain Standard Time": "America/Denver",
+	"Central Standard Time":  "America/Chicago",
+	"Eastern Standard Time":  "America/New_York",
+	"GMT Standard Time":      "Europe/London",
+	"UTC":                    "UTC",
+}
+
+func location(timeZone string) *time.Location {
+	if name, ok := windowsTimeZones[timeZone]; ok {
+		timeZone = name
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (p *Provider) calendar() (*caldav.Client, error) {
+	if p.cfg.CalDAVURL == "" {
+		return nil, errNoCalendar
+	}
+	httpClient := webdav.HTTPClientWithBasicAuth(http.DefaultClient, p.cfg.Username, p.cfg.Password)
+	return caldav.NewClient(httpClient, p.cfg.CalDAVURL)
+}
+
+// GetSchedule only knows about the user's own calendar. CalDAV has no portable way to read other people's free/busy
+// information, so other addresses come back without schedule items.
+func (p *Provider) GetSchedule(ctx context.Context, emails []string, start, end time.Time, timeZone string) ([]provider.ScheduleItem, error) {
+	var own bool
+	for _, email := range emails {
+		if strings.EqualFold(strings.TrimSpace(email), p.cfg.Email) {
+			own = true
+		}
+	}
+	if !own {
+		return nil, nil
+	}
+
+	c, err := p.calendar()
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := c.QueryCalendar(ctx, p.calendarPath(), &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CalendarCompRequest{{Name: ical.CompEvent, AllProps: true}},
+		},
+		CompFilter: caldav.CompFilter{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CompFilter{{Name: ical.CompEvent, Start: start, End: end}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calendar: %w", err)
+	}
+
+	loc := location(timeZone)
+	var ret []provider.ScheduleItem
+	for _, object := range objects {
+		for _, event := range object.Data.Events() {
+			status := provider.FreeBusyBusy
+			if transp, _ := event.Props.Text(ical.PropTransparency); transp == "TRANSPARENT" {
+				status = provider.FreeBusyFree
+			}
+			eventStart, err := event.DateTimeStart(loc)
+			if err != nil {
+				continue
+			}
+			eventEnd, err := event.DateTimeEnd(loc)
+			if err != nil {
+				continue
+			}
+			summary, _ := event.Props.Text(ical.PropSummary)
+			ret = append(ret, provider.ScheduleItem{
+				Email:    p.cfg.Email,
+				Status:   status,
+				Start:    eventStart.In(loc).Format(calendarDateTimeFormat),
+				End:      eventEnd.In(loc).Format(calendarDateTimeFormat),
+				TimeZone: timeZone,
+				Subject:  summary,
+			})
+		}
+	}
+	return ret, nil
+}
+
+func (p *Provider) CreateEvent(ctx context.Context, event provider.Event) (provider.Event, error) {
+	c, err := p.calendar()
+	if err != nil {
+		return provider.Event{}, err
+	}
+
+	loc := location(event.TimeZone)
+	start, err := parseEventTime(event.Start, loc)
+	if err != nil {
+		return provider.Event{}, err
+	}
+	end, err := parseEventTime(event.End, loc)
+	if err != nil {
+		return provider.Event{}, err
+	}
+
+	id := uuid.NewString()
+	vevent := ical.NewEvent()
+	vevent.Props.SetText(ical.PropUID, id)
+	vevent.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	vevent.Props.SetDateTime(ical.PropDateTimeStart, start.UTC())
+	vevent.Props.SetDateTime(ical.PropDateTimeEnd, end.UTC())
+	vevent.Props.SetText(ical.PropSummary, event.Subject)
+	vevent.Props.SetText(ical.PropDescription, event.Content)
+	organizer := ical.NewProp(ical.PropOrganizer)
+	organizer.Value = "mailto:" + p.cfg.Email
+	organizer.Params.Set(ical.ParamCommonName, p.cfg.Name)
+	vevent.Props.Set(organizer)
+	for _, attendee := range event.Attendees {
+		prop := ical.NewProp(ical.PropAttendee)
+		prop.Value = "mailto:" + attendee
+		vevent.Props.Add(prop)
+	}
+
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, "-//gptscript-ai//mail-assistant//EN")
+	cal.Children = append(cal.Children, vevent.Component)
+
+	if _, err := c.PutCalendarObject(ctx, p.calendarPath()+id+".ics", cal); err != nil {
+		return provider.Event{}, fmt.Errorf("failed to create calendar event: %w", err)
+	}
+
+	event.ID = id
+	event.Organizer = p.cfg.Name
+	event.Start = start.In(loc).Format(calendarDateTimeFormat)
+	event.End = end.In(loc).Format(calendarDateTimeFormat)
+	return event, nil
+}
+
+// AddOnlineMeeting is a no-op, self-hosted calendars have no conferencing service to attach.
+func (p *Provider) AddOnlineMeeting(context.Context, string) (*provider.OnlineMeeting, error) {
+	return nil, nil
+}
+
+func (p *Provider) calendarPath() string {
+	u, err := url.Parse(p.cfg.CalDAVURL)
+	if err != nil {
+		return "/"
+	}
+	return strings.TrimSuffix(u.Path, "/") + "/"
+}
+
+// parseEventTime accepts RFC3339 as asked of the model, and falls back to a local time in loc.
+func parseEventTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(calendarDateTimeFormat, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid event time %q: %w", s, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,422 @@
+package imap
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+
+	"ethan/pkg/provider"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message/mail"
+)
+
+const (
+	Name = "imap"
+
+	inbox = "INBOX"
+	sent  = "Sent"
+
+	defaultListSize = 50
+)
+
+// Config holds everything needed to reach a self-hosted mailbox. It is stored JSON encoded in place of an OAuth token.
+type Config struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// IMAPAddr and SMTPAddr are host:port pairs. Port 993 and 465 use implicit TLS, other ports use STARTTLS.
+	IMAPAddr string `json:"imapAddr"`
+	SMTPAddr string `json:"smtpAddr"`
+	// CalDAVURL is the calendar collection events are read from and written to. Calendar tools are disabled when empty.
+	CalDAVURL string `json:"caldavURL,omitempty"`
+	// Insecure disables TLS. Only meant for local, in-process test servers.
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+func ParseConfig(token string) (Config, error) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(token), &cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid imap credential: %w", err)
+	}
+	if cfg.IMAPAddr == "" || cfg.SMTPAddr == "" || cfg.Email == "" {
+		return Config{}, errors.New("invalid imap credential: imapAddr, smtpAddr and email are required")
+	}
+	if cfg.Username == "" {
+		cfg.Username = cfg.Email
+	}
+	return cfg, nil
+}
+
+// Token encodes the config so it can be stored as the user's credential.
+func (c Config) Token() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Provider implements provider.Provider with IMAP for reading, SMTP for sending and CalDAV for calendars.
+// Messages are identified by their Message-ID header, which unlike IMAP UIDs survives moving between folders.
+type Provider struct {
+	cfg Config
+}
+
+func New(token string) (provider.Provider, error) {
+	cfg, err := ParseConfig(token)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromConfig(cfg), nil
+}
+
+func NewFromConfig(cfg Config) *Provider {
+	return &Provider{cfg: cfg}
+}
+
+// Verify logs into the IMAP server to check the credentials.
+func (p *Provider) Verify() error {
+	c, err := p.dial()
+	if err != nil {
+		return err
+	}
+	return c.Logout()
+}
+
+func (p *Provider) dial() (*client.Client, error) {
+	var (
+		c   *client.Client
+		err error
+	)
+	host, port, _ := strings.Cut(p.cfg.IMAPAddr, ":")
+	switch {
+	case p.cfg.Insecure:
+		c, err = client.Dial(p.cfg.IMAPAddr)
+	case port == "993":
+		c, err = client.DialTLS(p.cfg.IMAPAddr, &tls.Config{ServerName: host})
+	default:
+		c, err = client.Dial(p.cfg.IMAPAddr)
+		if err == nil {
+			err = c.StartTLS(&tls.Config{ServerName: host})
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to imap server %v: %w", p.cfg.IMAPAddr, err)
+	}
+
+	if err := c.Login(p.cfg.Username, p.cfg.Password); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("failed to login to imap server: %w", err)
+	}
+	return c, nil
+}
+
+func (p *Provider) Me(context.Context) (provider.User, error) {
+	name := p.cfg.Name
+	if name == "" {
+		name = p.cfg.Email
+	}
+	return provider.User{
+		Name:  name,
+		Email: p.cfg.Email,
+	}, nil
+}
+
+func (p *Provider) ListMessages(_ context.Context, opts provider.ListOptions) ([]provider.Message, error) {
+	c, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Logout()
+
+	status, err := c.Select(inbox, true)
+	if err != nil {
+		return nil, err
+	}
+	if status.Messages == 0 {
+		return nil, nil
+	}
+
+	top := uint32(defaultListSize)
+	if opts.Top > 0 {
+		top = uint32(opts.Top)
+	}
+	from := uint32(1)
+	if status.Messages > top {
+		from = status.Messages - top + 1
+	}
+	seqset := new(goimap.SeqSet)
+	seqset.AddRange(from, status.Messages)
+
+	messages, err := fetch(c, seqset, false)
+	if err != nil {
+		return nil, err
+	}
+	// Newest first, the same order Graph returns.
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (p *Provider) GetMessage(_ context.Context, id string) (provider.Message, error) {
+	c, err := p.dial()
+	if err != nil {
+		return provider.Message{}, err
+	}
+	defer c.Logout()
+
+	uid, err := locate(c, id)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	seqset := new(goimap.SeqSet)
+	seqset.AddNum(uid)
+	messages, err := fetch(c, seqset, true)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	if len(messages) == 0 {
+		return provider.Message{}, fmt.Errorf("message %v not found", id)
+	}
+	return messages[0], nil
+}
+
+func (p *Provider) MoveMessage(_ context.Context, id string, folder string) (provider.Message, error) {
+	c, err := p.dial()
+	if err != nil {
+		return provider.Message{}, err
+	}
+	defer c.Logout()
+
+	uid, err := locate(c, id)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	seqset := new(goimap.SeqSet)
+	seqset.AddNum(uid)
+	messages, err := fetch(c, seqset, true)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	if len(messages) == 0 {
+		return provider.Message{}, fmt.Errorf("message %v not found", id)
+	}
+
+	if err := c.UidMove(seqset, mailboxName(folder)); err != nil {
+		return provider.Message{}, fmt.Errorf("failed to move message %v to %v: %w", id, folder, err)
+	}
+	return messages[0], nil
+}
+
+func (p *Provider) EnsureFolder(_ context.Context, name string) error {
+	c, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	exists, err := mailboxExists(c, name)
+	if err != nil || exists {
+		return err
+	}
+	return c.Create(name)
+}
+
+// SearchPeople looks through the senders of recent inbox messages, since plain IMAP has no address book.
+func (p *Provider) SearchPeople(ctx context.Context, query string) ([]provider.Person, error) {
+	messages, err := p.ListMessages(ctx, provider.ListOptions{Top: 200})
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	seen := map[string]struct{}{}
+	var ret []provider.Person
+	for _, m := range messages {
+		if m.SenderAddress == "" || !strings.Contains(strings.ToLower(m.SenderName), query) {
+			continue
+		}
+		if _, ok := seen[m.SenderAddress]; ok {
+			continue
+		}
+		seen[m.SenderAddress] = struct{}{}
+		ret = append(ret, provider.Person{
+			Name:   m.SenderName,
+			Emails: []string{m.SenderAddress},
+		})
+	}
+	return ret, nil
+}
+
+func (p *Provider) ListContacts(context.Context) ([]provider.Person, error) {
+	return nil, nil
+}
+
+// locate finds the message with the given Message-ID, looking in the inbox first and then every other mailbox.
+// The mailbox the message was found in is left selected.
+func locate(c *client.Client, id string) (uint32, error) {
+	criteria := goimap.NewSearchCriteria()
+	criteria.Header = textproto.MIMEHeader{"Message-Id": {"<" + id + ">"}}
+
+	mailboxes := []string{inbox}
+	ch := make(chan *goimap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", "*", ch)
+	}()
+	for m := range ch {
+		if !strings.EqualFold(m.Name, inbox) {
+			mailboxes = append(mailboxes, m.Name)
+		}
+	}
+	if err := <-done; err != nil {
+		return 0, err
+	}
+
+	for _, mailbox := range mailboxes {
+		if _, err := c.Select(mailbox, false); err != nil {
+			continue
+		}
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			return 0, err
+		}
+		if len(uids) > 0 {
+			return uids[0], nil
+		}
+	}
+	return 0, fmt.Errorf("message %v not found", id)
+}
+
+func mailboxExists(c *client.Client, name string) (bool, error) {
+	ch := make(chan *goimap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", name, ch)
+	}()
+	var exists bool
+	for range ch {
+		exists = true
+	}
+	return exists, <-done
+}
+
+func mailboxName(folder string) string {
+	if strings.EqualFold(folder, provider.FolderInbox) {
+		return inbox
+	}
+	return folder
+}
+
+// fetch loads and parses the messages in seqset from the selected mailbox.
+func fetch(c *client.Client, seqset *goimap.SeqSet, uid bool) ([]provider.Message, error) {
+	section := &goimap.BodySectionName{Peek: true}
+	items := []goimap.FetchItem{goimap.FetchUid, section.FetchItem()}
+
+	ch := make(chan *goimap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		if uid {
+			done <- c.UidFetch(seqset, items, ch)
+		} else {
+			done <- c.Fetch(seqset, items, ch)
+		}
+	}()
+
+	var ret []provider.Message
+	var parseErr error
+	for m := range ch {
+		body := m.GetBody(section)
+		if body == nil {
+			continue
+		}
+		message, err := parse(body)
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		ret = append(ret, message)
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if parseErr != nil && len(ret) == 0 {
+		return nil, parseErr
+	}
+	return ret, nil
+}
+
+func parse(r io.Reader) (provider.Message, error) {
+	mr, err := mail.CreateReader(r)
+	if err != nil {
+		return provider.Message{}, fmt.Errorf("failed to parse message: %w", err)
+	}
+	defer mr.Close()
+
+	var message provider.Message
+	message.ID, _ = mr.Header.MessageID()
+	message.InternetMessageID = message.ID
+	message.Subject, _ = mr.Header.Subject()
+	if from, err := mr.Header.AddressList("From"); err == nil && len(from) > 0 {
+		message.SenderName = from[0].Name
+		message.SenderAddress = from[0].Address
+	}
+	message.To = addressList(mr.Header, "To")
+	message.Cc = addressList(mr.Header, "Cc")
+	message.ConversationID = conversationID(mr.Header, message.ID)
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return provider.Message{}, fmt.Errorf("failed to read message part: %w", err)
+		}
+		h, ok := part.Header.(*mail.InlineHeader)
+		if !ok {
+			continue
+		}
+		if contentType, _, _ := h.ContentType(); contentType != "" && contentType != "text/plain" {
+			continue
+		}
+		body, err := io.ReadAll(part.Body)
+		if err != nil {
+			return provider.Message{}, err
+		}
+		message.Body = string(body)
+		break
+	}
+	return message, nil
+}
+
+// conversationID uses the root of the References chain, so every reply in a thread maps to the same conversation.
+func conversationID(h mail.Header, id string) string {
+	if refs, err := h.MsgIDList("References"); err == nil && len(refs) > 0 {
+		return refs[0]
+	}
+	if inReplyTo, err := h.MsgIDList("In-Reply-To"); err == nil && len(inReplyTo) > 0 {
+		return inReplyTo[0]
+	}
+	return id
+}
+
+func addressList(h mail.Header, key string) []string {
+	list, err := h.AddressList(key)
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for _, addr := range list {
+		ret = append(ret, addr.Address)
+	}
+	return ret
+}
@@ -0,0 +1,149 @@
+package imap
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/provider"
+)
+
+func TestVerify(t *testing.T) {
+	s := newTestServer(t)
+
+	if err := NewFromConfig(s.cfg).Verify(); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	cfg := s.cfg
+	cfg.Password = "wrong"
+	if err := NewFromConfig(cfg).Verify(); err == nil {
+		t.Fatal("Verify() with a wrong password succeeded")
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	s := newTestServer(t)
+	p := NewFromConfig(s.cfg)
+	ctx := context.Background()
+
+	sent, err := p.SendMessage(ctx, provider.Message{
+		Subject: "Lunch",
+		Body:    "Are you free on Friday?",
+		To:      []string{testEmail},
+		Cc:      []string{"cc@example.org"},
+		Bcc:     []string{"bcc@example.org"},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage() = %v", err)
+	}
+	if sent.ID == "" || sent.ConversationID != sent.ID {
+		t.Errorf("SendMessage() IDs = %q, %q, want the Message-ID for both", sent.ID, sent.ConversationID)
+	}
+	want := []string{testEmail, "cc@example.org", "bcc@example.org"}
+	if got := s.recipients(); len(got) != 1 || !slices.Equal(got[0], want) {
+		t.Errorf("SMTP recipients = %v, want [%v]", got, want)
+	}
+
+	// The message was delivered to the inbox and a copy was kept in Sent, locate finds either.
+	received, err := p.GetMessage(ctx, sent.ID)
+	if err != nil {
+		t.Fatalf("GetMessage() = %v", err)
+	}
+	if received.Subject != "Lunch" || strings.TrimSpace(received.Body) != "Are you free on Friday?" || received.SenderAddress != testEmail {
+		t.Errorf("GetMessage() = %+v", received)
+	}
+	if !slices.Equal(received.Cc, []string{"cc@example.org"}) || len(received.Bcc) != 0 {
+		t.Errorf("GetMessage() recipients = cc %v bcc %v, want the cc only", received.Cc, received.Bcc)
+	}
+
+	messages, err := p.ListMessages(ctx, provider.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListMessages() = %v", err)
+	}
+	if len(messages) == 0 || messages[0].ID != sent.ID {
+		t.Errorf("ListMessages() does not start with the newest message %v: %+v", sent.ID, messages)
+	}
+}
+
+func TestMoveMessage(t *testing.T) {
+	s := newTestServer(t)
+	p := NewFromConfig(s.cfg)
+	ctx := context.Background()
+	s.deliver(t, testMessage("cold@example.org", "Buy now"))
+
+	if err := p.EnsureFolder(ctx, provider.FolderColdEmails); err != nil {
+		t.Fatalf("EnsureFolder() = %v", err)
+	}
+	// A second call finds the folder.
+	if err := p.EnsureFolder(ctx, provider.FolderColdEmails); err != nil {
+		t.Fatalf("EnsureFolder() again = %v", err)
+	}
+	moved, err := p.MoveMessage(ctx, "cold@example.org", provider.FolderColdEmails)
+	if err != nil {
+		t.Fatalf("MoveMessage() = %v", err)
+	}
+	if moved.ID != "cold@example.org" {
+		t.Errorf("MoveMessage() ID = %q, want the unchanged Message-ID", moved.ID)
+	}
+
+	messages, err := p.ListMessages(ctx, provider.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListMessages() = %v", err)
+	}
+	for _, m := range messages {
+		if m.ID == moved.ID {
+			t.Fatal("the moved message is still in the inbox")
+		}
+	}
+	if _, err := p.GetMessage(ctx, moved.ID); err != nil {
+		t.Errorf("GetMessage() of the moved message = %v", err)
+	}
+}
+
+func TestWatchInbox(t *testing.T) {
+	s := newTestServer(t)
+	p := NewFromConfig(s.cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ids := make(chan string, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.WatchInbox(ctx, func(id string) { ids <- id })
+	}()
+
+	// Messages delivered before the watch selected the inbox are not new, so deliver until one is reported.
+	delivered := map[string]bool{}
+	timeout := time.After(10 * time.Second)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for reported := false; !reported; {
+		select {
+		case id := <-ids:
+			if !delivered[id] {
+				t.Fatalf("WatchInbox() reported %q, which was not delivered", id)
+			}
+			reported = true
+		case <-tick.C:
+			id := time.Now().Format("20060102150405.000000000") + "@example.org"
+			delivered[id] = true
+			s.deliver(t, testMessage(id, "New"))
+		case err := <-done:
+			t.Fatalf("WatchInbox() stopped: %v", err)
+		case <-timeout:
+			t.Fatal("WatchInbox() reported no message")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WatchInbox() = %v after cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("WatchInbox() did not return after cancel")
+	}
+}
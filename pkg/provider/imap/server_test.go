@@ -0,0 +1,291 @@
+package imap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+const (
+	testUsername = "username"
+	testPassword = "password"
+	testEmail    = "me@example.com"
+)
+
+// testServer is an in-process IMAP and SMTP server for one mailbox. Mail sent over SMTP to testEmail is delivered to
+// its inbox, and every delivery is announced to IDLE clients.
+type testServer struct {
+	cfg     Config
+	backend *testBackend
+
+	lock sync.Mutex
+	// sent holds the recipients of every message the SMTP server accepted.
+	sent [][]string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	be := &testBackend{Backend: memory.New(), updates: make(chan backend.Update, 10)}
+	user := be.user(t)
+	if err := user.CreateMailbox(sent); err != nil {
+		t.Fatal(err)
+	}
+
+	imapListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imapServer := server.New(be)
+	imapServer.AllowInsecureAuth = true
+	imapServer.ErrorLog = log.New(io.Discard, "", 0)
+	go imapServer.Serve(imapListener)
+	t.Cleanup(func() { imapServer.Close() })
+
+	s := &testServer{backend: be}
+	smtpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpServer := smtp.NewServer(smtp.BackendFunc(func(*smtp.Conn) (smtp.Session, error) {
+		return &testSession{server: s}, nil
+	}))
+	smtpServer.Domain = "localhost"
+	smtpServer.AllowInsecureAuth = true
+	go smtpServer.Serve(smtpListener)
+	t.Cleanup(func() { smtpServer.Close() })
+
+	s.cfg = Config{
+		Name:     "Me",
+		Email:    testEmail,
+		Username: testUsername,
+		Password: testPassword,
+		IMAPAddr: imapListener.Addr().String(),
+		SMTPAddr: smtpListener.Addr().String(),
+		Insecure: true,
+	}
+	return s
+}
+
+// deliver puts a message into the inbox, the same as a delivery from another server.
+func (s *testServer) deliver(t *testing.T, body []byte) {
+	t.Helper()
+	if err := s.backend.deliver(body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *testServer) recipients() [][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([][]string(nil), s.sent...)
+}
+
+func testMessage(id, subject string) []byte {
+	return []byte(fmt.Sprintf("From: Sender <sender@example.org>\r\n"+
+		"To: %v\r\n"+
+		"Subject: %v\r\n"+
+		"Date: %v\r\n"+
+		"Message-ID: <%v>\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"Hello", testEmail, subject, time.Now().Format(time.RFC1123Z), id))
+}
+
+// testBackend adds what the go-imap memory backend lacks: the MOVE extension, updates for IDLE clients and locking,
+// since the SMTP server delivers while IMAP clients read.
+type testBackend struct {
+	*memory.Backend
+	updates chan backend.Update
+	lock    sync.Mutex
+}
+
+func (b *testBackend) Login(info *goimap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(info, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &testUser{User: user, backend: b}, nil
+}
+
+func (b *testBackend) Updates() <-chan backend.Update {
+	return b.updates
+}
+
+func (b *testBackend) user(t *testing.T) backend.User {
+	t.Helper()
+	user, err := b.Login(nil, testUsername, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func (b *testBackend) deliver(body []byte) error {
+	user, err := b.Login(nil, testUsername, testPassword)
+	if err != nil {
+		return err
+	}
+	mailbox, err := user.GetMailbox(inbox)
+	if err != nil {
+		return err
+	}
+	if err := mailbox.CreateMessage(nil, time.Now(), bytes.NewBuffer(body)); err != nil {
+		return err
+	}
+	status, err := mailbox.Status([]goimap.StatusItem{goimap.StatusMessages, goimap.StatusUidNext})
+	if err != nil {
+		return err
+	}
+	b.updates <- &backend.MailboxUpdate{Update: backend.NewUpdate(testUsername, inbox), MailboxStatus: status}
+	return nil
+}
+
+type testUser struct {
+	backend.User
+	backend *testBackend
+}
+
+func (u *testUser) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
+	u.backend.lock.Lock()
+	defer u.backend.lock.Unlock()
+	return u.User.ListMailboxes(subscribed)
+}
+
+func (u *testUser) GetMailbox(name string) (backend.Mailbox, error) {
+	u.backend.lock.Lock()
+	defer u.backend.lock.Unlock()
+	mailbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return &testMailbox{Mailbox: mailbox, lock: &u.backend.lock}, nil
+}
+
+func (u *testUser) CreateMailbox(name string) error {
+	u.backend.lock.Lock()
+	defer u.backend.lock.Unlock()
+	return u.User.CreateMailbox(name)
+}
+
+type testMailbox struct {
+	backend.Mailbox
+	lock *sync.Mutex
+}
+
+func (m *testMailbox) Status(items []goimap.StatusItem) (*goimap.MailboxStatus, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Mailbox.Status(items)
+}
+
+func (m *testMailbox) ListMessages(uid bool, seqset *goimap.SeqSet, items []goimap.FetchItem, ch chan<- *goimap.Message) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Mailbox.ListMessages(uid, seqset, items, ch)
+}
+
+func (m *testMailbox) SearchMessages(uid bool, criteria *goimap.SearchCriteria) ([]uint32, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Mailbox.SearchMessages(uid, criteria)
+}
+
+func (m *testMailbox) CreateMessage(flags []string, date time.Time, body goimap.Literal) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Mailbox.CreateMessage(flags, date, body)
+}
+
+func (m *testMailbox) UpdateMessagesFlags(uid bool, seqset *goimap.SeqSet, op goimap.FlagsOp, flags []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Mailbox.UpdateMessagesFlags(uid, seqset, op, flags)
+}
+
+func (m *testMailbox) CopyMessages(uid bool, seqset *goimap.SeqSet, dest string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Mailbox.CopyMessages(uid, seqset, dest)
+}
+
+func (m *testMailbox) Expunge() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Mailbox.Expunge()
+}
+
+// MoveMessages implements the MOVE extension as a copy, followed by deleting the originals.
+func (m *testMailbox) MoveMessages(uid bool, seqset *goimap.SeqSet, dest string) error {
+	if err := m.CopyMessages(uid, seqset, dest); err != nil {
+		return err
+	}
+	if err := m.UpdateMessagesFlags(uid, seqset, goimap.AddFlags, []string{goimap.DeletedFlag}); err != nil {
+		return err
+	}
+	return m.Expunge()
+}
+
+type testSession struct {
+	server     *testServer
+	recipients []string
+}
+
+func (s *testSession) AuthMechanisms() []string {
+	return []string{sasl.Plain}
+}
+
+func (s *testSession) Auth(string) (sasl.Server, error) {
+	return sasl.NewPlainServer(func(_, username, password string) error {
+		if username != testUsername || password != testPassword {
+			return fmt.Errorf("invalid credentials")
+		}
+		return nil
+	}), nil
+}
+
+func (s *testSession) Mail(string, *smtp.MailOptions) error {
+	return nil
+}
+
+func (s *testSession) Rcpt(to string, _ *smtp.RcptOptions) error {
+	s.recipients = append(s.recipients, to)
+	return nil
+}
+
+func (s *testSession) Data(r io.Reader) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.server.lock.Lock()
+	s.server.sent = append(s.server.sent, s.recipients)
+	s.server.lock.Unlock()
+	for _, rcpt := range s.recipients {
+		if strings.EqualFold(rcpt, testEmail) {
+			return s.server.backend.deliver(body)
+		}
+	}
+	return nil
+}
+
+func (s *testSession) Reset() {
+	s.recipients = nil
+}
+
+func (s *testSession) Logout() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"ethan/pkg/provider"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/sirupsen/logrus"
+)
+
+func (p *Provider) SendMessage(_ context.Context, message provider.Message) (provider.Message, error) {
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetAddressList("From", []*mail.Address{{Name: p.cfg.Name, Address: p.cfg.Email}})
+	h.SetAddressList("To", mailAddresses(message.To))
+	if len(message.Cc) > 0 {
+		h.SetAddressList("Cc", mailAddresses(message.Cc))
+	}
+	h.SetSubject(message.Subject)
+	h.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	if err := h.GenerateMessageID(); err != nil {
+		return provider.Message{}, err
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := mail.CreateSingleInlineWriter(buf, h)
+	if err != nil {
+		return provider.Message{}, err
+	}
+	if _, err := io.WriteString(w, message.Body); err != nil {
+		return provider.Message{}, err
+	}
+	if err := w.Close(); err != nil {
+		return provider.Message{}, err
+	}
+
+	var rcpts []string
+	rcpts = append(rcpts, message.To...)
+	rcpts = append(rcpts, message.Cc...)
+	rcpts = append(rcpts, message.Bcc...)
+	if err := p.sendMail(rcpts, buf.Bytes()); err != nil {
+		return provider.Message{}, err
+	}
+
+	// Keep a copy in Sent so replies can be matched to the thread later, the same as Graph does.
+	if err := p.appendSent(buf.Bytes()); err != nil {
+		logrus.Warnf("Failed to store sent message in %v folder: %v", sent, err)
+	}
+
+	message.ID, _ = h.MessageID()
+	message.InternetMessageID = message.ID
+	message.ConversationID = message.ID
+	message.SenderName = p.cfg.Name
+	message.SenderAddress = p.cfg.Email
+	return message, nil
+}
+
+func (p *Provider) sendMail(rcpts []string, msg []byte) error {
+	host, port, _ := strings.Cut(p.cfg.SMTPAddr, ":")
+
+	var (
+		c   *smtp.Client
+		err error
+	)
+	if !p.cfg.Insecure && port == "465" {
+		conn, err := tls.Dial("tcp", p.cfg.SMTPAddr, &tls.Config{ServerName: host})
+		if err != nil {
+			return fmt.Errorf("failed to connect to smtp server %v: %w", p.cfg.SMTPAddr, err)
+		}
+		c, err = smtp.NewClient(conn, host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	} else {
+		conn, err := net.Dial("tcp", p.cfg.SMTPAddr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to smtp server %v: %w", p.cfg.SMTPAddr, err)
+		}
+		c, err = smtp.NewClient(conn, host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if !p.cfg.Insecure {
+			if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				c.Close()
+				return fmt.Errorf("failed to start tls with smtp server: %w", err)
+			}
+		}
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("AUTH"); ok && p.cfg.Password != "" {
+		if err = c.Auth(smtp.PlainAuth("", p.cfg.Username, p.cfg.Password, host)); err != nil {
+			return fmt.Errorf("failed to authenticate with smtp server: %w", err)
+		}
+	}
+	if err = c.Mail(p.cfg.Email); err != nil {
+		return err
+	}
+	for _, rcpt := range rcpts {
+		if err = c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("failed to add recipient %v: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (p *Provider) appendSent(msg []byte) error {
+	c, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	exists, err := mailboxExists(c, sent)
+	if err != nil || !exists {
+		return err
+	}
+	return c.Append(sent, []string{"\\Seen"}, time.Now(), bytes.NewBuffer(msg))
+}
+
+func mailAddresses(addresses []string) []*mail.Address {
+	var ret []*mail.Address
+	for _, addr := range addresses {
+		ret = append(ret, &mail.Address{Address: addr})
+	}
+	return ret
+}
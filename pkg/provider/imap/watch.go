@@ -0,0 +1,114 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// WatchInbox uses IMAP IDLE to wait for new inbox messages and reports each one by Message-ID.
+func (p *Provider) WatchInbox(ctx context.Context, onMessage func(id string)) error {
+	c, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	status, err := c.Select(inbox, true)
+	if err != nil {
+		return err
+	}
+	// Only messages that arrive after the watch starts are new.
+	lastUID := status.UidNext - 1
+
+	updates := make(chan client.Update, 10)
+	c.Updates = updates
+
+	for {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Idle(stop, nil)
+		}()
+
+		var mailboxChanged bool
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-done
+			return nil
+		case err := <-done:
+			return fmt.Errorf("imap idle stopped: %w", err)
+		case update := <-updates:
+			_, mailboxChanged = update.(*client.MailboxUpdate)
+		}
+		close(stop)
+		if err := <-done; err != nil {
+			return fmt.Errorf("imap idle stopped: %w", err)
+		}
+		mailboxChanged = drain(updates) || mailboxChanged
+		if !mailboxChanged {
+			continue
+		}
+
+		lastUID, err = p.newMessages(c, lastUID, onMessage)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// newMessages reports every message with a UID above lastUID and returns the highest UID seen.
+func (p *Provider) newMessages(c *client.Client, lastUID uint32, onMessage func(id string)) (uint32, error) {
+	criteria := goimap.NewSearchCriteria()
+	criteria.Uid = new(goimap.SeqSet)
+	criteria.Uid.AddRange(lastUID+1, 0)
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return lastUID, err
+	}
+
+	seqset := new(goimap.SeqSet)
+	for _, uid := range uids {
+		// A "n:*" range always matches the last message, even if its UID is below n.
+		if uid > lastUID {
+			seqset.AddNum(uid)
+		}
+	}
+	if seqset.Empty() {
+		return lastUID, nil
+	}
+
+	messages, err := fetch(c, seqset, true)
+	if err != nil {
+		return lastUID, err
+	}
+	for _, uid := range uids {
+		if uid > lastUID {
+			lastUID = uid
+		}
+	}
+	for _, m := range messages {
+		if m.ID != "" {
+			onMessage(m.ID)
+		}
+	}
+	return lastUID, nil
+}
+
+// drain empties updates so the client never blocks on a full channel, and reports whether the mailbox changed.
+func drain(updates chan client.Update) bool {
+	var changed bool
+	for {
+		select {
+		case update := <-updates:
+			if _, ok := update.(*client.MailboxUpdate); ok {
+				changed = true
+			}
+		default:
+			return changed
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultName is the provider used for mailboxes that don't have one set.
+const DefaultName = "graph"
+
+// None is the provider stored on users, whose mailboxes are now mailbox connections of their own.
+const None = "none"
+
+// ErrNoMailbox is returned by Registry.New for the None provider.
+var ErrNoMailbox = errors.New("no mailbox is connected")
+
+const (
+	// FolderInbox is the well-known name of the inbox folder.
+	FolderInbox = "inbox"
+	// FolderColdEmails is the folder cold emails are moved into when spam checking is enabled.
+	FolderColdEmails = "Cold Emails"
+)
+
+type FreeBusyStatus string
+
+const (
+	FreeBusyFree             FreeBusyStatus = "free"
+	FreeBusyTentative        FreeBusyStatus = "tentative"
+	FreeBusyBusy             FreeBusyStatus = "busy"
+	FreeBusyOOF              FreeBusyStatus = "oof"
+	FreeBusyWorkingElsewhere FreeBusyStatus = "workingElsewhere"
+	FreeBusyUnknown          FreeBusyStatus = "unknown"
+)
+
+// Busy returns true if the status blocks the time slot.
+func (s FreeBusyStatus) Busy() bool {
+	return s == FreeBusyBusy || s == FreeBusyOOF || s == FreeBusyTentative
+}
+
+type User struct {
+	Name  string
+	Email string
+}
+
+type Message struct {
+	ID             string
+	ConversationID string
+	// InternetMessageID is the Message-ID header, which unlike ID stays the same when the message is moved.
+	InternetMessageID string
+	Subject           string
+	Body              string
+	SenderName        string
+	SenderAddress     string
+	To                []string
+	Cc                []string
+	Bcc               []string
+	// WebLink opens the message in the provider's web client, where drafts can be edited.
+	WebLink string
+}
+
+type ListOptions struct {
+	// Top limits the number of messages returned. Zero means the provider default.
+	Top int32
+}
+
+type Event struct {
+	ID        string
+	Subject   string
+	Content   string
+	Start     string
+	End       string
+	TimeZone  string
+	Attendees []string
+	Organizer string
+}
+
+type OnlineMeeting struct {
+	URL          string
+	TollNumber   string
+	ConferenceID string
+}
+
+type ScheduleItem struct {
+	Email    string
+	Status   FreeBusyStatus
+	Start    string
+	End      string
+	TimeZone string
+	Subject  string
+}
+
+type Person struct {
+	Name   string
+	Emails []string
+}
+
+// MailProvider reads, sends and files messages in the user's mailbox.
+type MailProvider interface {
+	Me(ctx context.Context) (User, error)
+	SendMessage(ctx context.Context, message Message) (Message, error)
+	ListMessages(ctx context.Context, opts ListOptions) ([]Message, error)
+	GetMessage(ctx context.Context, id string) (Message, error)
+	// MoveMessage moves a message into the folder with the given display or well-known name and returns the moved message.
+	MoveMessage(ctx context.Context, id string, folder string) (Message, error)
+	// EnsureFolder creates the folder with the given display name if it does not exist yet.
+	EnsureFolder(ctx context.Context, name string) error
+}
+
+// CalendarProvider checks availability and manages events on the user's calendar.
+type CalendarProvider interface {
+	GetSchedule(ctx context.Context, emails []string, start, end time.Time, timeZone string) ([]ScheduleItem, error)
+	CreateEvent(ctx context.Context, event Event) (Event, error)
+	// AddOnlineMeeting turns an existing event into an online meeting. It returns nil if the provider didn't attach a meeting.
+	AddOnlineMeeting(ctx context.Context, eventID string) (*OnlineMeeting, error)
+}
+
+// ContactProvider looks up people the user has been in touch with.
+type ContactProvider interface {
+	SearchPeople(ctx context.Context, query string) ([]Person, error)
+	ListContacts(ctx context.Context) ([]Person, error)
+}
+
+type Provider interface {
+	MailProvider
+	CalendarProvider
+	ContactProvider
+}
+
+// Factory builds a Provider from the credential stored for a user.
+type Factory func(token string) (Provider, error)
+
+// Watcher is implemented by providers that detect new inbox messages themselves, instead of relying on a Graph webhook.
+type Watcher interface {
+	// WatchInbox blocks until ctx is done, calling onMessage with the ID of every new inbox message.
+	WatchInbox(ctx context.Context, onMessage func(id string)) error
+}
+
+// ErrSyncTokenExpired is returned by SyncInbox when the provider no longer accepts the sync token.
+var ErrSyncTokenExpired = errors.New("sync token expired")
+
+// InboxSyncer is implemented by providers that can list the changes to the inbox since an earlier sync, which is used
+// to catch up on mail whose notifications were missed.
+type InboxSyncer interface {
+	// SyncInbox returns the IDs of the messages added to the inbox since the sync that returned token, and the token
+	// for the next sync. With an empty token it returns the messages received at or after since.
+	SyncInbox(ctx context.Context, token string, since time.Time) (ids []string, next string, err error)
+}
+
+// ErrDraftsUnsupported is returned for draft calls on providers that do not implement Drafter.
+var ErrDraftsUnsupported = errors.New("the mail provider does not support drafts")
+
+// Drafter is implemented by providers that can leave a message in the Drafts folder, so it can be reviewed and edited
+// before it is sent.
+type Drafter interface {
+	CreateDraft(ctx context.Context, message Message) (Message, error)
+	// UpdateDraft replaces the subject, body and recipients of the draft.
+	UpdateDraft(ctx context.Context, id string, message Message) (Message, error)
+	SendDraft(ctx context.Context, id string) error
+	DeleteDraft(ctx context.Context, id string) error
+}
+
+// Permissions a delegate can be granted on the mailbox of someone else.
+const (
+	// PermissionMail reads and files the messages of the mailbox and watches its inbox.
+	PermissionMail = "mail"
+	// PermissionSend sends messages on behalf of the mailbox owner.
+	PermissionSend = "send"
+	// PermissionCalendar checks availability and creates events on the calendar of the mailbox owner.
+	PermissionCalendar = "calendar"
+)
+
+// Permissions are all the permissions a delegate can be granted.
+var Permissions = []string{PermissionMail, PermissionSend, PermissionCalendar}
+
+// ErrDelegationUnsupported is returned by Registry.NewDelegated for providers that only work on the mailbox of the
+// signed in user.
+var ErrDelegationUnsupported = errors.New("the mail provider does not support delegated access")
+
+// ErrNotPermitted is returned by delegated providers for calls outside the permissions the delegate was granted.
+var ErrNotPermitted = errors.New("the delegate was not granted this permission on the mailbox")
+
+// Delegation is the access to the mailbox of someone else that a delegate, such as an executive assistant, was
+// granted. The zero Delegation is the user's own mailbox.
+type Delegation struct {
+	// Mailbox is the address of the mailbox the delegate works on.
+	Mailbox     string
+	Permissions []string
+}
+
+func (d Delegation) Allows(permission string) bool {
+	return slices.Contains(d.Permissions, permission)
+}
+
+// Delegator is implemented by providers that can work on a mailbox shared with the signed in user.
+type Delegator interface {
+	// Delegate returns a provider for the mailbox with the given address, using the credential of p.
+	Delegate(mailbox string) Provider
+}
+
+// Registry maps the provider name stored on a mailbox connection to the factory that builds it.
+type Registry map[string]Factory
+
+func (r Registry) New(name, token string) (Provider, error) {
+	if name == "" {
+		name = DefaultName
+	} else if name == None {
+		return nil, ErrNoMailbox
+	}
+	f, ok := r[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail provider %q", name)
+	}
+	return f(token)
+}
+
+// NewDelegated builds the provider for the mailbox of the delegation, which only allows the calls its permissions
+// cover. For the zero Delegation it is the same as New.
+func (r Registry) NewDelegated(name, token string, d Delegation) (Provider, error) {
+	p, err := r.New(name, token)
+	if err != nil || d.Mailbox == "" {
+		return p, err
+	}
+	delegator, ok := p.(Delegator)
+	if !ok {
+		return nil, ErrDelegationUnsupported
+	}
+	return &delegated{Provider: delegator.Delegate(d.Mailbox), delegation: d}, nil
+}
+
+// Env returns the environment the gem-copilot tools need to build the same provider.
+func Env(name, token string, d Delegation) []string {
+	return []string{
+		fmt.Sprintf("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN=%v", token),
+		fmt.Sprintf("MAIL_PROVIDER=%v", name),
+		fmt.Sprintf("MAIL_DELEGATED_MAILBOX=%v", d.Mailbox),
+		fmt.Sprintf("MAIL_DELEGATED_PERMISSIONS=%v", strings.Join(d.Permissions, ",")),
+	}
+}
+
+// delegated checks the permissions of a delegation before passing calls on. Looking up people is allowed with any
+// permission.
+type delegated struct {
+	Provider
+	delegation Delegation
+}
+
+func (d *delegated) allow(permission string) error {
+	if !d.delegation.Allows(permission) {
+		return fmt.Errorf("%w: %v on %v", ErrNotPermitted, permission, d.delegation.Mailbox)
+	}
+	return nil
+}
+
+func (d *delegated) SendMessage(ctx context.Context, message Message) (Message, error) {
+	if err := d.allow(PermissionSend); err != nil {
+		return Message{}, err
+	}
+	return d.Provider.SendMessage(ctx, message)
+}
+
+func (d *delegated) ListMessages(ctx context.Context, opts ListOptions) ([]Message, error) {
+	if err := d.allow(PermissionMail); err != nil {
+		return nil, err
+	}
+	return d.Provider.ListMessages(ctx, opts)
+}
+
+func (d *delegated) GetMessage(ctx context.Context, id string) (Message, error) {
+	if err := d.allow(PermissionMail); err != nil {
+		return Message{}, err
+	}
+	return d.Provider.GetMessage(ctx, id)
+}
+
+func (d *delegated) MoveMessage(ctx context.Context, id string, folder string) (Message, error) {
+	if err := d.allow(PermissionMail); err != nil {
+		return Message{}, err
+	}
+	return d.Provider.MoveMessage(ctx, id, folder)
+}
+
+func (d *delegated) EnsureFolder(ctx context.Context, name string) error {
+	if err := d.allow(PermissionMail); err != nil {
+		return err
+	}
+	return d.Provider.EnsureFolder(ctx, name)
+}
+
+func (d *delegated) SyncInbox(ctx context.Context, token string, since time.Time) ([]string, string, error) {
+	if err := d.allow(PermissionMail); err != nil {
+		return nil, "", err
+	}
+	syncer, ok := d.Provider.(InboxSyncer)
+	if !ok {
+		return nil, "", errors.New("the mail provider cannot sync the inbox")
+	}
+	return syncer.SyncInbox(ctx, token, since)
+}
+
+func (d *delegated) drafter(permission string) (Drafter, error) {
+	if err := d.allow(permission); err != nil {
+		return nil, err
+	}
+	drafter, ok := d.Provider.(Drafter)
+	if !ok {
+		return nil, ErrDraftsUnsupported
+	}
+	return drafter, nil
+}
+
+func (d *delegated) CreateDraft(ctx context.Context, message Message) (Message, error) {
+	drafter, err := d.drafter(PermissionMail)
+	if err != nil {
+		return Message{}, err
+	}
+	return drafter.CreateDraft(ctx, message)
+}
+
+func (d *delegated) UpdateDraft(ctx context.Context, id string, message Message) (Message, error) {
+	drafter, err := d.drafter(PermissionMail)
+	if err != nil {
+		return Message{}, err
+	}
+	return drafter.UpdateDraft(ctx, id, message)
+}
+
+func (d *delegated) SendDraft(ctx context.Context, id string) error {
+	drafter, err := d.drafter(PermissionSend)
+	if err != nil {
+		return err
+	}
+	return drafter.SendDraft(ctx, id)
+}
+
+func (d *delegated) DeleteDraft(ctx context.Context, id string) error {
+	drafter, err := d.drafter(PermissionMail)
+	if err != nil {
+		return err
+	}
+	return drafter.DeleteDraft(ctx, id)
+}
+
+func (d *delegated) GetSchedule(ctx context.Context, emails []string, start, end time.Time, timeZone string) ([]ScheduleItem, error) {
+	if err := d.allow(PermissionCalendar); err != nil {
+		return nil, err
+	}
+	return d.Provider.GetSchedule(ctx, emails, start, end, timeZone)
+}
+
+func (d *delegated) CreateEvent(ctx context.Context, event Event) (Event, error) {
+	if err := d.allow(PermissionCalendar); err != nil {
+		return Event{}, err
+	}
+	return d.Provider.CreateEvent(ctx, event)
+}
+
+func (d *delegated) AddOnlineMeeting(ctx context.Context, eventID string) (*OnlineMeeting, error) {
+	if err := d.allow(PermissionCalendar); err != nil {
+		return nil, err
+	}
+	return d.Provider.AddOnlineMeeting(ctx, eventID)
+}
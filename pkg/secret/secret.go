@@ -0,0 +1,221 @@
+// Package secret encrypts credentials stored in Postgres with envelope encryption. Every value is encrypted with its
+// own random data key, and the data key is encrypted with a key encryption key from the keyring. The keyring is read
+// from TOKEN_ENCRYPTION_KEYS, or from the JSON file at TOKEN_ENCRYPTION_KEY_FILE.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// prefix marks an encrypted value, the format is enc:v1:{key id}:{encrypted data key}:{encrypted value}.
+const prefix = "enc:v1:"
+
+// Keyring holds the key encryption keys by ID. New values are encrypted with Primary, the other keys are kept to
+// decrypt values written before a rotation.
+type Keyring struct {
+	Primary string            `json:"primary"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+var keyring = sync.OnceValues(loadKeyring)
+
+// SetKeyring replaces the keyring read from the environment, which lets tests encrypt with keys of their own. A nil
+// keyring stores values as they are.
+func SetKeyring(k *Keyring) {
+	keyring = func() (*Keyring, error) { return k, nil }
+}
+
+// loadKeyring returns nil when no keys are configured, in which case values are stored as they are.
+func loadKeyring() (*Keyring, error) {
+	var k Keyring
+	if file := os.Getenv("TOKEN_ENCRYPTION_KEY_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &k); err != nil {
+			return nil, fmt.Errorf("invalid key file %v: %w", file, err)
+		}
+	} else if keys := os.Getenv("TOKEN_ENCRYPTION_KEYS"); keys != "" {
+		// A comma separated list of id:base64key, the first key is the primary.
+		k.Keys = map[string][]byte{}
+		for i, entry := range strings.Split(keys, ",") {
+			id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok || id == "" {
+				return nil, errors.New("TOKEN_ENCRYPTION_KEYS must be a comma separated list of id:base64key")
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %v: %w", id, err)
+			}
+			k.Keys[id] = key
+			if i == 0 {
+				k.Primary = id
+			}
+		}
+	} else {
+		logrus.Warn("TOKEN_ENCRYPTION_KEYS is not set, tokens are stored unencrypted")
+		return nil, nil
+	}
+
+	if _, ok := k.Keys[k.Primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", k.Primary)
+	}
+	for id, key := range k.Keys {
+		if strings.Contains(id, ":") {
+			return nil, fmt.Errorf("key id %q must not contain ':'", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %v must be 32 bytes", id)
+		}
+	}
+	return &k, nil
+}
+
+// Encrypt returns the envelope encrypted form of plain. Without a keyring it returns plain.
+func Encrypt(plain string) (string, error) {
+	k, err := keyring()
+	if err != nil || k == nil {
+		return plain, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	encryptedKey, err := seal(k.Keys[k.Primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	encryptedValue, err := seal(dataKey, []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.Primary + ":" + base64.RawStdEncoding.EncodeToString(encryptedKey) + ":" + base64.RawStdEncoding.EncodeToString(encryptedValue), nil
+}
+
+// NeedsRotation reports whether value is not encrypted with the primary key yet. It is always false without a keyring.
+func NeedsRotation(value string) (bool, error) {
+	k, err := keyring()
+	if err != nil || k == nil {
+		return false, err
+	}
+	return !strings.HasPrefix(value, prefix+k.Primary+":"), nil
+}
+
+// Decrypt reverses Encrypt. Values that are not encrypted, such as ones written before a keyring was configured, are
+// returned as they are.
+func Decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return value, nil
+	}
+	k, err := keyring()
+	if err != nil {
+		return "", err
+	}
+	if k == nil {
+		return "", errors.New("value is encrypted but TOKEN_ENCRYPTION_KEYS is not set")
+	}
+
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	kek, ok := k.Keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("value is encrypted with unknown key %q", parts[0])
+	}
+	encryptedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed data key: %w", err)
+	}
+	encryptedValue, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+
+	dataKey, err := open(kek, encryptedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	plain, err := open(dataKey, encryptedValue)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plain), nil
+}
+
+// seal encrypts with AES-256-GCM and prepends the nonce.
+func seal(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// String is a credential column. It is encrypted when written to the database, decrypted when read, and never
+// marshalled to JSON.
+type String string
+
+func (s String) Value() (driver.Value, error) {
+	return Encrypt(string(s))
+}
+
+func (s *String) Scan(src any) error {
+	var value string
+	switch v := src.(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into secret.String", src)
+	}
+	plain, err := Decrypt(value)
+	if err != nil {
+		return err
+	}
+	*s = String(plain)
+	return nil
+}
+
+func (s String) MarshalJSON() ([]byte, error) {
+	return []byte(`""`), nil
+}
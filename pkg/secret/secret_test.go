@@ -0,0 +1,155 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// useKeyring encrypts with a keyring of the given key IDs for the rest of the test, the first ID is the primary.
+func useKeyring(t *testing.T, ids ...string) {
+	t.Helper()
+	k := &Keyring{Primary: ids[0], Keys: map[string][]byte{}}
+	for _, id := range ids {
+		k.Keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+	}
+	SetKeyring(k)
+	t.Cleanup(func() { SetKeyring(nil) })
+}
+
+func TestEncrypt(t *testing.T) {
+	useKeyring(t, "a")
+
+	encrypted, err := Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:v1:a:") || strings.Contains(encrypted, "token") {
+		t.Errorf("Encrypt() = %v, want the value encrypted with key a", encrypted)
+	}
+	again, err := Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("Encrypt() returned the same value twice, want a new data key and nonce every time")
+	}
+	for _, value := range []string{encrypted, again} {
+		if plain, err := Decrypt(value); err != nil || plain != "token" {
+			t.Errorf("Decrypt(%v) = %q, %v, want token", value, plain, err)
+		}
+	}
+
+	SetKeyring(nil)
+	if plain, err := Encrypt("token"); err != nil || plain != "token" {
+		t.Errorf("Encrypt() without a keyring = %q, %v, want the value as it is", plain, err)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	useKeyring(t, "a")
+	encrypted, err := Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(encrypted, ":")
+	// tamper flips the last byte of part i of the encrypted value.
+	tamper := func(i int) string {
+		data, err := base64.RawStdEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)-1] ^= 1
+		tampered := append([]string(nil), parts...)
+		tampered[i] = base64.RawStdEncoding.EncodeToString(data)
+		return strings.Join(tampered, ":")
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		err   string
+	}{
+		{
+			name:  "plain text written before encryption",
+			value: "ya29.token",
+			want:  "ya29.token",
+		},
+		{
+			name:  "unknown key",
+			value: strings.Replace(encrypted, "enc:v1:a:", "enc:v1:b:", 1),
+			err:   `unknown key "b"`,
+		},
+		{
+			name:  "tampered data key",
+			value: tamper(3),
+			err:   "failed to decrypt data key",
+		},
+		{
+			name:  "tampered value",
+			value: tamper(4),
+			err:   "failed to decrypt value",
+		},
+		{
+			name:  "missing part",
+			value: strings.Join(parts[:4], ":"),
+			err:   "malformed encrypted value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Decrypt() = %q, %v, want an error with %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Decrypt() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	SetKeyring(nil)
+	if _, err := Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() of an encrypted value without a keyring succeeded, want an error")
+	}
+	if got, err := Decrypt("ya29.token"); err != nil || got != "ya29.token" {
+		t.Errorf("Decrypt() of plain text without a keyring = %q, %v, want it as it is", got, err)
+	}
+}
+
+func TestNeedsRotation(t *testing.T) {
+	useKeyring(t, "a")
+	old, err := Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b becomes the primary, a is kept to decrypt what it encrypted.
+	useKeyring(t, "b", "a")
+	current, err := Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for value, want := range map[string]bool{
+		"ya29.token": true,
+		old:          true,
+		current:      false,
+	} {
+		if got, err := NeedsRotation(value); err != nil || got != want {
+			t.Errorf("NeedsRotation(%v) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if plain, err := Decrypt(old); err != nil || plain != "token" {
+		t.Errorf("Decrypt() of a value of the old key = %q, %v, want token", plain, err)
+	}
+
+	SetKeyring(nil)
+	if got, err := NeedsRotation("ya29.token"); err != nil || got {
+		t.Errorf("NeedsRotation() without a keyring = %v, %v, want false", got, err)
+	}
+}
@@ -0,0 +1,160 @@
+// Package api holds the types the REST API exchanges with clients and the helpers handlers use to write responses.
+// Every failed request is answered with an Error body and a matching status code.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+// Error codes returned in Error.Code.
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeInternal       = "internal"
+)
+
+// uniqueViolation is the Postgres error code of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// Error is the body of every error response.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// FieldErrors maps the name of an invalid request field to what is wrong with it. It is returned as the details of a
+// 400 response.
+type FieldErrors map[string]string
+
+// WriteJSON writes v as the JSON body of a response with the given status.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Error(fmt.Errorf("failed to encode response: %w", err))
+	}
+}
+
+func WriteError(w http.ResponseWriter, status int, code, message string, details any) {
+	WriteJSON(w, status, Error{
+		Code:    code,
+		Message: message,
+		Details: details,
+	})
+}
+
+func BadRequest(w http.ResponseWriter, message string, details any) {
+	WriteError(w, http.StatusBadRequest, CodeInvalidRequest, message, details)
+}
+
+func Unauthorized(w http.ResponseWriter) {
+	WriteError(w, http.StatusUnauthorized, CodeUnauthorized, "authentication required", nil)
+}
+
+func Forbidden(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusForbidden, CodeForbidden, message, nil)
+}
+
+// NotFound answers that the resource named what, such as "task", does not exist.
+func NotFound(w http.ResponseWriter, what string) {
+	WriteError(w, http.StatusNotFound, CodeNotFound, what+" not found", nil)
+}
+
+func Conflict(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusConflict, CodeConflict, message, nil)
+}
+
+// InternalError logs err and answers with a generic message, so internals such as SQL errors are not sent to clients.
+func InternalError(w http.ResponseWriter, err error) {
+	logrus.Error(err)
+	WriteError(w, http.StatusInternalServerError, CodeInternal, "internal server error", nil)
+}
+
+// DBError answers a failed query on the resource named what: a missing row is a 404, a unique constraint violation a
+// 409, and anything else a 500.
+func DBError(w http.ResponseWriter, err error, what string) {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		NotFound(w, what)
+	case errors.As(err, &pgErr) && pgErr.Code == uniqueViolation:
+		Conflict(w, what+" already exists")
+	default:
+		InternalError(w, fmt.Errorf("failed to query %v: %w", what, err))
+	}
+}
+
+// Decode reads the JSON request body into v and answers with a 400 when it is malformed. An empty body leaves v
+// untouched. It reports whether the handler can continue.
+func Decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		BadRequest(w, "malformed request body", err.Error())
+		return false
+	}
+	return true
+}
+
+// Validator is implemented by request bodies that check their own fields.
+type Validator interface {
+	Validate() FieldErrors
+}
+
+// DecodeValid decodes the request body into v like Decode and then validates it, answering with a 400 that lists
+// the invalid fields.
+func DecodeValid(w http.ResponseWriter, r *http.Request, v Validator) bool {
+	if !Decode(w, r, v) {
+		return false
+	}
+	if errs := v.Validate(); len(errs) > 0 {
+		BadRequest(w, "invalid request", errs)
+		return false
+	}
+	return true
+}
+
+// UserID returns the ID of the signed in user set by auth.Middleware, answering with a 401 when it is missing.
+func UserID(w http.ResponseWriter, r *http.Request) (pgtype.UUID, bool) {
+	var uid pgtype.UUID
+	if err := uid.Scan(r.Header.Get("X-User-ID")); err != nil || !uid.Valid {
+		Unauthorized(w)
+		return uid, false
+	}
+	return uid, true
+}
+
+// PathID parses the UUID in the path variable name, answering with a 400 when it is not one.
+func PathID(w http.ResponseWriter, r *http.Request, name string) (pgtype.UUID, bool) {
+	value := mux.Vars(r)[name]
+	var id pgtype.UUID
+	if err := id.Scan(value); err != nil || !id.Valid {
+		BadRequest(w, fmt.Sprintf("invalid %v: %q is not a UUID", name, value), nil)
+		return id, false
+	}
+	return id, true
+}
+
+// Owned returns the ID in the path variable name together with the signed in user. Queries on a resource take both,
+// so a resource of another user is answered with a 404 exactly like one that does not exist.
+func Owned(w http.ResponseWriter, r *http.Request, name string) (id, userID pgtype.UUID, ok bool) {
+	if userID, ok = UserID(w, r); !ok {
+		return id, userID, false
+	}
+	if id, ok = PathID(w, r, name); !ok {
+		return id, userID, false
+	}
+	return id, userID, true
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI is the OpenAPI document of the routes under /api. Update it together with the routes and the types of this
+// package, the tests of the server check that they agree. The client in pkg/client is generated from it.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+func ServeOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(OpenAPI)
+}
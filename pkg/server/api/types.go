@@ -0,0 +1,946 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"github.com/google/uuid"
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// maxNameLength bounds the names of tasks, contexts and organizations.
+const maxNameLength = 200
+
+// ID formats a database ID, an unset ID is nil.
+func ID(id pgtype.UUID) *string {
+	if !id.Valid {
+		return nil
+	}
+	s := uuid.UUID(id.Bytes).String()
+	return &s
+}
+
+// Time formats a database timestamp as RFC3339 in UTC, an unset timestamp is nil.
+func Time(t pgtype.Timestamptz) *string {
+	if !t.Valid {
+		return nil
+	}
+	s := t.Time.UTC().Format(time.RFC3339)
+	return &s
+}
+
+func value[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
+
+func parseIDs(ids []string) ([]pgtype.UUID, bool) {
+	result := make([]pgtype.UUID, 0, len(ids))
+	for _, id := range ids {
+		var uid pgtype.UUID
+		if err := uid.Scan(id); err != nil || !uid.Valid {
+			return nil, false
+		}
+		result = append(result, uid)
+	}
+	return result, true
+}
+
+type Task struct {
+	ID string `json:"ID"`
+	// OwnerID is the user who created the task, AssigneeID the member of OrganizationID it is assigned to.
+	OwnerID        string   `json:"OwnerID"`
+	AssigneeID     *string  `json:"AssigneeID"`
+	OrganizationID *string  `json:"OrganizationID"`
+	Name           string   `json:"Name"`
+	Description    string   `json:"Description"`
+	Context        string   `json:"Context"`
+	ContextIds     []string `json:"ContextIds"`
+	MessageID      *string  `json:"MessageID"`
+	ConversationID *string  `json:"ConversationID"`
+	// MailboxID is the mailbox the task works with, nil when it was disconnected.
+	MailboxID *string `json:"MailboxID"`
+	// State is the gptscript state of the task's chat, base64 encoded.
+	State     []byte  `json:"State"`
+	CreatedAt *string `json:"CreatedAt"`
+}
+
+func NewTask(t db.Task) Task {
+	task := Task{
+		ID:             value(ID(t.ID)),
+		OwnerID:        value(ID(t.UserID)),
+		Name:           t.Name,
+		Description:    t.Description,
+		Context:        value(t.Context),
+		ContextIds:     []string{},
+		MessageID:      t.MessageID,
+		ConversationID: t.ConversationID,
+		MailboxID:      ID(t.ConnectionID),
+		State:          t.State,
+		CreatedAt:      Time(t.CreatedAt),
+	}
+	for _, id := range t.ContextIds {
+		if s := ID(id); s != nil {
+			task.ContextIds = append(task.ContextIds, *s)
+		}
+	}
+	// An assignment only counts while it belongs to an organization.
+	if t.OrganizationID.Valid {
+		task.AssigneeID = ID(t.AssigneeID)
+		task.OrganizationID = ID(t.OrganizationID)
+	}
+	return task
+}
+
+func NewTasks(tasks []db.Task) []Task {
+	result := make([]Task, 0, len(tasks))
+	for _, t := range tasks {
+		result = append(result, NewTask(t))
+	}
+	return result
+}
+
+// TaskRequest is the body of the requests that create and update a task.
+type TaskRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Context     *string  `json:"context"`
+	ContextIds  []string `json:"contextIds"`
+	// MailboxID is the mailbox the task works with. New tasks default to the first mailbox connected, updates keep
+	// the current one when it is left out.
+	MailboxID *string `json:"mailboxId"`
+}
+
+func (t *TaskRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		errs["name"] = "is required"
+	} else if len(t.Name) > maxNameLength {
+		errs["name"] = "is too long"
+	}
+	if _, ok := parseIDs(t.ContextIds); !ok {
+		errs["contextIds"] = "must be a list of UUIDs"
+	}
+	if t.MailboxID != nil {
+		if _, ok := parseIDs([]string{*t.MailboxID}); !ok {
+			errs["mailboxId"] = "must be a UUID"
+		}
+	}
+	return errs
+}
+
+// ContextUUIDs returns ContextIds as database IDs. It must only be called after Validate.
+func (t TaskRequest) ContextUUIDs() []pgtype.UUID {
+	ids, _ := parseIDs(t.ContextIds)
+	return ids
+}
+
+// MailboxUUID returns MailboxID as a database ID, which is not valid when it is left out. It must only be called after
+// Validate.
+func (t TaskRequest) MailboxUUID() pgtype.UUID {
+	if t.MailboxID == nil {
+		return pgtype.UUID{}
+	}
+	ids, _ := parseIDs([]string{*t.MailboxID})
+	return ids[0]
+}
+
+type Context struct {
+	ID          string `json:"ID"`
+	Name        string `json:"Name"`
+	Description string `json:"Description"`
+	Content     string `json:"Content"`
+	// OwnerID is the user who created the context, OrganizationID the organization it is shared with.
+	OwnerID        string  `json:"OwnerID"`
+	OrganizationID *string `json:"OrganizationID"`
+	CreatedAt      *string `json:"CreatedAt"`
+}
+
+func NewContext(c db.Context) Context {
+	return Context{
+		ID:             value(ID(c.ID)),
+		Name:           value(c.Name),
+		Description:    value(c.Description),
+		Content:        value(c.Content),
+		OwnerID:        value(ID(c.UserID)),
+		OrganizationID: ID(c.OrganizationID),
+		CreatedAt:      Time(c.CreatedAt),
+	}
+}
+
+func NewContexts(contexts []db.Context) []Context {
+	result := make([]Context, 0, len(contexts))
+	for _, c := range contexts {
+		result = append(result, NewContext(c))
+	}
+	return result
+}
+
+// ContextRequest is the body of the requests that create and update a context.
+type ContextRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Content     string `json:"content"`
+	// OrganizationID shares the context with the members of the organization, an empty string makes it private
+	// again. Updates keep the current sharing when it is left out.
+	OrganizationID *string `json:"organizationId"`
+}
+
+func (c *ContextRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		errs["name"] = "is required"
+	} else if len(c.Name) > maxNameLength {
+		errs["name"] = "is too long"
+	}
+	if c.OrganizationID != nil && *c.OrganizationID != "" {
+		if _, ok := parseIDs([]string{*c.OrganizationID}); !ok {
+			errs["organizationId"] = "must be a UUID"
+		}
+	}
+	return errs
+}
+
+// OrganizationUUID returns OrganizationID as a database ID, which is not valid when it is left out or empty. It must
+// only be called after Validate.
+func (c ContextRequest) OrganizationUUID() pgtype.UUID {
+	if c.OrganizationID == nil || *c.OrganizationID == "" {
+		return pgtype.UUID{}
+	}
+	ids, _ := parseIDs([]string{*c.OrganizationID})
+	return ids[0]
+}
+
+// Roles of organization members.
+const (
+	// RoleOwner manages the organization and its members, and can edit every shared context.
+	RoleOwner = "owner"
+	// RoleMember shares contexts and assigns tasks. Tasks assigned to a member work with the member's own mailbox.
+	RoleMember = "member"
+	// RoleDelegate is an assistant. Tasks assigned to a delegate work with the mailbox of the task's owner, through the
+	// delegation the owner granted them for it.
+	RoleDelegate = "delegate"
+)
+
+type Organization struct {
+	ID   string `json:"ID"`
+	Name string `json:"Name"`
+	// Role is the role of the signed in user.
+	Role      string  `json:"Role"`
+	CreatedAt *string `json:"CreatedAt"`
+}
+
+func NewOrganization(o db.Organization, role string) Organization {
+	return Organization{
+		ID:        value(ID(o.ID)),
+		Name:      o.Name,
+		Role:      role,
+		CreatedAt: Time(o.CreatedAt),
+	}
+}
+
+// OrganizationRequest is the body of the requests that create and rename an organization.
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+func (o *OrganizationRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		errs["name"] = "is required"
+	} else if len(o.Name) > maxNameLength {
+		errs["name"] = "is too long"
+	}
+	return errs
+}
+
+type OrganizationMember struct {
+	UserID    string  `json:"UserID"`
+	Name      string  `json:"Name"`
+	Email     string  `json:"Email"`
+	Role      string  `json:"Role"`
+	CreatedAt *string `json:"CreatedAt"`
+}
+
+func NewOrganizationMember(m db.OrganizationMember, name, email string) OrganizationMember {
+	return OrganizationMember{
+		UserID:    value(ID(m.UserID)),
+		Name:      name,
+		Email:     email,
+		Role:      m.Role,
+		CreatedAt: Time(m.CreatedAt),
+	}
+}
+
+// OrganizationInvitation invites a registered user to an organization. They become a member with Role once they accept
+// it.
+type OrganizationInvitation struct {
+	ID               string  `json:"ID"`
+	OrganizationID   string  `json:"OrganizationID"`
+	OrganizationName string  `json:"OrganizationName"`
+	UserID           string  `json:"UserID"`
+	Name             string  `json:"Name"`
+	Email            string  `json:"Email"`
+	Role             string  `json:"Role"`
+	InvitedBy        string  `json:"InvitedBy"`
+	CreatedAt        *string `json:"CreatedAt"`
+}
+
+func NewOrganizationInvitation(i db.OrganizationInvitation, organizationName, name, email, invitedBy string) OrganizationInvitation {
+	return OrganizationInvitation{
+		ID:               value(ID(i.ID)),
+		OrganizationID:   value(ID(i.OrganizationID)),
+		OrganizationName: organizationName,
+		UserID:           value(ID(i.UserID)),
+		Name:             name,
+		Email:            email,
+		Role:             i.Role,
+		InvitedBy:        invitedBy,
+		CreatedAt:        Time(i.CreatedAt),
+	}
+}
+
+// OrganizationMemberRequest is the body of POST /api/organizations/{id}/invitations, which invites a registered user
+// by email, and of POST /api/organizations/{id}/members, which changes the role of a member.
+type OrganizationMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (m *OrganizationMemberRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	m.Email = strings.TrimSpace(m.Email)
+	if m.Email == "" {
+		errs["email"] = "is required"
+	}
+	if m.Role == "" {
+		m.Role = RoleMember
+	}
+	if m.Role != RoleOwner && m.Role != RoleMember && m.Role != RoleDelegate {
+		errs["role"] = fmt.Sprintf("must be %v, %v or %v", RoleOwner, RoleMember, RoleDelegate)
+	}
+	return errs
+}
+
+// TaskAssignmentRequest is the body of POST /api/tasks/{id}/assignee. A null assigneeId takes the task back.
+type TaskAssignmentRequest struct {
+	AssigneeID     *string `json:"assigneeId"`
+	OrganizationID *string `json:"organizationId"`
+}
+
+func (t *TaskAssignmentRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if t.AssigneeID == nil {
+		return errs
+	}
+	if _, ok := parseIDs([]string{*t.AssigneeID}); !ok {
+		errs["assigneeId"] = "must be a UUID"
+	}
+	if t.OrganizationID == nil {
+		errs["organizationId"] = "is required with assigneeId"
+	} else if _, ok := parseIDs([]string{*t.OrganizationID}); !ok {
+		errs["organizationId"] = "must be a UUID"
+	}
+	return errs
+}
+
+// UUIDs returns the assignee and the organization as database IDs, which are not valid when the task is taken back.
+// It must only be called after Validate.
+func (t TaskAssignmentRequest) UUIDs() (assignee, organization pgtype.UUID) {
+	if t.AssigneeID == nil {
+		return assignee, organization
+	}
+	ids, _ := parseIDs([]string{*t.AssigneeID, *t.OrganizationID})
+	return ids[0], ids[1]
+}
+
+// Delegation is a grant that lets the delegate work on the mailbox and calendar of the principal. MailboxID is the
+// mailbox connection the delegate uses for it, which is nil when the grant could not be set up.
+type Delegation struct {
+	ID            string   `json:"ID"`
+	PrincipalID   string   `json:"PrincipalID"`
+	PrincipalName string   `json:"PrincipalName"`
+	Mailbox       string   `json:"Mailbox"`
+	DelegateID    string   `json:"DelegateID"`
+	DelegateName  string   `json:"DelegateName"`
+	DelegateEmail string   `json:"DelegateEmail"`
+	Permissions   []string `json:"Permissions"`
+	MailboxID     *string  `json:"MailboxID"`
+	CreatedAt     *string  `json:"CreatedAt"`
+}
+
+func NewDelegation(g db.DelegationGrant, principalName, delegateName, delegateEmail string, mailboxID pgtype.UUID) Delegation {
+	return Delegation{
+		ID:            value(ID(g.ID)),
+		PrincipalID:   value(ID(g.PrincipalID)),
+		PrincipalName: principalName,
+		Mailbox:       g.Mailbox,
+		DelegateID:    value(ID(g.DelegateID)),
+		DelegateName:  delegateName,
+		DelegateEmail: delegateEmail,
+		Permissions:   g.Permissions,
+		MailboxID:     ID(mailboxID),
+		CreatedAt:     Time(g.CreatedAt),
+	}
+}
+
+// DelegationRequest is the body of POST /api/delegations. It grants a registered user delegate access to a mailbox
+// the signed in user connected, or changes the permissions of a grant.
+type DelegationRequest struct {
+	DelegateEmail string   `json:"delegateEmail"`
+	MailboxID     string   `json:"mailboxId"`
+	Permissions   []string `json:"permissions"`
+}
+
+func (d *DelegationRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	d.DelegateEmail = strings.TrimSpace(d.DelegateEmail)
+	if d.DelegateEmail == "" {
+		errs["delegateEmail"] = "is required"
+	}
+	if d.MailboxID == "" {
+		errs["mailboxId"] = "is required"
+	} else if _, ok := parseIDs([]string{d.MailboxID}); !ok {
+		errs["mailboxId"] = "must be a UUID"
+	}
+	if len(d.Permissions) == 0 {
+		errs["permissions"] = "is required"
+	}
+	var permissions []string
+	for _, permission := range d.Permissions {
+		if !slices.Contains(provider.Permissions, permission) {
+			errs["permissions"] = "may only contain " + strings.Join(provider.Permissions, ", ")
+		} else if !slices.Contains(permissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	d.Permissions = permissions
+	return errs
+}
+
+// MailboxUUID returns MailboxID as a database ID. It must only be called after Validate.
+func (d DelegationRequest) MailboxUUID() pgtype.UUID {
+	ids, _ := parseIDs([]string{d.MailboxID})
+	return ids[0]
+}
+
+// MailboxDelegation returns the delegation a mailbox connection works with, the zero Delegation for the user's own
+// mailboxes.
+func MailboxDelegation(c db.MailboxConnection) provider.Delegation {
+	if !c.DelegationID.Valid {
+		return provider.Delegation{}
+	}
+	return provider.Delegation{
+		Mailbox:     c.Email,
+		Permissions: c.DelegatedPermissions,
+	}
+}
+
+// Message is an entry of the user's notification feed. TaskID is nil for messages that do not belong to a task.
+type Message struct {
+	ID        string  `json:"ID"`
+	TaskID    *string `json:"TaskID"`
+	MessageID *string `json:"MessageID"`
+	Content   string  `json:"Content"`
+	Read      bool    `json:"Read"`
+	CreatedAt *string `json:"CreatedAt"`
+	// Approval is the pending action the message asks the user to decide, as it was requested.
+	Approval *PendingAction `json:"Approval,omitempty"`
+}
+
+func NewMessage(m db.Message) Message {
+	message := Message{
+		ID:        value(ID(m.ID)),
+		TaskID:    ID(m.TaskID),
+		MessageID: m.MessageID,
+		Content:   value(m.Content),
+		Read:      value(m.Read),
+		CreatedAt: Time(m.CreatedAt),
+	}
+	if len(m.Approval) > 0 {
+		var action PendingAction
+		if err := json.Unmarshal(m.Approval, &action); err == nil {
+			message.Approval = &action
+		}
+	}
+	return message
+}
+
+func NewMessages(messages []db.Message) []Message {
+	result := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		result = append(result, NewMessage(m))
+	}
+	return result
+}
+
+type SpamEmail struct {
+	ID                string  `json:"ID"`
+	MessageID         *string `json:"MessageID"`
+	InternetMessageID *string `json:"InternetMessageID"`
+	MailboxID         *string `json:"MailboxID"`
+	Subject           string  `json:"Subject"`
+	EmailBody         string  `json:"EmailBody"`
+	CreatedAt         *string `json:"CreatedAt"`
+}
+
+func NewSpamEmail(s db.SpamEmail) SpamEmail {
+	return SpamEmail{
+		ID:                value(ID(s.ID)),
+		MessageID:         s.MessageID,
+		InternetMessageID: s.InternetMessageID,
+		MailboxID:         ID(s.ConnectionID),
+		Subject:           value(s.Subject),
+		EmailBody:         value(s.EmailBody),
+		CreatedAt:         Time(s.CreatedAt),
+	}
+}
+
+func NewSpamEmails(spamEmails []db.SpamEmail) []SpamEmail {
+	result := make([]SpamEmail, 0, len(spamEmails))
+	for _, s := range spamEmails {
+		result = append(result, NewSpamEmail(s))
+	}
+	return result
+}
+
+// User is the signed in user as returned by /api/me, with their mailboxes. It must never carry credentials.
+type User struct {
+	ID        string    `json:"ID"`
+	Name      string    `json:"Name"`
+	Email     string    `json:"Email"`
+	Mailboxes []Mailbox `json:"Mailboxes"`
+}
+
+func NewUser(u db.User, mailboxes []Mailbox) User {
+	return User{
+		ID:        value(ID(u.ID)),
+		Name:      u.Name,
+		Email:     u.Email,
+		Mailboxes: mailboxes,
+	}
+}
+
+// Mailbox is a mailbox connected to the user, with the health of its subscriptions. It must never carry credentials.
+type Mailbox struct {
+	ID                   string               `json:"ID"`
+	Provider             string               `json:"Provider"`
+	Email                string               `json:"Email"`
+	SubscriptionDisabled bool                 `json:"SubscriptionDisabled"`
+	CheckSpam            bool                 `json:"CheckSpam"`
+	Subscriptions        []SubscriptionHealth `json:"Subscriptions"`
+	// DelegationID and Permissions are set on the mailboxes of principals the user is a delegate for.
+	DelegationID *string  `json:"DelegationID"`
+	Permissions  []string `json:"Permissions"`
+	CreatedAt    *string  `json:"CreatedAt"`
+}
+
+type SubscriptionHealth struct {
+	Kind                 string  `json:"Kind"`
+	Status               string  `json:"Status"`
+	ExpireAt             *string `json:"ExpireAt"`
+	RenewedAt            *string `json:"RenewedAt"`
+	LastError            *string `json:"LastError"`
+	LastLifecycleEvent   *string `json:"LastLifecycleEvent"`
+	LastLifecycleEventAt *string `json:"LastLifecycleEventAt"`
+}
+
+// NewMailbox describes the connection with those of the subscriptions that belong to it.
+func NewMailbox(c db.MailboxConnection, subscriptions []db.Subscription) Mailbox {
+	mailbox := Mailbox{
+		ID:                   value(ID(c.ID)),
+		Provider:             c.Provider,
+		Email:                c.Email,
+		SubscriptionDisabled: c.SubscriptionDisabled,
+		CheckSpam:            c.CheckSpam,
+		Subscriptions:        []SubscriptionHealth{},
+		DelegationID:         ID(c.DelegationID),
+		Permissions:          c.DelegatedPermissions,
+		CreatedAt:            Time(c.CreatedAt),
+	}
+	for _, s := range subscriptions {
+		if s.ConnectionID != c.ID {
+			continue
+		}
+		mailbox.Subscriptions = append(mailbox.Subscriptions, SubscriptionHealth{
+			Kind:                 s.Kind,
+			Status:               s.Status,
+			ExpireAt:             Time(s.ExpireAt),
+			RenewedAt:            Time(s.RenewedAt),
+			LastError:            s.LastError,
+			LastLifecycleEvent:   s.LastLifecycleEvent,
+			LastLifecycleEventAt: Time(s.LastLifecycleEventAt),
+		})
+	}
+	return mailbox
+}
+
+func NewMailboxes(connections []db.MailboxConnection, subscriptions []db.Subscription) []Mailbox {
+	result := make([]Mailbox, 0, len(connections))
+	for _, c := range connections {
+		result = append(result, NewMailbox(c, subscriptions))
+	}
+	return result
+}
+
+// MailboxSettingsRequest is the body of POST /api/mailboxes/{id}, and of POST /api/me to change every mailbox at
+// once. Settings that are left out keep their current value.
+type MailboxSettingsRequest struct {
+	SubscriptionDisabled *bool `json:"subscriptionDisabled"`
+	CheckSpam            *bool `json:"checkSpam"`
+}
+
+// Scopes of API tokens. A token with the write scope can also read.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+const (
+	defaultTokenDays = 90
+	maxTokenDays     = 365
+)
+
+// APIToken describes a personal API token. The token itself is only returned once, when it is created.
+type APIToken struct {
+	ID         string   `json:"ID"`
+	Name       string   `json:"Name"`
+	Scopes     []string `json:"Scopes"`
+	ExpireAt   *string  `json:"ExpireAt"`
+	LastUsedAt *string  `json:"LastUsedAt"`
+	CreatedAt  *string  `json:"CreatedAt"`
+}
+
+func NewAPIToken(t db.ApiToken) APIToken {
+	return APIToken{
+		ID:         value(ID(t.ID)),
+		Name:       t.Name,
+		Scopes:     t.Scopes,
+		ExpireAt:   Time(t.ExpireAt),
+		LastUsedAt: Time(t.LastUsedAt),
+		CreatedAt:  Time(t.CreatedAt),
+	}
+}
+
+func NewAPITokens(tokens []db.ApiToken) []APIToken {
+	result := make([]APIToken, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, NewAPIToken(t))
+	}
+	return result
+}
+
+// CreatedAPIToken is the response to creating an API token, the only one that carries the token.
+type CreatedAPIToken struct {
+	APIToken
+	Token string `json:"Token"`
+}
+
+// APITokenRequest is the body of POST /api/tokens. Scopes default to read and write, and the token expires after 90
+// days unless ExpiresInDays says otherwise.
+type APITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays *int     `json:"expiresInDays"`
+}
+
+func (t *APITokenRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		errs["name"] = "is required"
+	} else if len(t.Name) > maxNameLength {
+		errs["name"] = "is too long"
+	}
+	if len(t.Scopes) == 0 {
+		t.Scopes = []string{ScopeRead, ScopeWrite}
+	}
+	for _, scope := range t.Scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			errs["scopes"] = "must only contain read and write"
+		}
+	}
+	if t.ExpiresInDays == nil {
+		days := defaultTokenDays
+		t.ExpiresInDays = &days
+	} else if *t.ExpiresInDays < 1 || *t.ExpiresInDays > maxTokenDays {
+		errs["expiresInDays"] = fmt.Sprintf("must be between 1 and %d", maxTokenDays)
+	}
+	return errs
+}
+
+// Session is a signed in browser or client. Current marks the session of the request.
+type Session struct {
+	ID          string  `json:"ID"`
+	UserAgent   *string `json:"UserAgent"`
+	Current     bool    `json:"Current"`
+	ExpireAt    *string `json:"ExpireAt"`
+	RefreshedAt *string `json:"RefreshedAt"`
+	CreatedAt   *string `json:"CreatedAt"`
+}
+
+func NewSession(s db.Session, currentID string) Session {
+	id := value(ID(s.ID))
+	return Session{
+		ID:          id,
+		UserAgent:   s.UserAgent,
+		Current:     id == currentID,
+		ExpireAt:    Time(s.ExpireAt),
+		RefreshedAt: Time(s.RefreshedAt),
+		CreatedAt:   Time(s.CreatedAt),
+	}
+}
+
+func NewSessions(sessions []db.Session, currentID string) []Session {
+	result := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, NewSession(s, currentID))
+	}
+	return result
+}
+
+// IMAPLoginRequest signs in with the password of a self-hosted mailbox that is connected already.
+type IMAPLoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (l *IMAPLoginRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	l.Email = strings.TrimSpace(l.Email)
+	if l.Email == "" {
+		errs["email"] = "is required"
+	}
+	if l.Password == "" {
+		errs["password"] = "is required"
+	}
+	return errs
+}
+
+// LoginProvider is a way to sign in, LoginURL starts the sign in in the browser.
+type LoginProvider struct {
+	ID       string `json:"ID"`
+	Name     string `json:"Name"`
+	LoginURL string `json:"LoginURL"`
+}
+
+// AuditEvent is an outbound action taken on a mailbox. Arguments and ResponseIDs are the JSON objects the action was
+// recorded with.
+type AuditEvent struct {
+	ID          string          `json:"ID"`
+	UserID      string          `json:"UserID"`
+	Actor       string          `json:"Actor"`
+	TaskID      *string         `json:"TaskID"`
+	Mailbox     string          `json:"Mailbox"`
+	Tool        string          `json:"Tool"`
+	Arguments   json.RawMessage `json:"Arguments"`
+	ResponseIDs json.RawMessage `json:"ResponseIDs"`
+	Status      string          `json:"Status"`
+	Error       *string         `json:"Error"`
+	CreatedAt   *string         `json:"CreatedAt"`
+}
+
+func NewAuditEvent(e db.AuditEvent) AuditEvent {
+	return AuditEvent{
+		ID:          value(ID(e.ID)),
+		UserID:      value(ID(e.UserID)),
+		Actor:       e.Actor,
+		TaskID:      ID(e.TaskID),
+		Mailbox:     e.Mailbox,
+		Tool:        e.Tool,
+		Arguments:   e.Arguments,
+		ResponseIDs: e.ResponseIDs,
+		Status:      e.Status,
+		Error:       e.Error,
+		CreatedAt:   Time(e.CreatedAt),
+	}
+}
+
+// Decisions on a pending action.
+const (
+	DecisionApprove = "approve"
+	DecisionEdit    = "edit"
+	DecisionReject  = "reject"
+)
+
+// PendingAction is an email, event or online meeting the assistant wants to send or create in a task run, waiting for
+// the approval of the user running the task. Arguments is the email, event or meeting, Result holds the IDs it was
+// sent or created with.
+type PendingAction struct {
+	ID        string          `json:"ID"`
+	TaskID    string          `json:"TaskID"`
+	MailboxID string          `json:"MailboxID"`
+	Tool      string          `json:"Tool"`
+	Arguments json.RawMessage `json:"Arguments"`
+	Status    string          `json:"Status"`
+	Result    json.RawMessage `json:"Result"`
+	Error     *string         `json:"Error"`
+	CreatedAt *string         `json:"CreatedAt"`
+	DecidedAt *string         `json:"DecidedAt"`
+}
+
+func NewPendingAction(a db.PendingAction) PendingAction {
+	return PendingAction{
+		ID:        value(ID(a.ID)),
+		TaskID:    value(ID(a.TaskID)),
+		MailboxID: value(ID(a.ConnectionID)),
+		Tool:      a.Tool,
+		Arguments: a.Arguments,
+		Status:    a.Status,
+		Result:    a.Result,
+		Error:     a.Error,
+		CreatedAt: Time(a.CreatedAt),
+		DecidedAt: Time(a.DecidedAt),
+	}
+}
+
+// ApprovalRequest is the body of POST /api/approvals/{id}. edit approves the action with Email or Event, whichever
+// matches its tool, in place of what the assistant wrote.
+type ApprovalRequest struct {
+	Decision string          `json:"decision"`
+	Email    *approval.Email `json:"email"`
+	Event    *approval.Event `json:"event"`
+}
+
+func (a *ApprovalRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	switch a.Decision {
+	case DecisionApprove, DecisionReject:
+		if a.Email != nil || a.Event != nil {
+			errs["decision"] = "must be edit to change the email or event"
+		}
+	case DecisionEdit:
+		if (a.Email == nil) == (a.Event == nil) {
+			errs["decision"] = "edit needs either email or event"
+		}
+	default:
+		errs["decision"] = "must be approve, edit or reject"
+	}
+	if a.Email != nil {
+		if strings.TrimSpace(a.Email.Subject) == "" {
+			errs["email.subject"] = "is required"
+		}
+		if len(a.Email.To) == 0 {
+			errs["email.to"] = "is required"
+		}
+	}
+	if a.Event != nil {
+		if strings.TrimSpace(a.Event.Subject) == "" {
+			errs["event.subject"] = "is required"
+		}
+		if a.Event.Start == "" {
+			errs["event.start"] = "is required"
+		}
+		if a.Event.End == "" {
+			errs["event.end"] = "is required"
+		}
+		if len(a.Event.Attendees) == 0 {
+			errs["event.attendees"] = "is required"
+		}
+	}
+	return errs
+}
+
+// TaskDraft is an email the assistant left in the Drafts folder of the mailbox in a task run. WebLink opens it in
+// the mail client. Email is the current content of the draft, which is only filled in for a single draft.
+type TaskDraft struct {
+	ID             string          `json:"ID"`
+	TaskID         string          `json:"TaskID"`
+	MailboxID      string          `json:"MailboxID"`
+	MessageID      string          `json:"MessageID"`
+	ConversationID *string         `json:"ConversationID"`
+	WebLink        *string         `json:"WebLink"`
+	Subject        string          `json:"Subject"`
+	Recipients     []string        `json:"Recipients"`
+	Status         string          `json:"Status"`
+	Email          *approval.Email `json:"Email,omitempty"`
+	CreatedAt      *string         `json:"CreatedAt"`
+	UpdatedAt      *string         `json:"UpdatedAt"`
+	SentAt         *string         `json:"SentAt"`
+}
+
+func NewTaskDraft(d db.TaskDraft, email *approval.Email) TaskDraft {
+	return TaskDraft{
+		ID:             value(ID(d.ID)),
+		TaskID:         value(ID(d.TaskID)),
+		MailboxID:      value(ID(d.ConnectionID)),
+		MessageID:      d.MessageID,
+		ConversationID: d.ConversationID,
+		WebLink:        d.WebLink,
+		Subject:        d.Subject,
+		Recipients:     d.Recipients,
+		Status:         d.Status,
+		Email:          email,
+		CreatedAt:      Time(d.CreatedAt),
+		UpdatedAt:      Time(d.UpdatedAt),
+		SentAt:         Time(d.SentAt),
+	}
+}
+
+// DraftRequest is the body of POST /api/tasks/{id}/drafts/{draftId}. Only the fields that are set are changed.
+type DraftRequest struct {
+	Subject *string   `json:"subject"`
+	Body    *string   `json:"body"`
+	To      *[]string `json:"to"`
+	Cc      *[]string `json:"cc"`
+	Bcc     *[]string `json:"bcc"`
+}
+
+func (d *DraftRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if d.Subject == nil && d.Body == nil && d.To == nil && d.Cc == nil && d.Bcc == nil {
+		errs["subject"] = "at least one of subject, body, to, cc or bcc is required"
+	}
+	if d.Subject != nil && strings.TrimSpace(*d.Subject) == "" {
+		errs["subject"] = "must not be empty"
+	}
+	if d.To != nil && len(*d.To) == 0 {
+		errs["to"] = "must not be empty"
+	}
+	return errs
+}
+
+// Apply returns the email with the fields of the request that are set.
+func (d *DraftRequest) Apply(email approval.Email) approval.Email {
+	if d.Subject != nil {
+		email.Subject = *d.Subject
+	}
+	if d.Body != nil {
+		email.Body = *d.Body
+	}
+	if d.To != nil {
+		email.To = *d.To
+	}
+	if d.Cc != nil {
+		email.Cc = *d.Cc
+	}
+	if d.Bcc != nil {
+		email.Bcc = *d.Bcc
+	}
+	return email
+}
+
+// RunFrame is the websocket message a task run sends for every call event.
+type RunFrame struct {
+	ID    string                         `json:"id"`
+	Frame gptscript.CallFrame            `json:"frame"`
+	State map[string]gptscript.CallFrame `json:"state"`
+}
+
+// ApprovalFrame is the websocket message a task run sends after each turn for every action waiting for approval.
+type ApprovalFrame struct {
+	Approval PendingAction `json:"approval"`
+}
@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 10000
+)
+
+// Formats of GET /api/audit, json answers like the other endpoints, csv and jsonl download an export.
+const (
+	formatJSON  = "json"
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+type Handler struct {
+	queries *db.Queries
+}
+
+func NewHandler(queries *db.Queries) *Handler {
+	return &Handler{queries: queries}
+}
+
+// ListAuditEvents lists the audit events the user can see, newest first: the actions taken for them, those taken on
+// their own mailboxes, such as by a delegate, and those of the runs of their tasks. They can be filtered by taskId,
+// tool, actor, mailbox and a since and until time, and exported with format csv or jsonl.
+func (h *Handler) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+
+	params, format, errs := parseQuery(r)
+	if len(errs) > 0 {
+		api.BadRequest(w, "invalid query", errs)
+		return
+	}
+	params.UserID = uid
+
+	events, err := h.queries.ListAuditEvents(r.Context(), params)
+	if err != nil {
+		api.DBError(w, err, "audit events")
+		return
+	}
+	result := make([]api.AuditEvent, 0, len(events))
+	for _, e := range events {
+		result = append(result, api.NewAuditEvent(e))
+	}
+
+	switch format {
+	case formatCSV:
+		writeCSV(w, result)
+	case formatJSONL:
+		writeJSONL(w, result)
+	default:
+		api.WriteJSON(w, http.StatusOK, result)
+	}
+}
+
+func parseQuery(r *http.Request) (db.ListAuditEventsParams, string, api.FieldErrors) {
+	query := r.URL.Query()
+	params := db.ListAuditEventsParams{MaxEvents: defaultLimit}
+	errs := api.FieldErrors{}
+
+	if v := query.Get("taskId"); v != "" {
+		if err := params.TaskID.Scan(v); err != nil || !params.TaskID.Valid {
+			errs["taskId"] = "must be a UUID"
+		}
+	}
+	for name, p := range map[string]**string{
+		"tool":    &params.Tool,
+		"actor":   &params.Actor,
+		"mailbox": &params.Mailbox,
+	} {
+		if v := query.Get(name); v != "" {
+			*p = &v
+		}
+	}
+	for name, p := range map[string]*pgtype.Timestamptz{
+		"since": &params.Since,
+		"until": &params.Until,
+	} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errs[name] = "must be an RFC 3339 time"
+				continue
+			}
+			*p = pgtype.Timestamptz{Time: t, Valid: true}
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLimit {
+			errs["limit"] = "must be between 1 and " + strconv.Itoa(maxLimit)
+		} else {
+			params.MaxEvents = int32(limit)
+		}
+	}
+
+	format := query.Get("format")
+	switch format {
+	case "":
+		format = formatJSON
+	case formatJSON, formatCSV, formatJSONL:
+	default:
+		errs["format"] = "must be json, csv or jsonl"
+	}
+	return params, format, errs
+}
+
+var csvHeader = []string{"ID", "CreatedAt", "UserID", "Actor", "TaskID", "Mailbox", "Tool", "Status", "Error", "Arguments", "ResponseIDs"}
+
+// writeCSV writes one row per event, Arguments and ResponseIDs stay JSON.
+func writeCSV(w http.ResponseWriter, events []api.AuditEvent) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	rows := [][]string{csvHeader}
+	for _, e := range events {
+		rows = append(rows, []string{
+			e.ID,
+			deref(e.CreatedAt),
+			e.UserID,
+			e.Actor,
+			deref(e.TaskID),
+			e.Mailbox,
+			e.Tool,
+			e.Status,
+			deref(e.Error),
+			string(e.Arguments),
+			string(e.ResponseIDs),
+		})
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		logrus.Error(fmt.Errorf("failed to write audit export: %w", err))
+	}
+}
+
+// writeJSONL writes one JSON object per line.
+func writeJSONL(w http.ResponseWriter, events []api.AuditEvent) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			logrus.Error(fmt.Errorf("failed to write audit export: %w", err))
+			return
+		}
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -4,23 +4,22 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"sync"
+	"strings"
 	"time"
 
 	"ethan/pkg/db"
-	"ethan/pkg/mstoken"
-	"github.com/google/uuid"
+	"ethan/pkg/provider"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/server/api"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	_ "github.com/lib/pq"
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/microsoft"
@@ -34,14 +33,37 @@ var (
 		ClientSecret: os.Getenv("MICROSOFT_CLIENT_SECRET"),
 		RedirectURL:  fmt.Sprintf("%v/api/auth/callback", getPublicURL()),
 		Scopes:       []string{"User.Read", "Mail.ReadWrite", "Mail.Send", "Contacts.Read", "Calendars.ReadWrite", "People.Read", "offline_access"},
-		Endpoint:     microsoft.AzureADEndpoint(os.Getenv("MICROSOFT_TENANT_ID")),
+		Endpoint:     loginEndpoint(),
 	}
 	jwtKey = []byte(os.Getenv("MICROSOFT_JWT_KEY"))
+
+	errInvalidState = errors.New("invalid or expired oauth state")
+	// errSignInWithIdentity is returned when a mailbox's email belongs to a user who signs in with an identity provider
+	// and has not connected a mailbox, who must connect it from their own session.
+	errSignInWithIdentity = errors.New("the email belongs to an account that signs in with another identity provider, sign in with it and connect the mailbox from the account page")
 )
 
+// StateStore keeps the OAuth states of logins in progress in Postgres, so the callback can reach any replica.
 type StateStore struct {
-	states map[string]time.Time
-	mutex  sync.RWMutex
+	queries *db.Queries
+}
+
+// loginEndpoint returns the Azure AD endpoint, or the one under MICROSOFT_LOGIN_URL when the server runs against
+// a stand-in such as graphfake.
+func loginEndpoint() oauth2.Endpoint {
+	loginURL := os.Getenv("MICROSOFT_LOGIN_URL")
+	if loginURL == "" {
+		return microsoft.AzureADEndpoint(os.Getenv("MICROSOFT_TENANT_ID"))
+	}
+	tenant := os.Getenv("MICROSOFT_TENANT_ID")
+	if tenant == "" {
+		tenant = "common"
+	}
+	loginURL = strings.TrimSuffix(loginURL, "/")
+	return oauth2.Endpoint{
+		AuthURL:  fmt.Sprintf("%v/%v/oauth2/v2.0/authorize", loginURL, tenant),
+		TokenURL: fmt.Sprintf("%v/%v/oauth2/v2.0/token", loginURL, tenant),
+	}
 }
 
 func getPublicURL() string {
@@ -51,31 +73,30 @@ func getPublicURL() string {
 	return os.Getenv("PUBLIC_URL")
 }
 
-func NewStateStore() *StateStore {
+func NewStateStore(queries *db.Queries) *StateStore {
 	return &StateStore{
-		states: make(map[string]time.Time),
+		queries: queries,
 	}
 }
 
-func (s *StateStore) Add(state string) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	s.states[state] = time.Now().Add(15 * time.Minute)
+// Add stores a new login. Besides the state, params carry what the callback needs to finish it: the PKCE verifier
+// and nonce of OIDC logins, and the user a mailbox is linked to.
+func (s *StateStore) Add(ctx context.Context, params db.CreateOAuthStateParams) error {
+	if err := s.queries.DeleteExpiredOAuthStates(ctx); err != nil {
+		return err
+	}
+	params.ExpireAt = pgtype.Timestamptz{Time: time.Now().Add(15 * time.Minute), Valid: true}
+	return s.queries.CreateOAuthState(ctx, params)
 }
 
-func (s *StateStore) Validate(state string) bool {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	expiry, exists := s.states[state]
-	if !exists {
-		return false
+// Consume returns the login of the state, failing with errInvalidState when it was not issued or has expired. A state
+// can only be used once.
+func (s *StateStore) Consume(ctx context.Context, state string) (db.OauthState, error) {
+	login, err := s.queries.ConsumeOAuthState(ctx, state)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.OauthState{}, errInvalidState
 	}
-	if time.Now().After(expiry) {
-		delete(s.states, state)
-		return false
-	}
-	delete(s.states, state)
-	return true
+	return login, err
 }
 
 func generateState() (string, error) {
@@ -88,149 +109,210 @@ func generateState() (string, error) {
 }
 
 type Handler struct {
-	queries *db.Queries
-	states  *StateStore
+	queries   *db.Queries
+	states    *StateStore
+	providers provider.Registry
 }
 
-func NewHandler(queries *db.Queries) *Handler {
+func NewHandler(queries *db.Queries, providers provider.Registry) *Handler {
 	return &Handler{
-		queries: queries,
-		states:  NewStateStore(),
+		queries:   queries,
+		states:    NewStateStore(queries),
+		providers: providers,
 	}
 }
 
 func (h *Handler) HandleMicrosoftLogin(w http.ResponseWriter, r *http.Request) {
+	h.startMicrosoftLogin(w, r, pgtype.UUID{})
+}
+
+// LinkMicrosoftMailbox connects a Microsoft 365 mailbox to the signed in user, who may have signed in with another
+// identity provider.
+func (h *Handler) LinkMicrosoftMailbox(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+	h.startMicrosoftLogin(w, r, uid)
+}
+
+// startMicrosoftLogin redirects to the Microsoft login. With a user ID the callback links the mailbox to that user
+// instead of signing in.
+func (h *Handler) startMicrosoftLogin(w http.ResponseWriter, r *http.Request, linkTo pgtype.UUID) {
 	state, err := generateState()
 	if err != nil {
-		fmt.Fprint(w, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		api.InternalError(w, fmt.Errorf("failed to generate oauth state: %w", err))
+		return
+	}
+	providerName := graph.Name
+	if err := h.states.Add(r.Context(), db.CreateOAuthStateParams{
+		State:    state,
+		Provider: &providerName,
+		UserID:   linkTo,
+	}); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to store oauth state: %w", err))
 		return
 	}
-	h.states.Add(state)
 	url := oauthConfig.AuthCodeURL(state)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 func (h *Handler) HandleMe(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	var uid pgtype.UUID
-	if err := uid.Scan(userID); err != nil {
-		fmt.Fprint(w, fmt.Errorf("invalid user id: %s", userID))
+	uid, ok := api.UserID(w, r)
+	if !ok {
 		return
 	}
 
 	user, err := h.queries.GetUser(r.Context(), uid)
 	if err != nil {
-		fmt.Fprint(w, fmt.Errorf("failed to get user: %s", userID))
+		api.DBError(w, err, "user")
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		fmt.Fprint(w, fmt.Errorf("failed to encode user: %s", userID))
+	mailboxes, err := h.mailboxes(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "mailboxes")
 		return
 	}
-
-	return
+	api.WriteJSON(w, http.StatusOK, api.NewUser(user, mailboxes))
 }
 
 func (h *Handler) HandleMicrosoftCallback(w http.ResponseWriter, r *http.Request) {
-	user, err := h.saveUserInfo(r.Context(), r.FormValue("state"), r.FormValue("code"))
-	if err != nil {
-		fmt.Fprint(w, fmt.Errorf("failed to save user info: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	login, err := h.states.Consume(r.Context(), r.FormValue("state"))
+	if err == nil && login.Provider != nil && *login.Provider != graph.Name {
+		err = errInvalidState
+	}
+	if errors.Is(err, errInvalidState) {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	} else if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to validate oauth state: %w", err))
 		return
 	}
 
-	jwtToken, err := createJWT(user)
+	token, me, err := h.exchangeMicrosoftCode(r.Context(), r.FormValue("code"))
 	if err != nil {
-		fmt.Fprint(w, fmt.Errorf("failed to create jwt token: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
-	cookie := &http.Cookie{
-		Name:     JwtTokenName,
-		Value:    jwtToken,
-		Expires:  time.Now().Add(time.Hour * 24),
-		SameSite: http.SameSiteDefaultMode,
-		Path:     "/",
+	if login.UserID.Valid {
+		if _, err := h.linkMailbox(r.Context(), login.UserID, oauthMailbox(graph.Name, me.Email, token)); err != nil {
+			mailboxError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/account", http.StatusTemporaryRedirect)
+		return
+	}
+
+	user, err := h.saveUserInfo(r.Context(), token, me)
+	if errors.Is(err, errSignInWithIdentity) || errors.Is(err, errMailboxTaken) {
+		api.Conflict(w, err.Error())
+		return
+	} else if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to save user info: %w", err))
+		return
+	}
+
+	if err := h.startSession(w, r, user); err != nil {
+		api.InternalError(w, err)
+		return
 	}
 
-	http.SetCookie(w, cookie)
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 	return
 }
 
-func (h *Handler) saveUserInfo(ctx context.Context, state string, code string) (db.User, error) {
-	if !h.states.Validate(state) {
-		return db.User{}, fmt.Errorf("invalid oauth state")
+// exchangeMicrosoftCode redeems the authorization code and looks up the mailbox it grants access to.
+func (h *Handler) exchangeMicrosoftCode(ctx context.Context, code string) (*oauth2.Token, provider.User, error) {
+	token, err := oauthConfig.Exchange(ctx, code)
+	if err != nil {
+		return nil, provider.User{}, fmt.Errorf("code exchange failed: %s", err.Error())
 	}
 
-	token, err := oauthConfig.Exchange(ctx, code)
+	p, err := h.providers.New(graph.Name, token.AccessToken)
 	if err != nil {
-		return db.User{}, fmt.Errorf("code exchange failed: %s", err.Error())
+		return nil, provider.User{}, fmt.Errorf("failed to construct mail provider: %s", err.Error())
 	}
 
-	cred := mstoken.NewStaticTokenCredential(token.AccessToken)
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	me, err := p.Me(ctx)
 	if err != nil {
-		return db.User{}, fmt.Errorf("failed to construct ms client: %s", err.Error())
+		return nil, provider.User{}, fmt.Errorf("failed to get me client: %s", err.Error())
 	}
+	return token, me, nil
+}
 
-	me, err := client.Me().Get(ctx, nil)
+// saveUserInfo returns the user who signed in with the mailbox, creating one for a mailbox seen for the first time, and
+// stores the new token on the mailbox.
+func (h *Handler) saveUserInfo(ctx context.Context, token *oauth2.Token, me provider.User) (db.User, error) {
+	user, err := h.mailboxOwner(ctx, me.Name, me.Email)
 	if err != nil {
-		return db.User{}, fmt.Errorf("failed to get me client: %s", err.Error())
+		return db.User{}, err
+	}
+	if _, err := h.linkMailbox(ctx, user.ID, oauthMailbox(graph.Name, me.Email, token)); err != nil {
+		return db.User{}, err
 	}
+	return user, nil
+}
 
-	email := me.GetMail()
-	t := pgtype.Timestamptz{}
-	if err := t.Scan(token.Expiry); err != nil {
-		return db.User{}, fmt.Errorf("failed to scan token for expiry time: %w", err)
+// mailboxOwner returns the user the mailbox is connected to, or else the user with the mailbox's email. A user is
+// created if there is neither. A delegate's connection to the mailbox is not considered, its owner is found by email and
+// can connect it next to the delegate. A user who signs in with an identity provider and has no mailbox yet is not
+// adopted by email: they connect the mailbox from their own session.
+func (h *Handler) mailboxOwner(ctx context.Context, name, email string) (db.User, error) {
+	conn, err := h.queries.GetMailboxConnectionFromEmail(ctx, email)
+	if err == nil {
+		return h.queries.GetUser(ctx, conn.UserID)
+	} else if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return db.User{}, fmt.Errorf("failed to get mailbox: %w", err)
 	}
 
-	user, err := h.queries.GetUserFromEmail(ctx, *email)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			newUser, err := h.queries.CreateUser(ctx, db.CreateUserParams{
-				Name:         *me.GetDisplayName(),
-				Email:        *email,
-				ExpireAt:     t,
-				Token:        token.AccessToken,
-				RefreshToken: &token.RefreshToken,
-			})
-			if err != nil {
-				return db.User{}, fmt.Errorf("failed to create user: %w", err)
-			}
-			logrus.Info("User created")
-			return newUser, nil
+	user, err := h.queries.GetUserFromEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		user, err = h.queries.CreateUser(ctx, db.CreateUserParams{
+			Name:  name,
+			Email: email,
+		})
+		if err != nil {
+			return db.User{}, fmt.Errorf("failed to create user: %w", err)
 		}
+		logrus.Info("User created")
+	} else if err != nil {
 		return db.User{}, fmt.Errorf("failed to get user: %w", err)
-	} else {
-		if err := h.queries.UpdateUser(ctx, db.UpdateUserParams{
-			ID:                   user.ID,
-			Token:                token.AccessToken,
-			RefreshToken:         &token.RefreshToken,
-			ExpireAt:             t,
-			SubscriptionID:       user.SubscriptionID,
-			SubscriptionExpireAt: user.SubscriptionExpireAt,
-			SubscriptionDisabled: user.SubscriptionDisabled,
-			CheckSpam:            user.CheckSpam,
-		}); err != nil {
-			return db.User{}, fmt.Errorf("failed to update user token: %w", err)
-		}
-		logrus.Infof("User %v updated", uuid.UUID(user.ID.Bytes).String())
+	} else if err := h.checkAdoptable(ctx, user); err != nil {
+		return db.User{}, err
 	}
 	return user, nil
 }
 
-func createJWT(user db.User) (string, error) {
+// checkAdoptable returns errSignInWithIdentity for users who have an identity provider identity but no mailbox.
+func (h *Handler) checkAdoptable(ctx context.Context, user db.User) error {
+	identities, err := h.queries.CountUserIdentities(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to count identities: %w", err)
+	}
+	if identities == 0 {
+		return nil
+	}
+	connections, err := h.queries.ListMailboxConnectionsForUser(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	if len(connections) == 0 {
+		return errSignInWithIdentity
+	}
+	return nil
+}
+
+func createJWT(user db.User, sessionID pgtype.UUID) (string, error) {
 	claims := jwt.MapClaims{
 		"sub":   user.ID,
+		"sid":   sessionID,
 		"name":  user.Name,
 		"email": user.Email,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(),
+		"iss":   getPublicURL(),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(accessTokenTTL).Unix(),
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return signJWT(claims)
 }
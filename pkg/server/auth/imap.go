@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+
+	"ethan/pkg/provider/imap"
+	"ethan/pkg/server/api"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/net/publicsuffix"
+)
+
+// allowPrivateServers lets mailboxes on private networks be connected, for servers that run next to a self-hosted mail
+// server. Otherwise private and loopback servers are refused, so users cannot make the server connect to internal
+// services.
+var allowPrivateServers = os.Getenv("IMAP_ALLOW_PRIVATE_SERVERS") == "true"
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routable on the internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// lookupMX finds the mail exchangers of a domain. Tests replace it.
+var lookupMX = net.DefaultResolver.LookupMX
+
+// HandleIMAPLogin signs in the user a self-hosted mailbox is connected to, with the password of the mailbox. The
+// password is checked against the servers stored on the connection, never ones from the request, so only someone who
+// can log into the mailbox the owner connected signs in as them. It never creates users: a self-hosted mailbox is
+// first connected from a signed in session with LinkIMAPMailbox.
+func (h *Handler) HandleIMAPLogin(w http.ResponseWriter, r *http.Request) {
+	var req api.IMAPLoginRequest
+	if !api.DecodeValid(w, r, &req) {
+		return
+	}
+
+	conn, err := h.queries.GetMailboxConnectionFromEmail(r.Context(), req.Email)
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && conn.Provider != imap.Name {
+		invalidIMAPLogin(w)
+		return
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+	cfg, err := imap.ParseConfig(string(conn.Token))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	cfg.Password = req.Password
+	// Mailboxes connected before the owner of the address was checked may be on a server anyone runs.
+	if errs := checkOwner(r.Context(), cfg); len(errs) > 0 {
+		invalidIMAPLogin(w)
+		return
+	}
+	if err := imap.NewFromConfig(cfg).Verify(); err != nil {
+		invalidIMAPLogin(w)
+		return
+	}
+
+	user, err := h.queries.GetUser(r.Context(), conn.UserID)
+	if err != nil {
+		api.DBError(w, err, "user")
+		return
+	}
+	// The password may have changed on the mail server since the mailbox was connected.
+	token, err := cfg.Token()
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if _, err := h.linkMailbox(r.Context(), user.ID, mailbox{
+		provider: imap.Name,
+		email:    conn.Email,
+		token:    token,
+	}); err != nil {
+		mailboxError(w, err)
+		return
+	}
+
+	if err := h.startSession(w, r, user); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// invalidIMAPLogin answers the same whether the mailbox is not connected or the password is wrong, so the answer does
+// not tell which addresses are registered.
+func invalidIMAPLogin(w http.ResponseWriter) {
+	api.WriteError(w, http.StatusUnauthorized, api.CodeUnauthorized, "invalid email or password", nil)
+}
+
+// LinkIMAPMailbox connects a self-hosted mailbox to the signed in user, who may have signed in with another identity
+// provider or connected other mailboxes already.
+func (h *Handler) LinkIMAPMailbox(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+	cfg, token, ok := verifyIMAP(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := h.linkMailbox(r.Context(), uid, mailbox{
+		provider: imap.Name,
+		email:    cfg.Email,
+		token:    token,
+	})
+	if err != nil {
+		mailboxError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, api.NewMailbox(conn, nil))
+}
+
+// verifyIMAP reads the IMAP/SMTP settings from the request body and checks them against the IMAP server. It returns
+// the settings and the token they are stored as. The server must be one of the email's domain, see checkOwner, so a
+// mailbox cannot be connected with an address its owner has not connected yet.
+func verifyIMAP(w http.ResponseWriter, r *http.Request) (imap.Config, string, bool) {
+	var cfg imap.Config
+	if !api.Decode(w, r, &cfg) {
+		return cfg, "", false
+	}
+
+	token, err := cfg.Token()
+	if err != nil {
+		api.InternalError(w, err)
+		return cfg, "", false
+	}
+	cfg, err = imap.ParseConfig(token)
+	if err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return cfg, "", false
+	}
+	errs := checkServers(r.Context(), cfg)
+	for field, err := range checkOwner(r.Context(), cfg) {
+		errs[field] = err
+	}
+	if len(errs) > 0 {
+		api.BadRequest(w, "invalid request", errs)
+		return cfg, "", false
+	}
+	if err := imap.NewFromConfig(cfg).Verify(); err != nil {
+		api.WriteError(w, http.StatusUnauthorized, api.CodeUnauthorized, err.Error(), nil)
+		return cfg, "", false
+	}
+	if token, err = cfg.Token(); err != nil {
+		api.InternalError(w, err)
+		return cfg, "", false
+	}
+	return cfg, token, true
+}
+
+// checkServers refuses settings that turn TLS off, and, unless allowPrivateServers is set, servers that resolve to
+// private, loopback or link-local addresses.
+func checkServers(ctx context.Context, cfg imap.Config) api.FieldErrors {
+	errs := api.FieldErrors{}
+	if cfg.Insecure {
+		errs["insecure"] = "is not allowed"
+	}
+
+	hosts := map[string]string{}
+	for field, addr := range map[string]string{"imapAddr": cfg.IMAPAddr, "smtpAddr": cfg.SMTPAddr} {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || host == "" {
+			errs[field] = "must be host:port"
+			continue
+		}
+		hosts[field] = host
+	}
+	if cfg.CalDAVURL != "" {
+		u, err := url.Parse(cfg.CalDAVURL)
+		if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+			errs["caldavURL"] = "must be an https URL"
+		} else {
+			hosts["caldavURL"] = u.Hostname()
+		}
+	}
+	if allowPrivateServers {
+		return errs
+	}
+
+	for field, host := range hosts {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil || len(addrs) == 0 {
+			errs[field] = "must resolve to an address"
+			continue
+		}
+		for _, addr := range addrs {
+			if !public(addr) {
+				errs[field] = "must not be a private, loopback or link-local address"
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// checkOwner checks that the email is the address of the account the IMAP server signs in. Anyone can run a server
+// that accepts any address, so the server must be one the domain of the email points to: a host of the domain itself,
+// or one of the same site as a mail exchanger of the domain. The username must be the address or its local part, so
+// another account on the same server cannot claim it.
+func checkOwner(ctx context.Context, cfg imap.Config) api.FieldErrors {
+	errs := api.FieldErrors{}
+	local, domain, ok := strings.Cut(strings.ToLower(cfg.Email), "@")
+	if !ok || local == "" || domain == "" || strings.Contains(domain, "@") {
+		errs["email"] = "must be an email address"
+		return errs
+	}
+	if username := strings.ToLower(cfg.Username); username != local && username != local+"@"+domain {
+		errs["username"] = "must be the email address or its local part"
+	}
+
+	host, _, err := net.SplitHostPort(cfg.IMAPAddr)
+	if err != nil || host == "" {
+		errs["imapAddr"] = "must be host:port"
+		return errs
+	}
+	if !serverOfDomain(ctx, strings.TrimSuffix(strings.ToLower(host), "."), domain) {
+		errs["imapAddr"] = "must be a server of the email's domain or of its mail exchangers"
+	}
+	return errs
+}
+
+func serverOfDomain(ctx context.Context, host, domain string) bool {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return false
+	}
+	if host == domain || strings.HasSuffix(host, "."+domain) {
+		return true
+	}
+	site, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return false
+	}
+	mxs, err := lookupMX(ctx, domain)
+	if err != nil {
+		return false
+	}
+	for _, mx := range mxs {
+		if mxSite, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(strings.ToLower(mx.Host), ".")); err == nil && mxSite == site {
+			return true
+		}
+	}
+	return false
+}
+
+func public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"ethan/pkg/provider/imap"
+
+	"github.com/google/uuid"
+)
+
+// fakeMX makes the mail exchangers of the domains the given ones, and other domains have none.
+func fakeMX(t *testing.T, mxs map[string][]string) {
+	lookup := lookupMX
+	t.Cleanup(func() { lookupMX = lookup })
+	lookupMX = func(_ context.Context, domain string) ([]*net.MX, error) {
+		hosts, ok := mxs[domain]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+		var ret []*net.MX
+		for _, host := range hosts {
+			ret = append(ret, &net.MX{Host: host + ".", Pref: 10})
+		}
+		return ret, nil
+	}
+}
+
+func TestCheckServers(t *testing.T) {
+	public := imap.Config{IMAPAddr: "8.8.8.8:993", SMTPAddr: "8.8.4.4:465", CalDAVURL: "https://1.1.1.1/dav/"}
+
+	tests := []struct {
+		name   string
+		change func(*imap.Config)
+		field  string
+	}{
+		{name: "public", change: func(*imap.Config) {}},
+		{name: "insecure", change: func(c *imap.Config) { c.Insecure = true }, field: "insecure"},
+		{name: "no port", change: func(c *imap.Config) { c.IMAPAddr = "8.8.8.8" }, field: "imapAddr"},
+		{name: "loopback", change: func(c *imap.Config) { c.IMAPAddr = "127.0.0.1:993" }, field: "imapAddr"},
+		{name: "localhost", change: func(c *imap.Config) { c.SMTPAddr = "localhost:25" }, field: "smtpAddr"},
+		{name: "private", change: func(c *imap.Config) { c.SMTPAddr = "10.0.0.1:587" }, field: "smtpAddr"},
+		{name: "link-local", change: func(c *imap.Config) { c.IMAPAddr = "169.254.169.254:993" }, field: "imapAddr"},
+		{name: "shared", change: func(c *imap.Config) { c.IMAPAddr = "100.64.0.1:993" }, field: "imapAddr"},
+		{name: "unspecified", change: func(c *imap.Config) { c.IMAPAddr = "0.0.0.0:993" }, field: "imapAddr"},
+		{name: "mapped loopback", change: func(c *imap.Config) { c.IMAPAddr = "[::ffff:127.0.0.1]:993" }, field: "imapAddr"},
+		{name: "ipv6 loopback", change: func(c *imap.Config) { c.IMAPAddr = "[::1]:993" }, field: "imapAddr"},
+		{name: "plain caldav", change: func(c *imap.Config) { c.CalDAVURL = "http://1.1.1.1/dav/" }, field: "caldavURL"},
+		{name: "private caldav", change: func(c *imap.Config) { c.CalDAVURL = "https://192.168.1.1/dav/" }, field: "caldavURL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := public
+			tt.change(&cfg)
+			errs := checkServers(context.Background(), cfg)
+			if tt.field == "" {
+				if len(errs) > 0 {
+					t.Fatalf("checkServers() = %v, want no errors", errs)
+				}
+				return
+			}
+			if _, ok := errs[tt.field]; !ok || len(errs) != 1 {
+				t.Fatalf("checkServers() = %v, want an error for %v", errs, tt.field)
+			}
+		})
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	fakeMX(t, map[string][]string{
+		"example.com": {"mx1.mailhost.net", "mx2.mailhost.net"},
+		"victim.com":  {"mx.victim.com"},
+	})
+
+	tests := []struct {
+		name                      string
+		email, username, imapAddr string
+		fields                    []string
+	}{
+		{name: "host of the domain", email: "jane@example.com", username: "jane", imapAddr: "mail.example.com:993"},
+		{name: "the domain", email: "Jane@Example.com", username: "jane@example.com", imapAddr: "example.com:993"},
+		{name: "site of the mail exchangers", email: "jane@example.com", username: "jane@example.com", imapAddr: "imap.mailhost.net:993"},
+		{name: "server of another domain", email: "ceo@victim.com", username: "ceo@victim.com", imapAddr: "imap.attacker.org:993", fields: []string{"imapAddr"}},
+		{name: "lookalike domain", email: "ceo@victim.com", username: "ceo", imapAddr: "mail.notvictim.com:993", fields: []string{"imapAddr"}},
+		{name: "ip address", email: "ceo@victim.com", username: "ceo", imapAddr: "203.0.113.7:993", fields: []string{"imapAddr"}},
+		{name: "no mail exchangers", email: "ceo@nomail.com", username: "ceo", imapAddr: "imap.mailhost.net:993", fields: []string{"imapAddr"}},
+		{name: "another account", email: "ceo@victim.com", username: "intern", imapAddr: "mx.victim.com:993", fields: []string{"username"}},
+		{name: "not an address", email: "victim.com", username: "ceo", imapAddr: "mx.victim.com:993", fields: []string{"email"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := checkOwner(context.Background(), imap.Config{Email: tt.email, Username: tt.username, IMAPAddr: tt.imapAddr})
+			if len(errs) != len(tt.fields) {
+				t.Fatalf("checkOwner() = %v, want errors for %v", errs, tt.fields)
+			}
+			for _, field := range tt.fields {
+				if _, ok := errs[field]; !ok {
+					t.Errorf("checkOwner() = %v, want an error for %v", errs, field)
+				}
+			}
+		})
+	}
+}
+
+// TestLinkIMAPMailboxSquatting checks that a mailbox cannot be connected with someone else's address on a server the
+// caller runs, which would accept any password and keep the owner from connecting their own mailbox.
+func TestLinkIMAPMailboxSquatting(t *testing.T) {
+	fakeMX(t, map[string][]string{"victim.com": {"mx.victim.com"}})
+	allow := allowPrivateServers
+	allowPrivateServers = true
+	t.Cleanup(func() { allowPrivateServers = allow })
+
+	// The server of the attacker, which is never connected to.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var connected atomic.Bool
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			connected.Store(true)
+			conn.Close()
+		}
+	}()
+
+	body, err := json.Marshal(imap.Config{
+		Email:    "ceo@victim.com",
+		Username: "ceo@victim.com",
+		Password: "anything",
+		IMAPAddr: "localhost:" + portOf(t, l),
+		SMTPAddr: "localhost:" + portOf(t, l),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/mailboxes/imap", bytes.NewReader(body))
+	req.Header.Set("X-User-ID", uuid.NewString())
+	rec := httptest.NewRecorder()
+	NewHandler(nil, nil).LinkIMAPMailbox(rec, req)
+
+	if rec.Code != http.StatusBadRequest || !bytes.Contains(rec.Body.Bytes(), []byte("imapAddr")) {
+		t.Errorf("LinkIMAPMailbox() = %v %s, want 400 for the server", rec.Code, rec.Body)
+	}
+	if connected.Load() {
+		t.Error("LinkIMAPMailbox() connected to the server of another domain")
+	}
+}
+
+func portOf(t *testing.T, l net.Listener) string {
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
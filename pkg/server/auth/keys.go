@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"ethan/pkg/server/api"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA key accepted for signing JWTs.
+const minRSABits = 2048
+
+// signingKey is a key JWTs are signed or verified with. Keys that are only kept to verify tokens signed before a
+// rotation, or that are not used yet, may come without their private half.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// signingKeyring holds the JWT signing keys by kid. New tokens are signed with primary, every key is published in the
+// JWKS so other services can verify tokens across a rotation.
+type signingKeyring struct {
+	primary *signingKey
+	keys    map[string]*signingKey
+}
+
+// signingKeyFile is the format of JWT_SIGNING_KEY_FILE, keys are PEM encoded.
+type signingKeyFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+var signingKeys = sync.OnceValues(loadSigningKeys)
+
+// loadSigningKeys reads the keyring from JWT_SIGNING_KEY_FILE or JWT_SIGNING_KEYS. It returns nil when neither is
+// set, in which case tokens are signed with HS256 and MICROSOFT_JWT_KEY, and fails when that is not set either.
+func loadSigningKeys() (*signingKeyring, error) {
+	k := &signingKeyring{keys: map[string]*signingKey{}}
+	var primary string
+	if file := os.Getenv("JWT_SIGNING_KEY_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var f signingKeyFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("invalid key file %v: %w", file, err)
+		}
+		for id, encoded := range f.Keys {
+			block, _ := pem.Decode([]byte(encoded))
+			if block == nil {
+				return nil, fmt.Errorf("key %v is not PEM encoded", id)
+			}
+			if err := k.add(id, block.Bytes); err != nil {
+				return nil, err
+			}
+		}
+		primary = f.Primary
+	} else if keys := os.Getenv("JWT_SIGNING_KEYS"); keys != "" {
+		// A comma separated list of kid:base64key with DER encoded keys, the first key is the primary.
+		for i, entry := range strings.Split(keys, ",") {
+			id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok || id == "" {
+				return nil, errors.New("JWT_SIGNING_KEYS must be a comma separated list of kid:base64key")
+			}
+			der, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %v: %w", id, err)
+			}
+			if err := k.add(id, der); err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				primary = id
+			}
+		}
+	} else {
+		if len(jwtKey) == 0 {
+			return nil, errors.New("neither JWT_SIGNING_KEYS, JWT_SIGNING_KEY_FILE nor MICROSOFT_JWT_KEY is set, sessions cannot be signed")
+		}
+		return nil, nil
+	}
+
+	k.primary = k.keys[primary]
+	if k.primary == nil {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+	if k.primary.private == nil {
+		return nil, fmt.Errorf("primary key %q has no private key", primary)
+	}
+	return k, nil
+}
+
+// add parses a PKCS #8 private key or a PKIX public key. Only RSA and Ed25519 keys are supported.
+func (k *signingKeyring) add(id string, der []byte) error {
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key %v", id)
+	}
+
+	key := &signingKey{id: id}
+	if private, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("key %v cannot sign", id)
+		}
+		key.private, key.public = signer, signer.Public()
+	} else if public, err := x509.ParsePKIXPublicKey(der); err == nil {
+		key.public = public
+	} else {
+		return fmt.Errorf("key %v is neither a PKCS #8 private key nor a PKIX public key", id)
+	}
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSABits {
+			return fmt.Errorf("RSA key %v must have at least %d bits", id, minRSABits)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("key %v must be an RSA or Ed25519 key", id)
+	}
+
+	k.keys[id] = key
+	return nil
+}
+
+// CheckSigningKeys loads the JWT signing keys, so a misconfigured keyring stops the server at startup rather than at
+// the first sign in.
+func CheckSigningKeys() error {
+	_, err := signingKeys()
+	return err
+}
+
+// signJWT signs claims with the primary key, or with MICROSOFT_JWT_KEY when no keyring is configured.
+func signJWT(claims jwt.Claims) (string, error) {
+	k, err := signingKeys()
+	if err != nil {
+		return "", err
+	}
+	if k == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	}
+
+	token := jwt.NewWithClaims(k.primary.method, claims)
+	token.Header["kid"] = k.primary.id
+	return token.SignedString(k.primary.private)
+}
+
+// verifyingKey returns the key to verify a token with, picked by its kid. HS256 tokens are accepted as long as
+// MICROSOFT_JWT_KEY is set, so sessions survive the switch to a keyring.
+func verifyingKey(token *jwt.Token) (interface{}, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		if len(jwtKey) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted without MICROSOFT_JWT_KEY")
+		}
+		return jwtKey, nil
+	}
+
+	k, err := signingKeys()
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Method.Alg())
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if key.method != token.Method {
+		return nil, fmt.Errorf("key %v does not sign with %v", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// validMethods are the algorithms the middleware accepts, anything else, such as "none", is rejected before
+// verifyingKey is consulted.
+var validMethods = jwt.WithValidMethods([]string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+	jwt.SigningMethodHS256.Alg(),
+})
+
+// jwk is a public key in the JSON Web Key format of RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// ServeJWKS publishes the public keys JWTs are verified with. The key set is empty when tokens are signed with
+// MICROSOFT_JWT_KEY, which cannot be shared.
+func ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	k, err := signingKeys()
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to load jwt signing keys: %w", err))
+		return
+	}
+
+	keys := []jwk{}
+	if k != nil {
+		ids := make([]string, 0, len(k.keys))
+		for id := range k.keys {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+
+		for _, id := range ids {
+			key := k.keys[id]
+			entry := jwk{Kid: id, Use: "sig", Alg: key.method.Alg()}
+			switch public := key.public.(type) {
+			case *rsa.PublicKey:
+				entry.Kty = "RSA"
+				entry.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+				entry.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+			case ed25519.PublicKey:
+				entry.Kty = "OKP"
+				entry.Crv = "Ed25519"
+				entry.X = base64.RawURLEncoding.EncodeToString(public)
+			}
+			keys = append(keys, entry)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	api.WriteJSON(w, http.StatusOK, map[string][]jwk{"keys": keys})
+}
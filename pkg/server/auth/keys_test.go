@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useSigningKeys loads the keyring from keys, in the format of JWT_SIGNING_KEYS, and sets MICROSOFT_JWT_KEY to
+// legacyKey for the rest of the test.
+func useSigningKeys(t *testing.T, keys string, legacyKey []byte) {
+	t.Helper()
+	t.Setenv("JWT_SIGNING_KEYS", keys)
+	loaded, legacy := signingKeys, jwtKey
+	signingKeys, jwtKey = sync.OnceValues(loadSigningKeys), legacyKey
+	t.Cleanup(func() { signingKeys, jwtKey = loaded, legacy })
+}
+
+// der returns the base64 DER encoding of a private key in PKCS #8, or of a public key in PKIX.
+func der(t *testing.T, key any) string {
+	t.Helper()
+	var data []byte
+	var err error
+	switch key.(type) {
+	case crypto.Signer:
+		data, err = x509.MarshalPKCS8PrivateKey(key)
+	default:
+		data, err = x509.MarshalPKIXPublicKey(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// testKeys has the current Ed25519 key "new" and the public half of the retired RSA key "old".
+type testKeys struct {
+	current ed25519.PrivateKey
+	retired *rsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T, legacyKey []byte) testKeys {
+	t.Helper()
+	_, current, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retired, err := rsa.GenerateKey(rand.Reader, minRSABits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSigningKeys(t, "new:"+der(t, current)+",old:"+der(t, &retired.PublicKey), legacyKey)
+	return testKeys{current: current, retired: retired}
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+// verify parses the token the way sessions are verified.
+func verify(token string) error {
+	_, err := jwt.Parse(token, verifyingKey, validMethods)
+	return err
+}
+
+// sign signs the claims with the method and key, under kid unless it is empty.
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, testClaims())
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestSignJWT(t *testing.T) {
+	newTestKeys(t, nil)
+
+	signed, err := signJWT(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != "new" || token.Method != jwt.SigningMethodEdDSA {
+		t.Errorf("signJWT() header = %v, want kid new with EdDSA", token.Header)
+	}
+	if err := verify(signed); err != nil {
+		t.Errorf("verifying the signed token = %v", err)
+	}
+}
+
+func TestVerifyingKey(t *testing.T) {
+	legacyKey := []byte("legacy-secret")
+	keys := newTestKeys(t, legacyKey)
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims())
+	none.Header["kid"] = "new"
+	unsigned, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&keys.retired.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"current key", sign(t, jwt.SigningMethodEdDSA, "new", keys.current), true},
+		{"retired key", sign(t, jwt.SigningMethodRS256, "old", keys.retired), true},
+		{"MICROSOFT_JWT_KEY", sign(t, jwt.SigningMethodHS256, "", legacyKey), true},
+		{"alg none", unsigned, false},
+		{"HS256 with the public key of the kid as secret", sign(t, jwt.SigningMethodHS256, "old", publicDER), false},
+		{"algorithm other than the kid's", sign(t, jwt.SigningMethodEdDSA, "old", keys.current), false},
+		{"unknown kid", sign(t, jwt.SigningMethodEdDSA, "other", other), false},
+		{"no kid", sign(t, jwt.SigningMethodEdDSA, "", keys.current), false},
+		{"key that is not in the keyring", sign(t, jwt.SigningMethodEdDSA, "new", other), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.token)
+			if tt.valid && err != nil {
+				t.Errorf("verify() = %v, want the token accepted", err)
+			} else if !tt.valid && err == nil {
+				t.Error("verify() accepted the token, want it rejected")
+			}
+		})
+	}
+
+	// Without MICROSOFT_JWT_KEY, HS256 is not accepted at all.
+	jwtKey = nil
+	if err := verify(sign(t, jwt.SigningMethodHS256, "", []byte{})); err == nil {
+		t.Error("verify() accepted an HS256 token with an empty secret")
+	}
+}
+
+func TestServeJWKS(t *testing.T) {
+	keys := newTestKeys(t, nil)
+
+	w := httptest.NewRecorder()
+	ServeJWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Fatalf("GET /.well-known/jwks.json = %v %v", w.Code, w.Header())
+	}
+	if strings.Contains(w.Body.String(), `"d"`) {
+		t.Errorf("JWKS %s has private key material", w.Body)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS = %s, want the current and the retired key", w.Body)
+	}
+
+	current := keys.current.Public().(ed25519.PublicKey)
+	want := jwk{Kty: "OKP", Kid: "new", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(current)}
+	if set.Keys[0] != want {
+		t.Errorf("JWK of the current key = %+v, want %+v", set.Keys[0], want)
+	}
+	retired := set.Keys[1]
+	n, err := base64.RawURLEncoding.DecodeString(retired.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(retired.E)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if retired.Kty != "RSA" || retired.Kid != "old" || retired.Alg != "RS256" || !public.Equal(&keys.retired.PublicKey) {
+		t.Errorf("JWK of the retired key = %+v, want its RSA public key", retired)
+	}
+
+	// The key MICROSOFT_JWT_KEY signs with is never published.
+	useSigningKeys(t, "", []byte("legacy-secret"))
+	w = httptest.NewRecorder()
+	ServeJWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"keys":[]}` {
+		t.Errorf("JWKS without a keyring = %v %s, want no keys", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/secret"
+	"ethan/pkg/server/api"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+var errMailboxTaken = errors.New("the mailbox is connected to another account")
+
+// mailbox is the credential of a mailbox that is connected to a user.
+type mailbox struct {
+	provider     string
+	email        string
+	token        string
+	refreshToken *string
+	expireAt     pgtype.Timestamptz
+}
+
+func oauthMailbox(providerName, email string, token *oauth2.Token) mailbox {
+	return mailbox{
+		provider:     providerName,
+		email:        email,
+		token:        token.AccessToken,
+		refreshToken: &token.RefreshToken,
+		expireAt:     pgtype.Timestamptz{Time: token.Expiry, Valid: !token.Expiry.IsZero()},
+	}
+}
+
+// linkMailbox connects the mailbox to the user, or updates its credentials if it is connected already. A mailbox that
+// belongs to another account is refused with errMailboxTaken, so its mail is not processed twice.
+func (h *Handler) linkMailbox(ctx context.Context, uid pgtype.UUID, m mailbox) (db.MailboxConnection, error) {
+	conn, err := h.queries.CreateMailboxConnection(ctx, db.CreateMailboxConnectionParams{
+		UserID:       uid,
+		Provider:     m.provider,
+		Email:        m.email,
+		Token:        secret.String(m.token),
+		RefreshToken: (*secret.String)(m.refreshToken),
+		ExpireAt:     m.expireAt,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The upsert skips mailboxes of other users.
+		return db.MailboxConnection{}, errMailboxTaken
+	} else if err != nil {
+		return db.MailboxConnection{}, fmt.Errorf("failed to connect mailbox: %w", err)
+	}
+	// Mailboxes of principals the user is a delegate for work with a copy of this token.
+	if err := h.queries.UpdateDelegatedMailboxConnectionTokens(ctx, db.UpdateDelegatedMailboxConnectionTokensParams{
+		CredentialID: conn.ID,
+		Token:        conn.Token,
+		ExpireAt:     conn.ExpireAt,
+	}); err != nil {
+		return db.MailboxConnection{}, fmt.Errorf("failed to update delegated mailboxes: %w", err)
+	}
+
+	if conn.CheckSpam {
+		h.ensureColdEmailFolder(ctx, conn)
+	}
+	return conn, nil
+}
+
+// ensureColdEmailFolder creates the folder cold emails are moved to. Failing to create it is logged, the spam check
+// reports the error again when it moves a message.
+func (h *Handler) ensureColdEmailFolder(ctx context.Context, conn db.MailboxConnection) {
+	p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
+	if err == nil {
+		err = p.EnsureFolder(ctx, provider.FolderColdEmails)
+	}
+	if err != nil {
+		logrus.Error(fmt.Errorf("failed to create cold email folder for mailbox %v: %w", *api.ID(conn.ID), err))
+	}
+}
+
+func mailboxError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errMailboxTaken) {
+		api.Conflict(w, err.Error())
+		return
+	}
+	api.InternalError(w, err)
+}
+
+func (h *Handler) ListMailboxes(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+
+	mailboxes, err := h.mailboxes(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "mailboxes")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, mailboxes)
+}
+
+// mailboxes returns the mailboxes of the user with the health of their subscriptions.
+func (h *Handler) mailboxes(ctx context.Context, uid pgtype.UUID) ([]api.Mailbox, error) {
+	connections, err := h.queries.ListMailboxConnectionsForUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions, err := h.queries.ListSubscriptionsForUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewMailboxes(connections, subscriptions), nil
+}
+
+// UpdateMailbox changes the settings of a mailbox. Settings that are left out keep their current value.
+func (h *Handler) UpdateMailbox(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	var req api.MailboxSettingsRequest
+	if !api.Decode(w, r, &req) {
+		return
+	}
+
+	conn, err := h.queries.GetMailboxConnectionForUser(r.Context(), db.GetMailboxConnectionForUserParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+	if conn, err = h.updateMailboxSettings(r.Context(), conn, req); err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+
+	subscriptions, err := h.queries.ListSubscriptionsForConnection(r.Context(), conn.ID)
+	if err != nil {
+		api.DBError(w, err, "subscriptions")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewMailbox(conn, subscriptions))
+}
+
+func (h *Handler) updateMailboxSettings(ctx context.Context, conn db.MailboxConnection, req api.MailboxSettingsRequest) (db.MailboxConnection, error) {
+	params := db.UpdateMailboxConnectionSettingsParams{
+		ID:                   conn.ID,
+		UserID:               conn.UserID,
+		SubscriptionDisabled: conn.SubscriptionDisabled,
+		CheckSpam:            conn.CheckSpam,
+	}
+	if req.SubscriptionDisabled != nil {
+		params.SubscriptionDisabled = *req.SubscriptionDisabled
+	}
+	if req.CheckSpam != nil {
+		params.CheckSpam = *req.CheckSpam
+	}
+
+	updated, err := h.queries.UpdateMailboxConnectionSettings(ctx, params)
+	if err != nil {
+		return db.MailboxConnection{}, err
+	}
+	if updated.CheckSpam && !conn.CheckSpam {
+		h.ensureColdEmailFolder(ctx, updated)
+	}
+	return updated, nil
+}
+
+// DeleteMailbox disconnects a mailbox. Its spam records and queued messages are removed, its tasks are kept without a
+// mailbox. Graph subscriptions of the mailbox are not deleted, they expire within a day and their notifications are
+// dropped because they no longer match a mailbox. Disconnecting the mailbox of a principal gives up the delegation.
+func (h *Handler) DeleteMailbox(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+
+	conn, err := h.queries.GetMailboxConnectionForUser(r.Context(), db.GetMailboxConnectionForUserParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+	if conn.DelegationID.Valid {
+		// The mailbox is removed with the grant.
+		if _, err := h.queries.DeleteDelegationGrant(r.Context(), db.DeleteDelegationGrantParams{
+			ID:     conn.DelegationID,
+			UserID: uid,
+		}); err != nil {
+			api.DBError(w, err, "delegation")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	n, err := h.queries.DeleteMailboxConnection(r.Context(), db.DeleteMailboxConnectionParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "mailbox")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -6,38 +6,36 @@ import (
 	"strings"
 
 	"ethan/pkg/db"
+	"ethan/pkg/server/api"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-func Middleware(next http.HandlerFunc) http.HandlerFunc {
+// Middleware authenticates the request with the session JWT or a personal API token and passes the user on to next
+// in the X-User-* headers. Requests made with an API token also carry its ID in X-Token-ID, requests made in a
+// session the session's ID in X-Session-ID.
+func (h *Handler) Middleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tokenStr := getTokenFromRequest(r)
-		if tokenStr == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		var claims jwt.MapClaims
-		token, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		r.Header.Del("X-Token-ID")
+		r.Header.Del("X-Session-ID")
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		var (
+			user db.User
+			ok   bool
+		)
+		if tokenStr := getTokenFromRequest(r); isAPIToken(tokenStr) {
+			user, ok = h.apiTokenUser(w, r, tokenStr)
+		} else {
+			user, ok = h.sessionUser(w, r, tokenStr)
 		}
-
-		user, err := getUserFromTokenClaims(claims)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if !ok {
 			return
 		}
 
 		// Set custom headers
 		v, err := user.ID.Value()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 		r.Header.Set("X-User-ID", v.(string))
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// oidcStatePrefix marks the oauth_states of OIDC logins, the provider ID follows it.
+const oidcStatePrefix = "oidc:"
+
+var (
+	oidcProviderID = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+	errEmailNotVerified = errors.New("the identity provider did not verify the email address")
+
+	oidcProviders = sync.OnceValues(loadOIDCProviders)
+)
+
+// oidcProvider is an OpenID Connect identity provider users can sign in with. It only establishes who the user is,
+// mailboxes are connected separately. Its endpoints are discovered from the issuer on first use, so the server starts
+// while the provider is unreachable.
+type oidcProvider struct {
+	id           string
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+
+	lock     sync.Mutex
+	provider *oidc.Provider
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS. Each one is configured with OIDC_{ID}_ISSUER,
+// OIDC_{ID}_CLIENT_ID, OIDC_{ID}_CLIENT_SECRET, and optionally OIDC_{ID}_NAME and OIDC_{ID}_SCOPES.
+func loadOIDCProviders() ([]*oidcProvider, error) {
+	var providers []*oidcProvider
+	for _, id := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !oidcProviderID.MatchString(id) {
+			return nil, fmt.Errorf("invalid OIDC provider %q, use lowercase letters, digits and dashes", id)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		p := &oidcProvider{
+			id:           id,
+			name:         os.Getenv(prefix + "NAME"),
+			issuer:       os.Getenv(prefix + "ISSUER"),
+			clientID:     os.Getenv(prefix + "CLIENT_ID"),
+			clientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if p.issuer == "" || p.clientID == "" {
+			return nil, fmt.Errorf("OIDC provider %v needs %vISSUER and %vCLIENT_ID", id, prefix, prefix)
+		}
+		if p.name == "" {
+			p.name = id
+		}
+		if len(p.scopes) == 0 {
+			p.scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// discover returns the provider's configuration, fetching it from the issuer the first time.
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.provider == nil {
+		// The provider keeps the context to fetch signing keys later, so it must outlive the request.
+		provider, err := oidc.NewProvider(context.WithoutCancel(ctx), p.issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover OIDC provider %v: %w", p.id, err)
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+// CheckOIDCProviders loads the OIDC provider settings, so a misconfigured provider stops the server at startup.
+func CheckOIDCProviders() error {
+	_, err := oidcProviders()
+	return err
+}
+
+func (p *oidcProvider) config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  fmt.Sprintf("%v/api/auth/oidc/%v/callback", getPublicURL(), p.id),
+		Scopes:       p.scopes,
+		Endpoint:     provider.Endpoint(),
+	}
+}
+
+// oidcClaims are the claims of an ID token used to find or create the user.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+func findOIDCProvider(w http.ResponseWriter, r *http.Request) (*oidcProvider, bool) {
+	providers, err := oidcProviders()
+	if err != nil {
+		api.InternalError(w, err)
+		return nil, false
+	}
+	id := mux.Vars(r)["provider"]
+	for _, p := range providers {
+		if p.id == id {
+			return p, true
+		}
+	}
+	api.NotFound(w, "identity provider")
+	return nil, false
+}
+
+// ListLoginProviders lists the ways to sign in, for the login page.
+func (h *Handler) ListLoginProviders(w http.ResponseWriter, r *http.Request) {
+	oidcProviders, err := oidcProviders()
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	providers := []api.LoginProvider{}
+	if oauthConfig.ClientID != "" {
+		providers = append(providers, api.LoginProvider{
+			ID:       "microsoft",
+			Name:     "Microsoft",
+			LoginURL: "/api/login",
+		})
+	}
+	for _, p := range oidcProviders {
+		providers = append(providers, api.LoginProvider{
+			ID:       p.id,
+			Name:     p.name,
+			LoginURL: "/api/login/oidc/" + p.id,
+		})
+	}
+	api.WriteJSON(w, http.StatusOK, providers)
+}
+
+// HandleOIDCLogin redirects to the identity provider. The login is bound to its state with a PKCE verifier and a
+// nonce, which the callback checks.
+func (h *Handler) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := findOIDCProvider(w, r)
+	if !ok {
+		return
+	}
+	provider, err := p.discover(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	state, err := generateState()
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to generate oauth state: %w", err))
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to generate nonce: %w", err))
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+	providerState := oidcStatePrefix + p.id
+	if err := h.states.Add(r.Context(), db.CreateOAuthStateParams{
+		State:        state,
+		Provider:     &providerState,
+		CodeVerifier: &verifier,
+		Nonce:        &nonce,
+	}); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to store oauth state: %w", err))
+		return
+	}
+
+	url := p.config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+// HandleOIDCCallback verifies the ID token of a login and signs the user in.
+func (h *Handler) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := findOIDCProvider(w, r)
+	if !ok {
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		api.BadRequest(w, fmt.Sprintf("sign in failed: %v %v", e, r.FormValue("error_description")), nil)
+		return
+	}
+
+	login, err := h.states.Consume(r.Context(), r.FormValue("state"))
+	if err == nil && (login.Provider == nil || *login.Provider != oidcStatePrefix+p.id || login.CodeVerifier == nil || login.Nonce == nil) {
+		err = errInvalidState
+	}
+	if errors.Is(err, errInvalidState) {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	} else if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to validate oauth state: %w", err))
+		return
+	}
+
+	provider, err := p.discover(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	token, err := p.config(provider).Exchange(r.Context(), r.FormValue("code"), oauth2.VerifierOption(*login.CodeVerifier))
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("code exchange failed: %w", err))
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		api.InternalError(w, fmt.Errorf("the token response of %v has no id_token", p.id))
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.clientID}).Verify(r.Context(), rawIDToken)
+	if err != nil {
+		api.WriteError(w, http.StatusUnauthorized, api.CodeUnauthorized, "invalid id token", nil)
+		logrus.Error(fmt.Errorf("failed to verify id token of %v: %w", p.id, err))
+		return
+	}
+	if idToken.Nonce != *login.Nonce {
+		api.WriteError(w, http.StatusUnauthorized, api.CodeUnauthorized, "invalid id token nonce", nil)
+		return
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to read id token claims: %w", err))
+		return
+	}
+
+	user, err := h.oidcUser(r.Context(), idToken, claims)
+	if errors.Is(err, errEmailNotVerified) {
+		api.Forbidden(w, err.Error())
+		return
+	} else if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := h.startSession(w, r, user); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+}
+
+// oidcUser returns the user of an identity. An identity seen for the first time needs an email the provider verified,
+// it is linked to the user with that email, or else a new user without a mailbox is created.
+func (h *Handler) oidcUser(ctx context.Context, idToken *oidc.IDToken, claims oidcClaims) (db.User, error) {
+	email := claims.Email
+
+	identity, err := h.queries.GetUserIdentity(ctx, db.GetUserIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	})
+	if err == nil {
+		if err := h.queries.TouchUserIdentity(ctx, db.TouchUserIdentityParams{
+			Issuer:  idToken.Issuer,
+			Subject: idToken.Subject,
+			Email:   &email,
+		}); err != nil {
+			return db.User{}, fmt.Errorf("failed to update identity: %w", err)
+		}
+		return h.queries.GetUser(ctx, identity.UserID)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return db.User{}, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	if email == "" {
+		return db.User{}, errors.New("the id token has no email claim")
+	}
+	if claims.EmailVerified == nil || !*claims.EmailVerified {
+		return db.User{}, errEmailNotVerified
+	}
+	user, err := h.queries.GetUserFromEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		name := claims.Name
+		if name == "" {
+			name = email
+		}
+		user, err = h.queries.CreateUser(ctx, db.CreateUserParams{
+			Name:  name,
+			Email: email,
+		})
+		if err != nil {
+			return db.User{}, fmt.Errorf("failed to create user: %w", err)
+		}
+		logrus.Info("User created")
+	} else if err != nil {
+		return db.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := h.queries.CreateUserIdentity(ctx, db.CreateUserIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		UserID:  user.ID,
+		Email:   &email,
+	}); err != nil {
+		return db.User{}, fmt.Errorf("failed to create identity: %w", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ethan/pkg/graphfake"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+func TestLoadOIDCProviders(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", "fake, corp-sso")
+	t.Setenv("OIDC_FAKE_ISSUER", "https://fake.example.com")
+	t.Setenv("OIDC_FAKE_CLIENT_ID", "client")
+	t.Setenv("OIDC_CORP_SSO_NAME", "Corp")
+	t.Setenv("OIDC_CORP_SSO_ISSUER", "https://sso.example.com")
+	t.Setenv("OIDC_CORP_SSO_CLIENT_ID", "corp")
+	t.Setenv("OIDC_CORP_SSO_SCOPES", "openid email")
+
+	providers, err := loadOIDCProviders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 2 {
+		t.Fatalf("loadOIDCProviders() = %d providers, want 2", len(providers))
+	}
+	if p := providers[0]; p.name != "fake" || len(p.scopes) != 3 {
+		t.Errorf("provider without a name or scopes = %v %v, want the ID and openid profile email", p.name, p.scopes)
+	}
+	if p := providers[1]; p.id != "corp-sso" || p.name != "Corp" || p.clientID != "corp" || len(p.scopes) != 2 {
+		t.Errorf("provider corp-sso = %+v", p)
+	}
+
+	for name, env := range map[string][2]string{
+		"an invalid ID":    {"OIDC_PROVIDERS", "Fake"},
+		"a missing issuer": {"OIDC_FAKE_ISSUER", ""},
+		"a missing client": {"OIDC_FAKE_CLIENT_ID", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("OIDC_PROVIDERS", "fake")
+			t.Setenv(env[0], env[1])
+			if _, err := loadOIDCProviders(); err == nil {
+				t.Errorf("loadOIDCProviders() with %v succeeded, want an error", name)
+			}
+		})
+	}
+}
+
+// TestOIDCExchange runs the part of a login between the server and graphfake: discovery, the code exchange with the
+// PKCE verifier, and the checks on the ID token.
+func TestOIDCExchange(t *testing.T) {
+	fake := graphfake.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	fake.AddMailbox(graphfake.Mailbox{User: graphfake.User{Mail: "verified@example.com"}})
+	fake.AddMailbox(graphfake.Mailbox{User: graphfake.User{Mail: "unverified@example.com"}, EmailUnverified: true})
+
+	ctx := context.Background()
+	p := &oidcProvider{id: "fake", issuer: srv.URL + "/common/v2.0", clientID: "client", scopes: []string{oidc.ScopeOpenID, "email"}}
+	provider, err := p.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := p.discover(ctx); err != nil || again != provider {
+		t.Errorf("discover() again = %v, want the provider it discovered", err)
+	}
+	if endpoint := provider.Endpoint(); endpoint.AuthURL != srv.URL+"/common/oauth2/v2.0/authorize" || endpoint.TokenURL != srv.URL+"/common/oauth2/v2.0/token" {
+		t.Errorf("endpoint = %+v, want the ones of the tenant", endpoint)
+	}
+	config := p.config(provider)
+
+	// code authorizes the login as the mailbox, with the challenge of verifier and the nonce.
+	code := func(email, verifier, nonce string) string {
+		t.Helper()
+		client := srv.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		resp, err := client.Get(config.AuthCodeURL("state", oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("login_hint", email)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			t.Fatalf("authorize = %v: %v", resp.StatusCode, err)
+		}
+		return location.Query().Get("code")
+	}
+	// exchange exchanges the code with the verifier and returns the verified ID token.
+	exchange := func(code, verifier string) (*oidc.IDToken, error) {
+		token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+		if err != nil {
+			return nil, err
+		}
+		rawIDToken, _ := token.Extra("id_token").(string)
+		return provider.Verifier(&oidc.Config{ClientID: p.clientID}).Verify(ctx, rawIDToken)
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	idToken, err := exchange(code("verified@example.com", verifier, "nonce"), verifier)
+	if err != nil {
+		t.Fatalf("exchanging the code = %v", err)
+	}
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Nonce != "nonce" || claims.Email != "verified@example.com" || claims.EmailVerified == nil || !*claims.EmailVerified {
+		t.Errorf("ID token nonce %q, claims %+v, want the nonce and the verified email", idToken.Nonce, claims)
+	}
+
+	if _, err := exchange(code("verified@example.com", verifier, "nonce"), oauth2.GenerateVerifier()); err == nil {
+		t.Error("exchanging a code with another verifier succeeded")
+	}
+
+	idToken, err = exchange(code("unverified@example.com", verifier, "nonce"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims = oidcClaims{}
+	if err := idToken.Claims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.EmailVerified == nil || *claims.EmailVerified {
+		t.Errorf("claims of an unverified email = %+v, want email_verified false", claims)
+	}
+}
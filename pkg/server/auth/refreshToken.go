@@ -8,11 +8,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
 	"ethan/pkg/db"
+	"ethan/pkg/secret"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -25,17 +25,19 @@ func RefreshToken(ctx context.Context, queries *db.Queries) {
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			users, err := queries.ListUsers(ctx)
+			connections, err := queries.ListMailboxConnections(ctx)
 			if err != nil {
 				logrus.Error(err)
 				continue
 			}
-			for _, user := range users {
-				if user.ExpireAt.Valid && user.ExpireAt.Time.After(time.Now()) {
+			for _, conn := range connections {
+				if conn.ExpireAt.Valid && conn.ExpireAt.Time.After(time.Now()) {
 					continue
-				} else if user.RefreshToken != nil {
-					token, err := refreshToken(ctx, *user.RefreshToken)
+				} else if conn.RefreshToken != nil {
+					token, err := refreshToken(ctx, string(*conn.RefreshToken))
 					if err != nil {
 						logrus.Error(err)
 						continue
@@ -50,20 +52,24 @@ func RefreshToken(ctx context.Context, queries *db.Queries) {
 						logrus.Error(err)
 						continue
 					}
-					if err := queries.UpdateUser(ctx, db.UpdateUserParams{
-						ID:                   user.ID,
-						Token:                token.AccessToken,
-						RefreshToken:         &token.RefreshToken,
-						ExpireAt:             t,
-						SubscriptionID:       user.SubscriptionID,
-						SubscriptionExpireAt: user.SubscriptionExpireAt,
-						SubscriptionDisabled: user.SubscriptionDisabled,
-						CheckSpam:            user.CheckSpam,
+					if err := queries.UpdateMailboxConnectionToken(ctx, db.UpdateMailboxConnectionTokenParams{
+						ID:           conn.ID,
+						Token:        secret.String(token.AccessToken),
+						RefreshToken: (*secret.String)(&token.RefreshToken),
+						ExpireAt:     t,
 					}); err != nil {
-						logrus.Error(fmt.Errorf("failed to update user after token refresh: %w", err))
+						logrus.Error(fmt.Errorf("failed to update mailbox after token refresh: %w", err))
 						continue
 					}
-					logrus.Infof("User %v updated, token refreshed at %v", uuid.UUID(user.ID.Bytes).String(), time.Now())
+					if err := queries.UpdateDelegatedMailboxConnectionTokens(ctx, db.UpdateDelegatedMailboxConnectionTokensParams{
+						CredentialID: conn.ID,
+						Token:        secret.String(token.AccessToken),
+						ExpireAt:     t,
+					}); err != nil {
+						logrus.Error(fmt.Errorf("failed to update delegated mailboxes after token refresh: %w", err))
+						continue
+					}
+					logrus.Infof("Mailbox %v updated, token refreshed at %v", uuid.UUID(conn.ID.Bytes).String(), time.Now())
 				}
 			}
 		}
@@ -77,7 +83,7 @@ type TokenResponse struct {
 }
 
 func refreshToken(ctx context.Context, refreshToken string) (TokenResponse, error) {
-	tokenEndpoint := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", os.Getenv("MICROSOFT_TENANT_ID"))
+	tokenEndpoint := oauthConfig.Endpoint.TokenURL
 
 	data := url.Values{}
 	data.Set("client_id", oauthConfig.ClientID)
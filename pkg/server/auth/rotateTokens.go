@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"ethan/pkg/db"
+	"ethan/pkg/secret"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// RotateTokens re-encrypts the tokens of every mailbox that are not encrypted with the primary key, which covers tokens
+// stored in plain text before encryption was enabled and tokens under a key that was rotated out. It must not run
+// at the same time as RefreshToken, or a refreshed token could be overwritten.
+func RotateTokens(ctx context.Context, queries *db.Queries) error {
+	rows, err := queries.ListMailboxConnectionRawTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		rotateToken, err := secret.NeedsRotation(row.RawToken)
+		if err != nil {
+			return err
+		}
+		rotateRefreshToken, err := secret.NeedsRotation(row.RawRefreshToken)
+		if err != nil {
+			return err
+		}
+		if !rotateToken && (row.RawRefreshToken == "" || !rotateRefreshToken) {
+			continue
+		}
+
+		conn, err := queries.GetMailboxConnection(ctx, row.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get mailbox %v: %w", uuid.UUID(row.ID.Bytes).String(), err)
+		}
+		if err := queries.UpdateMailboxConnectionTokens(ctx, db.UpdateMailboxConnectionTokensParams{
+			ID:           conn.ID,
+			Token:        conn.Token,
+			RefreshToken: conn.RefreshToken,
+		}); err != nil {
+			return fmt.Errorf("failed to update tokens of mailbox %v: %w", uuid.UUID(row.ID.Bytes).String(), err)
+		}
+		logrus.Infof("Re-encrypted tokens of mailbox %v", uuid.UUID(row.ID.Bytes).String())
+	}
+	return nil
+}
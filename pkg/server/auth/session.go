@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RefreshTokenName = "refresh-token"
+
+	// accessTokenTTL is how long a JWT is accepted. It is short because revoking a session only takes effect on the
+	// next refresh for clients that skip the session check.
+	accessTokenTTL = 15 * time.Minute
+	// sessionTTL is how long a session lasts without being refreshed.
+	sessionTTL = 30 * 24 * time.Hour
+	// refreshGrace is how long the refresh token a session was rotated away from stays usable, so the parallel
+	// requests a page makes when its access token expires do not trip reuse detection.
+	refreshGrace = 30 * time.Second
+)
+
+var errInvalidSession = errors.New("invalid or revoked session")
+
+// startSession signs the user in on a new session and sets the session cookies.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user db.User) error {
+	if err := h.queries.DeleteExpiredSessions(r.Context()); err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	var userAgent *string
+	if ua := r.UserAgent(); ua != "" {
+		userAgent = &ua
+	}
+	session, err := h.queries.CreateSession(r.Context(), db.CreateSessionParams{
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(refreshToken),
+		UserAgent:        userAgent,
+		ExpireAt:         pgtype.Timestamptz{Time: time.Now().Add(sessionTTL), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return setSessionCookies(w, user, session, refreshToken)
+}
+
+// setSessionCookies sets a new JWT for the session, and the refresh token when it was rotated.
+func setSessionCookies(w http.ResponseWriter, user db.User, session db.Session, refreshToken string) error {
+	jwtToken, err := createJWT(user, session.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create jwt token: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     JwtTokenName,
+		Value:    jwtToken,
+		Expires:  time.Now().Add(accessTokenTTL),
+		SameSite: http.SameSiteDefaultMode,
+		Path:     "/",
+	})
+	if refreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     RefreshTokenName,
+			Value:    refreshToken,
+			Expires:  session.ExpireAt.Time,
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(getPublicURL(), "https://"),
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/api",
+		})
+	}
+	return nil
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: JwtTokenName, Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: RefreshTokenName, Path: "/api", MaxAge: -1})
+}
+
+// verifyJWT returns the user and session of a JWT. It fails with errInvalidSession when the token is invalid or
+// expired, or its session was revoked.
+func (h *Handler) verifyJWT(ctx context.Context, tokenStr string, opts ...jwt.ParserOption) (db.User, pgtype.UUID, error) {
+	var claims jwt.MapClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &claims, verifyingKey, append(opts, validMethods)...)
+	if err != nil || !token.Valid {
+		return db.User{}, pgtype.UUID{}, errInvalidSession
+	}
+
+	user, err := getUserFromTokenClaims(claims)
+	if err != nil {
+		return db.User{}, pgtype.UUID{}, errInvalidSession
+	}
+	var sid pgtype.UUID
+	if s, ok := claims["sid"].(string); !ok || sid.Scan(s) != nil {
+		return db.User{}, pgtype.UUID{}, errInvalidSession
+	}
+
+	if _, err := h.queries.GetActiveSession(ctx, db.GetActiveSessionParams{
+		ID:     sid,
+		UserID: user.ID,
+	}); errors.Is(err, pgx.ErrNoRows) {
+		return db.User{}, pgtype.UUID{}, errInvalidSession
+	} else if err != nil {
+		return db.User{}, pgtype.UUID{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	return user, sid, nil
+}
+
+// refresh rotates the refresh token of a session and sets new session cookies. A refresh token that was already
+// rotated away from is only accepted within refreshGrace, later it means the token was stolen and the session is
+// revoked.
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request, refreshToken string) (db.User, pgtype.UUID, error) {
+	newRefreshToken, err := randomToken()
+	if err != nil {
+		return db.User{}, pgtype.UUID{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	session, err := h.queries.RotateSession(r.Context(), db.RotateSessionParams{
+		NewHash:  hashToken(newRefreshToken),
+		ExpireAt: pgtype.Timestamptz{Time: time.Now().Add(sessionTTL), Valid: true},
+		OldHash:  hashToken(refreshToken),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		newRefreshToken = ""
+		session, err = h.queries.GetSessionByPreviousRefreshToken(r.Context(), hashToken(refreshToken))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.User{}, pgtype.UUID{}, errInvalidSession
+		} else if err != nil {
+			return db.User{}, pgtype.UUID{}, fmt.Errorf("failed to get session: %w", err)
+		}
+
+		if session.RevokedAt.Valid || session.ExpireAt.Time.Before(time.Now()) {
+			return db.User{}, pgtype.UUID{}, errInvalidSession
+		}
+		if time.Since(session.RefreshedAt.Time) > refreshGrace {
+			logrus.Warnf("Refresh token of session %v was reused, revoking the session", *api.ID(session.ID))
+			if _, err := h.queries.RevokeSession(r.Context(), db.RevokeSessionParams{
+				ID:     session.ID,
+				UserID: session.UserID,
+			}); err != nil {
+				return db.User{}, pgtype.UUID{}, fmt.Errorf("failed to revoke session: %w", err)
+			}
+			return db.User{}, pgtype.UUID{}, errInvalidSession
+		}
+	} else if err != nil {
+		return db.User{}, pgtype.UUID{}, fmt.Errorf("failed to rotate session: %w", err)
+	}
+
+	user, err := h.queries.GetUser(r.Context(), session.UserID)
+	if err != nil {
+		return db.User{}, pgtype.UUID{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	if err := setSessionCookies(w, user, session, newRefreshToken); err != nil {
+		return db.User{}, pgtype.UUID{}, err
+	}
+	return user, session.ID, nil
+}
+
+// sessionUser returns the user of a session JWT. Browsers whose JWT expired get a new one from their refresh token
+// cookie, except on websocket upgrades, which cannot set cookies.
+func (h *Handler) sessionUser(w http.ResponseWriter, r *http.Request, tokenStr string) (db.User, bool) {
+	user, sid, err := h.verifyJWT(r.Context(), tokenStr)
+	if errors.Is(err, errInvalidSession) && r.Header.Get("Upgrade") == "" {
+		if cookie, cookieErr := r.Cookie(RefreshTokenName); cookieErr == nil {
+			user, sid, err = h.refresh(w, r, cookie.Value)
+		}
+	}
+	if errors.Is(err, errInvalidSession) {
+		api.Unauthorized(w)
+		return db.User{}, false
+	} else if err != nil {
+		api.InternalError(w, err)
+		return db.User{}, false
+	}
+
+	r.Header.Set("X-Session-ID", *api.ID(sid))
+	return user, true
+}
+
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(RefreshTokenName)
+	if err != nil {
+		api.Unauthorized(w)
+		return
+	}
+
+	if _, _, err := h.refresh(w, r, cookie.Value); errors.Is(err, errInvalidSession) {
+		clearSessionCookies(w)
+		api.Unauthorized(w)
+		return
+	} else if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Logout revokes the session of the request and clears its cookies. It works with an expired JWT, and answers with
+// a 204 even when there is no session to revoke.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(RefreshTokenName); err == nil {
+		if err := h.queries.RevokeSessionByRefreshToken(r.Context(), hashToken(cookie.Value)); err != nil {
+			api.InternalError(w, fmt.Errorf("failed to revoke session: %w", err))
+			return
+		}
+	}
+
+	if tokenStr := getTokenFromRequest(r); tokenStr != "" && !isAPIToken(tokenStr) {
+		user, sid, err := h.verifyJWT(r.Context(), tokenStr, jwt.WithoutClaimsValidation())
+		if err != nil && !errors.Is(err, errInvalidSession) {
+			api.InternalError(w, err)
+			return
+		} else if err == nil {
+			if _, err := h.queries.RevokeSession(r.Context(), db.RevokeSessionParams{
+				ID:     sid,
+				UserID: user.ID,
+			}); err != nil {
+				api.InternalError(w, fmt.Errorf("failed to revoke session: %w", err))
+				return
+			}
+		}
+	}
+
+	clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll revokes every session of the user and forgets the Graph refresh tokens of their mailboxes, so the server
+// stops acting on them once the current access tokens expire. Signing in again restores the session, and connecting a
+// mailbox again restores its refresh token.
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+
+	if err := h.queries.RevokeSessionsForUser(r.Context(), uid); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to revoke sessions: %w", err))
+		return
+	}
+	if err := h.queries.ClearMailboxConnectionRefreshTokens(r.Context(), uid); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to clear refresh tokens: %w", err))
+		return
+	}
+
+	clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+
+	sessions, err := h.queries.ListActiveSessionsForUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "sessions")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewSessions(sessions, r.Header.Get("X-Session-ID")))
+}
+
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+
+	n, err := h.queries.RevokeSession(r.Context(), db.RevokeSessionParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "session")
+		return
+	} else if n == 0 {
+		api.NotFound(w, "session")
+		return
+	}
+
+	if *api.ID(id) == r.Header.Get("X-Session-ID") {
+		clearSessionCookies(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
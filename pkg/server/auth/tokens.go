@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+// apiTokenPrefix starts every personal API token, so the middleware can tell them apart from JWTs.
+const apiTokenPrefix = "cpat_"
+
+// randomToken returns 32 random bytes encoded for use in headers and cookies.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func generateAPIToken() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + token, nil
+}
+
+// hashToken returns the hash API and refresh tokens are stored and looked up by. Tokens are random, so a plain
+// SHA-256 is enough to keep a leaked table from being usable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requiredScope returns the scope an API token needs for the request. Opening a task run is a GET, but it lets the
+// client chat with the assistant, so it needs the write scope like any other change.
+func requiredScope(r *http.Request) string {
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.Header.Get("Upgrade") == "" {
+		return api.ScopeRead
+	}
+	return api.ScopeWrite
+}
+
+func hasScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope) || (scope == api.ScopeRead && slices.Contains(scopes, api.ScopeWrite))
+}
+
+// apiTokenUser returns the user of a personal API token and records that the token was used. It answers with a 401
+// for unknown and expired tokens, and a 403 when the token lacks the scope the request needs.
+func (h *Handler) apiTokenUser(w http.ResponseWriter, r *http.Request, token string) (db.User, bool) {
+	row, err := h.queries.GetAPITokenUser(r.Context(), hashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.Unauthorized(w)
+		return db.User{}, false
+	} else if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to look up api token: %w", err))
+		return db.User{}, false
+	}
+
+	if scope := requiredScope(r); !hasScope(row.Scopes, scope) {
+		api.Forbidden(w, fmt.Sprintf("the API token lacks the %v scope", scope))
+		return db.User{}, false
+	}
+
+	if err := h.queries.TouchAPIToken(r.Context(), row.TokenID); err != nil {
+		logrus.Error(fmt.Errorf("failed to record use of api token: %w", err))
+	}
+	r.Header.Set("X-Token-ID", *api.ID(row.TokenID))
+
+	return db.User{
+		ID:    row.ID,
+		Name:  row.Name,
+		Email: row.Email,
+	}, true
+}
+
+// sessionOnly answers with a 403 when the request was authenticated with an API token, so a leaked token cannot be
+// used to mint new ones or to manage sessions.
+func sessionOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("X-Token-ID") != "" {
+		api.Forbidden(w, "API tokens cannot make this request, sign in instead")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+
+	tokens, err := h.queries.ListAPITokensForUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "api tokens")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewAPITokens(tokens))
+}
+
+func (h *Handler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+
+	var req api.APITokenRequest
+	if !api.DecodeValid(w, r, &req) {
+		return
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to generate api token: %w", err))
+		return
+	}
+
+	slices.Sort(req.Scopes)
+	created, err := h.queries.CreateAPIToken(r.Context(), db.CreateAPITokenParams{
+		UserID:    uid,
+		Name:      req.Name,
+		TokenHash: hashToken(token),
+		Scopes:    slices.Compact(req.Scopes),
+		ExpireAt: pgtype.Timestamptz{
+			Time:  time.Now().AddDate(0, 0, *req.ExpiresInDays),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		api.DBError(w, err, "api token")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusCreated, api.CreatedAPIToken{
+		APIToken: api.NewAPIToken(created),
+		Token:    token,
+	})
+}
+
+func (h *Handler) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok || !sessionOnly(w, r) {
+		return
+	}
+
+	n, err := h.queries.DeleteAPIToken(r.Context(), db.DeleteAPITokenParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "api token")
+		return
+	} else if n == 0 {
+		api.NotFound(w, "api token")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isAPIToken reports whether the bearer token is a personal API token rather than a JWT.
+func isAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ethan/pkg/server/api"
+)
+
+func TestRequiredScope(t *testing.T) {
+	upgrade := httptest.NewRequest(http.MethodGet, "/api/tasks/1/run", nil)
+	upgrade.Header.Set("Upgrade", "websocket")
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		want string
+	}{
+		{"GET", httptest.NewRequest(http.MethodGet, "/api/tasks", nil), api.ScopeRead},
+		{"HEAD", httptest.NewRequest(http.MethodHead, "/api/tasks", nil), api.ScopeRead},
+		{"POST", httptest.NewRequest(http.MethodPost, "/api/tasks", nil), api.ScopeWrite},
+		{"DELETE", httptest.NewRequest(http.MethodDelete, "/api/tasks/1", nil), api.ScopeWrite},
+		{"websocket upgrade", upgrade, api.ScopeWrite},
+	}
+	for _, tt := range tests {
+		if got := requiredScope(tt.r); got != tt.want {
+			t.Errorf("requiredScope(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{[]string{api.ScopeRead}, api.ScopeRead, true},
+		{[]string{api.ScopeRead}, api.ScopeWrite, false},
+		{[]string{api.ScopeWrite}, api.ScopeRead, true},
+		{[]string{api.ScopeWrite}, api.ScopeWrite, true},
+		{nil, api.ScopeRead, false},
+	}
+	for _, tt := range tests {
+		if got := hasScope(tt.scopes, tt.scope); got != tt.want {
+			t.Errorf("hasScope(%v, %v) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestSessionOnly(t *testing.T) {
+	w := httptest.NewRecorder()
+	if !sessionOnly(w, httptest.NewRequest(http.MethodGet, "/api/tokens", nil)) {
+		t.Errorf("sessionOnly() refused a session = %v %s", w.Code, w.Body)
+	}
+
+	// The middleware sets X-Token-ID for API tokens, after dropping any the client sent.
+	r := httptest.NewRequest(http.MethodGet, "/api/tokens", nil)
+	r.Header.Set("X-Token-ID", "1")
+	w = httptest.NewRecorder()
+	if sessionOnly(w, r) || w.Code != http.StatusForbidden {
+		t.Errorf("sessionOnly() with an API token = %v, want 403", w.Code)
+	}
+}
@@ -1,15 +1,31 @@
 package connection
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"time"
 
+	"ethan/pkg/db"
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
 )
 
+// closeChannel is the Postgres notification channel close requests are sent on, the payload is the task ID.
+const closeChannel = "close_conn"
+
+// decidedChannel is the Postgres notification channel decisions on pending actions are announced on, the payload is
+// the task ID.
+const decidedChannel = "pending_action_decided"
+
 var (
 	ConnLock = &sync.RWMutex{}
 
 	ConnMap = map[string]*websocket.Conn{}
+
+	decisionLock = &sync.Mutex{}
+	decisions    = map[string]chan struct{}{}
 )
 
 func SetConn(taskID string, conn *websocket.Conn) {
@@ -24,7 +40,15 @@ func RemoveConn(taskID string) {
 	delete(ConnMap, taskID)
 }
 
-func CloseConn(taskID string) {
+// CloseConn closes the connection of the task on whichever replica holds it.
+func CloseConn(ctx context.Context, queries *db.Queries, taskID string) error {
+	if err := queries.NotifyCloseConn(ctx, taskID); err != nil {
+		return fmt.Errorf("failed to request closing connection of task %v: %w", taskID, err)
+	}
+	return nil
+}
+
+func closeLocalConn(taskID string) {
 	ConnLock.RLock()
 	defer ConnLock.RUnlock()
 
@@ -32,3 +56,78 @@ func CloseConn(taskID string) {
 		conn.Close()
 	}
 }
+
+// WatchDecisions returns a channel that receives when a pending action of the task is decided, on any replica, while
+// the task runs here. stop ends the watch.
+func WatchDecisions(taskID string) (decided <-chan struct{}, stop func()) {
+	decisionLock.Lock()
+	defer decisionLock.Unlock()
+
+	ch := make(chan struct{}, 1)
+	decisions[taskID] = ch
+	return ch, func() {
+		decisionLock.Lock()
+		defer decisionLock.Unlock()
+		if decisions[taskID] == ch {
+			delete(decisions, taskID)
+		}
+	}
+}
+
+func notifyDecision(taskID string) {
+	decisionLock.Lock()
+	defer decisionLock.Unlock()
+
+	if ch, ok := decisions[taskID]; ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A wake up is already pending.
+		}
+	}
+}
+
+// Listen closes the connections of this process that CloseConn is called for, on any replica, and wakes up the runs
+// watching decisions. It blocks until ctx is done.
+func Listen(ctx context.Context, pool *pgxpool.Pool) {
+	for {
+		if err := listen(ctx, pool); err != nil {
+			logrus.Error(fmt.Errorf("failed to listen for connection close requests: %w", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func listen(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	for _, channel := range []string{closeChannel, decidedChannel} {
+		if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+			return err
+		}
+	}
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		switch notification.Channel {
+		case closeChannel:
+			closeLocalConn(notification.Payload)
+		case decidedChannel:
+			notifyDecision(notification.Payload)
+		}
+	}
+}
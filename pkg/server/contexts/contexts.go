@@ -1,18 +1,13 @@
 package contexts
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
 
 	"ethan/pkg/db"
-	"github.com/gorilla/mux"
+	"ethan/pkg/server/api"
 	"github.com/jackc/pgx/v5"
-
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/sirupsen/logrus"
 )
 
 type Handler struct {
@@ -24,114 +19,147 @@ func NewHandler(queries *db.Queries) *Handler {
 }
 
 func (h *Handler) CreateContext(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	var uid pgtype.UUID
-	if err := uid.Scan(userID); err != nil {
-		logrus.Error(fmt.Errorf("invalid user id: %s", userID))
-		w.WriteHeader(http.StatusInternalServerError)
+	uid, ok := api.UserID(w, r)
+	if !ok {
 		return
 	}
-
-	var contextParam db.CreateContextParams
-	data, err := io.ReadAll(r.Body)
-	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch tasks from database: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	var req api.ContextRequest
+	if !api.DecodeValid(w, r, &req) || !h.canShare(w, r, uid, req.OrganizationUUID()) {
 		return
 	}
-	if len(data) != 0 {
-		if err := json.Unmarshal(data, &contextParam); err != nil {
-			logrus.Error(fmt.Errorf("failed to unmarshal tasks from request body: %w", err))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	}
-	contextParam.UserID = uid
 
-	context, err := h.queries.CreateContext(r.Context(), contextParam)
+	context, err := h.queries.CreateContext(r.Context(), db.CreateContextParams{
+		Name:           &req.Name,
+		Description:    &req.Description,
+		Content:        &req.Content,
+		UserID:         uid,
+		OrganizationID: req.OrganizationUUID(),
+	})
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to create context: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	if err := json.NewEncoder(w).Encode(context); err != nil {
-		logrus.Error(fmt.Errorf("failed to encode task output: %w", err))
+		api.DBError(w, err, "context")
 		return
 	}
-	return
+	api.WriteJSON(w, http.StatusCreated, api.NewContext(context))
 }
 
+// ListContext lists the contexts of the user together with the contexts shared with their organizations.
 func (h *Handler) ListContext(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	var uid pgtype.UUID
-	if err := uid.Scan(userID); err != nil {
-		logrus.Error(fmt.Errorf("invalid user id: %s", userID))
-		w.WriteHeader(http.StatusInternalServerError)
+	uid, ok := api.UserID(w, r)
+	if !ok {
 		return
 	}
 	contexts, err := h.queries.ListContextsForUser(r.Context(), uid)
 	if err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			logrus.Error(fmt.Errorf("failed to fetch tasks from database: %w", err))
-		}
-		w.WriteHeader(http.StatusInternalServerError)
+		api.DBError(w, err, "contexts")
 		return
 	}
-	if err := json.NewEncoder(w).Encode(contexts); err != nil {
-		logrus.Error(fmt.Errorf("failed to encode tasks output: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	return
+	api.WriteJSON(w, http.StatusOK, api.NewContexts(contexts))
 }
 
+// UpdateContext changes a context of the user, or a context shared with an organization the user owns. Only the user
+// who created a context can change whom it is shared with.
 func (h *Handler) UpdateContext(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var contextID pgtype.UUID
-	if err := contextID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
-		w.WriteHeader(http.StatusInternalServerError)
+	contextID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
-
-	var contextParam db.UpdateContextParams
-	data, err := io.ReadAll(r.Body)
-	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch tasks from database: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	var req api.ContextRequest
+	if !api.DecodeValid(w, r, &req) {
 		return
 	}
-	if len(data) != 0 {
-		if err := json.Unmarshal(data, &contextParam); err != nil {
-			logrus.Error(fmt.Errorf("failed to unmarshal tasks from request body: %w", err))
-			w.WriteHeader(http.StatusInternalServerError)
+	if req.OrganizationID != nil {
+		current, err := h.queries.GetContext(r.Context(), db.GetContextParams{
+			ID:     contextID,
+			UserID: uid,
+		})
+		if err != nil {
+			api.DBError(w, err, "context")
 			return
 		}
+		if current.OrganizationID != req.OrganizationUUID() {
+			if current.UserID != uid {
+				api.Forbidden(w, "only the creator of a context can change whom it is shared with")
+				return
+			}
+			if !h.canShare(w, r, uid, req.OrganizationUUID()) {
+				return
+			}
+			if _, err := h.queries.SetContextOrganization(r.Context(), db.SetContextOrganizationParams{
+				ID:             contextID,
+				UserID:         uid,
+				OrganizationID: req.OrganizationUUID(),
+			}); err != nil {
+				api.DBError(w, err, "context")
+				return
+			}
+		}
 	}
-	contextParam.ID = contextID
 
-	if err := h.queries.UpdateContext(r.Context(), contextParam); err != nil {
-		logrus.Error(fmt.Errorf("failed to update task: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	n, err := h.queries.UpdateContext(r.Context(), db.UpdateContextParams{
+		ID:          contextID,
+		UserID:      uid,
+		Name:        &req.Name,
+		Description: &req.Description,
+		Content:     &req.Content,
+	})
+	if err != nil {
+		api.DBError(w, err, "context")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "context")
 		return
 	}
 
-	return
+	context, err := h.queries.GetContext(r.Context(), db.GetContextParams{
+		ID:     contextID,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "context")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewContext(context))
 }
 
+// DeleteContext deletes a context of the user, or a context shared with an organization the user owns.
 func (h *Handler) DeleteContext(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var contextID pgtype.UUID
-	if err := contextID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
+	contextID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
 
-	if err := h.queries.DeleteContext(r.Context(), contextID); err != nil {
-		logrus.Error(fmt.Errorf("failed to delete task: %w", err))
+	n, err := h.queries.DeleteContext(r.Context(), db.DeleteContextParams{
+		ID:     contextID,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "context")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "context")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	return
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// canShare answers with a 400 unless the user may share contexts with the organization, which owners and members
+// may but delegates may not. Without an organization there is nothing to check.
+func (h *Handler) canShare(w http.ResponseWriter, r *http.Request, uid, orgID pgtype.UUID) bool {
+	if !orgID.Valid {
+		return true
+	}
+	member, err := h.queries.GetOrganizationMember(r.Context(), db.GetOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         uid,
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && member.Role == api.RoleDelegate) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"organizationId": "is not an organization you can share with"})
+		return false
+	} else if err != nil {
+		api.DBError(w, err, "organization")
+		return false
+	}
+	return true
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/auth"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The tests that need a database run against TEST_DATABASE_URL, they are skipped without it. The schema is created
+// the way the server does at startup, and every test works with users of its own, so the database can be reused.
+
+var signingKey = sync.OnceValue(func() string {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		panic(err)
+	}
+	return "test:" + base64.StdEncoding.EncodeToString(der)
+})
+
+func testDB(t *testing.T) *db.Queries {
+	return db.New(testPool(t))
+}
+
+// testPool connects to the test database and creates the schema.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	t.Setenv("JWT_SIGNING_KEYS", signingKey())
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := pool.Exec(ctx, initSql); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	return pool
+}
+
+// testServer serves the API with the handlers of the server.
+func testServer(t *testing.T, h handlers) *httptest.Server {
+	srv := httptest.NewServer(newRouter(h, http.NotFoundHandler()))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func createUser(t *testing.T, queries *db.Queries, name string) db.User {
+	t.Helper()
+	user, err := queries.CreateUser(context.Background(), db.CreateUserParams{
+		Name:  name,
+		Email: name + "-" + uuid.NewString() + "@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// createSession starts a session of the user and returns it with its refresh token.
+func createSession(t *testing.T, queries *db.Queries, user db.User) (db.Session, string) {
+	t.Helper()
+	refreshToken := uuid.NewString()
+	sum := sha256.Sum256([]byte(refreshToken))
+	session, err := queries.CreateSession(context.Background(), db.CreateSessionParams{
+		UserID:           user.ID,
+		RefreshTokenHash: hex.EncodeToString(sum[:]),
+		ExpireAt:         pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, refreshToken
+}
+
+// signIn returns a session JWT of the user, which the server hands out for the refresh token cookie like it does to
+// a browser.
+func signIn(t *testing.T, srv *httptest.Server, queries *db.Queries, user db.User) string {
+	t.Helper()
+	_, refreshToken := createSession(t, queries, user)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: auth.RefreshTokenName, Value: refreshToken})
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/me with a refresh token = %v, want 200", resp.StatusCode)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == auth.JwtTokenName {
+			return cookie.Value
+		}
+	}
+	t.Fatal("no session JWT was set")
+	return ""
+}
+
+// call sends a request to the API as the user of token, with body as JSON unless it is nil, and returns the status
+// code and the body of the response.
+func call(t *testing.T, srv *httptest.Server, token, method, path string, body any) (int, []byte) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+"/api"+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func id(u pgtype.UUID) string {
+	return uuid.UUID(u.Bytes).String()
+}
@@ -0,0 +1,161 @@
+package delegation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/server/api"
+	"github.com/jackc/pgx/v5"
+)
+
+type Handler struct {
+	queries *db.Queries
+}
+
+func NewHandler(queries *db.Queries) *Handler {
+	return &Handler{queries: queries}
+}
+
+// ListDelegations lists the grants the user gave to their delegates and the grants they received as a delegate.
+func (h *Handler) ListDelegations(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+	rows, err := h.queries.ListDelegationGrantsForUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "delegations")
+		return
+	}
+	delegations := make([]api.Delegation, 0, len(rows))
+	for _, row := range rows {
+		delegations = append(delegations, api.NewDelegation(row.DelegationGrant, row.PrincipalName, row.DelegateName, row.DelegateEmail, row.MailboxID))
+	}
+	api.WriteJSON(w, http.StatusOK, delegations)
+}
+
+// CreateDelegation grants a registered user delegate access to a Microsoft mailbox the signed in user connected, or
+// changes the permissions of the grant. Only the owner of the connection can delegate its address. The delegate gets
+// a mailbox connection for it, which works with the token of their own Microsoft mailbox on /users/{mailbox}, so the
+// mailbox must also be shared with them in Exchange. The principal may keep their own connection to the mailbox,
+// which then processes its new mail instead of the delegate's. A mailbox that is delegated to someone else is refused.
+func (h *Handler) CreateDelegation(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+	var req api.DelegationRequest
+	if !api.DecodeValid(w, r, &req) {
+		return
+	}
+
+	principal, err := h.queries.GetUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "user")
+		return
+	}
+	mailbox, err := h.queries.GetMailboxConnectionForUser(r.Context(), db.GetMailboxConnectionForUserParams{
+		ID:     req.MailboxUUID(),
+		UserID: uid,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"mailboxId": "is not one of your mailboxes"})
+		return
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+	if mailbox.DelegationID.Valid {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"mailboxId": "is delegated to you, only its owner can delegate it"})
+		return
+	}
+	if mailbox.Provider != graph.Name {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"mailboxId": "is not a Microsoft mailbox"})
+		return
+	}
+	delegate, err := h.queries.GetUserFromEmail(r.Context(), req.DelegateEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"delegateEmail": "is not a registered user"})
+		return
+	} else if err != nil {
+		api.DBError(w, err, "user")
+		return
+	}
+	if delegate.ID == uid {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"delegateEmail": "is your own account"})
+		return
+	}
+	credential, err := h.queries.GetDefaultGraphMailboxConnection(r.Context(), delegate.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"delegateEmail": "has no Microsoft mailbox connected"})
+		return
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+
+	conn, err := h.queries.GetDelegatedMailboxConnectionFromEmail(r.Context(), mailbox.Email)
+	if err == nil && conn.UserID != delegate.ID {
+		api.Conflict(w, fmt.Sprintf("%v is already delegated to another account", mailbox.Email))
+		return
+	} else if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+
+	grant, err := h.queries.UpsertDelegationGrant(r.Context(), db.UpsertDelegationGrantParams{
+		PrincipalID: uid,
+		DelegateID:  delegate.ID,
+		Mailbox:     mailbox.Email,
+		Permissions: req.Permissions,
+	})
+	if err != nil {
+		api.DBError(w, err, "delegation")
+		return
+	}
+	conn, err = h.queries.CreateDelegatedMailboxConnection(r.Context(), db.CreateDelegatedMailboxConnectionParams{
+		UserID:               delegate.ID,
+		Provider:             graph.Name,
+		Email:                grant.Mailbox,
+		Token:                credential.Token,
+		ExpireAt:             credential.ExpireAt,
+		DelegationID:         grant.ID,
+		CredentialID:         credential.ID,
+		DelegatedPermissions: grant.Permissions,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The mailbox was delegated to another account in the meantime.
+		api.Conflict(w, fmt.Sprintf("%v is already delegated to another account", mailbox.Email))
+		return
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewDelegation(grant, principal.Name, delegate.Name, delegate.Email, conn.ID))
+}
+
+// DeleteDelegation revokes a grant. Both the principal and the delegate can end it. The delegate's mailbox connection
+// for it is removed, its tasks are kept without a mailbox.
+func (h *Handler) DeleteDelegation(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+
+	n, err := h.queries.DeleteDelegationGrant(r.Context(), db.DeleteDelegationGrantParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "delegation")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "delegation")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/server/api"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TestDelegateMailbox checks that a principal can only delegate a Microsoft mailbox they connected themselves, by
+// its ID, and that the grant covers the address of that mailbox rather than their sign in email.
+func TestDelegateMailbox(t *testing.T) {
+	queries := testDB(t)
+	srv := testServer(t, newHandlers(queries, nil))
+	ctx := context.Background()
+
+	principal := createUser(t, queries, "principal")
+	delegate := createUser(t, queries, "delegate")
+	other := createUser(t, queries, "other")
+	mailbox := func(user db.User, provider, email string) db.MailboxConnection {
+		conn, err := queries.CreateMailboxConnection(ctx, db.CreateMailboxConnectionParams{
+			UserID:   user.ID,
+			Provider: provider,
+			Email:    email,
+			Token:    "token",
+			ExpireAt: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	mailbox(principal, graph.Name, principal.Email)
+	shared := mailbox(principal, graph.Name, "shared@example.com")
+	google := mailbox(principal, "google", "principal@gmail.com")
+	mailbox(delegate, graph.Name, delegate.Email)
+	otherMailbox := mailbox(other, graph.Name, other.Email)
+
+	token := signIn(t, srv, queries, principal)
+	delegateTo := func(mailboxID string) (int, []byte) {
+		return call(t, srv, token, http.MethodPost, "/delegations", map[string]any{
+			"delegateEmail": delegate.Email,
+			"mailboxId":     mailboxID,
+			"permissions":   []string{"mail"},
+		})
+	}
+	for name, mailboxID := range map[string]string{
+		"no mailbox":             "",
+		"an invalid ID":          "inbox",
+		"another user's mailbox": id(otherMailbox.ID),
+		"a Google mailbox":       id(google.ID),
+	} {
+		if status, body := delegateTo(mailboxID); status != http.StatusBadRequest || !strings.Contains(string(body), "mailboxId") {
+			t.Errorf("delegating %v = %v %s, want 400 on mailboxId", name, status, body)
+		}
+	}
+	if got, err := queries.ListMailboxConnectionsForUser(ctx, delegate.ID); err != nil || len(got) != 1 {
+		t.Errorf("mailboxes of the delegate = %v, %v, want only their own", got, err)
+	}
+
+	status, body := delegateTo(id(shared.ID))
+	if status != http.StatusOK {
+		t.Fatalf("delegating the shared mailbox = %v %s", status, body)
+	}
+	var delegation api.Delegation
+	if err := json.Unmarshal(body, &delegation); err != nil {
+		t.Fatal(err)
+	}
+	if delegation.Mailbox != shared.Email {
+		t.Errorf("mailbox of the delegation = %v, want %v", delegation.Mailbox, shared.Email)
+	}
+
+	// The delegate cannot pass on the mailbox they were given.
+	if delegation.MailboxID == nil {
+		t.Fatalf("delegation %s has no mailbox for the delegate", body)
+	}
+	status, body = call(t, srv, signIn(t, srv, queries, delegate), http.MethodPost, "/delegations", map[string]any{
+		"delegateEmail": other.Email,
+		"mailboxId":     *delegation.MailboxID,
+		"permissions":   []string{"mail"},
+	})
+	if status != http.StatusBadRequest || !strings.Contains(string(body), "mailboxId") {
+		t.Errorf("delegating a delegated mailbox = %v %s, want 400 on mailboxId", status, body)
+	}
+}
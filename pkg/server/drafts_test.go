@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TestDraftsOfAssignee checks that a member the task is assigned to cannot reach the drafts its owner's runs left in
+// the owner's mailbox, while they still see the task and the drafts of their own runs.
+func TestDraftsOfAssignee(t *testing.T) {
+	queries := testDB(t)
+	srv := testServer(t, newHandlers(queries, nil))
+	ctx := context.Background()
+
+	owner := createUser(t, queries, "owner")
+	member := createUser(t, queries, "member")
+	mailbox := func(user db.User) db.MailboxConnection {
+		conn, err := queries.CreateMailboxConnection(ctx, db.CreateMailboxConnectionParams{
+			UserID:   user.ID,
+			Provider: "microsoft",
+			Email:    user.Email,
+			Token:    "token",
+			ExpireAt: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	ownerMailbox, memberMailbox := mailbox(owner), mailbox(member)
+
+	org, err := queries.CreateOrganization(ctx, "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for user, role := range map[pgtype.UUID]string{owner.ID: api.RoleOwner, member.ID: api.RoleMember} {
+		if _, err := queries.UpsertOrganizationMember(ctx, db.UpsertOrganizationMemberParams{
+			OrganizationID: org.ID,
+			UserID:         user,
+			Role:           role,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	task, err := queries.CreateTask(ctx, db.CreateTaskParams{UserID: owner.ID, Name: "Plan the offsite", ConnectionID: ownerMailbox.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := queries.AssignTask(ctx, db.AssignTaskParams{ID: task.ID, UserID: owner.ID, AssigneeID: member.ID, OrganizationID: org.ID}); err != nil || n != 1 {
+		t.Fatalf("AssignTask() = %v, %v", n, err)
+	}
+	draft := func(user db.User, conn db.MailboxConnection) db.TaskDraft {
+		d, err := queries.CreateTaskDraft(ctx, db.CreateTaskDraftParams{
+			UserID:       user.ID,
+			TaskID:       task.ID,
+			ConnectionID: conn.ID,
+			MessageID:    uuid.NewString(),
+			Subject:      "Offsite",
+			Recipients:   []string{"team@example.com"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	ownerDraft, memberDraft := draft(owner, ownerMailbox), draft(member, memberMailbox)
+
+	jwt := signIn(t, srv, queries, member)
+	if status, body := call(t, srv, jwt, http.MethodGet, "/tasks/"+id(task.ID), nil); status != http.StatusOK {
+		t.Fatalf("GET the assigned task = %v %s, want 200", status, body)
+	}
+	path := "/tasks/" + id(task.ID) + "/drafts/" + id(ownerDraft.ID)
+	for _, req := range []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodGet, path, nil},
+		{http.MethodPost, path, map[string]any{"subject": "Mine now"}},
+		{http.MethodPost, path + "/send", nil},
+		{http.MethodDelete, path, nil},
+	} {
+		if status, body := call(t, srv, jwt, req.method, req.path, req.body); status != http.StatusNotFound {
+			t.Errorf("%s %s = %v %s, want 404", req.method, req.path, status, body)
+		}
+	}
+
+	status, body := call(t, srv, jwt, http.MethodGet, "/tasks/"+id(task.ID)+"/drafts", nil)
+	if status != http.StatusOK || strings.Contains(string(body), id(ownerDraft.ID)) || !strings.Contains(string(body), id(memberDraft.ID)) {
+		t.Errorf("GET the drafts of the task = %v %s, want only the member's draft", status, body)
+	}
+
+	// Nor does the owner see the draft in the member's mailbox.
+	status, body = call(t, srv, signIn(t, srv, queries, owner), http.MethodGet, "/tasks/"+id(task.ID)+"/drafts", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), id(ownerDraft.ID)) || strings.Contains(string(body), id(memberDraft.ID)) {
+		t.Errorf("GET the drafts of the task as the owner = %v %s, want only the owner's draft", status, body)
+	}
+
+	if got, err := queries.ListTaskDrafts(ctx, task.ID); err != nil || len(got) != 2 {
+		t.Errorf("drafts of the task = %v, %v, want both kept", got, err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package leader elects one replica to run the background loops, using a Postgres advisory lock.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	retryInterval = 15 * time.Second
+	// checkInterval is how often the leader checks that the connection holding the lock is still alive.
+	checkInterval = 10 * time.Second
+)
+
+// Run calls lead whenever this process holds the advisory lock for name, and cancels the context passed to it when
+// the lock is lost. The lock is held by a session, so it is released when the process or its connection dies. Run
+// blocks until ctx is done.
+func Run(ctx context.Context, pool *pgxpool.Pool, name string, lead func(ctx context.Context)) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	key := int64(h.Sum64())
+
+	for {
+		if err := runOnce(ctx, pool, key, name, lead); err != nil {
+			logrus.Error(fmt.Errorf("leader election for %v failed: %w", name, err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func runOnce(ctx context.Context, pool *pgxpool.Pool, key int64, name string, lead func(ctx context.Context)) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired {
+		return nil
+	}
+	defer func() {
+		// Unlock on a fresh context, ctx may already be done.
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			logrus.Error(fmt.Errorf("failed to release leader lock for %v: %w", name, err))
+		}
+	}()
+
+	logrus.Infof("Became leader for %v", name)
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		logrus.Infof("Stopped leading %v", name)
+	}()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := conn.Ping(ctx); err != nil {
+				return fmt.Errorf("lost connection holding the lock: %w", err)
+			}
+		}
+	}
+}
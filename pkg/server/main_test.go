@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ethan/pkg/cmd"
+
+	acmd "github.com/acorn-io/cmd"
+	"github.com/gptscript-ai/gptscript/pkg/cache"
+	"github.com/gptscript-ai/gptscript/pkg/gptscript"
+	"github.com/gptscript-ai/gptscript/pkg/sdkserver"
+)
+
+// TestMain lets the test binary stand in for the gptscript binary and for the gem-copilot binary the tools run. The
+// gptscript SDK starts `gptscript sys.sdkserver`, with GPTSCRIPT_BIN pointing at the test binary it runs the SDK
+// server of the gptscript version the module depends on. Run under the name gem-copilot, found on PATH through a
+// link, it runs the tools of this module. Nothing needs to be installed for a task to run.
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "gem-copilot" {
+		acmd.Main(cmd.New())
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sys.sdkserver" {
+		if err := serveGPTScript(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func serveGPTScript(args []string) error {
+	flags := flag.NewFlagSet("sys.sdkserver", flag.ContinueOnError)
+	listenAddress := flags.String("listen-address", "127.0.0.1:0", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cacheDir, err := os.MkdirTemp("", "gptscript-cache")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(cacheDir)
+
+	return sdkserver.Start(context.Background(), sdkserver.Options{
+		Options: gptscript.Options{
+			Cache: cache.Options{CacheDir: cacheDir},
+			Env:   os.Environ(),
+		},
+		ListenAddress: *listenAddress,
+	})
+}
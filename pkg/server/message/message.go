@@ -1,15 +1,12 @@
 package message
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"ethan/pkg/db"
+	"ethan/pkg/server/api"
 
-	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/sirupsen/logrus"
 )
 
 type Handler struct {
@@ -21,18 +18,16 @@ func NewHandler(queries *db.Queries) *Handler {
 }
 
 func (h *Handler) ListMessages(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	var uID pgtype.UUID
-	if err := uID.Scan(userID); err != nil {
-		logrus.Error(fmt.Errorf("invalid user id: %s", userID))
+	uID, ok := api.UserID(w, r)
+	if !ok {
 		return
 	}
 
 	taskId := r.URL.Query().Get("taskId")
 	var taskID pgtype.UUID
 	if taskId != "" {
-		if err := taskID.Scan(taskId); err != nil {
-			logrus.Error(fmt.Errorf("invalid task id: %s", userID))
+		if err := taskID.Scan(taskId); err != nil || !taskID.Valid {
+			api.BadRequest(w, "invalid taskId: not a UUID", api.FieldErrors{"taskId": "must be a UUID"})
 			return
 		}
 	}
@@ -47,33 +42,31 @@ func (h *Handler) ListMessages(w http.ResponseWriter, r *http.Request) {
 	} else {
 		messages, err = h.queries.GetMessageFromUserID(r.Context(), uID)
 	}
-
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch message ID, err: %w", err))
-		return
-	}
-
-	if err := json.NewEncoder(w).Encode(messages); err != nil {
-		logrus.Error(fmt.Errorf("json encode err: %v", err))
+		api.DBError(w, err, "messages")
 		return
 	}
-	return
+	api.WriteJSON(w, http.StatusOK, api.NewMessages(messages))
 }
 
 func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var messageID pgtype.UUID
-	if err := messageID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
+	messageID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
 
-	if err := h.queries.UpdateMessageRead(r.Context(), db.UpdateMessageReadParams{
-		ID:   messageID,
-		Read: &[]bool{true}[0],
-	}); err != nil {
-		logrus.Error(fmt.Errorf("UpdateMessage err: %v", err))
+	n, err := h.queries.UpdateMessageRead(r.Context(), db.UpdateMessageReadParams{
+		ID:     messageID,
+		UserID: uid,
+		Read:   &[]bool{true}[0],
+	})
+	if err != nil {
+		api.DBError(w, err, "message")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "message")
 		return
 	}
-	return
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"ethan/pkg/db"
+	"ethan/pkg/graphfake"
+	"ethan/pkg/server/api"
+	"ethan/pkg/server/auth"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// oidcIssuer is the graphfake identity provider of the OIDC tests. The server loads its OIDC providers and discovers
+// them once, so every test signs in with the same one.
+var oidcIssuer = sync.OnceValues(func() (*graphfake.Server, string) {
+	fake := graphfake.New()
+	srv := httptest.NewServer(fake)
+	return fake, srv.URL + "/common/v2.0"
+})
+
+// oidcLogin is a sign in through the fake identity provider. Its hooks tamper with the redirects between the
+// server and the provider, the way an attacker in the browser could.
+type oidcLogin struct {
+	t   *testing.T
+	srv *httptest.Server
+	// authorize changes the query of the authorize request the server redirected to.
+	authorize func(query url.Values)
+	// callback changes the query of the callback the provider redirected to.
+	callback func(query url.Values)
+}
+
+// startOIDC serves the API with the fake identity provider configured as "fake".
+func startOIDC(t *testing.T) (*graphfake.Server, *pgxpool.Pool, *httptest.Server) {
+	t.Helper()
+	pool := testPool(t)
+	fake, issuer := oidcIssuer()
+	t.Setenv("OIDC_PROVIDERS", "fake")
+	t.Setenv("OIDC_FAKE_NAME", "Fake")
+	t.Setenv("OIDC_FAKE_ISSUER", issuer)
+	t.Setenv("OIDC_FAKE_CLIENT_ID", "client")
+	t.Setenv("OIDC_FAKE_CLIENT_SECRET", "secret")
+	srv := testServer(t, newHandlers(db.New(pool), nil))
+	t.Setenv("PUBLIC_URL", srv.URL)
+	return fake, pool, srv
+}
+
+// addIdentity adds a mailbox to the identity provider and returns its email.
+func addIdentity(fake *graphfake.Server, unverified bool) string {
+	email := "oidc-" + uuid.NewString() + "@example.com"
+	fake.AddMailbox(graphfake.Mailbox{User: graphfake.User{DisplayName: "OIDC user", Mail: email}, EmailUnverified: unverified})
+	return email
+}
+
+// run signs in as the mailbox with email and returns the response to the callback.
+func (l oidcLogin) run(email string) *http.Response {
+	t := l.t
+	t.Helper()
+	client := l.srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	redirect := func(resp *http.Response) *url.URL {
+		t.Helper()
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			t.Fatalf("response %v has no redirect: %v", resp.StatusCode, err)
+		}
+		return location
+	}
+
+	resp, err := client.Get(l.srv.URL + "/api/login/oidc/fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizeURL := redirect(resp)
+	query := authorizeURL.Query()
+	query.Set("login_hint", email)
+	if l.authorize != nil {
+		l.authorize(query)
+	}
+	authorizeURL.RawQuery = query.Encode()
+
+	resp, err = client.Get(authorizeURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	callbackURL := redirect(resp)
+	query = callbackURL.Query()
+	if l.callback != nil {
+		l.callback(query)
+	}
+	callbackURL.RawQuery = query.Encode()
+
+	resp, err = client.Get(callbackURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// sessionJWT returns the session JWT the response set, or an empty string.
+func sessionJWT(resp *http.Response) string {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == auth.JwtTokenName && cookie.MaxAge >= 0 {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// TestOIDCLogin checks that the login discovers the provider and binds the authorization request to its state with a
+// PKCE challenge and a nonce, and that the callback signs in a new identity as the user with its verified email.
+func TestOIDCLogin(t *testing.T) {
+	fake, pool, srv := startOIDC(t)
+	queries := db.New(pool)
+	ctx := context.Background()
+
+	status, body := call(t, srv, "", http.MethodGet, "/login/providers", nil)
+	var providers []api.LoginProvider
+	if err := json.Unmarshal(body, &providers); err != nil {
+		t.Fatalf("GET /login/providers = %v %s: %v", status, body, err)
+	}
+	want := api.LoginProvider{ID: "fake", Name: "Fake", LoginURL: "/api/login/oidc/fake"}
+	if len(providers) == 0 || providers[len(providers)-1] != want {
+		t.Errorf("login providers = %+v, want %+v", providers, want)
+	}
+
+	_, issuer := oidcIssuer()
+	email := addIdentity(fake, false)
+	existing, err := queries.CreateUser(ctx, db.CreateUserParams{Name: "existing", Email: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var authorizeQuery url.Values
+	login := oidcLogin{t: t, srv: srv, authorize: func(query url.Values) {
+		authorizeQuery = query
+		var verifier, nonce string
+		if err := pool.QueryRow(ctx, "SELECT code_verifier, nonce FROM oauth_states WHERE state = $1", query.Get("state")).Scan(&verifier, &nonce); err != nil {
+			t.Fatalf("state %q of the login was not stored: %v", query.Get("state"), err)
+		}
+		sum := sha256.Sum256([]byte(verifier))
+		if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			t.Errorf("authorize request %v, want the S256 challenge of the stored verifier", query)
+		}
+		if query.Get("nonce") == "" || query.Get("nonce") != nonce {
+			t.Errorf("nonce of the authorize request = %q, want the stored %q", query.Get("nonce"), nonce)
+		}
+	}}
+
+	resp := login.run(email)
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "/" {
+		t.Fatalf("callback = %v to %v, want a redirect to /", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if authorizeQuery.Get("client_id") != "client" || authorizeQuery.Get("redirect_uri") != srv.URL+"/api/auth/oidc/fake/callback" {
+		t.Errorf("authorize request %v, want client and the callback of the server", authorizeQuery)
+	}
+	jwt := sessionJWT(resp)
+	status, body = call(t, srv, jwt, http.MethodGet, "/me", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), email) {
+		t.Errorf("GET /me after signing in = %v %s, want the user with the email of the identity", status, body)
+	}
+
+	// The identity is remembered and signs in as the same user next time.
+	var identities int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM user_identities WHERE issuer = $1 AND user_id = $2", issuer, existing.ID).Scan(&identities); err != nil || identities != 1 {
+		t.Errorf("identities of the user = %v, %v, want 1", identities, err)
+	}
+	if resp := (oidcLogin{t: t, srv: srv}).run(email); sessionJWT(resp) == "" {
+		t.Errorf("signing in again = %v, want a session", resp.StatusCode)
+	}
+}
+
+// TestOIDCLoginRejected checks that the callback refuses a code exchanged without the PKCE verifier of the login, an
+// ID token of another login, and an email the provider did not verify.
+func TestOIDCLoginRejected(t *testing.T) {
+	fake, pool, srv := startOIDC(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		unverified bool
+		login      oidcLogin
+		want       int
+	}{
+		{
+			name: "PKCE challenge of another verifier",
+			login: oidcLogin{authorize: func(query url.Values) {
+				sum := sha256.Sum256([]byte("attacker"))
+				query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+			}},
+			want: http.StatusInternalServerError,
+		},
+		{
+			name:  "nonce of another login",
+			login: oidcLogin{authorize: func(query url.Values) { query.Set("nonce", "attacker") }},
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name:  "state of another login",
+			login: oidcLogin{callback: func(query url.Values) { query.Set("state", "attacker") }},
+			want:  http.StatusBadRequest,
+		},
+		{
+			name:       "unverified email",
+			unverified: true,
+			login:      oidcLogin{},
+			want:       http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := addIdentity(fake, tt.unverified)
+			login := tt.login
+			login.t, login.srv = t, srv
+			resp := login.run(email)
+			if resp.StatusCode != tt.want || sessionJWT(resp) != "" {
+				t.Errorf("callback = %v, JWT %q, want %v without a session", resp.StatusCode, sessionJWT(resp), tt.want)
+			}
+			var users int
+			if err := pool.QueryRow(ctx, "SELECT count(*) FROM users WHERE email = $1", email).Scan(&users); err != nil || users != 0 {
+				t.Errorf("users with the email = %v, %v, want none", users, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/provider/imap"
+	"ethan/pkg/server/api"
+
+	"github.com/gorilla/mux"
+)
+
+// schemaTypes are the Go types the schemas of the OpenAPI document describe.
+var schemaTypes = map[string]any{
+	"Error":                     api.Error{},
+	"FieldErrors":               api.FieldErrors{},
+	"Task":                      api.Task{},
+	"TaskRequest":               api.TaskRequest{},
+	"Context":                   api.Context{},
+	"ContextRequest":            api.ContextRequest{},
+	"Organization":              api.Organization{},
+	"OrganizationRequest":       api.OrganizationRequest{},
+	"OrganizationMember":        api.OrganizationMember{},
+	"OrganizationMemberRequest": api.OrganizationMemberRequest{},
+	"OrganizationInvitation":    api.OrganizationInvitation{},
+	"TaskAssignmentRequest":     api.TaskAssignmentRequest{},
+	"PendingAction":             api.PendingAction{},
+	"ApprovalRequest":           api.ApprovalRequest{},
+	"ApprovalEmail":             approval.Email{},
+	"ApprovalEvent":             approval.Event{},
+	"ApprovalMeeting":           approval.Meeting{},
+	"Delegation":                api.Delegation{},
+	"DelegationRequest":         api.DelegationRequest{},
+	"Message":                   api.Message{},
+	"SpamEmail":                 api.SpamEmail{},
+	"AuditEvent":                api.AuditEvent{},
+	"User":                      api.User{},
+	"Mailbox":                   api.Mailbox{},
+	"SubscriptionHealth":        api.SubscriptionHealth{},
+	"MailboxSettingsRequest":    api.MailboxSettingsRequest{},
+	"IMAPLoginRequest":          api.IMAPLoginRequest{},
+	"IMAPMailboxRequest":        imap.Config{},
+	"RunFrame":                  api.RunFrame{},
+	"ApprovalFrame":             api.ApprovalFrame{},
+	"TaskDraft":                 api.TaskDraft{},
+	"DraftRequest":              api.DraftRequest{},
+	"APIToken":                  api.APIToken{},
+	"CreatedAPIToken":           api.CreatedAPIToken{},
+	"APITokenRequest":           api.APITokenRequest{},
+	"Session":                   api.Session{},
+	"LoginProvider":             api.LoginProvider{},
+}
+
+// foreignSchemas describe bodies in formats the server does not define, which have no Go type of their own.
+var foreignSchemas = []string{
+	// Microsoft Graph change notifications, only the fields the webhook reads are decoded.
+	"ChangeNotifications",
+}
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// schema is the part of a JSON Schema the API types use.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaType         `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	AllOf                []*schema          `json:"allOf"`
+	OneOf                []*schema          `json:"oneOf"`
+}
+
+// schemaType is the type keyword, a name or a list of names such as ["string", "null"].
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaType{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*t = names
+	return nil
+}
+
+func (s *schema) is(name string) bool {
+	return slices.Contains(s.Type, name)
+}
+
+func loadOpenAPI(t *testing.T) openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	if err := json.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	return doc
+}
+
+// TestOpenAPIRoutes checks that every route under /api is documented, and that every documented operation has a
+// route. Routes registered without methods match any method and only need their path to be documented.
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router := newRouter(newHandlers(nil, nil), http.NotFoundHandler())
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	routed := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/") {
+			return nil
+		}
+		path := strings.TrimPrefix(template, "/api")
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			if _, ok := doc.Paths[path]; !ok {
+				t.Errorf("undocumented route %v", path)
+			}
+			for method := range doc.Paths[path] {
+				routed[strings.ToUpper(method)+" "+path] = true
+			}
+			return nil
+		}
+		for _, method := range methods {
+			key := method + " " + path
+			routed[key] = true
+			if !documented[key] {
+				t.Errorf("undocumented route %v", key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key := range documented {
+		if !routed[key] {
+			t.Errorf("documented operation without a route %v", key)
+		}
+	}
+}
+
+// TestOpenAPISchemas checks that the request and response bodies the operations reference exist, and that every
+// schema matches the JSON encoding of its Go type: the same properties, compatible types, and nullable exactly
+// where the Go type can encode null.
+func TestOpenAPISchemas(t *testing.T) {
+	doc := loadOpenAPI(t)
+	schemas := doc.Components.Schemas
+
+	for path, item := range doc.Paths {
+		for method, raw := range item {
+			var refs []string
+			collectRefs(raw, &refs)
+			for _, ref := range refs {
+				if _, ok := schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; !ok {
+					t.Errorf("%v %v references missing schema %v", strings.ToUpper(method), path, ref)
+				}
+			}
+		}
+	}
+
+	for name := range schemas {
+		if _, ok := schemaTypes[name]; !ok && !slices.Contains(foreignSchemas, name) {
+			t.Errorf("schema %v has no Go type in schemaTypes", name)
+		}
+	}
+	for name, v := range schemaTypes {
+		s, ok := schemas[name]
+		if !ok {
+			t.Errorf("schemaTypes names missing schema %v", name)
+			continue
+		}
+		c := schemaChecker{schemas: schemas, seen: map[string]bool{}}
+		c.check(name, reflect.TypeOf(v), s, true)
+		for _, problem := range c.problems {
+			t.Errorf("schema %v: %v", name, problem)
+		}
+	}
+}
+
+func collectRefs(raw json.RawMessage, refs *[]string) {
+	var v any
+	if json.Unmarshal(raw, &v) != nil {
+		return
+	}
+	var walk func(any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				*refs = append(*refs, ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+type schemaChecker struct {
+	schemas  map[string]*schema
+	seen     map[string]bool
+	problems []string
+}
+
+func (c *schemaChecker) fail(path, format string, args ...any) {
+	c.problems = append(c.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// check compares the Go type at path with s. required is false for properties that may be left out, which lets
+// pointers stand for them without the schema being nullable.
+func (c *schemaChecker) check(path string, typ reflect.Type, s *schema, required bool) {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		ref, ok := c.schemas[name]
+		if !ok {
+			c.fail(path, "missing schema %v", s.Ref)
+			return
+		}
+		key := name + " " + typ.String()
+		if c.seen[key] {
+			return
+		}
+		c.seen[key] = true
+		c.check(path, typ, ref, required)
+		return
+	}
+	if len(s.AllOf) > 0 && len(s.Type) == 0 {
+		c.check(path, typ, c.merge(s.AllOf), required)
+		return
+	}
+
+	nullable := s.is("null")
+	if typ.Kind() == reflect.Pointer {
+		if required && !nullable {
+			c.fail(path, "is a pointer, but the schema is neither nullable nor optional")
+		}
+		typ = typ.Elem()
+	} else if nullable && typ.Kind() != reflect.Slice && typ.Kind() != reflect.Map && typ.Kind() != reflect.Interface {
+		c.fail(path, "is nullable, but %v cannot be null", typ)
+	}
+
+	// Bodies of any shape, and those that encode themselves, are not compared further.
+	if len(s.Type) == 0 || len(s.OneOf) > 0 || typ.Kind() == reflect.Interface || typ == rawMessageType {
+		return
+	}
+	if typ != timeType && typ.Implements(marshalerType) {
+		return
+	}
+
+	switch {
+	case typ == timeType:
+		if !s.is("string") || s.Format != "date-time" {
+			c.fail(path, "is a time, the schema must be a date-time string")
+		}
+	case typ.Kind() == reflect.String:
+		if !s.is("string") {
+			c.fail(path, "is a string, the schema is %v", s.Type)
+		}
+	case typ.Kind() == reflect.Bool:
+		if !s.is("boolean") {
+			c.fail(path, "is a bool, the schema is %v", s.Type)
+		}
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64:
+		if !s.is("integer") {
+			c.fail(path, "is an integer, the schema is %v", s.Type)
+		}
+	case typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64:
+		if !s.is("number") {
+			c.fail(path, "is a number, the schema is %v", s.Type)
+		}
+	case typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8:
+		if !s.is("string") || s.Format != "byte" {
+			c.fail(path, "is bytes, the schema must be a byte string")
+		}
+	case typ.Kind() == reflect.Slice:
+		if !s.is("array") || s.Items == nil {
+			c.fail(path, "is a slice, the schema is %v", s.Type)
+			return
+		}
+		c.check(path+"[]", typ.Elem(), s.Items, true)
+	case typ.Kind() == reflect.Map:
+		if !s.is("object") {
+			c.fail(path, "is a map, the schema is %v", s.Type)
+			return
+		}
+		if s.AdditionalProperties != nil {
+			c.check(path+"{}", typ.Elem(), s.AdditionalProperties, true)
+		}
+	case typ.Kind() == reflect.Struct:
+		if !s.is("object") {
+			c.fail(path, "is a struct, the schema is %v", s.Type)
+			return
+		}
+		if s.Properties == nil {
+			// An object the document leaves opaque, such as a gptscript call frame.
+			return
+		}
+		c.checkFields(path, typ, s)
+	default:
+		c.fail(path, "has unsupported type %v", typ)
+	}
+}
+
+// merge combines the object schemas of an allOf into one, a single schema is returned as it is.
+func (c *schemaChecker) merge(parts []*schema) *schema {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	merged := &schema{Type: schemaType{"object"}, Properties: map[string]*schema{}}
+	for _, part := range parts {
+		if part.Ref != "" {
+			part = c.schemas[strings.TrimPrefix(part.Ref, "#/components/schemas/")]
+		}
+		if part == nil {
+			continue
+		}
+		for name, property := range part.Properties {
+			merged.Properties[name] = property
+		}
+		merged.Required = append(merged.Required, part.Required...)
+	}
+	return merged
+}
+
+func (c *schemaChecker) checkFields(path string, typ reflect.Type, s *schema) {
+	fields := map[string]reflect.StructField{}
+	omitEmpty := map[string]bool{}
+	for _, field := range reflect.VisibleFields(typ) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+		omitEmpty[name] = slices.Contains(strings.Split(opts, ","), "omitempty")
+	}
+
+	for name := range s.Properties {
+		if _, ok := fields[name]; !ok {
+			c.fail(path, "property %v has no field", name)
+		}
+	}
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			c.fail(path, "required property %v is not defined", name)
+		}
+	}
+	for name, field := range fields {
+		property, ok := s.Properties[name]
+		if !ok {
+			c.fail(path, "field %v is not documented", name)
+			continue
+		}
+		required := slices.Contains(s.Required, name)
+		if required && omitEmpty[name] {
+			c.fail(path, "required property %v is omitted when empty", name)
+		}
+		c.check(path+"."+name, field.Type, property, required)
+	}
+}
@@ -0,0 +1,191 @@
+package organization
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// InviteMember invites a registered user to the organization with a role, or changes the role of their invitation.
+// Only owners can invite. The user is told in their notifications, and only becomes a member once they accept.
+func (h *Handler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	var req api.OrganizationMemberRequest
+	if !api.DecodeValid(w, r, &req) || !h.requireOwner(w, r, orgID, uid) {
+		return
+	}
+
+	user, err := h.queries.GetUserFromEmail(r.Context(), req.Email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"email": "is not a registered user"})
+		return
+	} else if err != nil {
+		api.DBError(w, err, "user")
+		return
+	}
+	if _, err := h.queries.GetOrganizationMember(r.Context(), db.GetOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         user.ID,
+	}); err == nil {
+		api.Conflict(w, fmt.Sprintf("%v is already a member", user.Email))
+		return
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		api.DBError(w, err, "organization member")
+		return
+	}
+
+	organization, err := h.queries.GetOrganizationForMember(r.Context(), db.GetOrganizationForMemberParams{
+		ID:     orgID,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "organization")
+		return
+	}
+	inviter, err := h.queries.GetUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "user")
+		return
+	}
+	invitation, err := h.queries.UpsertOrganizationInvitation(r.Context(), db.UpsertOrganizationInvitationParams{
+		OrganizationID: orgID,
+		UserID:         user.ID,
+		Role:           req.Role,
+		InvitedBy:      uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "invitation")
+		return
+	}
+
+	content := fmt.Sprintf("%v invited you to join %v as %v. Accept or decline the invitation under /api/invitations.",
+		inviter.Name, organization.Organization.Name, req.Role)
+	if err := h.queries.CreateMessage(r.Context(), db.CreateMessageParams{
+		Content: &content,
+		UserID:  user.ID,
+	}); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to notify the invited user: %w", err))
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, api.NewOrganizationInvitation(invitation, organization.Organization.Name, user.Name, user.Email, inviter.Name))
+}
+
+// ListInvitations lists the invitations of the organization that wait for an answer. Every member can see them.
+func (h *Handler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.queries.GetOrganizationMember(r.Context(), db.GetOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         uid,
+	}); err != nil {
+		api.DBError(w, err, "organization")
+		return
+	}
+
+	rows, err := h.queries.ListOrganizationInvitations(r.Context(), orgID)
+	if err != nil {
+		api.DBError(w, err, "invitations")
+		return
+	}
+	invitations := make([]api.OrganizationInvitation, 0, len(rows))
+	for _, row := range rows {
+		invitations = append(invitations, api.NewOrganizationInvitation(row.OrganizationInvitation, row.OrganizationName, row.Name, row.Email, row.InviterName))
+	}
+	api.WriteJSON(w, http.StatusOK, invitations)
+}
+
+// RevokeInvitation withdraws an invitation of the organization. Only owners can revoke.
+func (h *Handler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	invitationID, ok := api.PathID(w, r, "invitationId")
+	if !ok || !h.requireOwner(w, r, orgID, uid) {
+		return
+	}
+
+	n, err := h.queries.DeleteOrganizationInvitation(r.Context(), db.DeleteOrganizationInvitationParams{
+		ID:             invitationID,
+		OrganizationID: orgID,
+	})
+	if err != nil {
+		api.DBError(w, err, "invitation")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "invitation")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListUserInvitations lists the invitations the signed in user received, newest first.
+func (h *Handler) ListUserInvitations(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+	rows, err := h.queries.ListOrganizationInvitationsForUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "invitations")
+		return
+	}
+	invitations := make([]api.OrganizationInvitation, 0, len(rows))
+	for _, row := range rows {
+		invitations = append(invitations, api.NewOrganizationInvitation(row.OrganizationInvitation, row.OrganizationName, row.Name, row.Email, row.InviterName))
+	}
+	api.WriteJSON(w, http.StatusOK, invitations)
+}
+
+// AcceptInvitation makes the signed in user a member of the organization of their invitation, with its role.
+func (h *Handler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	member, err := h.queries.AcceptOrganizationInvitation(r.Context(), db.AcceptOrganizationInvitationParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "invitation")
+		return
+	}
+	user, err := h.queries.GetUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "user")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewOrganizationMember(member, user.Name, user.Email))
+}
+
+// DeclineInvitation drops an invitation the signed in user received.
+func (h *Handler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	n, err := h.queries.DeleteOrganizationInvitationForUser(r.Context(), db.DeleteOrganizationInvitationForUserParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "invitation")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "invitation")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,290 @@
+package organization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type Handler struct {
+	queries *db.Queries
+}
+
+func NewHandler(queries *db.Queries) *Handler {
+	return &Handler{queries: queries}
+}
+
+func (h *Handler) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+	rows, err := h.queries.ListOrganizationsForUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "organizations")
+		return
+	}
+	organizations := make([]api.Organization, 0, len(rows))
+	for _, row := range rows {
+		organizations = append(organizations, api.NewOrganization(row.Organization, row.Role))
+	}
+	api.WriteJSON(w, http.StatusOK, organizations)
+}
+
+// CreateOrganization creates an organization with the signed in user as its owner.
+func (h *Handler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+	var req api.OrganizationRequest
+	if !api.DecodeValid(w, r, &req) {
+		return
+	}
+
+	organization, err := h.queries.CreateOrganization(r.Context(), req.Name)
+	if err != nil {
+		api.DBError(w, err, "organization")
+		return
+	}
+	if _, err := h.queries.UpsertOrganizationMember(r.Context(), db.UpsertOrganizationMemberParams{
+		OrganizationID: organization.ID,
+		UserID:         uid,
+		Role:           api.RoleOwner,
+	}); err != nil {
+		api.DBError(w, err, "organization member")
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, api.NewOrganization(organization, api.RoleOwner))
+}
+
+func (h *Handler) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	row, err := h.queries.GetOrganizationForMember(r.Context(), db.GetOrganizationForMemberParams{
+		ID:     orgID,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "organization")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewOrganization(row.Organization, row.Role))
+}
+
+func (h *Handler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	var req api.OrganizationRequest
+	if !api.DecodeValid(w, r, &req) || !h.requireOwner(w, r, orgID, uid) {
+		return
+	}
+
+	organization, err := h.queries.UpdateOrganization(r.Context(), db.UpdateOrganizationParams{
+		ID:   orgID,
+		Name: req.Name,
+	})
+	if err != nil {
+		api.DBError(w, err, "organization")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewOrganization(organization, api.RoleOwner))
+}
+
+// DeleteOrganization deletes the organization with its shared contexts. Tasks assigned through it go back to their
+// owners.
+func (h *Handler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok || !h.requireOwner(w, r, orgID, uid) {
+		return
+	}
+
+	n, err := h.queries.DeleteOrganization(r.Context(), orgID)
+	if err != nil {
+		api.DBError(w, err, "organization")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "organization")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.queries.GetOrganizationMember(r.Context(), db.GetOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         uid,
+	}); err != nil {
+		api.DBError(w, err, "organization")
+		return
+	}
+
+	rows, err := h.queries.ListOrganizationMembers(r.Context(), orgID)
+	if err != nil {
+		api.DBError(w, err, "organization members")
+		return
+	}
+	members := make([]api.OrganizationMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, api.NewOrganizationMember(row.OrganizationMember, row.Name, row.Email))
+	}
+	api.WriteJSON(w, http.StatusOK, members)
+}
+
+// UpdateMember changes the role of a member. Only owners can manage members, and the last owner cannot be demoted.
+// Users who are not members yet are invited with InviteMember, they join when they accept.
+func (h *Handler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	var req api.OrganizationMemberRequest
+	if !api.DecodeValid(w, r, &req) || !h.requireOwner(w, r, orgID, uid) {
+		return
+	}
+
+	user, err := h.queries.GetUserFromEmail(r.Context(), req.Email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"email": "is not a registered user"})
+		return
+	} else if err != nil {
+		api.DBError(w, err, "user")
+		return
+	}
+	if _, err := h.queries.GetOrganizationMember(r.Context(), db.GetOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         user.ID,
+	}); errors.Is(err, pgx.ErrNoRows) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"email": "is not a member, invite them first"})
+		return
+	} else if err != nil {
+		api.DBError(w, err, "organization member")
+		return
+	}
+	if req.Role != api.RoleOwner {
+		if ok, err := h.keepsOwner(r.Context(), orgID, user.ID); err != nil {
+			api.DBError(w, err, "organization members")
+			return
+		} else if !ok {
+			api.Conflict(w, "the organization needs another owner first")
+			return
+		}
+	}
+
+	member, err := h.queries.UpsertOrganizationMember(r.Context(), db.UpsertOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         user.ID,
+		Role:           req.Role,
+	})
+	if err != nil {
+		api.DBError(w, err, "organization member")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewOrganizationMember(member, user.Name, user.Email))
+}
+
+// RemoveMember removes a member from the organization. Owners can remove anyone, and every member can leave. The
+// contexts the member shared become private again, and tasks assigned to or by the member go back to their owners.
+func (h *Handler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	orgID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	memberID, ok := api.PathID(w, r, "userId")
+	if !ok {
+		return
+	}
+	if memberID != uid && !h.requireOwner(w, r, orgID, uid) {
+		return
+	}
+
+	if ok, err := h.keepsOwner(r.Context(), orgID, memberID); err != nil {
+		api.DBError(w, err, "organization members")
+		return
+	} else if !ok {
+		api.Conflict(w, "the organization needs another owner first")
+		return
+	}
+
+	n, err := h.queries.DeleteOrganizationMember(r.Context(), db.DeleteOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         memberID,
+	})
+	if err != nil {
+		api.DBError(w, err, "organization member")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "organization member")
+		return
+	}
+
+	if err := h.queries.ClearTaskAssignmentsForMember(r.Context(), db.ClearTaskAssignmentsForMemberParams{
+		OrganizationID: orgID,
+		UserID:         memberID,
+	}); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to take back tasks of removed member: %w", err))
+		return
+	}
+	if err := h.queries.UnshareContextsOfMember(r.Context(), db.UnshareContextsOfMemberParams{
+		OrganizationID: orgID,
+		UserID:         memberID,
+	}); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to unshare contexts of removed member: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireOwner answers with a 404 unless the user is a member of the organization, and with a 403 unless they own it.
+func (h *Handler) requireOwner(w http.ResponseWriter, r *http.Request, orgID, uid pgtype.UUID) bool {
+	member, err := h.queries.GetOrganizationMember(r.Context(), db.GetOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "organization")
+		return false
+	}
+	if member.Role != api.RoleOwner {
+		api.Forbidden(w, "only owners can manage the organization")
+		return false
+	}
+	return true
+}
+
+// keepsOwner reports whether the organization still has an owner once the user stops being one.
+func (h *Handler) keepsOwner(ctx context.Context, orgID, uid pgtype.UUID) (bool, error) {
+	member, err := h.queries.GetOrganizationMember(ctx, db.GetOrganizationMemberParams{
+		OrganizationID: orgID,
+		UserID:         uid,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if member.Role != api.RoleOwner {
+		return true, nil
+	}
+	owners, err := h.queries.CountOrganizationOwners(ctx, orgID)
+	if err != nil {
+		return false, err
+	}
+	return owners > 1, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/db"
+	"ethan/pkg/graphfake"
+	"ethan/pkg/llmfake"
+	"ethan/pkg/provider"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/server/api"
+	"ethan/pkg/server/connection"
+
+	"github.com/gorilla/websocket"
+	"github.com/gptscript-ai/go-gptscript"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TestDelegateRole checks that a task assigned to a delegate of an organization does not run with the token of the
+// task's owner: it is refused until the owner delegates their mailbox, and then runs with the delegate's own
+// credentials on the owner's mailbox.
+func TestDelegateRole(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	fake := startGraph(t)
+	startLLM(t,
+		llmfake.Fixture{
+			Name:     "introduction",
+			Times:    1,
+			Match:    llmfake.Match{Contains: []string{"helping me scheduling meeting", "is their delegate"}},
+			Response: llmfake.Response{Content: "Hi, I work on the mailbox of your principal."},
+		},
+		llmfake.Fixture{
+			Name:  "send-email",
+			Times: 1,
+			Match: llmfake.Match{LastRole: "user", LastContains: []string{"send Bob an email"}, Tool: "sendEmail"},
+			Response: llmfake.Response{ToolCalls: []llmfake.ToolCall{{
+				Name:      "sendEmail",
+				Arguments: json.RawMessage(`{"email-subject": "Offsite", "email-content": "Are you free on May 1st?", "email-recipient-to": "bob@example.com"}`),
+			}}},
+		},
+		llmfake.Fixture{
+			Name:     "sent",
+			Times:    1,
+			Match:    llmfake.Match{LastRole: "tool", LastContains: []string{"succeeded"}},
+			Response: llmfake.Response{Content: "The email was sent to Bob."},
+		},
+	)
+	srv := testServer(t, newHandlers(queries, provider.Registry{graph.Name: graph.New}))
+	t.Setenv("PUBLIC_URL", srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go connection.Listen(ctx, pool)
+
+	owner := createUser(t, queries, "owner")
+	assistant := createUser(t, queries, "assistant")
+	ownerMailbox := connectGraph(t, queries, fake, owner)
+	connectGraph(t, queries, fake, assistant)
+	fake.AddMailbox(graphfake.Mailbox{User: graphfake.User{DisplayName: "Bob", Mail: "bob@example.com"}})
+	// Exchange lets the assistant work on the owner's mailbox, as the owner would set up in Outlook.
+	m, _ := fake.Mailbox(owner.Email)
+	m.Delegates = []string{assistant.Email}
+	fake.AddMailbox(m)
+	// Should the owner's token be used, sending fails.
+	if _, err := pool.Exec(ctx, "UPDATE mailbox_connections SET token = 'revoked' WHERE id = $1", ownerMailbox.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	org, err := queries.CreateOrganization(ctx, "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for user, role := range map[pgtype.UUID]string{owner.ID: api.RoleOwner, assistant.ID: api.RoleDelegate} {
+		if _, err := queries.UpsertOrganizationMember(ctx, db.UpsertOrganizationMemberParams{
+			OrganizationID: org.ID,
+			UserID:         user,
+			Role:           role,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	task, err := queries.CreateTask(ctx, db.CreateTaskParams{UserID: owner.ID, Name: "Plan the offsite", ConnectionID: ownerMailbox.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := queries.AssignTask(ctx, db.AssignTaskParams{ID: task.ID, UserID: owner.ID, AssigneeID: assistant.ID, OrganizationID: org.ID}); err != nil || n != 1 {
+		t.Fatalf("AssignTask() = %v, %v", n, err)
+	}
+
+	token := signIn(t, srv, queries, assistant)
+	if status, body := call(t, srv, token, http.MethodGet, "/tasks/"+id(task.ID)+"/run", nil); status != http.StatusConflict {
+		t.Fatalf("running the task without a delegation = %v %s, want 409", status, body)
+	}
+
+	status, body := call(t, srv, signIn(t, srv, queries, owner), http.MethodPost, "/delegations", map[string]any{
+		"delegateEmail": assistant.Email,
+		"mailboxId":     id(ownerMailbox.ID),
+		"permissions":   []string{"mail", "send", "calendar"},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("delegating the mailbox = %v %s", status, body)
+	}
+	var delegation api.Delegation
+	if err := json.Unmarshal(body, &delegation); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, frames := runTask(t, srv, token, task)
+	waitFor(t, frames, "the introduction", func(f runFrame) bool {
+		return f.Frame.Type == gptscript.EventTypeCallFinish && strings.Contains(f.output(), "your principal")
+	})
+	// The schedule of Bob is read from the owner's calendar, the proposed time uses up the answer to tool results of
+	// the chat fixtures.
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("Please find a time with Bob")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, frames, "the proposed time", func(f runFrame) bool {
+		return f.Frame.Type == gptscript.EventTypeCallFinish && strings.Contains(f.output(), "Bob is free")
+	})
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("Yes, send Bob an email")); err != nil {
+		t.Fatal(err)
+	}
+	pending := waitFor(t, frames, "the email to approve", func(f runFrame) bool {
+		return f.Approval != nil && f.Approval.Status == approval.StatusPending
+	}).Approval
+	if delegation.MailboxID == nil || pending.MailboxID != *delegation.MailboxID {
+		t.Errorf("mailbox of the email = %v, want the assistant's delegated mailbox of %s", pending.MailboxID, body)
+	}
+	if status, body := call(t, srv, token, http.MethodPost, "/approvals/"+pending.ID, api.ApprovalRequest{Decision: api.DecisionApprove}); status != http.StatusOK {
+		t.Fatalf("approving the email = %v %s", status, body)
+	}
+	waitFor(t, frames, "the answer to the approval", func(f runFrame) bool {
+		return f.Frame.Type == gptscript.EventTypeCallFinish && strings.Contains(f.output(), "The email was sent")
+	})
+	if got := folder(t, fake, owner.Email, "sentitems"); len(got) != 1 || got[0].Subject != "Offsite" {
+		t.Errorf("Sent Items of the owner = %+v, want the email sent on their behalf", got)
+	}
+}
+
+// TestOrganizationInvitation checks that owners can only invite users to an organization, who join when they accept
+// and stay out when they decline.
+func TestOrganizationInvitation(t *testing.T) {
+	queries := testDB(t)
+	srv := testServer(t, newHandlers(queries, nil))
+
+	owner := createUser(t, queries, "owner")
+	invited := createUser(t, queries, "invited")
+	declining := createUser(t, queries, "declining")
+	ownerToken := signIn(t, srv, queries, owner)
+	invitedToken := signIn(t, srv, queries, invited)
+	decliningToken := signIn(t, srv, queries, declining)
+
+	status, body := call(t, srv, ownerToken, http.MethodPost, "/organizations", map[string]any{"name": "Team"})
+	if status != http.StatusCreated {
+		t.Fatalf("creating the organization = %v %s", status, body)
+	}
+	var org api.Organization
+	if err := json.Unmarshal(body, &org); err != nil {
+		t.Fatal(err)
+	}
+	orgPath := "/organizations/" + org.ID
+
+	// Members can no longer be added directly.
+	if status, body := call(t, srv, ownerToken, http.MethodPost, orgPath+"/members", map[string]any{"email": invited.Email, "role": "delegate"}); status != http.StatusBadRequest {
+		t.Errorf("adding a member without an invitation = %v %s, want 400", status, body)
+	}
+
+	invite := func(user db.User) api.OrganizationInvitation {
+		t.Helper()
+		status, body := call(t, srv, ownerToken, http.MethodPost, orgPath+"/invitations", map[string]any{"email": user.Email, "role": "delegate"})
+		if status != http.StatusCreated {
+			t.Fatalf("inviting %v = %v %s", user.Email, status, body)
+		}
+		var invitation api.OrganizationInvitation
+		if err := json.Unmarshal(body, &invitation); err != nil {
+			t.Fatal(err)
+		}
+		return invitation
+	}
+	invitation, declined := invite(invited), invite(declining)
+
+	// The invitation alone grants nothing.
+	if status, body := call(t, srv, invitedToken, http.MethodGet, orgPath, nil); status != http.StatusNotFound {
+		t.Errorf("GET the organization before accepting = %v %s, want 404", status, body)
+	}
+	status, body = call(t, srv, invitedToken, http.MethodGet, "/invitations", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), invitation.ID) || strings.Contains(string(body), declined.ID) {
+		t.Errorf("GET the invitations = %v %s, want only the user's own", status, body)
+	}
+	status, body = call(t, srv, invitedToken, http.MethodGet, "/messages", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), "invited you to join Team") {
+		t.Errorf("GET the notifications = %v %s, want the invitation", status, body)
+	}
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/invitations/" + declined.ID + "/accept"},
+		{http.MethodDelete, "/invitations/" + declined.ID},
+	} {
+		if status, body := call(t, srv, invitedToken, req.method, req.path, nil); status != http.StatusNotFound {
+			t.Errorf("%s %s of another user = %v %s, want 404", req.method, req.path, status, body)
+		}
+	}
+
+	status, body = call(t, srv, invitedToken, http.MethodPost, "/invitations/"+invitation.ID+"/accept", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), `"Role":"delegate"`) {
+		t.Fatalf("accepting the invitation = %v %s, want a delegate", status, body)
+	}
+	if status, body := call(t, srv, invitedToken, http.MethodPost, "/invitations/"+invitation.ID+"/accept", nil); status != http.StatusNotFound {
+		t.Errorf("accepting the invitation again = %v %s, want 404", status, body)
+	}
+	if status, body := call(t, srv, decliningToken, http.MethodDelete, "/invitations/"+declined.ID, nil); status != http.StatusNoContent {
+		t.Errorf("declining the invitation = %v %s", status, body)
+	}
+
+	status, body = call(t, srv, ownerToken, http.MethodGet, orgPath+"/members", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), invited.Email) || strings.Contains(string(body), declining.Email) {
+		t.Errorf("GET the members = %v %s, want the user who accepted only", status, body)
+	}
+	status, body = call(t, srv, ownerToken, http.MethodGet, orgPath+"/invitations", nil)
+	if status != http.StatusOK || strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("GET the invitations of the organization = %v %s, want none left", status, body)
+	}
+	revoked := invite(declining)
+	if status, body := call(t, srv, ownerToken, http.MethodDelete, orgPath+"/invitations/"+revoked.ID, nil); status != http.StatusNoContent {
+		t.Errorf("revoking the invitation = %v %s", status, body)
+	}
+	if status, body := call(t, srv, decliningToken, http.MethodPost, "/invitations/"+revoked.ID+"/accept", nil); status != http.StatusNotFound {
+		t.Errorf("accepting a revoked invitation = %v %s, want 404", status, body)
+	}
+	if status, body := call(t, srv, ownerToken, http.MethodPost, orgPath+"/invitations", map[string]any{"email": invited.Email}); status != http.StatusConflict {
+		t.Errorf("inviting a member = %v %s, want 409", status, body)
+	}
+	if status, body := call(t, srv, ownerToken, http.MethodPost, orgPath+"/members", map[string]any{"email": invited.Email, "role": "member"}); status != http.StatusOK {
+		t.Errorf("changing the role of the member = %v %s", status, body)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
+	"ethan/pkg/server/audit"
+	"ethan/pkg/server/auth"
+	"ethan/pkg/server/contexts"
+	"ethan/pkg/server/delegation"
+	"ethan/pkg/server/message"
+	"ethan/pkg/server/organization"
+	"ethan/pkg/server/spam"
+	"ethan/pkg/server/subscribe"
+	"ethan/pkg/server/task"
+
+	"github.com/gorilla/mux"
+)
+
+// handlers are the handlers the routes of the API dispatch to.
+type handlers struct {
+	auth         *auth.Handler
+	task         *task.Handler
+	contexts     *contexts.Handler
+	organization *organization.Handler
+	delegation   *delegation.Handler
+	subscribe    *subscribe.Handler
+	message      *message.Handler
+	spam         *spam.Handler
+	audit        *audit.Handler
+}
+
+func newHandlers(queries *db.Queries, providers provider.Registry) handlers {
+	return handlers{
+		auth:         auth.NewHandler(queries, providers),
+		task:         task.NewHandler(queries, providers),
+		contexts:     contexts.NewHandler(queries),
+		organization: organization.NewHandler(queries),
+		delegation:   delegation.NewHandler(queries),
+		subscribe:    subscribe.NewHandler(queries, providers),
+		message:      message.NewHandler(queries),
+		spam:         spam.NewHandler(queries, providers),
+		audit:        audit.NewHandler(queries),
+	}
+}
+
+// newRouter routes /api to the handlers and every other path to ui. The routes under /api are documented in
+// api.OpenAPI, TestOpenAPI checks that both agree.
+func newRouter(h handlers, ui http.Handler) *mux.Router {
+	r := mux.NewRouter()
+	apiRouter := r.PathPrefix("/api").Subrouter()
+
+	// Auth
+	apiRouter.HandleFunc("/login", h.auth.HandleMicrosoftLogin)
+	apiRouter.HandleFunc("/auth/callback", h.auth.HandleMicrosoftCallback)
+	apiRouter.HandleFunc("/login/imap", h.auth.HandleIMAPLogin).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/login/providers", h.auth.ListLoginProviders).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/login/oidc/{provider}", h.auth.HandleOIDCLogin).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/auth/oidc/{provider}/callback", h.auth.HandleOIDCCallback).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/refresh", h.auth.Refresh).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/logout", h.auth.Logout).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/logout/all", h.auth.Middleware(h.auth.LogoutAll)).Methods(http.MethodPost)
+
+	// Webhook
+	apiRouter.HandleFunc("/webhook", h.subscribe.Subscribe)
+	apiRouter.HandleFunc("/webhook/lifecycle", h.subscribe.Lifecycle)
+
+	// User
+	apiRouter.HandleFunc("/me", h.auth.Middleware(h.auth.HandleMe)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/me", h.auth.Middleware(h.auth.UpdateUser)).Methods(http.MethodPost)
+
+	// Mailboxes
+	apiRouter.HandleFunc("/mailboxes", h.auth.Middleware(h.auth.ListMailboxes)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mailboxes/microsoft", h.auth.Middleware(h.auth.LinkMicrosoftMailbox)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mailboxes/imap", h.auth.Middleware(h.auth.LinkIMAPMailbox)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/mailboxes/{id}", h.auth.Middleware(h.auth.UpdateMailbox)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/mailboxes/{id}", h.auth.Middleware(h.auth.DeleteMailbox)).Methods(http.MethodDelete)
+
+	// Sessions
+	apiRouter.HandleFunc("/sessions", h.auth.Middleware(h.auth.ListSessions)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/sessions/{id}", h.auth.Middleware(h.auth.DeleteSession)).Methods(http.MethodDelete)
+
+	// API tokens
+	apiRouter.HandleFunc("/tokens", h.auth.Middleware(h.auth.ListAPITokens)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/tokens", h.auth.Middleware(h.auth.CreateAPIToken)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/tokens/{id}", h.auth.Middleware(h.auth.DeleteAPIToken)).Methods(http.MethodDelete)
+
+	// Task
+	apiRouter.HandleFunc("/tasks", h.auth.Middleware(h.task.ListTasks)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/tasks", h.auth.Middleware(h.task.CreateTask)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/tasks/{id}", h.auth.Middleware(h.task.GetTask)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/tasks/{id}", h.auth.Middleware(h.task.UpdateTask)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/tasks/{id}", h.auth.Middleware(h.task.DeleteTask)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/tasks/{id}/assignee", h.auth.Middleware(h.task.AssignTask)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/tasks/{id}/run", h.auth.Middleware(h.task.RunTask))
+	apiRouter.HandleFunc("/tasks/{id}/drafts", h.auth.Middleware(h.task.ListDrafts)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/tasks/{id}/drafts/{draftId}", h.auth.Middleware(h.task.GetDraft)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/tasks/{id}/drafts/{draftId}", h.auth.Middleware(h.task.UpdateDraft)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/tasks/{id}/drafts/{draftId}", h.auth.Middleware(h.task.DeleteDraft)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/tasks/{id}/drafts/{draftId}/send", h.auth.Middleware(h.task.SendDraft)).Methods(http.MethodPost)
+
+	// Approvals, the link pages authenticate with the token of the notification link
+	apiRouter.HandleFunc("/approvals", h.auth.Middleware(h.task.ListApprovals)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/approvals/{id}", h.auth.Middleware(h.task.GetApproval)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/approvals/{id}", h.auth.Middleware(h.task.DecideApproval)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/approvals/{id}/link", h.task.ApprovalLink).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/approvals/{id}/link", h.task.DecideApprovalLink).Methods(http.MethodPost)
+
+	// Context
+	apiRouter.HandleFunc("/contexts", h.auth.Middleware(h.contexts.ListContext)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/contexts", h.auth.Middleware(h.contexts.CreateContext)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/contexts/{id}", h.auth.Middleware(h.contexts.UpdateContext)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/contexts/{id}", h.auth.Middleware(h.contexts.DeleteContext)).Methods(http.MethodDelete)
+
+	// Organizations
+	apiRouter.HandleFunc("/organizations", h.auth.Middleware(h.organization.ListOrganizations)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/organizations", h.auth.Middleware(h.organization.CreateOrganization)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/organizations/{id}", h.auth.Middleware(h.organization.GetOrganization)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/organizations/{id}", h.auth.Middleware(h.organization.UpdateOrganization)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/organizations/{id}", h.auth.Middleware(h.organization.DeleteOrganization)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/organizations/{id}/members", h.auth.Middleware(h.organization.ListMembers)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/organizations/{id}/members", h.auth.Middleware(h.organization.UpdateMember)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/organizations/{id}/members/{userId}", h.auth.Middleware(h.organization.RemoveMember)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/organizations/{id}/invitations", h.auth.Middleware(h.organization.ListInvitations)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/organizations/{id}/invitations", h.auth.Middleware(h.organization.InviteMember)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/organizations/{id}/invitations/{invitationId}", h.auth.Middleware(h.organization.RevokeInvitation)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/invitations", h.auth.Middleware(h.organization.ListUserInvitations)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/invitations/{id}/accept", h.auth.Middleware(h.organization.AcceptInvitation)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/invitations/{id}", h.auth.Middleware(h.organization.DeclineInvitation)).Methods(http.MethodDelete)
+
+	// Delegations
+	apiRouter.HandleFunc("/delegations", h.auth.Middleware(h.delegation.ListDelegations)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/delegations", h.auth.Middleware(h.delegation.CreateDelegation)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/delegations/{id}", h.auth.Middleware(h.delegation.DeleteDelegation)).Methods(http.MethodDelete)
+
+	// Messages
+	apiRouter.HandleFunc("/messages", h.auth.Middleware(h.message.ListMessages)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/messages/{id}", h.auth.Middleware(h.message.UpdateMessage)).Methods(http.MethodPost)
+
+	// Spam
+	apiRouter.HandleFunc("/spams", h.auth.Middleware(h.spam.ListSpams)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/spams/{id}", h.auth.Middleware(h.spam.GetSpam)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/spams/{id}/moveback", h.auth.Middleware(h.spam.MoveSpam)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/spams/{id}", h.auth.Middleware(h.spam.DeleteSpam)).Methods(http.MethodDelete)
+
+	// Audit
+	apiRouter.HandleFunc("/audit", h.auth.Middleware(h.audit.ListAuditEvents)).Methods(http.MethodGet)
+
+	// API document
+	apiRouter.HandleFunc("/openapi.json", api.ServeOpenAPI).Methods(http.MethodGet)
+
+	r.HandleFunc("/.well-known/jwks.json", auth.ServeJWKS).Methods(http.MethodGet)
+	r.PathPrefix("/").Handler(ui)
+
+	return r
+}
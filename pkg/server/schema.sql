@@ -13,6 +13,8 @@ CREATE TABLE IF NOT EXISTS users (
 );
 
 ALTER TABLE users ADD COLUMN IF NOT EXISTs check_spam boolean;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS provider text NOT NULL DEFAULT 'graph';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS subscription_client_state text;
 
 CREATE TABLE IF NOT EXISTS tasks (
     id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -72,3 +74,387 @@ CREATE TABLE IF NOT EXISTS spam_emails (
     REFERENCES users(id)
     ON DELETE CASCADE
 );
+
+CREATE TABLE IF NOT EXISTS webhook_jobs (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    message_id text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, message_id),
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_jobs_status_run_at ON webhook_jobs (status, run_at);
+
+ALTER TABLE webhook_jobs ADD COLUMN IF NOT EXISTS kind text NOT NULL DEFAULT 'inbox';
+ALTER TABLE webhook_jobs ADD COLUMN IF NOT EXISTS change_type text NOT NULL DEFAULT 'created';
+ALTER TABLE webhook_jobs DROP CONSTRAINT IF EXISTS webhook_jobs_user_id_message_id_key;
+
+ALTER TABLE spam_emails ADD COLUMN IF NOT EXISTS internet_message_id text;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id text PRIMARY KEY,
+    user_id uuid NOT NULL,
+    kind text NOT NULL,
+    expire_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, kind),
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS inbox_delta_token text;
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'active';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS last_error text;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS renewed_at TIMESTAMPTZ;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS last_lifecycle_event text;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS last_lifecycle_event_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state text PRIMARY KEY,
+    expire_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS spam_check_skips (
+    user_id uuid NOT NULL,
+    message_id text NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, message_id),
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    name text NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    scopes text[] NOT NULL,
+    expire_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    refresh_token_hash text NOT NULL UNIQUE,
+    previous_refresh_token_hash text,
+    user_agent text,
+    expire_at TIMESTAMPTZ NOT NULL,
+    refreshed_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS sessions_previous_refresh_token_hash ON sessions (previous_refresh_token_hash);
+
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS provider text;
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS code_verifier text;
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS nonce text;
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS user_id uuid;
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    user_id uuid NOT NULL,
+    email text,
+    last_login_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject),
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mailbox_connections (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    provider text NOT NULL,
+    email text NOT NULL UNIQUE,
+    token text NOT NULL,
+    refresh_token text,
+    expire_at TIMESTAMPTZ,
+    subscription_disabled boolean NOT NULL DEFAULT false,
+    subscription_client_state text,
+    check_spam boolean NOT NULL DEFAULT false,
+    inbox_delta_token text,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS mailbox_connections_user_id ON mailbox_connections (user_id);
+
+-- The mailbox used to be stored on the user. It moves to a connection with the user's ID, so the clientState of
+-- existing Graph subscriptions and the rows below keep pointing at it.
+INSERT INTO mailbox_connections (
+    id, user_id, provider, email, token, refresh_token, expire_at, subscription_disabled, subscription_client_state,
+    check_spam, inbox_delta_token
+)
+SELECT id, id, provider, email, token, refresh_token, expire_at, COALESCE(subscription_disabled, false),
+       subscription_client_state, COALESCE(check_spam, false), inbox_delta_token
+FROM users WHERE provider <> 'none'
+ON CONFLICT DO NOTHING;
+
+UPDATE users
+SET provider = 'none',
+    token = '',
+    refresh_token = NULL,
+    expire_at = NULL,
+    subscription_id = NULL,
+    subscription_expire_at = NULL,
+    subscription_client_state = NULL,
+    inbox_delta_token = NULL
+WHERE provider <> 'none';
+
+ALTER TABLE users ALTER COLUMN provider SET DEFAULT 'none';
+ALTER TABLE users ALTER COLUMN token SET DEFAULT '';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS connection_id uuid REFERENCES mailbox_connections(id) ON DELETE SET NULL;
+UPDATE tasks SET connection_id = user_id
+WHERE connection_id IS NULL AND user_id IN (SELECT id FROM mailbox_connections);
+
+ALTER TABLE spam_emails ADD COLUMN IF NOT EXISTS connection_id uuid REFERENCES mailbox_connections(id) ON DELETE CASCADE;
+UPDATE spam_emails SET connection_id = user_id
+WHERE connection_id IS NULL AND user_id IN (SELECT id FROM mailbox_connections);
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS connection_id uuid REFERENCES mailbox_connections(id) ON DELETE CASCADE;
+UPDATE subscriptions SET connection_id = user_id
+WHERE connection_id IS NULL AND user_id IN (SELECT id FROM mailbox_connections);
+DELETE FROM subscriptions WHERE connection_id IS NULL;
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_user_id_kind_key;
+CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_connection_kind ON subscriptions (connection_id, kind);
+
+ALTER TABLE webhook_jobs ADD COLUMN IF NOT EXISTS connection_id uuid REFERENCES mailbox_connections(id) ON DELETE CASCADE;
+UPDATE webhook_jobs SET connection_id = user_id
+WHERE connection_id IS NULL AND user_id IN (SELECT id FROM mailbox_connections);
+DELETE FROM webhook_jobs WHERE connection_id IS NULL;
+DROP INDEX IF EXISTS webhook_jobs_dedup;
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_jobs_connection_dedup ON webhook_jobs (connection_id, kind, change_type, message_id);
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name text NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- role is owner, member or delegate. Owners manage the organization, delegates are assistants whose assigned tasks
+-- work with the mailbox of the member who assigned them, through a delegation grant.
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    role text NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, user_id),
+    CONSTRAINT fk_organization_id
+    FOREIGN KEY (organization_id)
+    REFERENCES organizations(id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id ON organization_members (user_id);
+
+-- A context with an organization is shared with its members.
+ALTER TABLE contexts ADD COLUMN IF NOT EXISTS organization_id uuid REFERENCES organizations(id) ON DELETE CASCADE;
+
+-- A task assigned through an organization is visible to the assignee while both stay members.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS assignee_id uuid REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS organization_id uuid REFERENCES organizations(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS tasks_assignee_id ON tasks (assignee_id);
+
+-- Owners invite registered users to an organization, who only become members when they accept. The invitation is
+-- removed then, or when they decline it or an owner revokes it.
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    organization_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    role text NOT NULL,
+    invited_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (organization_id, user_id),
+    CONSTRAINT fk_organization_id
+    FOREIGN KEY (organization_id)
+    REFERENCES organizations(id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS organization_invitations_user_id ON organization_invitations (user_id);
+
+-- A delegation grant lets the delegate, such as an executive assistant, work on a mailbox and calendar the principal
+-- connected. permissions lists what the delegate may do: mail, send and calendar.
+CREATE TABLE IF NOT EXISTS delegation_grants (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    principal_id uuid NOT NULL,
+    delegate_id uuid NOT NULL,
+    mailbox text NOT NULL,
+    permissions text[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_principal_id
+    FOREIGN KEY (principal_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_delegate_id
+    FOREIGN KEY (delegate_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS delegation_grants_delegate_id ON delegation_grants (delegate_id);
+-- A principal with several mailboxes can delegate each of them to the same delegate.
+ALTER TABLE delegation_grants DROP CONSTRAINT IF EXISTS delegation_grants_principal_id_delegate_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS delegation_grants_mailbox ON delegation_grants (principal_id, delegate_id, mailbox);
+
+-- The delegate reaches the principal's mailbox through a connection of their own. It carries a copy of the token of
+-- the delegate's Microsoft mailbox, credential_id, which is kept in sync when that token is refreshed.
+ALTER TABLE mailbox_connections ADD COLUMN IF NOT EXISTS delegation_id uuid REFERENCES delegation_grants(id) ON DELETE CASCADE;
+ALTER TABLE mailbox_connections ADD COLUMN IF NOT EXISTS credential_id uuid REFERENCES mailbox_connections(id) ON DELETE CASCADE;
+ALTER TABLE mailbox_connections ADD COLUMN IF NOT EXISTS delegated_permissions text[];
+
+-- A mailbox has at most one connection of its owner and one of a delegate. Both may exist at the same time, the
+-- delegate's connection is then not watched, so new mail is only processed once.
+ALTER TABLE mailbox_connections DROP CONSTRAINT IF EXISTS mailbox_connections_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS mailbox_connections_owner_email ON mailbox_connections (email) WHERE delegation_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS mailbox_connections_delegate_email ON mailbox_connections (email) WHERE delegation_id IS NOT NULL;
+
+-- audit_events records every outbound action taken on a mailbox, by the assistant's tools or by the server, and is
+-- append-only. user_id is the user the action was taken for, actor is assistant or user, response_ids holds the
+-- IDs Graph answered with. There are no foreign keys, so the trail outlives the users, tasks and mailboxes it names.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    actor text NOT NULL,
+    task_id uuid,
+    mailbox text NOT NULL,
+    tool text NOT NULL,
+    arguments jsonb NOT NULL,
+    response_ids jsonb NOT NULL,
+    status text NOT NULL,
+    error text,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (user_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_mailbox ON audit_events (mailbox, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_task_id ON audit_events (task_id);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
+
+-- pending_actions holds the emails and events the assistant wants to send or create in a task run. They are only sent
+-- or created after the user running the task approves them, as they are or edited, in the app or from the one-time
+-- link of their notification. status is pending, approved while it runs, succeeded, failed or rejected. reported is
+-- set once the outcome was passed on to the assistant.
+CREATE TABLE IF NOT EXISTS pending_actions (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    task_id uuid NOT NULL,
+    connection_id uuid NOT NULL,
+    tool text NOT NULL,
+    arguments jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    result jsonb,
+    error text,
+    link_token_hash text NOT NULL,
+    reported boolean NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    decided_at TIMESTAMPTZ,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_task_id
+    FOREIGN KEY (task_id)
+    REFERENCES tasks(id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_connection_id
+    FOREIGN KEY (connection_id)
+    REFERENCES mailbox_connections(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS pending_actions_user_id ON pending_actions (user_id, created_at);
+CREATE INDEX IF NOT EXISTS pending_actions_task_id ON pending_actions (task_id, status);
+
+-- approval is the pending action a notification asks the user to decide, as the API rendered it when it was requested.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS approval jsonb;
+
+-- task_drafts are the emails the assistant left in the Drafts folder of the mailbox in a task run, for the user to
+-- edit in the app or in their mail client before sending them. status is draft until the draft is sent from the app.
+-- Discarded drafts are deleted.
+CREATE TABLE IF NOT EXISTS task_drafts (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    task_id uuid NOT NULL,
+    connection_id uuid NOT NULL,
+    message_id text NOT NULL,
+    conversation_id text,
+    web_link text,
+    subject text NOT NULL,
+    recipients text[] NOT NULL DEFAULT '{}',
+    status text NOT NULL DEFAULT 'draft',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMPTZ,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_task_id
+    FOREIGN KEY (task_id)
+    REFERENCES tasks(id)
+    ON DELETE CASCADE,
+    CONSTRAINT fk_connection_id
+    FOREIGN KEY (connection_id)
+    REFERENCES mailbox_connections(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS task_drafts_task_id ON task_drafts (task_id, created_at);
+
+CREATE INDEX IF NOT EXISTS tasks_connection_conversation ON tasks (connection_id, conversation_id);
+
+-- A message detected as spam is recorded under its inbox message ID before it is moved, so a retried webhook job
+-- finishes the move instead of processing the message again.
+ALTER TABLE spam_emails ADD COLUMN IF NOT EXISTS inbox_message_id text;
+CREATE UNIQUE INDEX IF NOT EXISTS spam_emails_inbox_message ON spam_emails (connection_id, inbox_message_id);
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/secret"
+	"ethan/pkg/server/auth"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TestRotateTokens checks that RotateTokens encrypts tokens stored in plain text, and re-encrypts them with the new
+// primary key after a rotation while they keep working.
+func TestRotateTokens(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	ctx := context.Background()
+	keys := map[string][]byte{"a": bytes.Repeat([]byte("a"), 32), "b": bytes.Repeat([]byte("b"), 32)}
+	// RotateTokens encrypts the tokens of every mailbox in the database, they are stored in plain text again for the
+	// other tests, which run without a keyring.
+	t.Cleanup(func() {
+		secret.SetKeyring(&secret.Keyring{Primary: "b", Keys: keys})
+		conns, err := queries.ListMailboxConnections(ctx)
+		secret.SetKeyring(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range conns {
+			if err := queries.UpdateMailboxConnectionTokens(ctx, db.UpdateMailboxConnectionTokensParams{
+				ID:           c.ID,
+				Token:        c.Token,
+				RefreshToken: c.RefreshToken,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	user := createUser(t, queries, "alice")
+	refreshToken := secret.String("refresh-token")
+	conn, err := queries.CreateMailboxConnection(ctx, db.CreateMailboxConnectionParams{
+		UserID:       user.ID,
+		Provider:     "microsoft",
+		Email:        user.Email,
+		Token:        "access-token",
+		RefreshToken: &refreshToken,
+		ExpireAt:     pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// raw returns the tokens of the mailbox as they are stored.
+	raw := func() (token, refreshToken string) {
+		t.Helper()
+		if err := pool.QueryRow(ctx, "SELECT token, refresh_token FROM mailbox_connections WHERE id = $1", conn.ID).Scan(&token, &refreshToken); err != nil {
+			t.Fatal(err)
+		}
+		return token, refreshToken
+	}
+	if token, refreshToken := raw(); token != "access-token" || refreshToken != "refresh-token" {
+		t.Fatalf("tokens without a keyring = %v, %v, want them in plain text", token, refreshToken)
+	}
+
+	for _, primary := range []string{"a", "b"} {
+		secret.SetKeyring(&secret.Keyring{Primary: primary, Keys: keys})
+		token, _ := raw()
+		if rotate, err := secret.NeedsRotation(token); err != nil || !rotate {
+			t.Errorf("NeedsRotation() before rotating to %v = %v, %v, want true", primary, rotate, err)
+		}
+		if err := auth.RotateTokens(ctx, queries); err != nil {
+			t.Fatalf("RotateTokens() to %v = %v", primary, err)
+		}
+
+		token, refreshToken := raw()
+		for _, value := range []string{token, refreshToken} {
+			if !strings.HasPrefix(value, "enc:v1:"+primary+":") {
+				t.Errorf("token after rotating to %v = %v, want it encrypted with %v", primary, value, primary)
+			}
+			if rotate, err := secret.NeedsRotation(value); err != nil || rotate {
+				t.Errorf("NeedsRotation() after rotating to %v = %v, %v, want false", primary, rotate, err)
+			}
+		}
+		got, err := queries.GetMailboxConnection(ctx, conn.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Token != "access-token" || got.RefreshToken == nil || *got.RefreshToken != "refresh-token" {
+			t.Errorf("tokens after rotating to %v = %v, %v, want them decrypted", primary, got.Token, got.RefreshToken)
+		}
+	}
+}
@@ -9,16 +9,17 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"sync"
 
 	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/provider/imap"
 	"ethan/pkg/server/auth"
-	"ethan/pkg/server/contexts"
-	"ethan/pkg/server/message"
-	"ethan/pkg/server/spam"
+	"ethan/pkg/server/connection"
+	"ethan/pkg/server/leader"
 	"ethan/pkg/server/subscribe"
-	"ethan/pkg/server/task"
 
-	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sirupsen/logrus"
 )
@@ -27,22 +28,8 @@ import (
 var initSql string
 
 func main() {
-	host := os.Getenv("PG_HOST")
-	port := 5432
-	user := os.Getenv("PG_USER")
-	dbname := os.Getenv("PG_DBNAME")
-	password := os.Getenv("PG_PASSWORD")
-
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
-		"dbname=%s sslmode=disable",
-		host, port, user, dbname)
-
-	if password != "" {
-		psqlInfo += " password=" + password
-	}
-
 	ctx := context.Background()
-	pool, err := pgxpool.New(ctx, psqlInfo)
+	pool, err := pgxpool.New(ctx, db.ConnString())
 	if err != nil {
 		log.Fatal("Error opening database connection: ", err)
 	}
@@ -55,15 +42,44 @@ func main() {
 		log.Fatal(err)
 	}
 
-	go subscribe.PerUser(ctx, queries)
-	go auth.RefreshToken(ctx, queries)
+	providers := provider.Registry{
+		graph.Name: graph.New,
+		imap.Name:  imap.New,
+	}
+
+	if err := auth.CheckSigningKeys(); err != nil {
+		log.Fatal(err)
+	}
+	if err := auth.CheckOIDCProviders(); err != nil {
+		log.Fatal(err)
+	}
 
-	authHandler := auth.NewHandler(queries)
-	taskHandler := task.NewHandler(queries)
-	contextHandler := contexts.NewHandler(queries)
-	subscribeHandler := subscribe.NewHandler(queries)
-	messageHandler := message.NewHandler(queries)
-	spamHandler := spam.NewHandler(queries)
+	h := newHandlers(queries, providers)
+
+	// Every replica processes queued jobs and closes its own task connections, the loops that talk to Graph and the
+	// token endpoint only run on the elected leader.
+	go h.subscribe.ProcessJobs(ctx)
+	go connection.Listen(ctx, pool)
+	go leader.Run(ctx, pool, "background", func(ctx context.Context) {
+		if err := auth.RotateTokens(ctx, queries); err != nil {
+			logrus.Error(fmt.Errorf("failed to rotate token encryption keys: %w", err))
+		}
+
+		var wg sync.WaitGroup
+		for _, loop := range []func(context.Context){
+			func(ctx context.Context) { subscribe.PerUser(ctx, queries) },
+			func(ctx context.Context) { auth.RefreshToken(ctx, queries) },
+			h.subscribe.WatchMailboxes,
+			h.subscribe.SyncInboxes,
+		} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				loop(ctx)
+			}()
+		}
+		wg.Wait()
+	})
 	target, err := url.Parse(os.Getenv("UI_SERVER"))
 	if err != nil {
 		log.Fatal(err)
@@ -72,45 +88,7 @@ func main() {
 	// Set up the reverse proxy
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
-	r := mux.NewRouter()
-	apiRouter := r.PathPrefix("/api").Subrouter()
-
-	// Auth
-	apiRouter.HandleFunc("/login", authHandler.HandleMicrosoftLogin)
-	apiRouter.HandleFunc("/auth/callback", authHandler.HandleMicrosoftCallback)
-
-	// Webhook
-	apiRouter.HandleFunc("/webhook", subscribeHandler.Subscribe)
-
-	// User
-	apiRouter.HandleFunc("/me", auth.Middleware(authHandler.HandleMe)).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/me", auth.Middleware(authHandler.UpdateUser)).Methods(http.MethodPost)
-
-	// Task
-	apiRouter.HandleFunc("/tasks", auth.Middleware(taskHandler.ListTasks)).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/tasks", auth.Middleware(taskHandler.CreateTask)).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/tasks/{id}", auth.Middleware(taskHandler.GetTask)).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/tasks/{id}", auth.Middleware(taskHandler.UpdateTask)).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/tasks/{id}", auth.Middleware(taskHandler.DeleteTask)).Methods(http.MethodDelete)
-	apiRouter.HandleFunc("/tasks/{id}/run", auth.Middleware(taskHandler.RunTask))
-
-	// Context
-	apiRouter.HandleFunc("/contexts", auth.Middleware(contextHandler.ListContext)).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/contexts", auth.Middleware(contextHandler.CreateContext)).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/contexts/{id}", auth.Middleware(contextHandler.UpdateContext)).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/contexts/{id}", auth.Middleware(contextHandler.DeleteContext)).Methods(http.MethodDelete)
-
-	// Messages
-	apiRouter.HandleFunc("/messages", auth.Middleware(messageHandler.ListMessages)).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/messages/{id}", auth.Middleware(messageHandler.UpdateMessage)).Methods(http.MethodPost)
-
-	// Spam
-	apiRouter.HandleFunc("/spams", auth.Middleware(spamHandler.ListSpams)).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/spams/{id}", auth.Middleware(spamHandler.GetSpam)).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/spams/{id}/moveback", auth.Middleware(spamHandler.MoveSpam)).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/spams/{id}", auth.Middleware(spamHandler.DeleteSpam)).Methods(http.MethodDelete)
-
-	r.PathPrefix("/").Handler(proxy)
+	r := newRouter(h, proxy)
 
 	log.Println("Server starting on :8080")
 	log.Fatal(http.ListenAndServe(":8080", r))
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/secret"
+	"ethan/pkg/server/auth"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// refreshSession posts the refresh token to /api/refresh and returns the status with the JWT and the refresh token
+// the server set, which are empty when it set none.
+func refreshSession(t *testing.T, srv *httptest.Server, refreshToken string) (status int, jwt, newRefreshToken string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/refresh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: auth.RefreshTokenName, Value: refreshToken})
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		if cookie.MaxAge < 0 {
+			continue
+		}
+		switch cookie.Name {
+		case auth.JwtTokenName:
+			jwt = cookie.Value
+		case auth.RefreshTokenName:
+			newRefreshToken = cookie.Value
+		}
+	}
+	return resp.StatusCode, jwt, newRefreshToken
+}
+
+// sessionActive reports whether the JWT is still accepted.
+func sessionActive(t *testing.T, srv *httptest.Server, jwt string) bool {
+	t.Helper()
+	status, body := call(t, srv, jwt, http.MethodGet, "/me", nil)
+	if status != http.StatusOK && status != http.StatusUnauthorized {
+		t.Fatalf("GET /me = %v %s", status, body)
+	}
+	return status == http.StatusOK
+}
+
+// TestSessionRefresh checks that refreshing rotates the refresh token, that the token rotated away from still works
+// for the concurrent refreshes of a page within the grace window, and that reusing it later revokes the session with
+// every token it handed out.
+func TestSessionRefresh(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	srv := testServer(t, newHandlers(queries, nil))
+	ctx := context.Background()
+
+	user := createUser(t, queries, "alice")
+	session, first := createSession(t, queries, user)
+
+	status, jwt, second := refreshSession(t, srv, first)
+	if status != http.StatusNoContent || jwt == "" || second == "" || second == first {
+		t.Fatalf("refreshing = %v, JWT %q, refresh token %q, want a new JWT and refresh token", status, jwt, second)
+	}
+	if !sessionActive(t, srv, jwt) {
+		t.Fatal("the refreshed JWT is not accepted")
+	}
+
+	// The requests a page sends when its JWT expired all carry the same refresh token. One of them rotates it, the
+	// others are let through with the token that was just rotated away from.
+	var wg sync.WaitGroup
+	results := make([]struct {
+		status            int
+		jwt, refreshToken string
+	}, 3)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := &results[i]
+			r.status, r.jwt, r.refreshToken = refreshSession(t, srv, second)
+		}()
+	}
+	wg.Wait()
+	var third string
+	for _, r := range results {
+		if r.status != http.StatusNoContent || r.jwt == "" {
+			t.Errorf("concurrent refresh = %v, JWT %q, want a JWT", r.status, r.jwt)
+		} else if !sessionActive(t, srv, r.jwt) {
+			t.Error("the JWT of a concurrent refresh is not accepted")
+		}
+		if r.refreshToken != "" {
+			if third != "" {
+				t.Error("the refresh token was rotated more than once")
+			}
+			third = r.refreshToken
+		}
+	}
+	if third == "" {
+		t.Fatal("none of the concurrent refreshes rotated the refresh token")
+	}
+
+	// Later, the old refresh token can only come from someone who copied it.
+	if _, err := pool.Exec(ctx, "UPDATE sessions SET refreshed_at = now() - interval '1 minute' WHERE id = $1", session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status, jwt, _ := refreshSession(t, srv, second); status != http.StatusUnauthorized || jwt != "" {
+		t.Errorf("reusing the refresh token = %v, JWT %q, want 401", status, jwt)
+	}
+	if sessionActive(t, srv, jwt) {
+		t.Error("the JWT of the session is accepted after the reuse")
+	}
+	for _, refreshToken := range []string{first, third} {
+		if status, _, _ := refreshSession(t, srv, refreshToken); status != http.StatusUnauthorized {
+			t.Errorf("refreshing with another refresh token of the session after the reuse = %v, want 401", status)
+		}
+	}
+	if _, err := queries.GetActiveSession(ctx, db.GetActiveSessionParams{ID: session.ID, UserID: user.ID}); err == nil {
+		t.Error("the session is still active after the reuse")
+	}
+}
+
+// TestLogout checks that logging out revokes only the session of the request, whether it is named by its refresh
+// token or its JWT, and that logging out everywhere revokes every session and forgets the mailbox refresh tokens.
+func TestLogout(t *testing.T) {
+	queries := testDB(t)
+	srv := testServer(t, newHandlers(queries, nil))
+	ctx := context.Background()
+
+	user := createUser(t, queries, "alice")
+	other := createUser(t, queries, "bob")
+	// start begins a session and returns its JWT and refresh token.
+	start := func(user db.User) (string, string) {
+		t.Helper()
+		_, refreshToken := createSession(t, queries, user)
+		status, jwt, refreshToken := refreshSession(t, srv, refreshToken)
+		if status != http.StatusNoContent {
+			t.Fatalf("starting a session = %v", status)
+		}
+		return jwt, refreshToken
+	}
+	logout := func(jwt, refreshToken string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/logout", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwt != "" {
+			req.Header.Set("Authorization", "Bearer "+jwt)
+		}
+		if refreshToken != "" {
+			req.AddCookie(&http.Cookie{Name: auth.RefreshTokenName, Value: refreshToken})
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	byCookieJWT, byCookie := start(user)
+	byJWT, byJWTRefresh := start(user)
+	keptJWT, kept := start(user)
+	if status := logout("", byCookie); status != http.StatusNoContent {
+		t.Errorf("logging out with the refresh token = %v", status)
+	}
+	if status := logout(byJWT, ""); status != http.StatusNoContent {
+		t.Errorf("logging out with the JWT = %v", status)
+	}
+	for name, s := range map[string]struct{ jwt, refreshToken string }{
+		"logged out with the refresh token": {byCookieJWT, byCookie},
+		"logged out with the JWT":           {byJWT, byJWTRefresh},
+	} {
+		if sessionActive(t, srv, s.jwt) {
+			t.Errorf("the JWT of the session %v is accepted", name)
+		}
+		if status, _, _ := refreshSession(t, srv, s.refreshToken); status != http.StatusUnauthorized {
+			t.Errorf("refreshing the session %v = %v, want 401", name, status)
+		}
+	}
+	if !sessionActive(t, srv, keptJWT) {
+		t.Error("logging out revoked another session of the user")
+	}
+	if status := logout("", ""); status != http.StatusNoContent {
+		t.Errorf("logging out without a session = %v, want 204", status)
+	}
+
+	refreshToken := secret.String("graph-refresh-token")
+	conn, err := queries.CreateMailboxConnection(ctx, db.CreateMailboxConnectionParams{
+		UserID:       user.ID,
+		Provider:     "microsoft",
+		Email:        user.Email,
+		Token:        "token",
+		RefreshToken: &refreshToken,
+		ExpireAt:     pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondJWT, _ := start(user)
+	otherJWT, _ := start(other)
+	if status, body := call(t, srv, keptJWT, http.MethodPost, "/logout/all", nil); status != http.StatusNoContent {
+		t.Fatalf("logging out everywhere = %v %s", status, body)
+	}
+	for _, jwt := range []string{keptJWT, secondJWT} {
+		if sessionActive(t, srv, jwt) {
+			t.Error("a session of the user is accepted after logging out everywhere")
+		}
+	}
+	if status, _, _ := refreshSession(t, srv, kept); status != http.StatusUnauthorized {
+		t.Errorf("refreshing after logging out everywhere = %v, want 401", status)
+	}
+	if !sessionActive(t, srv, otherJWT) {
+		t.Error("logging out everywhere revoked the session of another user")
+	}
+	got, err := queries.GetMailboxConnection(ctx, conn.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RefreshToken != nil && *got.RefreshToken != "" {
+		t.Errorf("refresh token of the mailbox after logging out everywhere = %q, want it forgotten", *got.RefreshToken)
+	}
+}
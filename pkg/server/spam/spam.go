@@ -1,139 +1,151 @@
 package spam
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 
+	"ethan/pkg/audit"
 	"ethan/pkg/db"
-	"ethan/pkg/mstoken"
-	"ethan/pkg/server/subscribe"
-	"github.com/gorilla/mux"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	graphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
 	"github.com/sirupsen/logrus"
 )
 
 type Handler struct {
-	queries *db.Queries
+	queries   *db.Queries
+	providers provider.Registry
 }
 
-func NewHandler(queries *db.Queries) *Handler {
-	return &Handler{queries}
+func NewHandler(queries *db.Queries, providers provider.Registry) *Handler {
+	return &Handler{queries, providers}
 }
 
 func (h *Handler) ListSpams(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	var uid pgtype.UUID
-	if err := uid.Scan(userID); err != nil {
-		logrus.Error(fmt.Errorf("invalid user id: %s", userID))
-		w.WriteHeader(http.StatusInternalServerError)
+	uid, ok := api.UserID(w, r)
+	if !ok {
 		return
 	}
 	spamEmails, err := h.queries.ListSpamEmails(r.Context(), uid)
 	if err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			logrus.Error(fmt.Errorf("failed to fetch tasks from database: %w", err))
-		}
-		w.WriteHeader(http.StatusInternalServerError)
+		api.DBError(w, err, "spam emails")
 		return
 	}
-	if err := json.NewEncoder(w).Encode(spamEmails); err != nil {
-		logrus.Error(fmt.Errorf("failed to encode tasks output: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	return
+	api.WriteJSON(w, http.StatusOK, api.NewSpamEmails(spamEmails))
 }
 
 func (h *Handler) GetSpam(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var spamID pgtype.UUID
-	if err := spamID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
+	spamID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
 
-	spamEmail, err := h.queries.GetSpamEmail(r.Context(), spamID)
+	spamEmail, err := h.queries.GetSpamEmail(r.Context(), db.GetSpamEmailParams{
+		ID:     spamID,
+		UserID: uid,
+	})
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch spam email from database: %w", err))
-		return
-	}
-
-	if err := json.NewEncoder(w).Encode(spamEmail); err != nil {
-		logrus.Error(fmt.Errorf("failed to encode tasks output: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		api.DBError(w, err, "spam email")
 		return
 	}
-	return
+	api.WriteJSON(w, http.StatusOK, api.NewSpamEmail(spamEmail))
 }
 
 func (h *Handler) MoveSpam(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var spamID pgtype.UUID
-	if err := spamID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
+	spamID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
 
-	spamEmail, err := h.queries.GetSpamEmail(r.Context(), spamID)
+	spamEmail, err := h.queries.GetSpamEmail(r.Context(), db.GetSpamEmailParams{
+		ID:     spamID,
+		UserID: uid,
+	})
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch spam email from database: %w", err))
+		api.DBError(w, err, "spam email")
+		return
+	}
+	if spamEmail.MessageID == nil {
+		api.Conflict(w, "spam email has no message to move")
 		return
 	}
 
-	user, err := h.queries.GetUser(r.Context(), spamEmail.UserID)
-	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch user from database: %w", err))
+	conn, err := h.queries.GetMailboxConnectionForUser(r.Context(), db.GetMailboxConnectionForUserParams{
+		ID:     spamEmail.ConnectionID,
+		UserID: uid,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.Conflict(w, "the mailbox of this spam email was disconnected")
+		return
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
 		return
 	}
 
-	cred := mstoken.NewStaticTokenCredential(user.Token)
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+	p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to create graph client: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		api.InternalError(w, fmt.Errorf("failed to create mail provider: %w", err))
 		return
 	}
 
-	requestBody := graphusers.NewItemMailfoldersItemMessagesItemMovePostRequestBody()
-	inboxFolderID := "inbox"
-	requestBody.SetDestinationId(&inboxFolderID)
-	newMessage, err := client.Me().Messages().ByMessageId(*spamEmail.MessageID).Move().Post(r.Context(), requestBody, nil)
+	newMessage, err := p.MoveMessage(r.Context(), *spamEmail.MessageID, provider.FolderInbox)
+	event := audit.Event{
+		UserID:    uid,
+		Actor:     audit.ActorUser,
+		Mailbox:   conn.Email,
+		Tool:      "restore-spam",
+		Arguments: map[string]any{"messageId": *spamEmail.MessageID, "subject": spamEmail.Subject},
+		Err:       err,
+	}
+	if err == nil {
+		event.ResponseIDs = map[string]string{"messageId": newMessage.ID}
+	}
+	if err := audit.Record(r.Context(), h.queries, event); err != nil {
+		logrus.Error(fmt.Errorf("failed to record restoring message %v in the audit log: %w", *spamEmail.MessageID, err))
+	}
 	if err != nil {
-		logrus.Errorf("Failed to move message to back to inbox, error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		api.InternalError(w, fmt.Errorf("failed to move message %v back to inbox: %w", *spamEmail.MessageID, err))
 		return
 	}
 	// After we move the message back to Inbox, we need to skip checking this email because it has been falsely detected as spam
-	// For now we temporarily store the message ID into memory map. This is not going to work in HA but don't worry about it now.
-	subscribe.SkipEmails[*newMessage.GetId()] = struct{}{}
+	if err := h.queries.CreateSpamCheckSkip(r.Context(), db.CreateSpamCheckSkipParams{
+		UserID:    uid,
+		MessageID: newMessage.ID,
+	}); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to skip spam check for message %v: %w", newMessage.ID, err))
+		return
+	}
 
-	logrus.Infof("Move spam email %s to %s", *spamEmail.MessageID, inboxFolderID)
+	logrus.Infof("Move spam email %s to %s", *spamEmail.MessageID, provider.FolderInbox)
 
-	if err := h.queries.DeleteSpamEmail(r.Context(), spamID); err != nil {
-		logrus.Error(fmt.Errorf("failed to delete task: %w", err))
+	if _, err := h.queries.DeleteSpamEmail(r.Context(), db.DeleteSpamEmailParams{
+		ID:     spamID,
+		UserID: uid,
+	}); err != nil {
+		api.DBError(w, err, "spam email")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	return
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handler) DeleteSpam(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var spamID pgtype.UUID
-	if err := spamID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
+	spamID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
 
-	if err := h.queries.DeleteSpamEmail(r.Context(), spamID); err != nil {
-		logrus.Error(fmt.Errorf("failed to delete task: %w", err))
+	n, err := h.queries.DeleteSpamEmail(r.Context(), db.DeleteSpamEmailParams{
+		ID:     spamID,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "spam email")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "spam email")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	return
+	w.WriteHeader(http.StatusNoContent)
 }
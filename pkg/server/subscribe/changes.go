@@ -0,0 +1,213 @@
+package subscribe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
+	"ethan/pkg/server/connection"
+	"github.com/google/uuid"
+	"github.com/gptscript-ai/gptscript/pkg/runner"
+	"github.com/gptscript-ai/gptscript/pkg/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// spamMovedBack reports whether message was marked as spam before and the user moved it back to the inbox. The spam
+// record is removed so the message is not checked again.
+func (h *Handler) spamMovedBack(ctx context.Context, conn db.MailboxConnection, message provider.Message) (bool, error) {
+	if message.InternetMessageID == "" {
+		return false, nil
+	}
+
+	spamEmail, err := h.queries.GetSpamEmailFromInternetMessageID(ctx, db.GetSpamEmailFromInternetMessageIDParams{
+		ConnectionID:      conn.ID,
+		InternetMessageID: &message.InternetMessageID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get spam email record: %w", err)
+	}
+
+	// The record is removed together with putting the message on the skip list, so a retry does not check it again.
+	// The notification leaves the message ID to the task the message may become.
+	logrus.Infof("Message %v was moved back to the inbox, removing spam record", message.ID)
+	if err := h.queries.DeleteMovedBackSpamEmail(ctx, db.DeleteMovedBackSpamEmailParams{
+		ID:        spamEmail.ID,
+		MessageID: message.ID,
+		Content:   &[]string{fmt.Sprint("Email moved back to inbox, no longer marked as SPAM")}[0],
+	}); err != nil {
+		return false, fmt.Errorf("failed to delete spam email record: %w", err)
+	}
+	return true, nil
+}
+
+// handleInboxUpdate keeps the email body of a task in sync when the message the task was created from is edited.
+// Other updates, such as the message being marked as read, are ignored.
+func (h *Handler) handleInboxUpdate(ctx context.Context, conn db.MailboxConnection, messageID string) error {
+	task, err := h.queries.GetTaskFromMessageID(ctx, db.GetTaskFromMessageIDParams{
+		ConnectionID: conn.ID,
+		MessageID:    &messageID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get task: %w", err)
+	}
+
+	p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
+	if err != nil {
+		return fmt.Errorf("failed to create mail provider: %w", err)
+	}
+	message, err := p.GetMessage(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	if task.MessageBody != nil && *task.MessageBody == message.Body {
+		return nil
+	}
+
+	if err := h.queries.UpdateTaskMessageBody(ctx, db.UpdateTaskMessageBodyParams{
+		ID:          task.ID,
+		MessageBody: &message.Body,
+	}); err != nil {
+		return fmt.Errorf("failed to update task message body: %w", err)
+	}
+	return nil
+}
+
+// handleInboxDelete drops a message that is deleted or moved out of the inbox before it was processed. Rows that
+// point at a message that was already processed lose the link, since Graph gives a moved message a new ID: a task
+// created from it keeps its email body and is told the email is gone, and its spam record is removed.
+func (h *Handler) handleInboxDelete(ctx context.Context, conn db.MailboxConnection, messageID string) error {
+	if err := h.queries.CancelWebhookJob(ctx, db.CancelWebhookJobParams{
+		ConnectionID: conn.ID,
+		Kind:         kindInbox,
+		ChangeType:   changeCreated,
+		MessageID:    messageID,
+	}); err != nil {
+		return fmt.Errorf("failed to cancel webhook job: %w", err)
+	}
+
+	if err := h.queries.DeleteSpamEmailFromMessageID(ctx, db.DeleteSpamEmailFromMessageIDParams{
+		ConnectionID: conn.ID,
+		MessageID:    &messageID,
+	}); err != nil {
+		return fmt.Errorf("failed to delete spam email record: %w", err)
+	}
+	if err := h.queries.ClearMessagesMessageID(ctx, db.ClearMessagesMessageIDParams{
+		UserID:    conn.UserID,
+		MessageID: &messageID,
+	}); err != nil {
+		return fmt.Errorf("failed to unlink messages: %w", err)
+	}
+
+	task, err := h.queries.GetTaskFromMessageID(ctx, db.GetTaskFromMessageIDParams{
+		ConnectionID: conn.ID,
+		MessageID:    &messageID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get task: %w", err)
+	}
+	content := fmt.Sprintf("The email task %v was created from was deleted or moved out of the inbox", task.Name)
+	if err := h.queries.CreateMessage(ctx, db.CreateMessageParams{
+		Content: &content,
+		TaskID:  task.ID,
+		UserID:  conn.UserID,
+	}); err != nil {
+		return fmt.Errorf("failed to create message: %w", err)
+	}
+	if err := h.queries.ClearTaskMessageID(ctx, task.ID); err != nil {
+		return fmt.Errorf("failed to unlink task: %w", err)
+	}
+	return nil
+}
+
+// handleSentMessage adds a reply the user sent from their mail client to the task of the conversation, so the
+// assistant does not keep asking for something the user already answered.
+func (h *Handler) handleSentMessage(ctx context.Context, conn db.MailboxConnection, messageID string) error {
+	p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
+	if err != nil {
+		return fmt.Errorf("failed to create mail provider: %w", err)
+	}
+	message, err := p.GetMessage(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+
+	// Conversation IDs are only unique within a mailbox.
+	task, err := h.queries.GetTaskFromConversationID(ctx, db.GetTaskFromConversationIDParams{
+		ConversationID: &message.ConversationID,
+		ConnectionID:   conn.ID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Email sent by the assistant is already part of the chat, the send-email tool result holds its Message-ID.
+	// The brackets are trimmed because the state escapes them.
+	if message.InternetMessageID != "" && strings.Contains(string(task.State), strings.Trim(message.InternetMessageID, "<>")) {
+		return nil
+	}
+
+	content := "You have replied to the email"
+	if err := h.queries.CreateMessage(ctx, db.CreateMessageParams{
+		MessageID: &message.ID,
+		Content:   &content,
+		TaskID:    task.ID,
+		UserID:    conn.UserID,
+	}); err != nil {
+		return err
+	}
+
+	if task.State == nil {
+		return nil
+	}
+
+	messageTemplate := `
+User has replied to the email directly with the following content: %v.
+Take the reply into account when asking user about the next step.
+`
+	var ret runner.State
+	if err := json.Unmarshal(task.State, &ret); err != nil {
+		return fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+
+	if ret.Continuation != nil && ret.Continuation.State != nil {
+		ret.Continuation.State.Completion.Messages = append(ret.Continuation.State.Completion.Messages, types.CompletionMessage{
+			Role: types.CompletionMessageRoleTypeAssistant,
+			Content: []types.ContentPart{
+				{
+					Text: fmt.Sprintf(messageTemplate, message.Body),
+				},
+			},
+		})
+	}
+
+	state, err := json.Marshal(ret)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	if err := h.queries.UpdateTaskState(ctx, db.UpdateTaskStateParams{
+		ID:    task.ID,
+		State: state,
+	}); err != nil {
+		return fmt.Errorf("failed to update task state: %w", err)
+	}
+
+	// Close the active connection so the task resumes with the reply
+	if err := connection.CloseConn(ctx, h.queries, uuid.UUID(task.ID.Bytes).String()); err != nil {
+		return err
+	}
+	return nil
+}
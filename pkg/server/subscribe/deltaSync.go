@@ -0,0 +1,111 @@
+package subscribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultSyncInterval = 15 * time.Minute
+
+// SyncInboxes catches up on inbox messages whose notifications were missed, for example while the server was down or
+// a subscription had expired. It runs a delta sync for every mailbox on startup and then every DELTA_SYNC_INTERVAL, and
+// queues the messages that were not processed yet the same way as Subscribe.
+func (h *Handler) SyncInboxes(ctx context.Context) {
+	interval := defaultSyncInterval
+	if v := os.Getenv("DELTA_SYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logrus.Warnf("Invalid DELTA_SYNC_INTERVAL %q, using %v", v, defaultSyncInterval)
+		} else {
+			interval = d
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		connections, err := h.queries.ListMailboxConnections(ctx)
+		if err != nil {
+			logrus.Error(fmt.Errorf("failed to list mailboxes for inbox sync: %w", err))
+		}
+		for _, conn := range connections {
+			if err := h.syncInbox(ctx, conn); err != nil {
+				logrus.Error(fmt.Errorf("failed to sync inbox for mailbox %v: %w", uuid.UUID(conn.ID.Bytes).String(), err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) syncInbox(ctx context.Context, conn db.MailboxConnection) error {
+	if ok, err := watched(ctx, h.queries, conn); err != nil || !ok {
+		return err
+	}
+	p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
+	if err != nil {
+		return fmt.Errorf("failed to create mail provider: %w", err)
+	}
+	syncer, ok := p.(provider.InboxSyncer)
+	if !ok {
+		return nil
+	}
+
+	// The first sync only records where the inbox stands, mail that arrived before is not replayed.
+	var token string
+	if conn.InboxDeltaToken != nil {
+		token = *conn.InboxDeltaToken
+	}
+	replay := token != ""
+
+	ids, next, err := syncer.SyncInbox(ctx, token, time.Now())
+	if errors.Is(err, provider.ErrSyncTokenExpired) {
+		// Finished jobs are kept for finishedJobRetention, so anything received since then can be checked for
+		// whether it was processed.
+		logrus.Warnf("Inbox sync token expired for mailbox %v, syncing the last %v", uuid.UUID(conn.ID.Bytes).String(), finishedJobRetention)
+		ids, next, err = syncer.SyncInbox(ctx, "", time.Now().Add(-finishedJobRetention))
+		replay = true
+	}
+	if err != nil {
+		return err
+	}
+
+	if replay {
+		for _, id := range ids {
+			processed, err := h.queries.IsMessageProcessed(ctx, db.IsMessageProcessedParams{
+				ConnectionID: conn.ID,
+				MessageID:    id,
+				UserID:       conn.UserID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to check message %v: %w", id, err)
+			}
+			if processed {
+				continue
+			}
+			logrus.Infof("Catching up on message %v for mailbox %v", id, uuid.UUID(conn.ID.Bytes).String())
+			if err := h.enqueue(ctx, conn, kindInbox, changeCreated, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return h.queries.UpdateMailboxConnectionInboxDeltaToken(ctx, db.UpdateMailboxConnectionInboxDeltaTokenParams{
+		ID:              conn.ID,
+		InboxDeltaToken: &next,
+	})
+}
@@ -0,0 +1,111 @@
+package subscribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ethan/pkg/db"
+	"ethan/pkg/provider/graph"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// Lifecycle events Graph sends to the lifecycleNotificationUrl of a subscription.
+const (
+	lifecycleReauthorizationRequired = "reauthorizationRequired"
+	lifecycleSubscriptionRemoved     = "subscriptionRemoved"
+	lifecycleMissed                  = "missed"
+)
+
+// Lifecycle receives the lifecycle notifications of the Graph subscriptions.
+func (h *Handler) Lifecycle(w http.ResponseWriter, r *http.Request) {
+	notifications, _, ok := readNotifications(w, r)
+	if !ok {
+		return
+	}
+
+	for _, n := range notifications.Value {
+		conn, kind, ok, err := h.notificationMailbox(r.Context(), n)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			continue
+		}
+		if err := h.handleLifecycleEvent(r.Context(), conn, kind, n); err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleLifecycleEvent records the event on the subscription and reacts to it. A removed subscription is recreated by
+// PerUser, and missed notifications are caught up on with a delta sync.
+func (h *Handler) handleLifecycleEvent(ctx context.Context, conn db.MailboxConnection, kind string, n changeNotification) error {
+	connID := uuid.UUID(conn.ID.Bytes).String()
+	logrus.Infof("Received lifecycle event %v for %v subscription %v of mailbox %v", n.LifecycleEvent, kind, n.SubscriptionID, connID)
+
+	subscription, err := h.queries.GetSubscription(ctx, n.SubscriptionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The subscription was already replaced.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get subscription %v: %w", n.SubscriptionID, err)
+	}
+	if subscription.ConnectionID != conn.ID {
+		return nil
+	}
+
+	if err := h.queries.RecordSubscriptionLifecycleEvent(ctx, db.RecordSubscriptionLifecycleEventParams{
+		ID:                 subscription.ID,
+		LastLifecycleEvent: &n.LifecycleEvent,
+	}); err != nil {
+		return fmt.Errorf("failed to record lifecycle event: %w", err)
+	}
+
+	switch n.LifecycleEvent {
+	case lifecycleReauthorizationRequired:
+		status, lastError := statusActive, (*string)(nil)
+		if err := reauthorizeSubscription(ctx, conn, subscription.ID); err != nil {
+			logrus.Warnf("Failed to reauthorize subscription %v of mailbox %v: %v", subscription.ID, connID, err)
+			message := err.Error()
+			status, lastError = statusReauthorizationRequired, &message
+		}
+		return h.queries.UpdateSubscriptionStatus(ctx, db.UpdateSubscriptionStatusParams{
+			ID:        subscription.ID,
+			Status:    status,
+			LastError: lastError,
+		})
+	case lifecycleSubscriptionRemoved:
+		return h.queries.UpdateSubscriptionStatus(ctx, db.UpdateSubscriptionStatusParams{
+			ID:     subscription.ID,
+			Status: statusRemoved,
+		})
+	case lifecycleMissed:
+		if kind != kindInbox {
+			return nil
+		}
+		// The sync can take a while, Graph expects an answer within a few seconds.
+		go func() {
+			if err := h.syncInbox(context.Background(), conn); err != nil {
+				logrus.Error(fmt.Errorf("failed to sync inbox for mailbox %v after missed notifications: %w", connID, err))
+			}
+		}()
+	}
+	return nil
+}
+
+func reauthorizeSubscription(ctx context.Context, conn db.MailboxConnection, subscriptionID string) error {
+	client, err := graph.NewClient(string(conn.Token))
+	if err != nil {
+		return err
+	}
+	return client.Subscriptions().BySubscriptionId(subscriptionID).Reauthorize().Post(ctx, nil)
+}
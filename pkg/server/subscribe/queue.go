@@ -0,0 +1,193 @@
+package subscribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"ethan/pkg/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWorkers = 4
+	// maxAttempts is how many times a job runs before it is moved to the dead state.
+	maxAttempts = 5
+	// jobLease is how long a claimed job stays locked. A job whose worker died is picked up again once it expires.
+	jobLease     = 10 * time.Minute
+	pollInterval = 5 * time.Second
+	baseBackoff  = 15 * time.Second
+	maxBackoff   = 15 * time.Minute
+	// finishedJobRetention keeps done jobs around so repeated notifications for the same message are deduplicated.
+	finishedJobRetention = 7 * 24 * time.Hour
+)
+
+const (
+	changeCreated = "created"
+	changeUpdated = "updated"
+	changeDeleted = "deleted"
+)
+
+// enqueue stores a change to a message for processing. A change that was already queued for the mailbox is ignored,
+// which deduplicates Graph notification retries. Messages can be updated many times, so a finished update job is
+// queued again.
+func (h *Handler) enqueue(ctx context.Context, conn db.MailboxConnection, kind, changeType, messageID string) error {
+	if err := h.queries.EnqueueWebhookJob(ctx, db.EnqueueWebhookJobParams{
+		UserID:       conn.UserID,
+		ConnectionID: conn.ID,
+		MessageID:    messageID,
+		Kind:         kind,
+		ChangeType:   changeType,
+		Rearm:        changeType == changeUpdated,
+	}); err != nil {
+		return fmt.Errorf("failed to enqueue %v message %v: %w", changeType, messageID, err)
+	}
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ProcessJobs runs a pool of workers that process queued messages until ctx is done. The pool size is read from
+// WEBHOOK_WORKERS.
+func (h *Handler) ProcessJobs(ctx context.Context) {
+	workers := defaultWorkers
+	if v := os.Getenv("WEBHOOK_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			logrus.Warnf("Invalid WEBHOOK_WORKERS %q, using %v workers", v, defaultWorkers)
+		} else {
+			workers = n
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		go h.worker(ctx)
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := h.queries.DeleteFinishedWebhookJobs(ctx, pgtype.Timestamptz{Time: time.Now().Add(-finishedJobRetention), Valid: true}); err != nil {
+			logrus.Error(fmt.Errorf("failed to delete finished webhook jobs: %w", err))
+		}
+		// Moved back messages are processed long before the skip list entry is removed.
+		if err := h.queries.DeleteSpamCheckSkipsBefore(ctx, pgtype.Timestamptz{Time: time.Now().Add(-finishedJobRetention), Valid: true}); err != nil {
+			logrus.Error(fmt.Errorf("failed to delete spam check skips: %w", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) worker(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		case <-timer.C:
+		}
+
+		// Drain the queue before waiting again.
+		for ctx.Err() == nil {
+			job, err := h.queries.ClaimWebhookJob(ctx, pgtype.Timestamptz{Time: time.Now().Add(jobLease), Valid: true})
+			if errors.Is(err, pgx.ErrNoRows) {
+				break
+			} else if err != nil {
+				logrus.Error(fmt.Errorf("failed to claim webhook job: %w", err))
+				break
+			}
+			h.runJob(ctx, job)
+		}
+
+		timer.Reset(pollInterval)
+	}
+}
+
+func (h *Handler) runJob(ctx context.Context, job db.WebhookJob) {
+	jobID := uuid.UUID(job.ID.Bytes).String()
+
+	err := h.processJob(ctx, job)
+	if err == nil {
+		if err := h.queries.CompleteWebhookJob(ctx, job.ID); err != nil {
+			logrus.Error(fmt.Errorf("failed to complete webhook job %v: %w", jobID, err))
+		}
+		return
+	}
+
+	message := err.Error()
+	if job.Attempts >= maxAttempts {
+		logrus.Error(fmt.Errorf("webhook job %v for message %v failed %v times, giving up: %w", jobID, job.MessageID, job.Attempts, err))
+		if err := h.queries.FailWebhookJob(ctx, db.FailWebhookJobParams{
+			ID:        job.ID,
+			LastError: &message,
+		}); err != nil {
+			logrus.Error(fmt.Errorf("failed to mark webhook job %v as dead: %w", jobID, err))
+		}
+		return
+	}
+
+	runAt := time.Now().Add(backoff(job.Attempts))
+	logrus.Warnf("Webhook job %v for message %v failed, retrying at %v: %v", jobID, job.MessageID, runAt, err)
+	if err := h.queries.RetryWebhookJob(ctx, db.RetryWebhookJobParams{
+		ID:        job.ID,
+		RunAt:     pgtype.Timestamptz{Time: runAt, Valid: true},
+		LastError: &message,
+	}); err != nil {
+		logrus.Error(fmt.Errorf("failed to reschedule webhook job %v: %w", jobID, err))
+	}
+}
+
+func (h *Handler) processJob(ctx context.Context, job db.WebhookJob) error {
+	conn, err := h.queries.GetMailboxConnection(ctx, job.ConnectionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The mailbox was disconnected after the job was claimed.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get mailbox: %w", err)
+	}
+	if ok, err := watched(ctx, h.queries, conn); err != nil || !ok {
+		return err
+	}
+
+	switch {
+	case job.Kind == kindInbox && job.ChangeType == changeCreated:
+		return h.handleMessage(ctx, conn, job.MessageID)
+	case job.Kind == kindInbox && job.ChangeType == changeUpdated:
+		return h.handleInboxUpdate(ctx, conn, job.MessageID)
+	case job.Kind == kindInbox && job.ChangeType == changeDeleted:
+		return h.handleInboxDelete(ctx, conn, job.MessageID)
+	case job.Kind == kindSentItems && job.ChangeType == changeCreated:
+		return h.handleSentMessage(ctx, conn, job.MessageID)
+	case job.Kind == kindSentItems:
+		// Edits and deletes in Sent Items do not affect tasks.
+		return nil
+	}
+	logrus.Warnf("Dropping webhook job for unknown change %v/%v", job.Kind, job.ChangeType)
+	return nil
+}
+
+// backoff doubles the delay with every attempt, starting at baseBackoff and capped at maxBackoff.
+func backoff(attempts int32) time.Duration {
+	d := baseBackoff
+	for i := int32(1); i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
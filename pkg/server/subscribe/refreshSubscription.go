@@ -2,22 +2,94 @@ package subscribe
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"ethan/pkg/db"
-	"ethan/pkg/mstoken"
+	"ethan/pkg/provider"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/server/api"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
 	graphmodels "github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	kindInbox     = "inbox"
+	kindSentItems = "sentitems"
+)
+
+// subscriptionKinds are the Graph subscriptions every mailbox has, subscriptionResources the folders they watch.
+var (
+	subscriptionKinds     = []string{kindInbox, kindSentItems}
+	subscriptionResources = map[string]string{
+		kindInbox:     "mailFolders('Inbox')/messages",
+		kindSentItems: "mailFolders('SentItems')/messages",
+	}
+)
+
+// subscriptionResource is the Graph resource the subscription of the given kind watches. A delegate watches the
+// mailbox of the principal through /users.
+func subscriptionResource(conn db.MailboxConnection, kind string) string {
+	if conn.DelegationID.Valid {
+		return "users/" + conn.Email + "/" + subscriptionResources[kind]
+	}
+	return "me/" + subscriptionResources[kind]
+}
+
+// watched reports whether new mail of the mailbox is processed. A delegate only watches the mailbox of a principal
+// who granted the mail permission and has not connected the mailbox themselves, so its mail is processed once.
+func watched(ctx context.Context, queries *db.Queries, conn db.MailboxConnection) (bool, error) {
+	if conn.SubscriptionDisabled {
+		return false, nil
+	}
+	d := api.MailboxDelegation(conn)
+	if d.Mailbox == "" {
+		return true, nil
+	}
+	if !d.Allows(provider.PermissionMail) {
+		return false, nil
+	}
+	owned, err := queries.HasOwnerMailboxConnection(ctx, conn.Email)
+	if err != nil {
+		return false, fmt.Errorf("failed to check the owner's mailbox: %w", err)
+	}
+	return !owned, nil
+}
+
+// Subscription health as stored in subscriptions.status.
+const (
+	statusActive                  = "active"
+	statusRenewalFailed           = "renewalFailed"
+	statusReauthorizationRequired = "reauthorizationRequired"
+	statusRemoved                 = "removed"
+)
+
+const (
+	subscriptionLifetime = 24 * time.Hour
+	defaultRenewBefore   = 2 * time.Hour
+)
+
+// renewBefore is how long before expiry a subscription is renewed, read from SUBSCRIPTION_RENEW_BEFORE.
+func renewBefore() time.Duration {
+	if v := os.Getenv("SUBSCRIPTION_RENEW_BEFORE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 && d < subscriptionLifetime {
+			return d
+		}
+		logrus.Warnf("Invalid SUBSCRIPTION_RENEW_BEFORE %q, using %v", v, defaultRenewBefore)
+	}
+	return defaultRenewBefore
+}
+
 func PerUser(ctx context.Context, queries *db.Queries) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -28,6 +100,8 @@ func PerUser(ctx context.Context, queries *db.Queries) {
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			if err := ensureSubscriptions(ctx, queries); err != nil {
 				logrus.Error(fmt.Errorf("failed to ensure subscriptions: %w", err))
@@ -38,100 +112,222 @@ func PerUser(ctx context.Context, queries *db.Queries) {
 }
 
 func ensureSubscriptions(ctx context.Context, queries *db.Queries) error {
-	users, err := queries.ListUsers(ctx)
+	connections, err := queries.ListMailboxConnections(ctx)
 	if err != nil {
 		return err
 	}
-	for _, user := range users {
-		if err := ensureSubscriptionsForUser(ctx, user, queries); err != nil {
-			return err
+	for _, conn := range connections {
+		// One mailbox's failure is recorded on its subscriptions and must not hold back the others.
+		if err := ensureSubscriptionsForMailbox(ctx, conn, queries); err != nil {
+			logrus.Error(fmt.Errorf("failed to ensure subscriptions for mailbox %v: %w", uuid.UUID(conn.ID.Bytes).String(), err))
 		}
 	}
 	return nil
 }
 
-func ensureSubscriptionsForUser(ctx context.Context, user db.User, queries *db.Queries) error {
-	if user.SubscriptionDisabled != nil && *user.SubscriptionDisabled {
-		if user.SubscriptionID != nil {
-			cred := mstoken.NewStaticTokenCredential(user.Token)
-			client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
-			if err != nil {
+func ensureSubscriptionsForMailbox(ctx context.Context, conn db.MailboxConnection, queries *db.Queries) error {
+	// Other providers watch the inbox themselves, see WatchMailboxes.
+	if conn.Provider != graph.Name {
+		return nil
+	}
+	connID := uuid.UUID(conn.ID.Bytes).String()
+
+	subscriptions, err := queries.ListSubscriptionsForConnection(ctx, conn.ID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := watched(ctx, queries, conn)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		for _, subscription := range subscriptions {
+			if err := deleteSubscription(ctx, conn, subscription.ID); err != nil {
+				return err
+			}
+			if err := queries.DeleteSubscription(ctx, subscription.ID); err != nil {
 				return err
 			}
+			logrus.Infof("Subscription %v deleted for mailbox %v", subscription.ID, connID)
+		}
+		return nil
+	}
+
+	secret := conn.SubscriptionClientState
+	if secret == nil {
+		newSecret, err := newClientState()
+		if err != nil {
+			return err
+		}
+		if err := queries.UpdateMailboxConnectionSubscriptionClientState(ctx, db.UpdateMailboxConnectionSubscriptionClientStateParams{
+			ID:                      conn.ID,
+			SubscriptionClientState: &newSecret,
+		}); err != nil {
+			return err
+		}
+		secret = &newSecret
+	}
+
+	existing := map[string]db.Subscription{}
+	for _, subscription := range subscriptions {
+		existing[subscription.Kind] = subscription
+	}
+	for _, kind := range subscriptionKinds {
+		subscription, ok := existing[kind]
+		if ok && subscription.Status == statusActive && time.Until(subscription.ExpireAt.Time) > renewBefore() {
+			continue
+		}
 
-			if err := client.Subscriptions().BySubscriptionId(*user.SubscriptionID).Delete(ctx, nil); err != nil {
-				var e *odataerrors.ODataError
-				switch {
-				case errors.As(err, &e):
-					if e.ApiError.ResponseStatusCode == 404 {
-						break
-					}
-				default:
+		if ok && subscription.Status != statusRemoved && subscription.ExpireAt.Time.After(time.Now()) {
+			expireTime, err := renewSubscription(ctx, conn, subscription.ID)
+			if err == nil {
+				if err := queries.RenewSubscription(ctx, db.RenewSubscriptionParams{
+					ID:       subscription.ID,
+					ExpireAt: pgtype.Timestamptz{Time: expireTime, Valid: true},
+				}); err != nil {
 					return err
 				}
+				logrus.Infof("Renewed %v subscription %v for mailbox %v until %v", kind, subscription.ID, connID, expireTime)
+				continue
 			}
-			logrus.Infof("Subscription %v deleted for user %v", *user.SubscriptionID, uuid.UUID(user.ID.Bytes).String())
-			if err := queries.UpdateUser(ctx, db.UpdateUserParams{
-				ID:                   user.ID,
-				Token:                user.Token,
-				RefreshToken:         user.RefreshToken,
-				ExpireAt:             user.ExpireAt,
-				SubscriptionID:       nil,
-				SubscriptionExpireAt: pgtype.Timestamptz{},
-				SubscriptionDisabled: user.SubscriptionDisabled,
-				CheckSpam:            user.CheckSpam,
-			}); err != nil {
-				return err
+			if !isNotFound(err) {
+				message := err.Error()
+				if err := queries.UpdateSubscriptionStatus(ctx, db.UpdateSubscriptionStatusParams{
+					ID:        subscription.ID,
+					Status:    statusRenewalFailed,
+					LastError: &message,
+				}); err != nil {
+					return err
+				}
+				return fmt.Errorf("failed to renew %v subscription %v: %w", kind, subscription.ID, err)
 			}
+			// Graph no longer knows the subscription, replace it below.
 		}
-	} else if user.SubscriptionID == nil || (user.SubscriptionExpireAt.Valid && user.SubscriptionExpireAt.Time.Before(time.Now())) {
-		subscriptionID, expireTime, err := createSubscription(ctx, user)
-		if err != nil {
-			return err
+		if ok {
+			if err := deleteSubscription(ctx, conn, subscription.ID); err != nil {
+				logrus.Warnf("Failed to delete %v subscription %v for mailbox %v: %v", kind, subscription.ID, connID, err)
+			}
 		}
-		var t pgtype.Timestamptz
-		if err := t.Scan(expireTime); err != nil {
+
+		subscriptionID, expireTime, err := createSubscription(ctx, conn, kind, clientState(connID, kind, *secret))
+		if err != nil {
+			if ok {
+				message := err.Error()
+				if err := queries.UpdateSubscriptionStatus(ctx, db.UpdateSubscriptionStatusParams{
+					ID:        subscription.ID,
+					Status:    statusRenewalFailed,
+					LastError: &message,
+				}); err != nil {
+					return err
+				}
+			}
 			return err
 		}
-		if err := queries.UpdateUser(ctx, db.UpdateUserParams{
-			ID:                   user.ID,
-			Token:                user.Token,
-			RefreshToken:         user.RefreshToken,
-			ExpireAt:             user.ExpireAt,
-			SubscriptionID:       &subscriptionID,
-			SubscriptionExpireAt: t,
-			SubscriptionDisabled: user.SubscriptionDisabled,
-			CheckSpam:            user.CheckSpam,
+		if err := queries.UpsertSubscription(ctx, db.UpsertSubscriptionParams{
+			ID:           subscriptionID,
+			UserID:       conn.UserID,
+			ConnectionID: conn.ID,
+			Kind:         kind,
+			ExpireAt:     pgtype.Timestamptz{Time: expireTime, Valid: true},
 		}); err != nil {
 			return err
 		}
-		logrus.Infof("User %v updated with new subscription ID %v", uuid.UUID(user.ID.Bytes).String(), subscriptionID)
+		logrus.Infof("Mailbox %v updated with new %v subscription ID %v", connID, kind, subscriptionID)
 	}
 	return nil
 }
 
-func createSubscription(ctx context.Context, user db.User) (string, time.Time, error) {
-	cred := mstoken.NewStaticTokenCredential(user.Token)
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
+// renewSubscription moves the expiry of the subscription a full subscriptionLifetime ahead.
+func renewSubscription(ctx context.Context, conn db.MailboxConnection, subscriptionID string) (time.Time, error) {
+	client, err := graph.NewClient(string(conn.Token))
 	if err != nil {
-		return "", time.Time{}, err
+		return time.Time{}, err
+	}
+
+	requestBody := graphmodels.NewSubscription()
+	expirationDateTime := time.Now().Add(subscriptionLifetime)
+	requestBody.SetExpirationDateTime(&expirationDateTime)
+	if _, err := client.Subscriptions().BySubscriptionId(subscriptionID).Patch(ctx, requestBody, nil); err != nil {
+		return time.Time{}, err
+	}
+	return expirationDateTime, nil
+}
+
+func isNotFound(err error) bool {
+	var e *odataerrors.ODataError
+	return errors.As(err, &e) && e.ApiError.ResponseStatusCode == http.StatusNotFound
+}
+
+// deleteSubscription removes the subscription from Graph, one that is already gone is not an error.
+func deleteSubscription(ctx context.Context, conn db.MailboxConnection, subscriptionID string) error {
+	client, err := graph.NewClient(string(conn.Token))
+	if err != nil {
+		return err
+	}
+
+	if err := client.Subscriptions().BySubscriptionId(subscriptionID).Delete(ctx, nil); err != nil && !isNotFound(err) {
+		return err
 	}
+	return nil
+}
 
-	parts := strings.Split(os.Getenv("EMAIL_RECIPIENT"), ",")
-	var recipients []string
-	for _, part := range parts {
-		recipients = append(recipients, strings.TrimSpace(part))
+func newClientState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// clientState identifies the mailbox and the kind of subscription a notification is for, and carries the mailbox's
+// secret that proves the notification came from Graph. Mailboxes moved off the users table kept the user's ID, so
+// the clientState of their existing subscriptions stays valid.
+func clientState(connID, kind, secret string) string {
+	return fmt.Sprintf("%v:%v:%v", connID, kind, secret)
+}
+
+func parseClientState(s string) (connID, kind, secret string, ok bool) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+func createSubscription(ctx context.Context, conn db.MailboxConnection, kind, clientState string) (string, time.Time, error) {
+	client, err := graph.NewClient(string(conn.Token))
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
 	requestBody := graphmodels.NewSubscription()
-	changeType := "created"
+	changeType := "created,updated,deleted"
 	requestBody.SetChangeType(&changeType)
 	notificationUrl := os.Getenv("PUBLIC_URL") + "/api/webhook"
 	requestBody.SetNotificationUrl(&notificationUrl)
-	resource := "me/mailFolders('Inbox')/messages"
+	resource := subscriptionResource(conn, kind)
 	requestBody.SetResource(&resource)
-	expirationDateTime := time.Now().Add(time.Hour * 24)
+	expirationDateTime := time.Now().Add(subscriptionLifetime)
 	requestBody.SetExpirationDateTime(&expirationDateTime)
+	requestBody.SetClientState(&clientState)
+	lifecycleNotificationUrl := os.Getenv("PUBLIC_URL") + "/api/webhook/lifecycle"
+	requestBody.SetLifecycleNotificationUrl(&lifecycleNotificationUrl)
+
+	cert, err := richNotifications()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if cert != nil {
+		// Rich notifications need a $select on Outlook resources.
+		resource += "?$select=id,subject,conversationId"
+		requestBody.SetResource(&resource)
+		includeResourceData := true
+		requestBody.SetIncludeResourceData(&includeResourceData)
+		encodedCert := cert.encodedCert()
+		requestBody.SetEncryptionCertificate(&encodedCert)
+		requestBody.SetEncryptionCertificateId(&cert.id)
+	}
 
 	subscription, err := client.Subscriptions().Post(ctx, requestBody, nil)
 	if err != nil {
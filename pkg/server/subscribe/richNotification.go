@@ -0,0 +1,202 @@
+package subscribe
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// graphChangeTrackingAppID is the application Microsoft Graph signs notification validation tokens as.
+const graphChangeTrackingAppID = "0bf30f3b-4a52-48df-9a82-234910c4a086"
+
+// notificationCert is the certificate Graph encrypts resource data with when rich notifications are enabled by
+// setting GRAPH_NOTIFICATION_CERT and GRAPH_NOTIFICATION_KEY to PEM files.
+type notificationCert struct {
+	id   string
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+var (
+	richNotifications = sync.OnceValues(loadNotificationCert)
+	graphKeySet       = sync.OnceValue(func() *oidc.RemoteKeySet {
+		loginURL := strings.TrimSuffix(os.Getenv("MICROSOFT_LOGIN_URL"), "/")
+		if loginURL == "" {
+			loginURL = "https://login.microsoftonline.com"
+		}
+		return oidc.NewRemoteKeySet(context.Background(), loginURL+"/common/discovery/v2.0/keys")
+	})
+)
+
+// loadNotificationCert returns nil when rich notifications are not configured.
+func loadNotificationCert() (*notificationCert, error) {
+	certFile, keyFile := os.Getenv("GRAPH_NOTIFICATION_CERT"), os.Getenv("GRAPH_NOTIFICATION_KEY")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	} else if certFile == "" || keyFile == "" {
+		return nil, errors.New("GRAPH_NOTIFICATION_CERT and GRAPH_NOTIFICATION_KEY must be set together")
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %v", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse notification certificate: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %v", keyFile)
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("notification key must be an RSA key")
+		}
+		key = rsaKey
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to parse notification key: %w", err)
+	}
+
+	thumbprint := sha1.Sum(cert.Raw)
+	return &notificationCert{
+		id:   hex.EncodeToString(thumbprint[:]),
+		cert: cert,
+		key:  key,
+	}, nil
+}
+
+func (c *notificationCert) encodedCert() string {
+	return base64.StdEncoding.EncodeToString(c.cert.Raw)
+}
+
+type encryptedContent struct {
+	Data                    string `json:"data"`
+	DataSignature           string `json:"dataSignature"`
+	DataKey                 string `json:"dataKey"`
+	EncryptionCertificateID string `json:"encryptionCertificateId"`
+}
+
+// decrypt checks the signature of the resource data and decrypts it. The symmetric key is encrypted with RSA-OAEP,
+// the data is signed with HMAC-SHA256 and encrypted with AES-CBC using the first 16 bytes of the key as IV.
+func (c *notificationCert) decrypt(content encryptedContent) ([]byte, error) {
+	if content.EncryptionCertificateID != c.id {
+		return nil, fmt.Errorf("resource data is encrypted for unknown certificate %q", content.EncryptionCertificateID)
+	}
+
+	encryptedKey, err := base64.StdEncoding.DecodeString(content.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+	key, err := rsa.DecryptOAEP(sha1.New(), nil, c.key, encryptedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(content.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(content.DataSignature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data signature: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return nil, errors.New("resource data signature does not match")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("resource data is not a whole number of blocks")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, key[:aes.BlockSize]).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid resource data padding")
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+type validationClaims struct {
+	Audience  string `json:"aud"`
+	Issuer    string `json:"iss"`
+	AppID     string `json:"azp"`
+	Expiry    int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+// validateTokens checks the tokens Graph attaches to rich notifications. Every token has to be signed by Microsoft,
+// issued to this app by the Graph change tracking app, and come from a tenant in tenants.
+func validateTokens(ctx context.Context, tokens []string, tenants map[string]struct{}) error {
+	if len(tokens) == 0 {
+		return errors.New("notification has no validation tokens")
+	}
+
+	for _, token := range tokens {
+		payload, err := graphKeySet().VerifySignature(ctx, token)
+		if err != nil {
+			return fmt.Errorf("invalid validation token signature: %w", err)
+		}
+		var claims validationClaims
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return fmt.Errorf("invalid validation token claims: %w", err)
+		}
+
+		now := time.Now().Unix()
+		if claims.Expiry < now || claims.NotBefore > now {
+			return errors.New("validation token is expired")
+		}
+		if claims.Audience != os.Getenv("MICROSOFT_CLIENT_ID") {
+			return fmt.Errorf("validation token is for audience %q", claims.Audience)
+		}
+		if claims.AppID != graphChangeTrackingAppID {
+			return fmt.Errorf("validation token is from app %q", claims.AppID)
+		}
+		var validIssuer bool
+		for tenant := range tenants {
+			if claims.Issuer == fmt.Sprintf("https://sts.windows.net/%v/", tenant) {
+				validIssuer = true
+			}
+		}
+		if !validIssuer {
+			return fmt.Errorf("validation token is from issuer %q", claims.Issuer)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package subscribe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeNotificationCert writes a self-signed certificate and its key, in PKCS #1 or PKCS #8, to PEM files and points
+// GRAPH_NOTIFICATION_CERT and GRAPH_NOTIFICATION_KEY at them.
+func writeNotificationCert(t *testing.T, pkcs8 bool) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "notifications"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if pkcs8 {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(keyBlock), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GRAPH_NOTIFICATION_CERT", certFile)
+	t.Setenv("GRAPH_NOTIFICATION_KEY", keyFile)
+}
+
+func testNotificationCert(t *testing.T) *notificationCert {
+	t.Helper()
+	writeNotificationCert(t, false)
+	cert, err := loadNotificationCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// encryptResource encrypts resource data for the certificate the way Graph does.
+func encryptResource(t *testing.T, c *notificationCert, data []byte) encryptedContent {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, c.cert.PublicKey.(*rsa.PublicKey), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, key[:aes.BlockSize]).CryptBlocks(encrypted, plain)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encrypted)
+	return encryptedContent{
+		Data:                    base64.StdEncoding.EncodeToString(encrypted),
+		DataSignature:           base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		DataKey:                 base64.StdEncoding.EncodeToString(encryptedKey),
+		EncryptionCertificateID: c.id,
+	}
+}
+
+func TestLoadNotificationCert(t *testing.T) {
+	for _, pkcs8 := range []bool{false, true} {
+		writeNotificationCert(t, pkcs8)
+		cert, err := loadNotificationCert()
+		if err != nil || cert == nil {
+			t.Fatalf("loadNotificationCert() with a PKCS #8 key %v = %v, %v", pkcs8, cert, err)
+		}
+		thumbprint := sha1.Sum(cert.cert.Raw)
+		if len(cert.id) != 2*len(thumbprint) {
+			t.Errorf("certificate ID = %v, want the hex SHA-1 thumbprint", cert.id)
+		}
+	}
+
+	t.Setenv("GRAPH_NOTIFICATION_KEY", "")
+	if _, err := loadNotificationCert(); err == nil {
+		t.Error("loadNotificationCert() without a key succeeded, want an error")
+	}
+	t.Setenv("GRAPH_NOTIFICATION_CERT", "")
+	if cert, err := loadNotificationCert(); cert != nil || err != nil {
+		t.Errorf("loadNotificationCert() unconfigured = %v, %v, want rich notifications off", cert, err)
+	}
+}
+
+func TestDecryptResource(t *testing.T) {
+	cert := testNotificationCert(t)
+	content := encryptResource(t, cert, []byte(`{"id":"message-1"}`))
+	other := encryptResource(t, cert, []byte(`{"id":"message-2"}`))
+
+	tests := []struct {
+		name   string
+		modify func(c *encryptedContent)
+		err    string
+	}{
+		{
+			name:   "as Graph sent it",
+			modify: func(c *encryptedContent) {},
+		},
+		{
+			name:   "signature of other data",
+			modify: func(c *encryptedContent) { c.DataSignature = other.DataSignature },
+			err:    "signature does not match",
+		},
+		{
+			name: "data changed after signing",
+			modify: func(c *encryptedContent) {
+				data, _ := base64.StdEncoding.DecodeString(c.Data)
+				data[0] ^= 1
+				c.Data = base64.StdEncoding.EncodeToString(data)
+			},
+			err: "signature does not match",
+		},
+		{
+			name:   "data key of other data",
+			modify: func(c *encryptedContent) { c.DataKey = other.DataKey },
+			err:    "signature does not match",
+		},
+		{
+			name:   "data key not for the certificate",
+			modify: func(c *encryptedContent) { c.DataKey = base64.StdEncoding.EncodeToString([]byte("key")) },
+			err:    "failed to decrypt data key",
+		},
+		{
+			name:   "unknown certificate",
+			modify: func(c *encryptedContent) { c.EncryptionCertificateID = "other" },
+			err:    "unknown certificate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := content
+			tt.modify(&c)
+			data, err := cert.decrypt(c)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("decrypt() = %s, %v, want an error with %q", data, err, tt.err)
+				}
+				return
+			}
+			if err != nil || string(data) != `{"id":"message-1"}` {
+				t.Errorf("decrypt() = %s, %v, want the resource data", data, err)
+			}
+		})
+	}
+}
+
+func TestNotificationMessageID(t *testing.T) {
+	cert := testNotificationCert(t)
+	content := encryptResource(t, cert, []byte(`{"id":"message-1"}`))
+	empty := encryptResource(t, cert, []byte(`{}`))
+
+	tests := []struct {
+		name string
+		cert *notificationCert
+		n    changeNotification
+		want string
+	}{
+		{"resource data", nil, changeNotification{ResourceData: &resourceData{ID: "message-1"}}, "message-1"},
+		{"no resource data", nil, changeNotification{}, ""},
+		{"encrypted content", cert, changeNotification{EncryptedContent: &content}, "message-1"},
+		// With rich notifications, only the encrypted content is trusted.
+		{"plain resource data", cert, changeNotification{ResourceData: &resourceData{ID: "message-1"}}, ""},
+		{"encrypted content without an ID", cert, changeNotification{EncryptedContent: &empty}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.n.messageID(tt.cert)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("messageID() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("messageID() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
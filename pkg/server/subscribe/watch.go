@@ -0,0 +1,101 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type watchers struct {
+	lock    sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// WatchMailboxes keeps an inbox watcher running for every mailbox whose provider detects new mail itself, such as
+// IMAP IDLE. New messages are queued the same way as Graph webhook notifications.
+func (h *Handler) WatchMailboxes(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	w := &watchers{
+		cancels: map[string]context.CancelFunc{},
+	}
+
+	for {
+		if err := h.ensureWatchers(ctx, w); err != nil {
+			logrus.Error(fmt.Errorf("failed to ensure mailbox watchers: %w", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) ensureWatchers(ctx context.Context, w *watchers) error {
+	connections, err := h.queries.ListMailboxConnections(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	active := map[string]struct{}{}
+	for _, conn := range connections {
+		connID := uuid.UUID(conn.ID.Bytes).String()
+		p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
+		if err != nil {
+			logrus.Error(fmt.Errorf("failed to create mail provider for mailbox %v: %w", connID, err))
+			continue
+		}
+		watcher, ok := p.(provider.Watcher)
+		if !ok {
+			continue
+		}
+
+		// The key includes the token so the watcher restarts when the mailbox's credentials change.
+		key := connID + "/" + string(conn.Token)
+		active[key] = struct{}{}
+		if _, ok := w.cancels[key]; ok {
+			continue
+		}
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		w.cancels[key] = cancel
+		go func() {
+			defer func() {
+				cancel()
+				// Forget the watcher so that the next run restarts it.
+				w.lock.Lock()
+				delete(w.cancels, key)
+				w.lock.Unlock()
+			}()
+
+			logrus.Infof("Watching inbox for mailbox %v", connID)
+			if err := watcher.WatchInbox(watchCtx, func(messageID string) {
+				if err := h.enqueue(watchCtx, conn, kindInbox, changeCreated, messageID); err != nil {
+					logrus.Error(fmt.Errorf("failed to queue message %v for mailbox %v: %w", messageID, connID, err))
+				}
+			}); err != nil {
+				logrus.Error(fmt.Errorf("inbox watcher for mailbox %v stopped: %w", connID, err))
+			}
+		}()
+	}
+
+	for key, cancel := range w.cancels {
+		if _, ok := active[key]; !ok {
+			cancel()
+			delete(w.cancels, key)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package subscribe
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,8 +12,10 @@ import (
 	"os"
 	"strings"
 
+	"ethan/pkg/audit"
 	"ethan/pkg/db"
-	"ethan/pkg/mstoken"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
 	"ethan/pkg/server/connection"
 	"ethan/pkg/tool"
 	"github.com/acorn-io/namegenerator"
@@ -22,16 +25,9 @@ import (
 	"github.com/gptscript-ai/gptscript/pkg/types"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
-	abstractions "github.com/microsoft/kiota-abstractions-go"
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	graphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	SkipEmails = map[string]struct{}{}
-)
-
 var checkSpamTemplatePrompt = `
 Given email body: %v, email sender: %v, email subject: %v, Check if email belongs to a cold email.
 Do not mark it as cold email of the sender has an email address that is the same domain as yours email(%v).
@@ -44,293 +40,424 @@ Answer yes or no.
 `
 
 type Handler struct {
-	queries *db.Queries
+	queries   *db.Queries
+	providers provider.Registry
+	// wake tells an idle worker that a job was queued, so it does not wait for the next poll.
+	wake chan struct{}
 }
 
-func NewHandler(queries *db.Queries) *Handler {
+func NewHandler(queries *db.Queries, providers provider.Registry) *Handler {
 	return &Handler{
-		queries: queries,
+		queries:   queries,
+		providers: providers,
+		wake:      make(chan struct{}, 1),
 	}
 }
 
 func (h *Handler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	notifications, cert, ok := readNotifications(w, r)
+	if !ok {
+		return
+	}
+
+	for _, n := range notifications.Value {
+		conn, kind, ok, err := h.notificationMailbox(r.Context(), n)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			continue
+		}
+
+		// Subscriptions created without a lifecycleNotificationUrl get their lifecycle events here.
+		if n.LifecycleEvent != "" {
+			if err := h.handleLifecycleEvent(r.Context(), conn, kind, n); err != nil {
+				logrus.Error(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			continue
+		}
+
+		switch n.ChangeType {
+		case changeCreated, changeUpdated, changeDeleted:
+		default:
+			logrus.Infof("Ignoring %v notification for subscription %v", n.ChangeType, n.SubscriptionID)
+			continue
+		}
+
+		messageID, err := n.messageID(cert)
+		if err != nil {
+			logrus.Warnf("Rejected notification for subscription %v: %v", n.SubscriptionID, err)
+			continue
+		}
+		// Only queue the message here, Graph expects an answer within a few seconds and processing involves several
+		// LLM calls.
+		if err := h.enqueue(r.Context(), conn, kind, n.ChangeType, messageID); err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// readNotifications answers the validation request Graph sends when a subscription is created, and otherwise parses
+// the notifications and checks their validation tokens. It returns false if it already wrote the response.
+func readNotifications(w http.ResponseWriter, r *http.Request) (changeNotifications, *notificationCert, bool) {
 	token := r.URL.Query().Get("validationToken")
 	if token != "" {
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(token))
-		return
+		return changeNotifications{}, nil, false
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Unable to read request body", http.StatusInternalServerError)
-		return
+		return changeNotifications{}, nil, false
 	}
 	defer r.Body.Close()
 
-	var bodyJson map[string]interface{}
-	if err := json.Unmarshal(body, &bodyJson); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	var notifications changeNotifications
+	if err := json.Unmarshal(body, &notifications); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, err)
-		return
+		return changeNotifications{}, nil, false
 	}
 
-	values := bodyJson["value"].([]interface{})
-	for _, v := range values {
-		subscriptionId := v.(map[string]interface{})["subscriptionId"].(string)
-		user, err := h.queries.GetUserFromSubscriptionID(r.Context(), &subscriptionId)
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+	cert, err := richNotifications()
+	if err != nil {
+		logrus.Error(fmt.Errorf("failed to load notification certificate: %w", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return changeNotifications{}, nil, false
+	}
+	if cert != nil {
+		tenants := map[string]struct{}{}
+		for _, n := range notifications.Value {
+			tenants[n.TenantID] = struct{}{}
 		}
-		cred := mstoken.NewStaticTokenCredential(user.Token)
-		client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{})
-		if err != nil {
-			logrus.Error(fmt.Errorf("failed to create graph client: %w", err))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if err := validateTokens(r.Context(), notifications.ValidationTokens, tenants); err != nil {
+			logrus.Warnf("Rejected webhook notification: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return changeNotifications{}, nil, false
 		}
+	}
+	return notifications, cert, true
+}
 
-		resourceData := v.(map[string]interface{})["resourceData"].(map[string]interface{})
-		messageID := resourceData["id"].(string)
-		headers := abstractions.NewRequestHeaders()
-		headers.Add("Prefer", "outlook.body-content-type=text")
-		configuration := &graphusers.ItemMessagesMessageItemRequestBuilderGetRequestConfiguration{
-			Headers: headers,
-		}
-		message, err := client.Me().Messages().ByMessageId(messageID).Get(r.Context(), configuration)
-		if err != nil {
-			logrus.Error(fmt.Errorf("failed to get messsage message: %w", err))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+// notificationMailbox returns the mailbox and subscription kind the clientState of n names. It returns false if the
+// clientState is malformed or its secret does not match the mailbox's.
+func (h *Handler) notificationMailbox(ctx context.Context, n changeNotification) (db.MailboxConnection, string, bool, error) {
+	// The clientState names the mailbox and the folder, so the notification can be routed without a lookup that
+	// races with the subscription being stored.
+	connID, kind, secret, ok := parseClientState(n.ClientState)
+	if _, known := subscriptionResources[kind]; !ok || !known {
+		logrus.Warnf("Rejected notification for subscription %v with malformed clientState", n.SubscriptionID)
+		return db.MailboxConnection{}, "", false, nil
+	}
+	id, err := uuid.Parse(connID)
+	if err != nil {
+		logrus.Warnf("Rejected notification for subscription %v with malformed clientState", n.SubscriptionID)
+		return db.MailboxConnection{}, "", false, nil
+	}
+	conn, err := h.queries.GetMailboxConnection(ctx, pgtype.UUID{Bytes: id, Valid: true})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The mailbox was disconnected, its subscriptions expire on their own.
+		return db.MailboxConnection{}, "", false, nil
+	} else if err != nil {
+		return db.MailboxConnection{}, "", false, fmt.Errorf("failed to get mailbox %v: %w", connID, err)
+	}
+	if conn.SubscriptionClientState == nil || subtle.ConstantTimeCompare([]byte(secret), []byte(*conn.SubscriptionClientState)) != 1 {
+		logrus.Warnf("Rejected notification for subscription %v with mismatched clientState", n.SubscriptionID)
+		return db.MailboxConnection{}, "", false, nil
+	}
+	return conn, kind, true, nil
+}
+
+type changeNotifications struct {
+	Value            []changeNotification `json:"value"`
+	ValidationTokens []string             `json:"validationTokens"`
+}
+
+type changeNotification struct {
+	SubscriptionID   string            `json:"subscriptionId"`
+	ClientState      string            `json:"clientState"`
+	ChangeType       string            `json:"changeType"`
+	TenantID         string            `json:"tenantId"`
+	LifecycleEvent   string            `json:"lifecycleEvent"`
+	ResourceData     *resourceData     `json:"resourceData"`
+	EncryptedContent *encryptedContent `json:"encryptedContent"`
+}
+
+type resourceData struct {
+	ID string `json:"id"`
+}
+
+// messageID returns the ID of the changed message. With rich notifications enabled the ID is taken from the
+// encrypted resource data, which also proves the notification came from Graph.
+func (n changeNotification) messageID(cert *notificationCert) (string, error) {
+	if cert == nil {
+		if n.ResourceData == nil || n.ResourceData.ID == "" {
+			return "", errors.New("notification has no resource data")
 		}
+		return n.ResourceData.ID, nil
+	}
 
-		logrus.Infof("Received message with no task from conversation %v", *message.GetConversationId())
-		gptClient, err := gptscript.NewGPTScript(gptscript.GlobalOptions{
-			OpenAIAPIKey: os.Getenv("OPENAI_API_KEY"),
-			Env:          append(os.Environ(), fmt.Sprintf("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN=%v", user.Token)),
-		})
-		if err != nil {
-			logrus.Error(fmt.Errorf("failed to create gptscript client: %w", err))
-			return
+	if n.EncryptedContent == nil {
+		return "", errors.New("notification has no encrypted content")
+	}
+	data, err := cert.decrypt(*n.EncryptedContent)
+	if err != nil {
+		return "", err
+	}
+	var resource resourceData
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return "", fmt.Errorf("invalid resource data: %w", err)
+	}
+	if resource.ID == "" {
+		return "", errors.New("resource data has no id")
+	}
+	return resource.ID, nil
+}
+
+// handleMessage runs a new inbox message through spam detection, task creation or task reply handling. A failed job
+// is retried, so what the message led to is keyed on its ID: a message that already became a task is skipped, a
+// message recorded as spam is only moved again, and a reply is added to its task once.
+func (h *Handler) handleMessage(ctx context.Context, conn db.MailboxConnection, messageID string) error {
+	p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
+	if err != nil {
+		return fmt.Errorf("failed to create mail provider: %w", err)
+	}
+
+	spamEmail, err := h.queries.GetSpamEmailFromInboxMessageID(ctx, db.GetSpamEmailFromInboxMessageIDParams{
+		ConnectionID:   conn.ID,
+		InboxMessageID: &messageID,
+	})
+	if err == nil {
+		if spamEmail.MessageID != nil {
+			return nil
 		}
-		defer gptClient.Close()
-
-		name := *message.GetSender().GetEmailAddress().GetName()
-		email := *message.GetSender().GetEmailAddress().GetAddress()
-		subject := *message.GetSubject()
-		emailContent := *message.GetBody().GetContent()
-
-		task, err := h.queries.GetTaskFromConversationID(context.Background(), message.GetConversationId())
-		if errors.Is(err, pgx.ErrNoRows) {
-			if user.CheckSpam != nil && *user.CheckSpam {
-				// Once we identified te the email is related to meeting, use AI to check whether email belongs to cold email. If so, move it to spam
-				if _, ok := SkipEmails[messageID]; !ok {
-					checkSpamRun, err := gptClient.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{
-						Instructions: fmt.Sprintf(checkSpamTemplatePrompt, emailContent, email, subject, user.Email),
-					})
-					if err != nil {
-						logrus.Error(fmt.Errorf("failed to run gptscript to check email content to detect spam: %w", err))
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-					checkSpamRunOutput, err := checkSpamRun.Text()
-					if err != nil {
-						logrus.Error(fmt.Errorf("failed to run gptscript to check email content to detect spam: %w", err))
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-
-					if strings.ToLower(checkSpamRunOutput) == "yes" {
-						logrus.Infof("Mark message %v as Spam cold email, moving to Cold Email folder", messageID)
-
-						folders, err := client.Me().MailFolders().Get(r.Context(), nil)
-						if err != nil {
-							logrus.Error(fmt.Errorf("failed to get folder list: %w", err))
-							w.WriteHeader(http.StatusInternalServerError)
-							return
-						}
-						var coldEmailFolderID string
-						for _, folder := range folders.GetValue() {
-							if folder.GetDisplayName() != nil && *folder.GetDisplayName() == "Cold Emails" {
-								coldEmailFolderID = *folder.GetId()
-								break
-							}
-						}
-
-						if coldEmailFolderID == "" {
-							logrus.Error("Failed to find cold email folder")
-							return
-						}
-
-						requestBody := graphusers.NewItemMailfoldersItemMessagesItemMovePostRequestBody()
-						requestBody.SetDestinationId(&coldEmailFolderID)
-						newMessage, err := client.Me().Messages().ByMessageId(messageID).Move().Post(r.Context(), requestBody, nil)
-						if err != nil {
-							logrus.Error("Failed to move message to junk items")
-							w.WriteHeader(http.StatusInternalServerError)
-							return
-						}
-
-						if err := h.queries.CreateSpamEmailRecord(r.Context(), db.CreateSpamEmailRecordParams{
-							Subject:   &subject,
-							EmailBody: &emailContent,
-							UserID:    user.ID,
-							MessageID: newMessage.GetId(),
-						}); err != nil {
-							logrus.Error(fmt.Errorf("failed to create spam email record: %w", err))
-							w.WriteHeader(http.StatusInternalServerError)
-							return
-						}
-
-						if err := h.queries.CreateMessage(r.Context(), db.CreateMessageParams{
-							MessageID: newMessage.GetId(),
-							Content:   &[]string{fmt.Sprint("Mark incoming email as SPAM")}[0],
-							UserID:    user.ID,
-							TaskID: pgtype.UUID{
-								Valid: false,
-							},
-						}); err != nil {
-							logrus.Error(fmt.Errorf("failed to create spam email message: %w", err))
-							w.WriteHeader(http.StatusInternalServerError)
-							return
-						}
-						return
-					}
-				}
-			}
-			// if we can't find task, let LLM decide whether to create a new task based on email content.
-			run, err := gptClient.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{
-				Instructions: fmt.Sprintf(`Given email subject: %v\, Check if this is related to a meeting. Answer yes or no. \n `, subject),
-			})
+		return h.moveToColdEmails(ctx, p, conn, spamEmail, map[string]any{"messageId": messageID, "subject": spamEmail.Subject})
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to get spam email record: %w", err)
+	}
+	if _, err := h.queries.GetTaskFromMessageID(ctx, db.GetTaskFromMessageIDParams{
+		ConnectionID: conn.ID,
+		MessageID:    &messageID,
+	}); err == nil {
+		return nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to get task of message: %w", err)
+	}
+
+	message, err := p.GetMessage(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to get messsage message: %w", err)
+	}
+
+	logrus.Infof("Received message with no task from conversation %v", message.ConversationID)
+	gptClient, err := gptscript.NewGPTScript(gptscript.GlobalOptions{
+		OpenAIAPIKey:  os.Getenv("OPENAI_API_KEY"),
+		OpenAIBaseURL: os.Getenv("OPENAI_BASE_URL"),
+		DefaultModel:  os.Getenv("DEFAULT_MODEL"),
+		Env:           append(os.Environ(), provider.Env(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))...),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create gptscript client: %w", err)
+	}
+	defer gptClient.Close()
 
-			defer run.Close()
+	name := message.SenderName
+	email := message.SenderAddress
+	subject := message.Subject
+	emailContent := message.Body
 
-			output, err := run.Text()
+	movedBack, err := h.spamMovedBack(ctx, conn, message)
+	if err != nil {
+		return err
+	}
+	// Messages moved back to the inbox through the spams API were falsely detected as spam before.
+	skipped, err := h.queries.IsSpamCheckSkipped(ctx, db.IsSpamCheckSkippedParams{
+		UserID:    conn.UserID,
+		MessageID: messageID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check spam check skip list: %w", err)
+	}
+
+	task, err := h.queries.GetTaskFromConversationID(context.Background(), db.GetTaskFromConversationIDParams{
+		ConversationID: &message.ConversationID,
+		ConnectionID:   conn.ID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		if conn.CheckSpam && !movedBack && !skipped {
+			// Once we identified te the email is related to meeting, use AI to check whether email belongs to cold email. If so, move it to spam
+			checkSpamRun, err := gptClient.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{
+				Instructions: fmt.Sprintf(checkSpamTemplatePrompt, emailContent, email, subject, conn.Email),
+			})
 			if err != nil {
-				logrus.Error(fmt.Errorf("failed to run gptscript to check email content: %w", err))
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+				return fmt.Errorf("failed to run gptscript to check email content to detect spam: %w", err)
+			}
+			checkSpamRunOutput, err := checkSpamRun.Text()
+			if err != nil {
+				return fmt.Errorf("failed to run gptscript to check email content to detect spam: %w", err)
 			}
 
-			if strings.ToLower(output) == "yes" {
-				nameRun, err := gptClient.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{
-					Instructions: fmt.Sprintf(`Given email body: %v, summarize the email content and Assign a name for this email. Give me a json object that has name and summary as keys. use lower case. Just return string represetation of json object that can be serialized.'`, *message.GetBody().GetContent()),
+			if strings.ToLower(checkSpamRunOutput) == "yes" {
+				// The record is created before the move, which changes the ID of the message.
+				spamEmail, err := h.queries.CreateSpamEmailRecord(ctx, db.CreateSpamEmailRecordParams{
+					Subject:           &subject,
+					EmailBody:         &emailContent,
+					UserID:            conn.UserID,
+					InternetMessageID: &message.InternetMessageID,
+					ConnectionID:      conn.ID,
+					InboxMessageID:    &messageID,
 				})
 				if err != nil {
-					logrus.Error(fmt.Errorf("failed to run gptscript to check email content: %w", err))
-					w.WriteHeader(http.StatusInternalServerError)
-					return
+					return fmt.Errorf("failed to create spam email record: %w", err)
 				}
+				return h.moveToColdEmails(ctx, p, conn, spamEmail, map[string]any{"messageId": messageID, "subject": subject, "sender": email})
+			}
+		}
+		// if we can't find task, let LLM decide whether to create a new task based on email content.
+		run, err := gptClient.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{
+			Instructions: fmt.Sprintf(`Given email subject: %v\, Check if this is related to a meeting. Answer yes or no. \n `, subject),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to run gptscript to check email content: %w", err)
+		}
+		defer run.Close()
 
-				out, err := nameRun.Text()
-				if err != nil {
-					logrus.Error(fmt.Errorf("failed to run gptscript to get name: %w", err))
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
+		output, err := run.Text()
+		if err != nil {
+			return fmt.Errorf("failed to run gptscript to check email content: %w", err)
+		}
 
-				// Sometimes LLM gives repsonse wrapped by ```json```, this is to workaround that
-				out = strings.TrimSuffix(strings.TrimPrefix(out, "```json"), "```")
-				var output struct {
-					Summary string `json:"summary"`
-					Name    string `json:"name"`
-				}
-				if err := json.Unmarshal([]byte(out), &output); err != nil {
-					logrus.Error(fmt.Errorf("failed to unmarshal output: %v, %w", out, err))
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
+		if strings.ToLower(output) == "yes" {
+			nameRun, err := gptClient.Evaluate(context.Background(), gptscript.Options{}, gptscript.ToolDef{
+				Instructions: fmt.Sprintf(`Given email body: %v, summarize the email content and Assign a name for this email. Give me a json object that has name and summary as keys. use lower case. Just return string represetation of json object that can be serialized.'`, message.Body),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to run gptscript to check email content: %w", err)
+			}
 
-				name := output.Name
-				if name == "" {
-					name = namegenerator.NewNameGenerator(rand.Int63()).Generate()
-				}
+			out, err := nameRun.Text()
+			if err != nil {
+				return fmt.Errorf("failed to run gptscript to get name: %w", err)
+			}
 
-				taskParam := db.CreateTaskParams{
-					Name:           name,
-					Description:    output.Summary,
-					ToolDefinition: &tool.DefaultToolDef,
-					UserID:         user.ID,
-					MessageID:      message.GetId(),
-					MessageBody:    message.GetBody().GetContent(),
-				}
-				task, err := h.queries.CreateTask(context.Background(), taskParam)
-				if err != nil {
-					logrus.Error(fmt.Errorf("failed to create task: %w", err))
-					return
-				}
-				messsageContent := fmt.Sprintf("Task %v is created.", task.Name)
-				if err := h.queries.CreateMessage(context.Background(), db.CreateMessageParams{
-					MessageID: message.GetId(),
-					Content:   &messsageContent,
-					TaskID:    task.ID,
-					UserID:    user.ID,
-				}); err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					fmt.Fprint(w, err)
-					return
-				}
+			// Sometimes LLM gives repsonse wrapped by ```json```, this is to workaround that
+			out = strings.TrimSuffix(strings.TrimPrefix(out, "```json"), "```")
+			var output struct {
+				Summary string `json:"summary"`
+				Name    string `json:"name"`
 			}
-		} else if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, err)
-			return
-		} else {
-			content := fmt.Sprintf("%s has replied to your email", name)
-
-			if err := h.queries.CreateMessage(r.Context(), db.CreateMessageParams{
-				MessageID: message.GetId(),
-				Content:   &content,
-				TaskID:    task.ID,
-				UserID:    user.ID,
-			}); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprint(w, err)
-				return
+			if err := json.Unmarshal([]byte(out), &output); err != nil {
+				return fmt.Errorf("failed to unmarshal output: %v, %w", out, err)
+			}
+
+			name := output.Name
+			if name == "" {
+				name = namegenerator.NewNameGenerator(rand.Int63()).Generate()
 			}
 
-			messageTemplate := `
+			// The task and its notification are created together, a retry finds the task from the message ID.
+			messsageContent := fmt.Sprintf("Task %v is created.", name)
+			if err := h.queries.CreateTaskFromMessage(context.Background(), db.CreateTaskFromMessageParams{
+				Name:           name,
+				Description:    output.Summary,
+				ToolDefinition: &tool.DefaultToolDef,
+				UserID:         conn.UserID,
+				MessageID:      &message.ID,
+				MessageBody:    &message.Body,
+				ConnectionID:   conn.ID,
+				Content:        &messsageContent,
+			}); err != nil {
+				return fmt.Errorf("failed to create task: %w", err)
+			}
+		}
+	} else if err != nil {
+		return err
+	} else {
+		messageTemplate := `
 %v(%v) has replied your email with the following content: %v.
 If all the participants have replied, ask user about the next step. If not, remind user who haven't replied.
 `
-			var ret runner.State
-			if err := json.Unmarshal(task.State, &ret); err != nil {
-				logrus.Error(fmt.Errorf("failed to unmarshal state: %w", err))
-				return
-			}
+		var ret runner.State
+		if err := json.Unmarshal(task.State, &ret); err != nil {
+			return fmt.Errorf("failed to unmarshal state: %w", err)
+		}
 
-			if ret.Continuation != nil && ret.Continuation.State != nil {
-				ret.Continuation.State.Completion.Messages = append(ret.Continuation.State.Completion.Messages, types.CompletionMessage{
-					Role: types.CompletionMessageRoleTypeAssistant,
-					Content: []types.ContentPart{
-						{
-							Text: fmt.Sprintf(messageTemplate, name, email, *message.GetBody().GetContent()),
-						},
+		if ret.Continuation != nil && ret.Continuation.State != nil {
+			ret.Continuation.State.Completion.Messages = append(ret.Continuation.State.Completion.Messages, types.CompletionMessage{
+				Role: types.CompletionMessageRoleTypeAssistant,
+				Content: []types.ContentPart{
+					{
+						Text: fmt.Sprintf(messageTemplate, name, email, message.Body),
 					},
-				})
-			}
+				},
+			})
+		}
 
-			state, err := json.Marshal(ret)
-			if err != nil {
-				logrus.Error(fmt.Errorf("failed to marshal state: %w", err))
-				return
-			}
-			if err := h.queries.UpdateTaskState(r.Context(), db.UpdateTaskStateParams{
-				ID:    task.ID,
-				State: state,
-			}); err != nil {
-				logrus.Error(fmt.Errorf("failed to update task state: %w", err))
-				return
-			}
+		state, err := json.Marshal(ret)
+		if err != nil {
+			return fmt.Errorf("failed to marshal state: %w", err)
+		}
+		// The notification and the new state are saved together, a reply that was already added is not added again.
+		content := fmt.Sprintf("%s has replied to your email", name)
+		if _, err := h.queries.AddTaskReply(ctx, db.AddTaskReplyParams{
+			State:     state,
+			MessageID: &message.ID,
+			TaskID:    task.ID,
+			Content:   &content,
+			UserID:    conn.UserID,
+		}); err != nil {
+			return fmt.Errorf("failed to add reply to task: %w", err)
+		}
 
-			// Manually close possible active connection to resume task, so that user get latest information
-			connection.CloseConn(uuid.UUID(task.ID.Bytes).String())
+		// Manually close possible active connection to resume task, so that user get latest information
+		if err := connection.CloseConn(ctx, h.queries, uuid.UUID(task.ID.Bytes).String()); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// moveToColdEmails moves a message recorded as spam to the Cold Emails folder, then gives the record the ID of the
+// moved message and notifies the user.
+func (h *Handler) moveToColdEmails(ctx context.Context, p provider.Provider, conn db.MailboxConnection, spamEmail db.SpamEmail, arguments map[string]any) error {
+	messageID := *spamEmail.InboxMessageID
+	logrus.Infof("Mark message %v as Spam cold email, moving to Cold Email folder", messageID)
+
+	newMessage, err := p.MoveMessage(ctx, messageID, provider.FolderColdEmails)
+	event := audit.Event{
+		UserID:    conn.UserID,
+		Actor:     audit.ActorAssistant,
+		Mailbox:   conn.Email,
+		Tool:      "move-to-cold-emails",
+		Arguments: arguments,
+		Err:       err,
+	}
+	if err == nil {
+		event.ResponseIDs = map[string]string{"messageId": newMessage.ID}
+	}
+	if err := audit.Record(ctx, h.queries, event); err != nil {
+		logrus.Error(fmt.Errorf("failed to record moving message %v in the audit log: %w", messageID, err))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move message to cold email folder: %w", err)
+	}
+
+	if err := h.queries.CompleteSpamEmail(ctx, db.CompleteSpamEmailParams{
+		ID:        spamEmail.ID,
+		MessageID: &newMessage.ID,
+		Content:   &[]string{fmt.Sprint("Mark incoming email as SPAM")}[0],
+	}); err != nil {
+		return fmt.Errorf("failed to create spam email message: %w", err)
+	}
+	return nil
 }
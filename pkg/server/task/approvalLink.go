@@ -0,0 +1,169 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+// approvalPage shows the email, event or meeting of a pending action to the holder of its notification link. Approving
+// and rejecting post the token back, so following the link alone, as mail scanners do, decides nothing.
+var approvalPage = template.Must(template.New("approval").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Approval</title></head>
+<body style="font-family: sans-serif; max-width: 40em; margin: 2em auto">
+{{- if .Message}}
+<p>{{.Message}}</p>
+{{- end}}
+{{- with .Email}}
+<h2>Send email</h2>
+<p><b>To:</b> {{range $i, $a := .To}}{{if $i}}, {{end}}{{$a}}{{end}}</p>
+{{- if .Cc}}<p><b>Cc:</b> {{range $i, $a := .Cc}}{{if $i}}, {{end}}{{$a}}{{end}}</p>{{end}}
+{{- if .Bcc}}<p><b>Bcc:</b> {{range $i, $a := .Bcc}}{{if $i}}, {{end}}{{$a}}{{end}}</p>{{end}}
+<p><b>Subject:</b> {{.Subject}}</p>
+<pre style="white-space: pre-wrap">{{.Body}}</pre>
+{{- end}}
+{{- with .Event}}
+<h2>Create event</h2>
+<p><b>Subject:</b> {{.Subject}}</p>
+<p><b>From</b> {{.Start}} <b>to</b> {{.End}} ({{.TimeZone}})</p>
+<p><b>Attendees:</b> {{range $i, $a := .Attendees}}{{if $i}}, {{end}}{{$a}}{{end}}</p>
+<pre style="white-space: pre-wrap">{{.Content}}</pre>
+{{- end}}
+{{- with .Meeting}}
+<h2>Add an online meeting</h2>
+<p><b>Event:</b> {{.EventID}}</p>
+{{- end}}
+{{- if .Pending}}
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit" name="decision" value="approve">Approve</button>
+<button type="submit" name="decision" value="reject">Reject</button>
+</form>
+<p>To change it first, edit it in the task.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+type approvalPageData struct {
+	Message string
+	Email   *approval.Email
+	Event   *approval.Event
+	Meeting *approval.Meeting
+	Pending bool
+	Token   string
+}
+
+// ApprovalLink shows the pending action of a notification link, with buttons to approve or reject it. The token of
+// the link stands in for signing in.
+func (h *Handler) ApprovalLink(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	action, ok := h.linkedAction(w, r, token)
+	if !ok {
+		return
+	}
+	data := approvalPageData{Pending: action.Status == approval.StatusPending, Token: token}
+	if !data.Pending {
+		data.Message = fmt.Sprintf("This action was already %v.", action.Status)
+	}
+	writeApprovalPage(w, http.StatusOK, action, data)
+}
+
+// DecideApprovalLink approves or rejects the pending action of a notification link.
+func (h *Handler) DecideApprovalLink(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeApprovalPage(w, http.StatusBadRequest, db.PendingAction{}, approvalPageData{Message: "The request is malformed."})
+		return
+	}
+	action, ok := h.linkedAction(w, r, r.PostForm.Get("token"))
+	if !ok {
+		return
+	}
+	decision := r.PostForm.Get("decision")
+	if decision != api.DecisionApprove && decision != api.DecisionReject {
+		writeApprovalPage(w, http.StatusBadRequest, action, approvalPageData{Message: "Choose to approve or reject the action."})
+		return
+	}
+
+	action, err := h.decide(r.Context(), action, api.ApprovalRequest{Decision: decision})
+	if errors.Is(err, errDecided) {
+		writeApprovalPage(w, http.StatusConflict, action, approvalPageData{Message: fmt.Sprintf("This action was already %v.", action.Status)})
+		return
+	} else if err != nil {
+		logrus.Error(err)
+		writeApprovalPage(w, http.StatusInternalServerError, action, approvalPageData{Message: "Something went wrong, try again in the task."})
+		return
+	}
+
+	var message string
+	switch action.Status {
+	case approval.StatusSucceeded:
+		message = "Approved, it was done."
+	case approval.StatusFailed:
+		message = fmt.Sprintf("Approved, but it failed: %v", value(action.Error))
+	default:
+		message = "Rejected, nothing was done."
+	}
+	writeApprovalPage(w, http.StatusOK, action, approvalPageData{Message: message})
+}
+
+// linkedAction returns the action of the link, answering with a 404 page when the ID or token does not match.
+func (h *Handler) linkedAction(w http.ResponseWriter, r *http.Request, token string) (db.PendingAction, bool) {
+	var id pgtype.UUID
+	if err := id.Scan(mux.Vars(r)["id"]); err != nil || !id.Valid || token == "" {
+		writeApprovalPage(w, http.StatusNotFound, db.PendingAction{}, approvalPageData{Message: "This link is not valid."})
+		return db.PendingAction{}, false
+	}
+	action, err := h.queries.GetPendingActionByLinkToken(r.Context(), db.GetPendingActionByLinkTokenParams{
+		ID:            id,
+		LinkTokenHash: approval.HashLinkToken(token),
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logrus.Error(fmt.Errorf("failed to get linked action: %w", err))
+		}
+		writeApprovalPage(w, http.StatusNotFound, db.PendingAction{}, approvalPageData{Message: "This link is not valid."})
+		return db.PendingAction{}, false
+	}
+	return action, true
+}
+
+func writeApprovalPage(w http.ResponseWriter, status int, action db.PendingAction, data approvalPageData) {
+	switch action.Tool {
+	case approval.ToolSendEmail:
+		data.Email = &approval.Email{}
+		if err := json.Unmarshal(action.Arguments, data.Email); err != nil {
+			data.Email = nil
+		}
+	case approval.ToolSchedule:
+		data.Event = &approval.Event{}
+		if err := json.Unmarshal(action.Arguments, data.Event); err != nil {
+			data.Event = nil
+		}
+	case approval.ToolAddOnlineMeeting:
+		data.Meeting = &approval.Meeting{}
+		if err := json.Unmarshal(action.Arguments, data.Meeting); err != nil {
+			data.Meeting = nil
+		}
+	}
+
+	// The token is in the URL, keep it out of caches and Referer headers.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := approvalPage.Execute(w, data); err != nil {
+		logrus.Error(fmt.Errorf("failed to render approval page: %w", err))
+	}
+}
@@ -0,0 +1,389 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/audit"
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// errDecided is returned when the action was decided before, possibly at the same time.
+	errDecided = errors.New("the action was already decided")
+	// errEditMismatch is returned when an edit does not match the tool of the action.
+	errEditMismatch = errors.New("the edit does not match the action")
+)
+
+// ListApprovals lists the pending actions of the user's task runs, newest first. They can be filtered by taskId and
+// status.
+func (h *Handler) ListApprovals(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
+		return
+	}
+	params := db.ListPendingActionsParams{UserID: uid}
+	if taskId := r.URL.Query().Get("taskId"); taskId != "" {
+		if err := params.TaskID.Scan(taskId); err != nil || !params.TaskID.Valid {
+			api.BadRequest(w, "invalid taskId: not a UUID", api.FieldErrors{"taskId": "must be a UUID"})
+			return
+		}
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		params.Status = &status
+	}
+
+	actions, err := h.queries.ListPendingActions(r.Context(), params)
+	if err != nil {
+		api.DBError(w, err, "approvals")
+		return
+	}
+	result := make([]api.PendingAction, 0, len(actions))
+	for _, a := range actions {
+		result = append(result, api.NewPendingAction(a))
+	}
+	api.WriteJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) GetApproval(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	action, err := h.queries.GetPendingAction(r.Context(), db.GetPendingActionParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "approval")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewPendingAction(action))
+}
+
+// DecideApproval approves, edits or rejects a pending action. An approved action is sent or created right away, the
+// answer tells whether that succeeded.
+func (h *Handler) DecideApproval(w http.ResponseWriter, r *http.Request) {
+	id, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	var req api.ApprovalRequest
+	if !api.DecodeValid(w, r, &req) {
+		return
+	}
+
+	action, err := h.queries.GetPendingAction(r.Context(), db.GetPendingActionParams{
+		ID:     id,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "approval")
+		return
+	}
+	action, err = h.decide(r.Context(), action, req)
+	if errors.Is(err, errDecided) {
+		api.Conflict(w, fmt.Sprintf("the action was already %v", action.Status))
+		return
+	} else if errors.Is(err, errEditMismatch) {
+		switch action.Tool {
+		case approval.ToolSendEmail:
+			api.BadRequest(w, "invalid request", api.FieldErrors{"email": "is required to edit an email"})
+		case approval.ToolSchedule:
+			api.BadRequest(w, "invalid request", api.FieldErrors{"event": "is required to edit an event"})
+		default:
+			api.BadRequest(w, "invalid request", api.FieldErrors{"decision": fmt.Sprintf("%v cannot be edited, approve or reject it", action.Tool)})
+		}
+		return
+	} else if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewPendingAction(action))
+}
+
+// decide records the decision on the action and runs it when approved. The run of the task, on whichever replica
+// holds it, is woken up to pass the outcome on to the assistant. A failure of the action itself is recorded on it and
+// is not an error. On errDecided the action is returned as it currently is.
+func (h *Handler) decide(ctx context.Context, action db.PendingAction, req api.ApprovalRequest) (db.PendingAction, error) {
+	if action.Status != approval.StatusPending {
+		return action, errDecided
+	}
+
+	var arguments []byte
+	if req.Decision == api.DecisionEdit {
+		var edit any
+		switch {
+		case action.Tool == approval.ToolSendEmail && req.Email != nil:
+			edit = req.Email
+		case action.Tool == approval.ToolSchedule && req.Event != nil:
+			if req.Event.TimeZone == "" {
+				var original approval.Event
+				if err := json.Unmarshal(action.Arguments, &original); err != nil {
+					return action, fmt.Errorf("failed to decode event of action %v: %w", uuid.UUID(action.ID.Bytes), err)
+				}
+				req.Event.TimeZone = original.TimeZone
+			}
+			edit = req.Event
+		default:
+			return action, errEditMismatch
+		}
+		var err error
+		if arguments, err = json.Marshal(edit); err != nil {
+			return action, err
+		}
+	}
+
+	status := approval.StatusApproved
+	if req.Decision == api.DecisionReject {
+		status = approval.StatusRejected
+	}
+	decided, err := h.queries.DecidePendingAction(ctx, db.DecidePendingActionParams{
+		ID:        action.ID,
+		UserID:    action.UserID,
+		Status:    status,
+		Arguments: arguments,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		if current, err := h.queries.GetPendingAction(ctx, db.GetPendingActionParams{ID: action.ID, UserID: action.UserID}); err == nil {
+			action = current
+		}
+		return action, errDecided
+	} else if err != nil {
+		return action, fmt.Errorf("failed to decide action %v: %w", uuid.UUID(action.ID.Bytes), err)
+	}
+
+	if status == approval.StatusApproved {
+		decided, err = h.complete(ctx, decided)
+		if err != nil {
+			return decided, err
+		}
+	}
+	if err := h.queries.NotifyPendingActionDecided(ctx, uuid.UUID(decided.TaskID.Bytes).String()); err != nil {
+		logrus.Error(fmt.Errorf("failed to notify the run of task %v: %w", uuid.UUID(decided.TaskID.Bytes), err))
+	}
+	return decided, nil
+}
+
+// complete runs the approved action and records its outcome, on the action and in the audit log.
+func (h *Handler) complete(ctx context.Context, action db.PendingAction) (db.PendingAction, error) {
+	mailbox, responseIDs, runErr := h.execute(ctx, action)
+
+	if err := audit.Record(ctx, h.queries, audit.Event{
+		UserID:      action.UserID,
+		Actor:       audit.ActorAssistant,
+		TaskID:      action.TaskID,
+		Mailbox:     mailbox,
+		Tool:        action.Tool,
+		Arguments:   json.RawMessage(action.Arguments),
+		ResponseIDs: responseIDs,
+		Err:         runErr,
+	}); err != nil {
+		logrus.Error(fmt.Errorf("failed to record action %v in the audit log: %w", uuid.UUID(action.ID.Bytes), err))
+	}
+
+	params := db.CompletePendingActionParams{
+		ID:     action.ID,
+		Status: approval.StatusSucceeded,
+	}
+	if runErr != nil {
+		message := runErr.Error()
+		params.Status = approval.StatusFailed
+		params.Error = &message
+	} else {
+		result, err := json.Marshal(responseIDs)
+		if err != nil {
+			return action, err
+		}
+		params.Result = result
+	}
+	completed, err := h.queries.CompletePendingAction(ctx, params)
+	if err != nil {
+		return action, fmt.Errorf("failed to complete action %v: %w", uuid.UUID(action.ID.Bytes), err)
+	}
+	return completed, nil
+}
+
+// execute sends the email, creates the event or adds the online meeting of the action with the mailbox the task ran
+// with, and returns the address of the mailbox and the IDs the provider answered with.
+func (h *Handler) execute(ctx context.Context, action db.PendingAction) (string, map[string]string, error) {
+	p, mailbox, err := h.provider(ctx, action.ConnectionID)
+	if err != nil {
+		return mailbox, nil, err
+	}
+
+	switch action.Tool {
+	case approval.ToolSendEmail:
+		var e approval.Email
+		if err := json.Unmarshal(action.Arguments, &e); err != nil {
+			return mailbox, nil, err
+		}
+		message, err := p.SendMessage(ctx, provider.Message{
+			Subject: e.Subject,
+			Body:    e.Body,
+			To:      e.To,
+			Cc:      e.Cc,
+			Bcc:     e.Bcc,
+		})
+		if err != nil {
+			return mailbox, nil, err
+		}
+		// Replies to the first email the task sends are handled by the task.
+		if err := h.queries.SetTaskConversationIDIfUnset(ctx, db.SetTaskConversationIDIfUnsetParams{
+			ID:             action.TaskID,
+			ConversationID: &message.ConversationID,
+		}); err != nil {
+			logrus.Error(fmt.Errorf("failed to update task conversation: %w", err))
+		}
+		return mailbox, map[string]string{
+			"messageId":         message.ID,
+			"conversationId":    message.ConversationID,
+			"internetMessageId": message.InternetMessageID,
+		}, nil
+	case approval.ToolSchedule:
+		var e approval.Event
+		if err := json.Unmarshal(action.Arguments, &e); err != nil {
+			return mailbox, nil, err
+		}
+		event, err := p.CreateEvent(ctx, provider.Event{
+			Subject:   e.Subject,
+			Content:   e.Content,
+			Start:     e.Start,
+			End:       e.End,
+			TimeZone:  e.TimeZone,
+			Attendees: e.Attendees,
+		})
+		if err != nil {
+			return mailbox, nil, err
+		}
+		return mailbox, map[string]string{"eventId": event.ID}, nil
+	case approval.ToolAddOnlineMeeting:
+		var m approval.Meeting
+		if err := json.Unmarshal(action.Arguments, &m); err != nil {
+			return mailbox, nil, err
+		}
+		meeting, err := p.AddOnlineMeeting(ctx, m.EventID)
+		if err != nil {
+			return mailbox, nil, err
+		}
+		responseIDs := map[string]string{"eventId": m.EventID}
+		if meeting != nil {
+			responseIDs["conferenceId"] = meeting.ConferenceID
+			responseIDs["url"] = meeting.URL
+		}
+		return mailbox, responseIDs, nil
+	default:
+		return mailbox, nil, fmt.Errorf("unknown tool %v", action.Tool)
+	}
+}
+
+// provider builds the provider of the mailbox connection, and returns the address of the mailbox.
+func (h *Handler) provider(ctx context.Context, connectionID pgtype.UUID) (provider.Provider, string, error) {
+	conn, err := h.queries.GetMailboxConnection(ctx, connectionID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get mailbox: %w", err)
+	}
+	p, err := h.providers.NewDelegated(conn.Provider, string(conn.Token), api.MailboxDelegation(conn))
+	if err != nil {
+		return nil, conn.Email, fmt.Errorf("failed to create mail provider: %w", err)
+	}
+	return p, conn.Email, nil
+}
+
+// requestApproval stores the action of a tool call of the task run as pending, and adds it to the notifications of the
+// user running the task with a link that approves or rejects it without signing in. The notification stays in the
+// app, nothing is sent to the user.
+func (h *Handler) requestApproval(ctx context.Context, userID, taskID, connectionID pgtype.UUID, a approval.Action) (db.PendingAction, error) {
+	arguments, err := json.Marshal(a.Arguments)
+	if err != nil {
+		return db.PendingAction{}, err
+	}
+	token, err := approval.NewLinkToken()
+	if err != nil {
+		return db.PendingAction{}, err
+	}
+
+	action, err := h.queries.CreatePendingAction(ctx, db.CreatePendingActionParams{
+		UserID:        userID,
+		TaskID:        taskID,
+		ConnectionID:  connectionID,
+		Tool:          a.Tool,
+		Arguments:     arguments,
+		LinkTokenHash: approval.HashLinkToken(token),
+	})
+	if err != nil {
+		return action, fmt.Errorf("failed to create pending action: %w", err)
+	}
+
+	rendered, err := json.Marshal(api.NewPendingAction(action))
+	if err != nil {
+		return action, err
+	}
+	id := uuid.UUID(action.ID.Bytes).String()
+	content := fmt.Sprintf("Approval needed: %v. Approve or reject it in the task, or at %v", a.Summary, approval.LinkURL(id, token))
+	if err := h.queries.CreateApprovalMessage(ctx, db.CreateApprovalMessageParams{
+		TaskID:   taskID,
+		Content:  &content,
+		UserID:   userID,
+		Approval: rendered,
+	}); err != nil {
+		return action, fmt.Errorf("failed to create approval message: %w", err)
+	}
+	return action, nil
+}
+
+// awaitDecision blocks until the user decided the action and, when they approved it, it was run. decided wakes it up.
+// The outcome is marked as reported, the caller passes it on to the assistant.
+func (h *Handler) awaitDecision(ctx context.Context, action db.PendingAction, decided <-chan struct{}) (db.PendingAction, error) {
+	for {
+		current, err := h.queries.GetPendingAction(ctx, db.GetPendingActionParams{
+			ID:     action.ID,
+			UserID: action.UserID,
+		})
+		if err != nil {
+			return action, fmt.Errorf("failed to get pending action %v: %w", uuid.UUID(action.ID.Bytes), err)
+		}
+		if current.Status != approval.StatusPending && current.Status != approval.StatusApproved {
+			if err := h.queries.ReportPendingAction(ctx, current.ID); err != nil {
+				return current, fmt.Errorf("failed to report action %v: %w", uuid.UUID(action.ID.Bytes), err)
+			}
+			return current, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return current, ctx.Err()
+		case <-decided:
+		}
+	}
+}
+
+// outcome is what the assistant is told about a decided action.
+func outcome(action db.PendingAction) string {
+	id := uuid.UUID(action.ID.Bytes).String()
+	switch action.Status {
+	case approval.StatusSucceeded:
+		return fmt.Sprintf("The user approved %v %v with the arguments %s, it succeeded with %s.", action.Tool, id, action.Arguments, action.Result)
+	case approval.StatusFailed:
+		return fmt.Sprintf("The user approved %v %v with the arguments %s, but it failed: %v.", action.Tool, id, action.Arguments, value(action.Error))
+	default:
+		return fmt.Sprintf("The user rejected %v %v, nothing was sent or created.", action.Tool, id)
+	}
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,314 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/audit"
+	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sirupsen/logrus"
+)
+
+// ListDrafts lists the drafts the assistant left in the mailbox in the runs of the task, oldest first.
+func (h *Handler) ListDrafts(w http.ResponseWriter, r *http.Request) {
+	taskID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	task, err := h.queries.GetTask(r.Context(), db.GetTaskParams{ID: taskID, UserID: uid})
+	if err != nil {
+		api.DBError(w, err, "task")
+		return
+	}
+
+	drafts, err := h.queries.ListTaskDrafts(r.Context(), taskID)
+	if err != nil {
+		api.DBError(w, err, "drafts")
+		return
+	}
+	mailboxes, err := h.queries.ListMailboxConnectionsForUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "mailboxes")
+		return
+	}
+	result := make([]api.TaskDraft, 0, len(drafts))
+	for _, d := range drafts {
+		if canSeeDraft(task, d, uid, mailboxes) {
+			result = append(result, api.NewTaskDraft(d, nil))
+		}
+	}
+	api.WriteJSON(w, http.StatusOK, result)
+}
+
+// GetDraft answers with the draft and, unless it was sent, its current content in the mailbox, which includes the
+// changes the user made in their mail client.
+func (h *Handler) GetDraft(w http.ResponseWriter, r *http.Request) {
+	draft, ok := h.taskDraft(w, r)
+	if !ok {
+		return
+	}
+	if draft.Status != approval.DraftStatusDraft {
+		api.WriteJSON(w, http.StatusOK, api.NewTaskDraft(draft, nil))
+		return
+	}
+
+	drafter, _, err := h.drafter(r.Context(), draft)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	message, err := drafter.GetMessage(r.Context(), draft.MessageID)
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to get draft: %w", err))
+		return
+	}
+	email := toEmail(message)
+	api.WriteJSON(w, http.StatusOK, api.NewTaskDraft(draft, &email))
+}
+
+// UpdateDraft changes the fields of the draft that are set in the request, in the mailbox and on the task.
+func (h *Handler) UpdateDraft(w http.ResponseWriter, r *http.Request) {
+	draft, ok := h.taskDraft(w, r)
+	if !ok {
+		return
+	}
+	var req api.DraftRequest
+	if !api.DecodeValid(w, r, &req) {
+		return
+	}
+	if draft.Status != approval.DraftStatusDraft {
+		api.Conflict(w, "the draft was already sent")
+		return
+	}
+
+	drafter, _, err := h.drafter(r.Context(), draft)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	// The draft may have been edited in the mail client since, so the request applies to its current content.
+	current, err := drafter.GetMessage(r.Context(), draft.MessageID)
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to get draft: %w", err))
+		return
+	}
+	email := req.Apply(toEmail(current))
+	message, err := drafter.UpdateDraft(r.Context(), draft.MessageID, provider.Message{
+		Subject: email.Subject,
+		Body:    email.Body,
+		To:      email.To,
+		Cc:      email.Cc,
+		Bcc:     email.Bcc,
+	})
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to update draft: %w", err))
+		return
+	}
+
+	var webLink *string
+	if message.WebLink != "" {
+		webLink = &message.WebLink
+	}
+	draft, err = h.queries.UpdateTaskDraft(r.Context(), db.UpdateTaskDraftParams{
+		ID:         draft.ID,
+		Subject:    email.Subject,
+		Recipients: approval.Recipients(message),
+		WebLink:    webLink,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.Conflict(w, "the draft was already sent")
+		return
+	} else if err != nil {
+		api.DBError(w, err, "draft")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewTaskDraft(draft, &email))
+}
+
+// SendDraft sends the draft as it currently is in the mailbox. The send is recorded in the audit log as the user's.
+func (h *Handler) SendDraft(w http.ResponseWriter, r *http.Request) {
+	draft, ok := h.taskDraft(w, r)
+	if !ok {
+		return
+	}
+	uid, _ := api.UserID(w, r)
+	if draft.Status != approval.DraftStatusDraft {
+		api.Conflict(w, "the draft was already sent")
+		return
+	}
+
+	drafter, mailbox, err := h.drafter(r.Context(), draft)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	message, err := drafter.GetMessage(r.Context(), draft.MessageID)
+	if err != nil {
+		api.InternalError(w, fmt.Errorf("failed to get draft: %w", err))
+		return
+	}
+
+	// Marking the draft sent first keeps concurrent requests from sending it twice.
+	sent, err := h.queries.MarkTaskDraftSent(r.Context(), draft.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.Conflict(w, "the draft was already sent")
+		return
+	} else if err != nil {
+		api.DBError(w, err, "draft")
+		return
+	}
+
+	sendErr := drafter.SendDraft(r.Context(), draft.MessageID)
+	var responseIDs map[string]string
+	if sendErr == nil {
+		responseIDs = map[string]string{
+			"messageId":      draft.MessageID,
+			"conversationId": message.ConversationID,
+		}
+	}
+	if err := audit.Record(r.Context(), h.queries, audit.Event{
+		UserID:      uid,
+		Actor:       audit.ActorUser,
+		TaskID:      draft.TaskID,
+		Mailbox:     mailbox,
+		Tool:        approval.ToolSendEmail,
+		Arguments:   toEmail(message),
+		ResponseIDs: responseIDs,
+		Err:         sendErr,
+	}); err != nil {
+		logrus.Error(fmt.Errorf("failed to record draft %v in the audit log: %w", uuid.UUID(draft.ID.Bytes), err))
+	}
+	if sendErr != nil {
+		if err := h.queries.RevertTaskDraftSent(r.Context(), draft.ID); err != nil {
+			logrus.Error(fmt.Errorf("failed to revert draft %v: %w", uuid.UUID(draft.ID.Bytes), err))
+		}
+		api.InternalError(w, fmt.Errorf("failed to send draft: %w", sendErr))
+		return
+	}
+
+	// Replies to the first email the task sends are handled by the task.
+	if message.ConversationID != "" {
+		if err := h.queries.SetTaskConversationIDIfUnset(r.Context(), db.SetTaskConversationIDIfUnsetParams{
+			ID:             draft.TaskID,
+			ConversationID: &message.ConversationID,
+		}); err != nil {
+			logrus.Error(fmt.Errorf("failed to update task conversation: %w", err))
+		}
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewTaskDraft(sent, nil))
+}
+
+// DeleteDraft discards the draft, deleting it from the mailbox and from the task.
+func (h *Handler) DeleteDraft(w http.ResponseWriter, r *http.Request) {
+	draft, ok := h.taskDraft(w, r)
+	if !ok {
+		return
+	}
+	if draft.Status != approval.DraftStatusDraft {
+		api.Conflict(w, "the draft was already sent")
+		return
+	}
+
+	drafter, _, err := h.drafter(r.Context(), draft)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := drafter.DeleteDraft(r.Context(), draft.MessageID); err != nil {
+		api.InternalError(w, fmt.Errorf("failed to delete draft: %w", err))
+		return
+	}
+	if err := h.queries.DeleteTaskDraft(r.Context(), draft.ID); err != nil {
+		api.DBError(w, err, "draft")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// taskDraft returns the draft in the path of a task the user can see, answering with a 404 otherwise. See canSeeDraft
+// for the drafts of a task the user can see.
+func (h *Handler) taskDraft(w http.ResponseWriter, r *http.Request) (db.TaskDraft, bool) {
+	taskID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return db.TaskDraft{}, false
+	}
+	draftID, ok := api.PathID(w, r, "draftId")
+	if !ok {
+		return db.TaskDraft{}, false
+	}
+	task, err := h.queries.GetTask(r.Context(), db.GetTaskParams{ID: taskID, UserID: uid})
+	if err != nil {
+		api.DBError(w, err, "task")
+		return db.TaskDraft{}, false
+	}
+	draft, err := h.queries.GetTaskDraft(r.Context(), db.GetTaskDraftParams{ID: draftID, TaskID: taskID})
+	if err != nil {
+		api.DBError(w, err, "draft")
+		return db.TaskDraft{}, false
+	}
+	mailboxes, err := h.queries.ListMailboxConnectionsForUser(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "mailboxes")
+		return db.TaskDraft{}, false
+	}
+	if !canSeeDraft(task, draft, uid, mailboxes) {
+		api.NotFound(w, "draft")
+		return db.TaskDraft{}, false
+	}
+	return draft, true
+}
+
+// canSeeDraft reports whether the user can see and work on a draft of the task. Assignees run the task too, and each
+// sees the drafts of their own runs. The owner of the task also sees the drafts left in their mailboxes, such as those of
+// a delegate's runs, but not those in the mailbox of an assignee.
+func canSeeDraft(task db.Task, draft db.TaskDraft, uid pgtype.UUID, mailboxes []db.MailboxConnection) bool {
+	if draft.UserID == uid {
+		return true
+	}
+	if task.UserID != uid {
+		return false
+	}
+	for _, m := range mailboxes {
+		if m.ID == draft.ConnectionID {
+			return true
+		}
+	}
+	return false
+}
+
+// drafter returns the provider of the mailbox the draft was saved in, and the address of the mailbox.
+func (h *Handler) drafter(ctx context.Context, draft db.TaskDraft) (draftProvider, string, error) {
+	p, mailbox, err := h.provider(ctx, draft.ConnectionID)
+	if err != nil {
+		return nil, mailbox, err
+	}
+	d, ok := p.(draftProvider)
+	if !ok {
+		return nil, mailbox, provider.ErrDraftsUnsupported
+	}
+	return d, mailbox, nil
+}
+
+// draftProvider is a provider that reads and works on drafts.
+type draftProvider interface {
+	provider.MailProvider
+	provider.Drafter
+}
+
+func toEmail(m provider.Message) approval.Email {
+	return approval.Email{
+		Subject: m.Subject,
+		Body:    m.Body,
+		To:      m.To,
+		Cc:      m.Cc,
+		Bcc:     m.Bcc,
+	}
+}
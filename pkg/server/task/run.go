@@ -8,57 +8,67 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"ethan/pkg/approval"
+	"ethan/pkg/audit"
 	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
 	"ethan/pkg/server/connection"
 	"github.com/google/uuid"
-	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/gptscript-ai/go-gptscript"
-	"github.com/gptscript-ai/gptscript/pkg/runner"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/sirupsen/logrus"
 )
 
 func (h *Handler) RunTask(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-	userID := r.Header.Get("X-User-ID")
-	var uid pgtype.UUID
-	if err := uid.Scan(userID); err != nil {
-		logrus.Error(fmt.Errorf("invalid user id: %s", userID))
+	taskID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
+	userID := uuid.UUID(uid.Bytes).String()
 
-	vars := mux.Vars(r)
-	var taskID pgtype.UUID
-	if err := taskID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", userID))
-		return
-	}
-
-	task, err := h.queries.GetTask(ctx, taskID)
+	task, err := h.queries.GetTask(ctx, db.GetTaskParams{
+		ID:     taskID,
+		UserID: uid,
+	})
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch task from database: %w", err))
+		api.DBError(w, err, "task")
 		return
 	}
 
 	user, err := h.queries.GetUser(ctx, uid)
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch user: %w", err))
+		api.DBError(w, err, "user")
+		return
+	}
+	mailbox, ok := h.runAs(ctx, w, task, user)
+	if !ok {
 		return
 	}
 
+	// The tools record their actions in the audit log as taken for the user running the task, and add the drafts they
+	// leave to the task. Emails and events are held for that user to approve, see confirm.
+	env := append(os.Environ(), provider.Env(mailbox.Provider, string(mailbox.Token), api.MailboxDelegation(mailbox))...)
+	env = append(env, audit.Env(user.ID, taskID, mailbox.Email)...)
+	env = append(env, approval.Env(user.ID, taskID, mailbox.ID)...)
 	client, err := gptscript.NewGPTScript(gptscript.GlobalOptions{
-		OpenAIAPIKey: os.Getenv("OPENAI_API_KEY"),
-		DefaultModel: os.Getenv("DEFAULT_MODEL"),
-		Env:          append(os.Environ(), fmt.Sprintf("GPTSCRIPT_GRAPH_MICROSOFT_COM_BEARER_TOKEN=%v", user.Token)),
-		HashID:       uuid.UUID(user.ID.Bytes).String(),
+		OpenAIAPIKey:  os.Getenv("OPENAI_API_KEY"),
+		OpenAIBaseURL: os.Getenv("OPENAI_BASE_URL"),
+		DefaultModel:  os.Getenv("DEFAULT_MODEL"),
+		Env:           env,
+		HashID:        uuid.UUID(user.ID.Bytes).String(),
 	})
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to create gptscript client: %w", err))
+		api.InternalError(w, fmt.Errorf("failed to create gptscript client: %w", err))
 		return
 	}
 	defer client.Close()
@@ -99,8 +109,15 @@ func (h *Handler) RunTask(w http.ResponseWriter, r *http.Request) {
 		toolDefs[0].Instructions += "\n" + fmt.Sprintf("You are provided with the following rules: %v\n", *task.Context)
 	}
 	for _, contextID := range task.ContextIds {
-		cont, err := h.queries.GetContext(ctx, contextID)
-		if err != nil {
+		// Contexts were picked by the owner, an assignee may not see all of them.
+		cont, err := h.queries.GetContext(ctx, db.GetContextParams{
+			ID:     contextID,
+			UserID: task.UserID,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The context was deleted, or belongs to someone else.
+			continue
+		} else if err != nil {
 			logrus.Error(fmt.Errorf("failed to fetch context from database: %w", err))
 			return
 		}
@@ -108,15 +125,29 @@ func (h *Handler) RunTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	toolDefs[0].Instructions += "\n" + fmt.Sprintf("Current user: %v\n", user.Name)
-	toolDefs[0].Instructions += "\n" + fmt.Sprintf("Current email: %v\n", user.Email)
+	toolDefs[0].Instructions += "\n" + fmt.Sprintf("Current email: %v\n", mailbox.Email)
+	if mailbox.DelegationID.Valid {
+		grant, err := h.queries.GetDelegationGrant(ctx, db.GetDelegationGrantParams{
+			ID:     mailbox.DelegationID,
+			UserID: mailbox.UserID,
+		})
+		if err != nil {
+			logrus.Error(fmt.Errorf("failed to fetch delegation from database: %w", err))
+			return
+		}
+		toolDefs[0].Instructions += "\n" + fmt.Sprintf("This is the mailbox of %v. %v is their delegate, emails go out on behalf of %v and events are created on their calendar. The delegate may only: %v.\n",
+			grant.PrincipalName, user.Name, grant.PrincipalName, strings.Join(grant.DelegationGrant.Permissions, ", "))
+	}
 	toolDefs[0].Instructions += "\n" + fmt.Sprintf("Current time: %v\n", time.Now())
 
 	if task.MessageBody != nil {
 		toolDefs[0].Instructions += "\n" + fmt.Sprintf("You are provided with an existing email: %v\n", *task.MessageBody)
 	}
 
+	// Every tool call waits for confirm, which holds those that send emails or create events for the user to approve.
 	run, err := client.Evaluate(ctx, gptscript.Options{
 		Prompt:        true,
+		Confirm:       true,
 		IncludeEvents: true,
 		DisableCache:  true,
 		ChatState:     string(task.State),
@@ -126,9 +157,15 @@ func (h *Handler) RunTask(w http.ResponseWriter, r *http.Request) {
 	writeLock := &sync.Mutex{}
 	go ping(conn, run, writeLock, cancel)
 
+	// Decisions on the pending actions of the run wake it up while it waits for the user.
+	decided, stopWatching := connection.WatchDecisions(taskIDString)
+	defer stopWatching()
+	messages := make(chan string)
+	go read(ctx, conn, messages, cancel)
+
 	for {
 		// Since the token is only valid for an hour, check whether token
-		if user.ExpireAt.Valid && user.ExpireAt.Time.Before(time.Now()) {
+		if mailbox.ExpireAt.Valid && mailbox.ExpireAt.Time.Before(time.Now()) {
 			logrus.Warnf("User %v's credential token expired, restarting connection", userID)
 			return
 		}
@@ -139,11 +176,7 @@ func (h *Handler) RunTask(w http.ResponseWriter, r *http.Request) {
 		default:
 			for event := range run.Events() {
 				if event.Call != nil {
-					message := struct {
-						ID    string                         `json:"id"`
-						Frame gptscript.CallFrame            `json:"frame"`
-						State map[string]gptscript.CallFrame `json:"state"`
-					}{
+					message := api.RunFrame{
 						ID:    event.Call.ID,
 						Frame: *event.Call,
 						State: run.Calls(),
@@ -163,6 +196,13 @@ func (h *Handler) RunTask(w http.ResponseWriter, r *http.Request) {
 						return
 					}
 					writeLock.Unlock()
+
+					if event.Call.Type == gptscript.EventTypeCallConfirm {
+						if err := h.confirm(ctx, client, conn, writeLock, *event.Call, user.ID, taskID, mailbox, decided); err != nil {
+							logrus.Error(fmt.Errorf("failed to confirm tool call: %w", err))
+							return
+						}
+					}
 				}
 			}
 
@@ -184,58 +224,203 @@ func (h *Handler) RunTask(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
-			if task.ConversationID == nil && run.ChatState() != "" {
-				var ret runner.State
-				if err := json.Unmarshal([]byte(run.ChatState()), &ret); err != nil {
-					logrus.Error(fmt.Errorf("failed to unmarshal state: %w", err))
-					return
-				}
-				if ret.Continuation != nil && ret.Continuation.State != nil {
-					for _, r := range ret.Continuation.State.Results {
-						if r.ToolID == "inline:send-email" {
-							var out struct {
-								MessageID      string `json:"messageId"`
-								ConversationID string `json:"conversationId"`
-							}
-							if err := json.Unmarshal([]byte(r.Result), &out); err != nil {
-								logrus.Error(fmt.Errorf("failed to unmarshal result: %w", err))
-								return
-							}
-
-							if err := h.queries.UpdateTaskConversationID(ctx, db.UpdateTaskConversationIDParams{
-								ID:             taskID,
-								ConversationID: &out.ConversationID,
-							}); err != nil {
-								logrus.Error(fmt.Errorf("failed to update task conversation: %w", err))
-								return
-							}
-							task.ConversationID = &out.ConversationID
-						}
-					}
-				}
+			if err := h.sendApprovals(ctx, conn, writeLock, taskID); err != nil {
+				logrus.Error(fmt.Errorf("failed to send approvals to client: %w", err))
+				return
 			}
 
-			messageType, m, err := conn.ReadMessage()
+			prompt, ok := h.nextPrompt(ctx, taskID, messages, decided)
+			if !ok {
+				return
+			}
+			run, err = run.NextChat(ctx, prompt)
 			if err != nil {
+				logrus.Error(fmt.Errorf("failed to run NextChat: %w", err))
 				return
 			}
+		}
+	}
+}
 
-			switch messageType {
-			case websocket.TextMessage:
-				run, err = run.NextChat(ctx, string(m))
-				if err != nil {
-					logrus.Error(fmt.Errorf("failed to run NextChat: %w", err))
-					return
-				}
-			case websocket.CloseMessage:
-				return
-			default:
-				log.Println("Received unknown message type")
-				return
+// read passes the chat messages of the user on to messages, and cancels the run when the connection closes.
+func read(ctx context.Context, conn *websocket.Conn, messages chan<- string, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		messageType, m, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType != websocket.TextMessage {
+			log.Println("Received unknown message type")
+			return
+		}
+		select {
+		case messages <- string(m):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendApprovals sends the pending actions of the task to the client, for the user to approve, edit or reject.
+func (h *Handler) sendApprovals(ctx context.Context, conn *websocket.Conn, lock *sync.Mutex, taskID pgtype.UUID) error {
+	actions, err := h.queries.ListPendingActionsForTask(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		if err := writeApproval(conn, lock, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeApproval(conn *websocket.Conn, lock *sync.Mutex, action db.PendingAction) error {
+	data, err := json.Marshal(api.ApprovalFrame{
+		Approval: api.NewPendingAction(action),
+	})
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// confirm lets a tool call of the run go on. Calls that would send an email or create an event do not run: the action
+// is held for the user running the task to approve, and the run waits for their decision. The server runs the action
+// once they approve it, as it is or edited, and the call answers the assistant with the outcome in place of the tool.
+func (h *Handler) confirm(ctx context.Context, client gptscript.GPTScript, conn *websocket.Conn, lock *sync.Mutex, call gptscript.CallFrame, userID, taskID pgtype.UUID, mailbox db.MailboxConnection, decided <-chan struct{}) error {
+	a, ok, err := approval.FromCall(call.Tool.Instructions, call.Input, mailbox.Email)
+	if err != nil {
+		return client.Confirm(ctx, gptscript.AuthResponse{
+			ID:      call.ID,
+			Message: fmt.Sprintf("Nothing was done: %v.", err),
+		})
+	}
+	if !ok {
+		return client.Confirm(ctx, gptscript.AuthResponse{ID: call.ID, Accept: true})
+	}
+
+	action, err := h.requestApproval(ctx, userID, taskID, mailbox.ID, a)
+	if err != nil {
+		return err
+	}
+	if err := writeApproval(conn, lock, action); err != nil {
+		return err
+	}
+	if action, err = h.awaitDecision(ctx, action, decided); err != nil {
+		return err
+	}
+	return client.Confirm(ctx, gptscript.AuthResponse{
+		ID:      call.ID,
+		Message: outcome(action),
+	})
+}
+
+// nextPrompt waits for the next chat message of the user. Decisions on the pending actions of the task, made in the
+// meantime or while nobody ran it, are passed on to the assistant first.
+func (h *Handler) nextPrompt(ctx context.Context, taskID pgtype.UUID, messages <-chan string, decided <-chan struct{}) (string, bool) {
+	for {
+		reported, err := h.queries.ReportPendingActions(ctx, taskID)
+		if err != nil {
+			logrus.Error(fmt.Errorf("failed to report decided actions: %w", err))
+			return "", false
+		}
+		if len(reported) > 0 {
+			outcomes := make([]string, 0, len(reported))
+			for _, action := range reported {
+				outcomes = append(outcomes, outcome(action))
 			}
+			return strings.Join(outcomes, "\n"), true
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", false
+		case m := <-messages:
+			return m, true
+		case <-decided:
 		}
+	}
+}
+
+// runAs returns the mailbox the assistant works with when the user runs the task. Owners work with the task's mailbox
+// and assigned members with their own. A delegate works with the mailbox of the task's owner, but only through the
+// delegation the owner granted them for it: their own Microsoft credentials on the owner's mailbox, within the granted
+// permissions. The owner's token is never lent to them.
+func (h *Handler) runAs(ctx context.Context, w http.ResponseWriter, task db.Task, user db.User) (db.MailboxConnection, bool) {
+	if task.UserID == user.ID {
+		return h.runMailbox(ctx, w, task.UserID, task.ConnectionID)
+	}
+
+	member, err := h.queries.GetOrganizationMember(ctx, db.GetOrganizationMemberParams{
+		OrganizationID: task.OrganizationID,
+		UserID:         user.ID,
+	})
+	if err != nil {
+		api.DBError(w, err, "task")
+		return db.MailboxConnection{}, false
+	}
+	if member.Role != api.RoleDelegate {
+		return h.runMailbox(ctx, w, user.ID, pgtype.UUID{})
+	}
+
+	owned, ok := h.runMailbox(ctx, w, task.UserID, task.ConnectionID)
+	if !ok {
+		return db.MailboxConnection{}, false
+	}
+	notDelegated := fmt.Sprintf("%v is not delegated to you, the owner of the task grants it under /api/delegations", owned.Email)
+	conn, err := h.queries.GetDelegatedMailboxConnectionFromEmail(ctx, owned.Email)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && conn.UserID != user.ID) {
+		api.Conflict(w, notDelegated)
+		return db.MailboxConnection{}, false
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
+		return db.MailboxConnection{}, false
+	}
+	grant, err := h.queries.GetDelegationGrant(ctx, db.GetDelegationGrantParams{
+		ID:     conn.DelegationID,
+		UserID: user.ID,
+	})
+	if err != nil {
+		api.DBError(w, err, "delegation")
+		return db.MailboxConnection{}, false
+	}
+	if grant.DelegationGrant.PrincipalID != task.UserID {
+		api.Conflict(w, notDelegated)
+		return db.MailboxConnection{}, false
+	}
+	return conn, true
+}
+
+// runMailbox returns the mailbox connID of the user. Tasks whose mailbox was disconnected, and tasks created before
+// any mailbox was connected, run with the first mailbox of the user.
+func (h *Handler) runMailbox(ctx context.Context, w http.ResponseWriter, uid, connID pgtype.UUID) (db.MailboxConnection, bool) {
+	if connID.Valid {
+		conn, err := h.queries.GetMailboxConnectionForUser(ctx, db.GetMailboxConnectionForUserParams{
+			ID:     connID,
+			UserID: uid,
+		})
+		if err == nil {
+			return conn, true
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			api.DBError(w, err, "mailbox")
+			return db.MailboxConnection{}, false
+		}
+	}
 
+	conn, err := h.queries.GetDefaultMailboxConnection(ctx, uid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.Conflict(w, "no mailbox is connected")
+		return db.MailboxConnection{}, false
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
+		return db.MailboxConnection{}, false
 	}
+	return conn, true
 }
 
 func ping(conn *websocket.Conn, run *gptscript.Run, lock *sync.Mutex, cancel context.CancelFunc) {
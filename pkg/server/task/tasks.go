@@ -1,20 +1,17 @@
 package task
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
 	"time"
 
 	"ethan/pkg/db"
+	"ethan/pkg/provider"
+	"ethan/pkg/server/api"
 	"ethan/pkg/tool"
 
-	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -27,136 +24,258 @@ const (
 )
 
 type Handler struct {
-	queries *db.Queries
+	queries   *db.Queries
+	providers provider.Registry
 }
 
-func NewHandler(queries *db.Queries) *Handler {
-	return &Handler{queries: queries}
+func NewHandler(queries *db.Queries, providers provider.Registry) *Handler {
+	return &Handler{queries: queries, providers: providers}
 }
 
 func (h *Handler) CreateTask(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	var uid pgtype.UUID
-	if err := uid.Scan(userID); err != nil {
-		logrus.Error(fmt.Errorf("invalid user id: %s", userID))
+	uid, ok := api.UserID(w, r)
+	if !ok {
 		return
 	}
-	var taskParam db.CreateTaskParams
-	data, err := io.ReadAll(r.Body)
-	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch tasks from database: %w", err))
+	var req api.TaskRequest
+	if !api.DecodeValid(w, r, &req) || !h.ownsContexts(w, r, uid, req.ContextUUIDs()) {
 		return
 	}
-	if len(data) != 0 {
-		if err := json.Unmarshal(data, &taskParam); err != nil {
-			logrus.Error(fmt.Errorf("failed to unmarshal tasks from request body: %w", err))
-			return
-		}
-	}
-	taskParam.UserID = uid
-	if taskParam.ToolDefinition == nil {
-		taskParam.ToolDefinition = &tool.DefaultToolDef
-	}
-	task, err := h.queries.CreateTask(r.Context(), taskParam)
-	if err != nil {
-		logrus.Error(fmt.Errorf("failed to create task: %w", err))
+	connID, ok := h.taskMailbox(w, r, uid, req, true)
+	if !ok {
 		return
 	}
-	if err := json.NewEncoder(w).Encode(task); err != nil {
-		logrus.Error(fmt.Errorf("failed to encode task output: %w", err))
+
+	task, err := h.queries.CreateTask(r.Context(), db.CreateTaskParams{
+		UserID:         uid,
+		Name:           req.Name,
+		Description:    req.Description,
+		Context:        req.Context,
+		ContextIds:     req.ContextUUIDs(),
+		ToolDefinition: &tool.DefaultToolDef,
+		ConnectionID:   connID,
+	})
+	if err != nil {
+		api.DBError(w, err, "task")
 		return
 	}
-	return
+	api.WriteJSON(w, http.StatusCreated, api.NewTask(task))
 }
 
 func (h *Handler) GetTask(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var taskID pgtype.UUID
-	if err := taskID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
+	taskID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
 
-	task, err := h.queries.GetTask(r.Context(), taskID)
+	task, err := h.queries.GetTask(r.Context(), db.GetTaskParams{
+		ID:     taskID,
+		UserID: uid,
+	})
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to unmarshal tasks from request body: %w", err))
+		api.DBError(w, err, "task")
 		return
 	}
+	api.WriteJSON(w, http.StatusOK, api.NewTask(task))
+}
 
-	if err := json.NewEncoder(w).Encode(task); err != nil {
-		logrus.Error(fmt.Errorf("failed to encode task output: %w", err))
+func (h *Handler) UpdateTask(w http.ResponseWriter, r *http.Request) {
+	taskID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	var req api.TaskRequest
+	if !api.DecodeValid(w, r, &req) || !h.ownsContexts(w, r, uid, req.ContextUUIDs()) {
+		return
+	}
+	connID, ok := h.taskMailbox(w, r, uid, req, false)
+	if !ok {
 		return
 	}
-	return
-}
 
-func (h *Handler) UpdateTask(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var taskID pgtype.UUID
-	if err := taskID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
-		w.WriteHeader(http.StatusInternalServerError)
+	n, err := h.queries.UpdateTask(r.Context(), db.UpdateTaskParams{
+		ID:           taskID,
+		UserID:       uid,
+		Name:         req.Name,
+		Description:  req.Description,
+		Context:      req.Context,
+		ContextIds:   req.ContextUUIDs(),
+		ConnectionID: connID,
+	})
+	if err != nil {
+		api.DBError(w, err, "task")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "task")
 		return
 	}
 
-	var taskParam db.UpdateTaskParams
-	data, err := io.ReadAll(r.Body)
+	task, err := h.queries.GetTask(r.Context(), db.GetTaskParams{
+		ID:     taskID,
+		UserID: uid,
+	})
 	if err != nil {
-		logrus.Error(fmt.Errorf("failed to fetch tasks from database: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		api.DBError(w, err, "task")
 		return
 	}
-	if len(data) != 0 {
-		if err := json.Unmarshal(data, &taskParam); err != nil {
-			logrus.Error(fmt.Errorf("failed to unmarshal tasks from request body: %w", err))
-			w.WriteHeader(http.StatusInternalServerError)
+	api.WriteJSON(w, http.StatusOK, api.NewTask(task))
+}
+
+// AssignTask hands a task to a member of an organization the owner belongs to, or takes it back. The assignee sees
+// the task and can run it, everything else stays with the owner.
+func (h *Handler) AssignTask(w http.ResponseWriter, r *http.Request) {
+	taskID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
+		return
+	}
+	var req api.TaskAssignmentRequest
+	if !api.DecodeValid(w, r, &req) {
+		return
+	}
+
+	assignee, organization := req.UUIDs()
+	if assignee.Valid {
+		if assignee == uid {
+			api.BadRequest(w, "invalid request", api.FieldErrors{"assigneeId": "is the owner of the task"})
 			return
 		}
+		// Both the owner and the assignee must belong to the organization.
+		for _, member := range []struct {
+			field, message string
+			uid            pgtype.UUID
+		}{
+			{field: "organizationId", message: "is not an organization of yours", uid: uid},
+			{field: "assigneeId", message: "is not a member of the organization", uid: assignee},
+		} {
+			_, err := h.queries.GetOrganizationMember(r.Context(), db.GetOrganizationMemberParams{
+				OrganizationID: organization,
+				UserID:         member.uid,
+			})
+			if errors.Is(err, pgx.ErrNoRows) {
+				api.BadRequest(w, "invalid request", api.FieldErrors{member.field: member.message})
+				return
+			} else if err != nil {
+				api.DBError(w, err, "organization")
+				return
+			}
+		}
 	}
-	taskParam.ID = taskID
 
-	if err := h.queries.UpdateTask(r.Context(), taskParam); err != nil {
-		logrus.Error(fmt.Errorf("failed to update task: %w", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	n, err := h.queries.AssignTask(r.Context(), db.AssignTaskParams{
+		ID:             taskID,
+		UserID:         uid,
+		AssigneeID:     assignee,
+		OrganizationID: organization,
+	})
+	if err != nil {
+		api.DBError(w, err, "task")
+		return
+	}
+	if n == 0 {
+		api.NotFound(w, "task")
 		return
 	}
 
-	return
+	task, err := h.queries.GetTask(r.Context(), db.GetTaskParams{
+		ID:     taskID,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "task")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, api.NewTask(task))
 }
 
 func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var taskID pgtype.UUID
-	if err := taskID.Scan(vars["id"]); err != nil {
-		logrus.Error(fmt.Errorf("invalid task id: %s", vars["id"]))
+	taskID, uid, ok := api.Owned(w, r, "id")
+	if !ok {
 		return
 	}
 
-	if err := h.queries.DeleteTask(r.Context(), taskID); err != nil {
-		logrus.Error(fmt.Errorf("failed to delete task: %w", err))
+	n, err := h.queries.DeleteTask(r.Context(), db.DeleteTaskParams{
+		ID:     taskID,
+		UserID: uid,
+	})
+	if err != nil {
+		api.DBError(w, err, "task")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	return
+	if n == 0 {
+		api.NotFound(w, "task")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *Handler) ListTasks(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	var uid pgtype.UUID
-	if err := uid.Scan(userID); err != nil {
-		logrus.Error(fmt.Errorf("invalid user id: %s", userID))
-		return
+// ownsContexts answers with a 400 unless every context in contextIDs belongs to the user or is shared with one of
+// their organizations, so a task cannot pull in the rules of another user.
+func (h *Handler) ownsContexts(w http.ResponseWriter, r *http.Request, uid pgtype.UUID, contextIDs []pgtype.UUID) bool {
+	unique := map[pgtype.UUID]struct{}{}
+	for _, id := range contextIDs {
+		unique[id] = struct{}{}
 	}
-	tasks, err := h.queries.GetTaskFromUserID(r.Context(), uid)
+	if len(unique) == 0 {
+		return true
+	}
+
+	n, err := h.queries.CountContextsForUser(r.Context(), db.CountContextsForUserParams{
+		UserID: uid,
+		Ids:    contextIDs,
+	})
 	if err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			logrus.Error(fmt.Errorf("failed to fetch tasks from database: %w", err))
+		api.DBError(w, err, "contexts")
+		return false
+	}
+	if n != int64(len(unique)) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"contextIds": "contains unknown contexts"})
+		return false
+	}
+	return true
+}
+
+// taskMailbox returns the mailbox a task request names, answering with a 400 unless it is connected to the user.
+// Without a mailbox in the request new tasks get the first mailbox the user connected, if any, and updates an
+// invalid ID that keeps the current one.
+func (h *Handler) taskMailbox(w http.ResponseWriter, r *http.Request, uid pgtype.UUID, req api.TaskRequest, create bool) (pgtype.UUID, bool) {
+	if req.MailboxID == nil {
+		if !create {
+			return pgtype.UUID{}, true
 		}
+		conn, err := h.queries.GetDefaultMailboxConnection(r.Context(), uid)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pgtype.UUID{}, true
+		} else if err != nil {
+			api.DBError(w, err, "mailbox")
+			return pgtype.UUID{}, false
+		}
+		return conn.ID, true
+	}
+
+	conn, err := h.queries.GetMailboxConnectionForUser(r.Context(), db.GetMailboxConnectionForUserParams{
+		ID:     req.MailboxUUID(),
+		UserID: uid,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.BadRequest(w, "invalid request", api.FieldErrors{"mailboxId": "is not a connected mailbox"})
+		return pgtype.UUID{}, false
+	} else if err != nil {
+		api.DBError(w, err, "mailbox")
+		return pgtype.UUID{}, false
+	}
+	return conn.ID, true
+}
+
+func (h *Handler) ListTasks(w http.ResponseWriter, r *http.Request) {
+	uid, ok := api.UserID(w, r)
+	if !ok {
 		return
 	}
-	if err := json.NewEncoder(w).Encode(tasks); err != nil {
-		logrus.Error(fmt.Errorf("failed to encode tasks output: %w", err))
+	tasks, err := h.queries.GetTaskFromUserID(r.Context(), uid)
+	if err != nil {
+		api.DBError(w, err, "tasks")
 		return
 	}
-	return
+	api.WriteJSON(w, http.StatusOK, api.NewTasks(tasks))
 }
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/approval"
+	"ethan/pkg/db"
+	"ethan/pkg/graphfake"
+	"ethan/pkg/llmfake"
+	"ethan/pkg/provider"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/server/api"
+	"ethan/pkg/server/connection"
+	"ethan/pkg/server/subscribe"
+
+	"github.com/gorilla/websocket"
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+// startLLM starts an llmfake server with the fixtures of testdata and the given ones after them, and points gptscript
+// at it. The test binary serves gptscript and the tools, see TestMain.
+func startLLM(t *testing.T, fixtures ...llmfake.Fixture) *llmfake.Server {
+	t.Helper()
+	var all []llmfake.Fixture
+	for _, file := range []string{"webhook.json", "chat.json"} {
+		loaded, err := llmfake.Load(filepath.Join("..", "llmfake", "testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, loaded...)
+	}
+	llm := llmfake.New(append(all, fixtures...)...)
+	srv := httptest.NewServer(llm)
+	t.Cleanup(srv.Close)
+	t.Setenv("OPENAI_BASE_URL", srv.URL+"/v1")
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	if err := os.Symlink(self, filepath.Join(bin, "gem-copilot")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GPTSCRIPT_BIN", self)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return llm
+}
+
+// runFrame is a websocket message of a task run, either a frame of the run or an action waiting for approval.
+type runFrame struct {
+	Frame    gptscript.CallFrame `json:"frame"`
+	Approval *api.PendingAction  `json:"approval"`
+}
+
+func (f runFrame) output() string {
+	var ret strings.Builder
+	for _, o := range f.Frame.Output {
+		ret.WriteString(o.Content)
+	}
+	return ret.String()
+}
+
+// runTask opens the websocket of a task run as the user of token and returns the messages the server sends on it.
+func runTask(t *testing.T, srv *httptest.Server, token string, task db.Task) (*websocket.Conn, <-chan runFrame) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/tasks/" + id(task.ID) + "/run"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("failed to run the task: %v", err)
+	}
+	resp.Body.Close()
+	t.Cleanup(func() { conn.Close() })
+
+	frames := make(chan runFrame, 1024)
+	go func() {
+		defer close(frames)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var f runFrame
+			if err := json.Unmarshal(data, &f); err != nil {
+				t.Errorf("invalid message of the run: %s", data)
+				return
+			}
+			frames <- f
+		}
+	}()
+	return conn, frames
+}
+
+// waitFor returns the first message of the run that done accepts.
+func waitFor(t *testing.T, frames <-chan runFrame, what string, done func(runFrame) bool) runFrame {
+	t.Helper()
+	timeout := time.After(time.Minute)
+	for {
+		select {
+		case f, ok := <-frames:
+			if !ok {
+				t.Fatalf("the run ended while waiting for %v", what)
+			}
+			if done(f) {
+				return f
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", what)
+		}
+	}
+}
+
+// TestWebhookToTaskToSend follows an email from the webhook to the task it creates, and runs the task until the
+// email the assistant drafts is approved and sent. The model is replaced by the llmfake fixtures and Graph by
+// graphfake.
+func TestWebhookToTaskToSend(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	fake := startGraph(t)
+	fake.AddMailbox(graphfake.Mailbox{
+		User: graphfake.User{DisplayName: "Bob", Mail: "bob@example.com"},
+		ScheduleItems: []graphfake.ScheduleItem{{
+			Status:  "busy",
+			Subject: "Dentist",
+			Start:   graphfake.DateTimeTimeZone{DateTime: "2024-05-01T08:00:00", TimeZone: approval.TimeZone},
+			End:     graphfake.DateTimeTimeZone{DateTime: "2024-05-01T09:00:00", TimeZone: approval.TimeZone},
+		}},
+	})
+	llm := startLLM(t,
+		llmfake.Fixture{
+			Name:     "introduction",
+			Times:    1,
+			Match:    llmfake.Match{Contains: []string{"helping me scheduling meeting"}},
+			Response: llmfake.Response{Content: "Hi, I can help you schedule a meeting with Bob."},
+		},
+		llmfake.Fixture{
+			Name:  "send-email",
+			Times: 1,
+			Match: llmfake.Match{LastRole: "user", LastContains: []string{"send Bob an email"}, Tool: "sendEmail"},
+			Response: llmfake.Response{ToolCalls: []llmfake.ToolCall{{
+				Name:      "sendEmail",
+				Arguments: json.RawMessage(`{"email-subject": "Meeting on May 1st", "email-content": "Are you free on May 1st from 10am to 11am?", "email-recipient-to": "bob@example.com"}`),
+			}}},
+		},
+		llmfake.Fixture{
+			Name:     "sent",
+			Times:    1,
+			Match:    llmfake.Match{LastRole: "tool", LastContains: []string{"succeeded"}},
+			Response: llmfake.Response{Content: "The email was sent to Bob."},
+		},
+	)
+
+	h := newHandlers(queries, provider.Registry{graph.Name: graph.New})
+	srv := testServer(t, h)
+	t.Setenv("PUBLIC_URL", srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice := createUser(t, queries, "alice")
+	conn := connectGraph(t, queries, fake, alice)
+	token := signIn(t, srv, queries, alice)
+	if status, body := call(t, srv, token, http.MethodPost, "/mailboxes/"+id(conn.ID), map[string]any{"checkSpam": true}); status != http.StatusOK {
+		t.Fatalf("turning on the spam check = %v %s", status, body)
+	}
+
+	go h.subscribe.ProcessJobs(ctx)
+	go connection.Listen(ctx, pool)
+	go subscribe.PerUser(ctx, queries)
+	eventually(t, 10*time.Second, "the subscriptions of the mailbox", func() bool {
+		subscriptions, err := queries.ListSubscriptionsForConnection(ctx, conn.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(subscriptions) == 2
+	})
+
+	// A cold email is moved out of the inbox, and no task is created for it.
+	if _, err := fake.Deliver(ctx, alice.Email, graphfake.Message{
+		Subject: "Limited time offer",
+		Body:    graphfake.ItemBody{ContentType: "text", Content: "Buy now and save 50%."},
+		From:    &graphfake.Recipient{EmailAddress: graphfake.EmailAddress{Name: "Sales", Address: "sales@example.org"}},
+	}); err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	eventually(t, time.Minute, "the cold email to be moved", func() bool {
+		spams, err := queries.ListSpamEmails(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(spams) == 1
+	})
+	if got := folder(t, fake, alice.Email, provider.FolderColdEmails); len(got) != 1 || got[0].Subject != "Limited time offer" {
+		t.Errorf("%v of Alice = %+v, want the cold email", provider.FolderColdEmails, got)
+	}
+
+	// An email about a meeting becomes a task.
+	if _, err := fake.Deliver(ctx, alice.Email, graphfake.Message{
+		Subject: "Meeting next week",
+		Body:    graphfake.ItemBody{ContentType: "text", Content: "Can we meet next week?"},
+		From:    &graphfake.Recipient{EmailAddress: graphfake.EmailAddress{Name: "Bob", Address: "bob@example.com"}},
+	}); err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	var tasks []db.Task
+	eventually(t, time.Minute, "the task of the meeting email", func() bool {
+		var err error
+		tasks, err = queries.GetTaskFromUserID(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(tasks) > 0
+	})
+	if len(tasks) != 1 {
+		t.Fatalf("tasks of Alice = %v, want only the one of the meeting email", tasks)
+	}
+	task := tasks[0]
+	if task.Name != "meeting with bob" || task.Description != "bob wants to meet next week." || task.ConnectionID != conn.ID {
+		t.Errorf("task = %v, %q, want the one named by the model for the mailbox", task.Name, task.Description)
+	}
+
+	// The run introduces itself, checks the schedule of Bob with the tool, and proposes a time.
+	ws, frames := runTask(t, srv, token, task)
+	waitFor(t, frames, "the introduction", func(f runFrame) bool {
+		return f.Frame.Type == gptscript.EventTypeCallFinish && strings.Contains(f.output(), "I can help you")
+	})
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("Please find a time with Bob")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, frames, "the proposed time", func(f runFrame) bool {
+		return f.Frame.Type == gptscript.EventTypeCallFinish && strings.Contains(f.output(), "Bob is free")
+	})
+
+	// Sending the email waits for Alice to approve it.
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("Yes, send Bob an email")); err != nil {
+		t.Fatal(err)
+	}
+	pending := waitFor(t, frames, "the email to approve", func(f runFrame) bool {
+		return f.Approval != nil && f.Approval.Status == approval.StatusPending
+	}).Approval
+	if pending.Tool != approval.ToolSendEmail || pending.TaskID != id(task.ID) {
+		t.Errorf("approval = %+v, want the email of the task", pending)
+	}
+	if got := folder(t, fake, "bob@example.com", "inbox"); len(got) != 0 {
+		t.Fatalf("inbox of Bob = %+v, want nothing before the approval", got)
+	}
+	if status, body := call(t, srv, token, http.MethodPost, "/approvals/"+pending.ID, api.ApprovalRequest{Decision: api.DecisionApprove}); status != http.StatusOK {
+		t.Fatalf("approving the email = %v %s", status, body)
+	}
+	waitFor(t, frames, "the answer to the approval", func(f runFrame) bool {
+		return f.Frame.Type == gptscript.EventTypeCallFinish && strings.Contains(f.output(), "The email was sent")
+	})
+
+	if got := folder(t, fake, "bob@example.com", "inbox"); len(got) != 1 || got[0].Subject != "Meeting on May 1st" {
+		t.Errorf("inbox of Bob = %+v, want the approved email", got)
+	}
+	if got := folder(t, fake, alice.Email, "sentitems"); len(got) != 1 || got[0].Subject != "Meeting on May 1st" {
+		t.Errorf("Sent Items of Alice = %+v, want the approved email", got)
+	}
+	if got, err := queries.ListPendingActionsForTask(ctx, task.ID); err != nil || len(got) != 1 || got[0].Status != approval.StatusSucceeded {
+		t.Errorf("actions of the task = %v, %v, want the email sent", got, err)
+	}
+	if got, err := queries.GetTask(ctx, db.GetTaskParams{ID: task.ID, UserID: alice.ID}); err != nil || len(got.State) == 0 {
+		t.Errorf("task state = %s, %v, want the chat saved", got.State, err)
+	}
+
+	// The tool reported the schedule of Bob from Graph to the model.
+	var used []string
+	var schedule bool
+	for _, req := range llm.Requests() {
+		used = append(used, req.Fixture)
+		if req.Fixture == "propose-time" {
+			schedule = strings.Contains(req.Messages[len(req.Messages)-1].Content, "bob@example.com, Status: Busy")
+		}
+	}
+	for _, name := range []string{"cold-email", "meeting-detection", "name-and-summary", "introduction", "check-availability", "propose-time", "send-email", "sent"} {
+		if !slices.Contains(used, name) {
+			t.Errorf("fixture %v was not used, the model was asked %v", name, used)
+		}
+	}
+	if !schedule {
+		t.Error("the model was not told about the schedule of Bob")
+	}
+	if pending := llm.Pending(); len(pending) != 0 {
+		t.Errorf("fixtures not used up: %v", pending)
+	}
+}
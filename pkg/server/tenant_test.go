@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ethan/pkg/db"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TestOtherUsersResources checks that a user who knows the IDs of another user's resources can neither see nor change
+// them: every request answers 404, as if the resource did not exist, and the lists do not contain them.
+func TestOtherUsersResources(t *testing.T) {
+	queries := testDB(t)
+	srv := testServer(t, newHandlers(queries, nil))
+	ctx := context.Background()
+
+	owner := createUser(t, queries, "owner")
+	other := createUser(t, queries, "other")
+	delegate := createUser(t, queries, "delegate")
+
+	mailbox, err := queries.CreateMailboxConnection(ctx, db.CreateMailboxConnectionParams{
+		UserID:   owner.ID,
+		Provider: "microsoft",
+		Email:    owner.Email,
+		Token:    "token",
+		ExpireAt: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := queries.CreateTask(ctx, db.CreateTaskParams{
+		UserID:       owner.ID,
+		Name:         "Plan the offsite",
+		Description:  "Find a date for the offsite",
+		ConnectionID: mailbox.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "Which dates work for you?"
+	if err := queries.CreateMessage(ctx, db.CreateMessageParams{TaskID: task.ID, Content: &content, UserID: owner.ID}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := queries.GetMessageFromUserID(ctx, owner.ID)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("messages of the owner = %v, %v", messages, err)
+	}
+	message := messages[0]
+	name := "Team"
+	ownerContext, err := queries.CreateContext(ctx, db.CreateContextParams{Name: &name, Content: &content, UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	org, err := queries.CreateOrganization(ctx, "Owner's organization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queries.UpsertOrganizationMember(ctx, db.UpsertOrganizationMemberParams{
+		OrganizationID: org.ID,
+		UserID:         owner.ID,
+		Role:           "owner",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	subject := "You won"
+	if _, err := queries.CreateSpamEmailRecord(ctx, db.CreateSpamEmailRecordParams{
+		Subject:      &subject,
+		UserID:       owner.ID,
+		MessageID:    &[]string{uuid.NewString()}[0],
+		ConnectionID: mailbox.ID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	spams, err := queries.ListSpamEmails(ctx, owner.ID)
+	if err != nil || len(spams) != 1 {
+		t.Fatalf("spam of the owner = %v, %v", spams, err)
+	}
+	spam := spams[0]
+	action, err := queries.CreatePendingAction(ctx, db.CreatePendingActionParams{
+		UserID:        owner.ID,
+		TaskID:        task.ID,
+		ConnectionID:  mailbox.ID,
+		Tool:          "send-email",
+		Arguments:     []byte(`{"Subject":"Offsite","Body":"Which dates work for you?","To":["team@example.com"]}`),
+		LinkTokenHash: uuid.NewString(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft, err := queries.CreateTaskDraft(ctx, db.CreateTaskDraftParams{
+		UserID:       owner.ID,
+		TaskID:       task.ID,
+		ConnectionID: mailbox.ID,
+		MessageID:    uuid.NewString(),
+		Subject:      "Offsite",
+		Recipients:   []string{"team@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := createSession(t, queries, owner)
+	sum := sha256.Sum256([]byte(uuid.NewString()))
+	token, err := queries.CreateAPIToken(ctx, db.CreateAPITokenParams{
+		UserID:    owner.ID,
+		Name:      "cli",
+		TokenHash: hex.EncodeToString(sum[:]),
+		ExpireAt:  pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, err := queries.UpsertDelegationGrant(ctx, db.UpsertDelegationGrantParams{
+		PrincipalID: owner.ID,
+		DelegateID:  delegate.ID,
+		Mailbox:     owner.Email,
+		Permissions: []string{"read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwt := signIn(t, srv, queries, other)
+
+	// A task of the other user, to check that a draft of the owner cannot be reached through it.
+	otherTask, err := queries.CreateTask(ctx, db.CreateTaskParams{UserID: other.ID, Name: "Own task"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodGet, "/tasks/" + id(task.ID), nil},
+		{http.MethodPost, "/tasks/" + id(task.ID), map[string]any{"name": "Mine now"}},
+		{http.MethodPost, "/tasks/" + id(task.ID) + "/assignee", map[string]any{}},
+		{http.MethodGet, "/tasks/" + id(task.ID) + "/run", nil},
+		{http.MethodGet, "/tasks/" + id(task.ID) + "/drafts", nil},
+		{http.MethodGet, "/tasks/" + id(task.ID) + "/drafts/" + id(draft.ID), nil},
+		{http.MethodPost, "/tasks/" + id(task.ID) + "/drafts/" + id(draft.ID), map[string]any{"subject": "Mine now"}},
+		{http.MethodPost, "/tasks/" + id(task.ID) + "/drafts/" + id(draft.ID) + "/send", nil},
+		{http.MethodGet, "/tasks/" + id(otherTask.ID) + "/drafts/" + id(draft.ID), nil},
+		{http.MethodPost, "/tasks/" + id(otherTask.ID) + "/drafts/" + id(draft.ID) + "/send", nil},
+		{http.MethodDelete, "/tasks/" + id(otherTask.ID) + "/drafts/" + id(draft.ID), nil},
+		{http.MethodDelete, "/tasks/" + id(task.ID) + "/drafts/" + id(draft.ID), nil},
+		{http.MethodGet, "/approvals/" + id(action.ID), nil},
+		{http.MethodPost, "/approvals/" + id(action.ID), map[string]any{"decision": "reject"}},
+		{http.MethodPost, "/messages/" + id(message.ID), nil},
+		{http.MethodPost, "/contexts/" + id(ownerContext.ID), map[string]any{"name": "Mine now"}},
+		{http.MethodDelete, "/contexts/" + id(ownerContext.ID), nil},
+		{http.MethodGet, "/organizations/" + id(org.ID), nil},
+		{http.MethodPost, "/organizations/" + id(org.ID), map[string]any{"name": "Mine now"}},
+		{http.MethodGet, "/organizations/" + id(org.ID) + "/members", nil},
+		{http.MethodPost, "/organizations/" + id(org.ID) + "/members", map[string]any{"email": other.Email, "role": "member"}},
+		{http.MethodDelete, "/organizations/" + id(org.ID) + "/members/" + id(owner.ID), nil},
+		{http.MethodDelete, "/organizations/" + id(org.ID) + "/members/" + id(other.ID), nil},
+		{http.MethodGet, "/organizations/" + id(org.ID) + "/invitations", nil},
+		{http.MethodPost, "/organizations/" + id(org.ID) + "/invitations", map[string]any{"email": other.Email, "role": "member"}},
+		{http.MethodDelete, "/organizations/" + id(org.ID), nil},
+		{http.MethodGet, "/spams/" + id(spam.ID), nil},
+		{http.MethodPost, "/spams/" + id(spam.ID) + "/moveback", nil},
+		{http.MethodDelete, "/spams/" + id(spam.ID), nil},
+		{http.MethodPost, "/mailboxes/" + id(mailbox.ID), map[string]any{}},
+		{http.MethodDelete, "/mailboxes/" + id(mailbox.ID), nil},
+		{http.MethodDelete, "/sessions/" + id(session.ID), nil},
+		{http.MethodDelete, "/tokens/" + id(token.ID), nil},
+		{http.MethodDelete, "/delegations/" + id(grant.ID), nil},
+		{http.MethodDelete, "/tasks/" + id(task.ID), nil},
+	}
+	for _, req := range requests {
+		if status, body := call(t, srv, jwt, req.method, req.path, req.body); status != http.StatusNotFound {
+			t.Errorf("%s %s = %v %s, want 404", req.method, req.path, status, body)
+		}
+	}
+
+	owned := []string{
+		id(task.ID), id(message.ID), id(draft.ID), id(action.ID), id(ownerContext.ID), id(org.ID), id(spam.ID),
+		id(mailbox.ID), id(session.ID), id(token.ID), id(grant.ID),
+	}
+	for _, path := range []string{
+		"/tasks", "/messages", "/approvals", "/contexts", "/organizations", "/spams", "/mailboxes", "/sessions",
+		"/tokens", "/delegations", "/audit",
+	} {
+		status, body := call(t, srv, jwt, http.MethodGet, path, nil)
+		if status != http.StatusOK {
+			t.Errorf("GET %s = %v %s, want 200", path, status, body)
+			continue
+		}
+		for _, ownedID := range owned {
+			if strings.Contains(string(body), ownedID) {
+				t.Errorf("GET %s lists %s of another user: %s", path, ownedID, body)
+			}
+		}
+	}
+
+	// Nothing of the owner was changed.
+	if got, err := queries.GetTask(ctx, db.GetTaskParams{ID: task.ID, UserID: owner.ID}); err != nil || got.Name != task.Name {
+		t.Errorf("task of the owner = %v, %v, want it unchanged", got.Name, err)
+	}
+	if got, err := queries.GetMessageFromUserID(ctx, owner.ID); err != nil || len(got) != 1 || got[0].Read != nil && *got[0].Read {
+		t.Errorf("messages of the owner = %v, %v, want one unread", got, err)
+	}
+	if got, err := queries.ListContextsForUser(ctx, owner.ID); err != nil || len(got) != 1 || *got[0].Name != name {
+		t.Errorf("contexts of the owner = %v, %v, want it unchanged", got, err)
+	}
+	if got, err := queries.ListOrganizationMembers(ctx, org.ID); err != nil || len(got) != 1 {
+		t.Errorf("members of the organization = %v, %v, want only the owner", got, err)
+	}
+	if got, err := queries.ListSpamEmails(ctx, owner.ID); err != nil || len(got) != 1 {
+		t.Errorf("spam of the owner = %v, %v, want it kept", got, err)
+	}
+	if got, err := queries.ListTaskDrafts(ctx, task.ID); err != nil || len(got) != 1 || got[0].Subject != draft.Subject {
+		t.Errorf("drafts of the task = %v, %v, want it unchanged", got, err)
+	}
+	if got, err := queries.ListPendingActionsForTask(ctx, task.ID); err != nil || len(got) != 1 || got[0].Status != action.Status {
+		t.Errorf("pending actions of the task = %v, %v, want it undecided", got, err)
+	}
+	if got, err := queries.ListMailboxConnectionsForUser(ctx, owner.ID); err != nil || len(got) != 1 {
+		t.Errorf("mailboxes of the owner = %v, %v, want it kept", got, err)
+	}
+	if got, err := queries.ListActiveSessionsForUser(ctx, owner.ID); err != nil || len(got) != 1 {
+		t.Errorf("sessions of the owner = %v, %v, want it kept", got, err)
+	}
+	if got, err := queries.ListAPITokensForUser(ctx, owner.ID); err != nil || len(got) != 1 {
+		t.Errorf("tokens of the owner = %v, %v, want it kept", got, err)
+	}
+	if got, err := queries.ListDelegationGrantsForUser(ctx, owner.ID); err != nil || len(got) != 1 {
+		t.Errorf("delegations of the owner = %v, %v, want it kept", got, err)
+	}
+}
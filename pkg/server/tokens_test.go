@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ethan/pkg/db"
+	"ethan/pkg/server/api"
+)
+
+// createAPIToken creates a personal API token with the scopes in the session of jwt.
+func createAPIToken(t *testing.T, srv *httptest.Server, jwt string, scopes ...string) api.CreatedAPIToken {
+	t.Helper()
+	status, body := call(t, srv, jwt, http.MethodPost, "/tokens", map[string]any{"name": strings.Join(scopes, " "), "scopes": scopes})
+	if status != http.StatusCreated {
+		t.Fatalf("creating a token with %v = %v %s", scopes, status, body)
+	}
+	var token api.CreatedAPIToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token.Token, "cpat_") {
+		t.Fatalf("token = %q, want a cpat_ token", token.Token)
+	}
+	return token
+}
+
+// TestAPITokenScopes checks that an API token only makes the requests its scopes allow, and that opening a task run
+// needs the write scope although it is a GET.
+func TestAPITokenScopes(t *testing.T) {
+	queries := testDB(t)
+	srv := testServer(t, newHandlers(queries, nil))
+
+	user := createUser(t, queries, "alice")
+	jwt := signIn(t, srv, queries, user)
+	read := createAPIToken(t, srv, jwt, api.ScopeRead)
+	write := createAPIToken(t, srv, jwt, api.ScopeWrite)
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   any
+		want   int
+	}{
+		{"read token reading", read.Token, http.MethodGet, "/me", nil, http.StatusOK},
+		{"read token listing tasks", read.Token, http.MethodGet, "/tasks", nil, http.StatusOK},
+		{"read token changing", read.Token, http.MethodPost, "/me", map[string]any{}, http.StatusForbidden},
+		{"read token deleting", read.Token, http.MethodDelete, "/contexts/" + id(user.ID), nil, http.StatusForbidden},
+		{"write token reading", write.Token, http.MethodGet, "/me", nil, http.StatusOK},
+		{"write token changing", write.Token, http.MethodPost, "/me", map[string]any{}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := call(t, srv, tt.token, tt.method, tt.path, tt.body); status != tt.want {
+				t.Errorf("%v %v = %v %s, want %v", tt.method, tt.path, status, body, tt.want)
+			}
+		})
+	}
+
+	// A websocket upgrade is a GET, the middleware answers before the upgrade is attempted.
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/tasks/"+id(user.ID)+"/run", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+read.Token)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("opening a task run with a read token = %v, want 403", resp.StatusCode)
+	}
+}
+
+// TestAPITokenRevoked checks that unknown, expired and deleted API tokens are answered with a 401.
+func TestAPITokenRevoked(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	srv := testServer(t, newHandlers(queries, nil))
+	ctx := context.Background()
+
+	user := createUser(t, queries, "alice")
+	jwt := signIn(t, srv, queries, user)
+	expired := createAPIToken(t, srv, jwt, api.ScopeRead)
+	deleted := createAPIToken(t, srv, jwt, api.ScopeRead)
+	kept := createAPIToken(t, srv, jwt, api.ScopeRead)
+
+	if _, err := pool.Exec(ctx, "UPDATE api_tokens SET expire_at = now() - interval '1 minute' WHERE id = $1", expired.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := call(t, srv, jwt, http.MethodDelete, "/tokens/"+deleted.ID, nil); status != http.StatusNoContent {
+		t.Fatalf("deleting the token = %v %s", status, body)
+	}
+
+	for name, token := range map[string]string{
+		"an unknown token": "cpat_" + strings.Repeat("x", 43),
+		"an expired token": expired.Token,
+		"a deleted token":  deleted.Token,
+	} {
+		if status, body := call(t, srv, token, http.MethodGet, "/me", nil); status != http.StatusUnauthorized {
+			t.Errorf("GET /me with %v = %v %s, want 401", name, status, body)
+		}
+	}
+	if status, body := call(t, srv, kept.Token, http.MethodGet, "/me", nil); status != http.StatusOK {
+		t.Errorf("GET /me with a token that was kept = %v %s, want 200", status, body)
+	}
+
+	tokens, err := queries.ListAPITokensForUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if id(token.ID) == kept.ID && !token.LastUsedAt.Valid {
+			t.Error("the use of the token was not recorded")
+		}
+	}
+}
+
+// TestAPITokenSessionOnly checks that an API token, even with the write scope, cannot manage tokens and sessions, so
+// a leaked token cannot outlive its revocation.
+func TestAPITokenSessionOnly(t *testing.T) {
+	queries := testDB(t)
+	srv := testServer(t, newHandlers(queries, nil))
+
+	user := createUser(t, queries, "alice")
+	jwt := signIn(t, srv, queries, user)
+	token := createAPIToken(t, srv, jwt, api.ScopeRead, api.ScopeWrite)
+	session, _ := createSession(t, queries, user)
+
+	tests := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodGet, "/tokens", nil},
+		{http.MethodPost, "/tokens", map[string]any{"name": "minted"}},
+		{http.MethodDelete, "/tokens/" + token.ID, nil},
+		{http.MethodGet, "/sessions", nil},
+		{http.MethodDelete, "/sessions/" + id(session.ID), nil},
+		{http.MethodPost, "/logout/all", nil},
+	}
+	for _, tt := range tests {
+		if status, body := call(t, srv, token.Token, tt.method, tt.path, tt.body); status != http.StatusForbidden {
+			t.Errorf("%v %v with an API token = %v %s, want 403", tt.method, tt.path, status, body)
+		}
+	}
+
+	// None of the requests went through.
+	if status, body := call(t, srv, jwt, http.MethodGet, "/tokens", nil); status != http.StatusOK {
+		t.Fatalf("listing tokens with the session = %v %s", status, body)
+	} else {
+		var tokens []api.APIToken
+		if err := json.Unmarshal(body, &tokens); err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != 1 || tokens[0].ID != token.ID {
+			t.Errorf("tokens = %s, want only the one created", body)
+		}
+	}
+	if !sessionActive(t, srv, jwt) || !sessionActive(t, srv, token.Token) {
+		t.Error("a session or the token was revoked by a request made with an API token")
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ethan/pkg/db"
+	"ethan/pkg/graphfake"
+	"ethan/pkg/provider"
+	"ethan/pkg/provider/graph"
+	"ethan/pkg/secret"
+	"ethan/pkg/server/subscribe"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// startGraph starts a graphfake server and points the Graph provider at it.
+func startGraph(t *testing.T) *graphfake.Server {
+	t.Helper()
+	fake := graphfake.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	t.Setenv("GRAPH_BASE_URL", srv.URL+"/v1.0")
+	return fake
+}
+
+// connectGraph adds a mailbox for the user to the graphfake server and connects it, the way signing in with
+// Microsoft does.
+func connectGraph(t *testing.T, queries *db.Queries, fake *graphfake.Server, user db.User) db.MailboxConnection {
+	t.Helper()
+	fake.AddMailbox(graphfake.Mailbox{User: graphfake.User{DisplayName: user.Name, Mail: user.Email}})
+	token, err := fake.Token(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := queries.CreateMailboxConnection(context.Background(), db.CreateMailboxConnectionParams{
+		UserID:   user.ID,
+		Provider: graph.Name,
+		Email:    user.Email,
+		Token:    secret.String(token),
+		ExpireAt: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// folder returns the messages of the mailbox in the folder with the given well-known or display name.
+func folder(t *testing.T, fake *graphfake.Server, email, name string) []graphfake.Message {
+	t.Helper()
+	m, _ := fake.Mailbox(email)
+	var folderID string
+	for _, f := range m.Folders {
+		if f.WellKnownName == name || f.DisplayName == name {
+			folderID = f.ID
+		}
+	}
+	var messages []graphfake.Message
+	for _, msg := range m.Messages {
+		if msg.ParentFolderID == folderID {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// eventually fails the test unless done reports true within timeout.
+func eventually(t *testing.T, timeout time.Duration, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !done(); time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+	}
+}
+
+// TestWebhook checks that the mailboxes are subscribed to on Graph and that the change notifications of new mail
+// queue the message for processing.
+func TestWebhook(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	fake := startGraph(t)
+	srv := testServer(t, newHandlers(queries, provider.Registry{graph.Name: graph.New}))
+	t.Setenv("PUBLIC_URL", srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice := createUser(t, queries, "alice")
+	conn := connectGraph(t, queries, fake, alice)
+
+	go subscribe.PerUser(ctx, queries)
+	var subscriptions []db.Subscription
+	eventually(t, 10*time.Second, "the subscriptions of the mailbox", func() bool {
+		var err error
+		subscriptions, err = queries.ListSubscriptionsForConnection(ctx, conn.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(subscriptions) == 2
+	})
+	cancel()
+
+	onGraph := map[string]graphfake.Subscription{}
+	for _, s := range fake.Subscriptions() {
+		if s.Mailbox == alice.Email {
+			onGraph[s.ID] = s
+		}
+	}
+	for _, s := range subscriptions {
+		graphSubscription, ok := onGraph[s.ID]
+		if !ok {
+			t.Errorf("%v subscription %v is not on Graph", s.Kind, s.ID)
+			continue
+		}
+		if graphSubscription.NotificationURL != srv.URL+"/api/webhook" || graphSubscription.LifecycleNotificationURL != srv.URL+"/api/webhook/lifecycle" {
+			t.Errorf("%v subscription notifies %v and %v", s.Kind, graphSubscription.NotificationURL, graphSubscription.LifecycleNotificationURL)
+		}
+		if s.Status != "active" {
+			t.Errorf("%v subscription status = %v, want active", s.Kind, s.Status)
+		}
+	}
+	if len(onGraph) != 2 {
+		t.Errorf("Graph subscriptions of the mailbox = %v, want 2", onGraph)
+	}
+
+	// Deliver returns once the webhook answered the notification.
+	message, err := fake.Deliver(context.Background(), alice.Email, graphfake.Message{
+		Subject: "Meeting next week",
+		Body:    graphfake.ItemBody{ContentType: "text", Content: "Can we meet next week?"},
+		From:    &graphfake.Recipient{EmailAddress: graphfake.EmailAddress{Name: "Bob", Address: "bob@example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	var kind, status string
+	if err := pool.QueryRow(context.Background(), "SELECT kind, status FROM webhook_jobs WHERE connection_id = $1 AND message_id = $2",
+		conn.ID, message.ID).Scan(&kind, &status); err != nil {
+		t.Fatalf("the message was not queued: %v", err)
+	}
+	if kind != "inbox" || status != "pending" {
+		t.Errorf("queued job = %v %v, want a pending inbox job", kind, status)
+	}
+
+	// Graph asks for the subscription to be reauthorized, which the server does right away.
+	inbox := subscriptions[0]
+	if err := fake.SendLifecycleEvent(context.Background(), inbox.ID, "reauthorizationRequired"); err != nil {
+		t.Fatalf("SendLifecycleEvent() = %v", err)
+	}
+	got, err := queries.GetSubscription(context.Background(), inbox.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "active" || got.LastLifecycleEvent == nil || *got.LastLifecycleEvent != "reauthorizationRequired" {
+		t.Errorf("subscription after reauthorization = %v, %v", got.Status, got.LastLifecycleEvent)
+	}
+}
+
+// TestWebhookJobRetry checks that a retried job does not process its message twice: a cold email whose move failed
+// is moved and recorded once when the job runs again, and a job that runs again after it created a task adds
+// nothing.
+func TestWebhookJobRetry(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	fake := graphfake.New()
+	// The first move fails with an error the Graph client does not retry itself.
+	var failMove atomic.Bool
+	failMove.Store(true)
+	graphSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/move") && failMove.CompareAndSwap(true, false) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": {"code": "generalException", "message": "The move failed."}}`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(graphSrv.Close)
+	t.Setenv("GRAPH_BASE_URL", graphSrv.URL+"/v1.0")
+	startLLM(t)
+
+	h := newHandlers(queries, provider.Registry{graph.Name: graph.New})
+	srv := testServer(t, h)
+	t.Setenv("PUBLIC_URL", srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice := createUser(t, queries, "alice")
+	conn := connectGraph(t, queries, fake, alice)
+	if status, body := call(t, srv, signIn(t, srv, queries, alice), http.MethodPost, "/mailboxes/"+id(conn.ID), map[string]any{"checkSpam": true}); status != http.StatusOK {
+		t.Fatalf("turning on the spam check = %v %s", status, body)
+	}
+
+	go h.subscribe.ProcessJobs(ctx)
+	go subscribe.PerUser(ctx, queries)
+	eventually(t, 10*time.Second, "the subscriptions of the mailbox", func() bool {
+		subscriptions, err := queries.ListSubscriptionsForConnection(ctx, conn.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(subscriptions) == 2
+	})
+
+	// job reads the inbox job of the message, and retry runs it again right away.
+	job := func(messageID string) (status string, attempts int) {
+		t.Helper()
+		if err := pool.QueryRow(ctx, "SELECT status, attempts FROM webhook_jobs WHERE connection_id = $1 AND kind = 'inbox' AND change_type = 'created' AND message_id = $2",
+			conn.ID, messageID).Scan(&status, &attempts); err != nil {
+			t.Fatal(err)
+		}
+		return status, attempts
+	}
+	retry := func(messageID string) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, "UPDATE webhook_jobs SET status = 'pending', run_at = now() WHERE connection_id = $1 AND kind = 'inbox' AND change_type = 'created' AND message_id = $2",
+			conn.ID, messageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notifications := func(content string) int {
+		t.Helper()
+		messages, err := queries.GetMessageFromUserID(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, m := range messages {
+			if m.Content != nil && *m.Content == content {
+				n++
+			}
+		}
+		return n
+	}
+
+	cold, err := fake.Deliver(ctx, alice.Email, graphfake.Message{
+		Subject: "Limited time offer",
+		Body:    graphfake.ItemBody{ContentType: "text", Content: "Buy now and save 50%."},
+		From:    &graphfake.Recipient{EmailAddress: graphfake.EmailAddress{Name: "Sales", Address: "sales@example.org"}},
+	})
+	if err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	eventually(t, time.Minute, "the move of the cold email to fail", func() bool {
+		status, attempts := job(cold.ID)
+		return status == "pending" && attempts == 1
+	})
+	spams, err := queries.ListSpamEmails(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spams) != 1 || spams[0].MessageID != nil {
+		t.Fatalf("spam emails after the failed move = %+v, want one waiting for the move", spams)
+	}
+
+	retry(cold.ID)
+	eventually(t, time.Minute, "the retried move", func() bool {
+		status, _ := job(cold.ID)
+		return status == "done"
+	})
+	if got := folder(t, fake, alice.Email, provider.FolderColdEmails); len(got) != 1 || got[0].Subject != "Limited time offer" {
+		t.Errorf("%v of Alice = %+v, want the cold email once", provider.FolderColdEmails, got)
+	}
+	spams, err = queries.ListSpamEmails(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spams) != 1 || spams[0].MessageID == nil || *spams[0].MessageID == cold.ID {
+		t.Errorf("spam emails after the retry = %+v, want one with the ID of the moved message", spams)
+	}
+	if n := notifications("Mark incoming email as SPAM"); n != 1 {
+		t.Errorf("spam notifications = %v, want 1", n)
+	}
+
+	meeting, err := fake.Deliver(ctx, alice.Email, graphfake.Message{
+		Subject: "Meeting next week",
+		Body:    graphfake.ItemBody{ContentType: "text", Content: "Can we meet next week?"},
+		From:    &graphfake.Recipient{EmailAddress: graphfake.EmailAddress{Name: "Bob", Address: "bob@example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	eventually(t, time.Minute, "the task of the meeting email", func() bool {
+		status, _ := job(meeting.ID)
+		return status == "done"
+	})
+	// As if the worker died before it completed the job.
+	retry(meeting.ID)
+	eventually(t, time.Minute, "the job to run again", func() bool {
+		status, attempts := job(meeting.ID)
+		return status == "done" && attempts == 2
+	})
+	tasks, err := queries.GetTaskFromUserID(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("tasks of Alice = %+v, want the one of the meeting email", tasks)
+	}
+	if n := notifications("Task meeting with bob is created."); n != 1 {
+		t.Errorf("task notifications = %v, want 1", n)
+	}
+}
+
+// TestWebhookClientState checks that a notification is only queued when its clientState carries the secret of the
+// mailbox it names, so anyone who learns the webhook URL cannot make the server process messages.
+func TestWebhookClientState(t *testing.T) {
+	pool := testPool(t)
+	queries := db.New(pool)
+	srv := testServer(t, newHandlers(queries, nil))
+	ctx := context.Background()
+
+	connect := func(user db.User, clientState string) db.MailboxConnection {
+		t.Helper()
+		conn, err := queries.CreateMailboxConnection(ctx, db.CreateMailboxConnectionParams{
+			UserID:   user.ID,
+			Provider: graph.Name,
+			Email:    user.Email,
+			Token:    "token",
+			ExpireAt: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := queries.UpdateMailboxConnectionSubscriptionClientState(ctx, db.UpdateMailboxConnectionSubscriptionClientStateParams{
+			ID:                      conn.ID,
+			SubscriptionClientState: &clientState,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	alice := connect(createUser(t, queries, "alice"), "alice-secret")
+	connect(createUser(t, queries, "bob"), "bob-secret")
+
+	tests := []struct {
+		name        string
+		clientState string
+		queued      bool
+	}{
+		{"the secret of the mailbox", id(alice.ID) + ":inbox:alice-secret", true},
+		{"a wrong secret", id(alice.ID) + ":inbox:guess", false},
+		{"the secret of another mailbox", id(alice.ID) + ":inbox:bob-secret", false},
+		{"no secret", id(alice.ID) + ":inbox:", false},
+		{"an unknown folder", id(alice.ID) + ":drafts:alice-secret", false},
+		{"an unknown mailbox", "00000000-0000-0000-0000-000000000000:inbox:alice-secret", false},
+		{"a malformed clientState", "alice-secret", false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageID := fmt.Sprintf("message-%d", i)
+			body, err := json.Marshal(map[string]any{"value": []map[string]any{{
+				"subscriptionId": "subscription",
+				"clientState":    tt.clientState,
+				"changeType":     "created",
+				"resourceData":   map[string]string{"id": messageID},
+			}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := srv.Client().Post(srv.URL+"/api/webhook", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			// Graph retries notifications that are not accepted, a rejected one is accepted and dropped.
+			if resp.StatusCode != http.StatusAccepted {
+				t.Errorf("POST /api/webhook = %v, want 202", resp.StatusCode)
+			}
+			var jobs int
+			if err := pool.QueryRow(ctx, "SELECT count(*) FROM webhook_jobs WHERE connection_id = $1 AND message_id = $2", alice.ID, messageID).Scan(&jobs); err != nil {
+				t.Fatal(err)
+			}
+			if queued := jobs == 1; queued != tt.queued {
+				t.Errorf("queued the message = %v, want %v", queued, tt.queued)
+			}
+		})
+	}
+}
@@ -10,50 +10,35 @@ WHERE email = $1 LIMIT 1;
 SELECT * FROM users
 WHERE subscription_id = $1 LIMIT 1;
 
--- name: ListUsers :many
-SELECT * FROM users
-ORDER BY name;
-
 -- name: CreateUser :one
 INSERT INTO users (
-    name, token, refresh_token, email, expire_at
+    name, email
 ) VALUES (
-             $1, $2, $3, $4, $5
-         )
+    $1, $2
+)
 RETURNING *;
 
--- name: UpdateUser :exec
-UPDATE users
-set token = $2,
-    refresh_token = $3,
-    expire_at = $4,
-    subscription_id = $5,
-    subscription_expire_at = $6,
-    subscription_disabled = $7,
-    check_spam = $8
-WHERE id = $1;
-
 -- name: DeleteUser :exec
 DELETE FROM users
 WHERE id = $1;
 
 -- name: GetTask :one
 SELECT * FROM tasks
-WHERE id = $1 LIMIT 1;
+WHERE id = $1 AND (user_id = $2 OR (assignee_id = $2 AND organization_id IS NOT NULL)) LIMIT 1;
 
 -- name: GetTaskFromUserID :many
 SELECT * FROM tasks
-WHERE user_id = $1 ORDER BY created_at DESC;
+WHERE user_id = $1 OR (assignee_id = $1 AND organization_id IS NOT NULL) ORDER BY created_at DESC;
 
 -- name: GetTaskFromConversationID :one
 SELECT * FROM tasks
-WHERE conversation_id = $1 LIMIT 1;
+WHERE conversation_id = $1 AND connection_id = $2 ORDER BY created_at DESC LIMIT 1;
 
 -- name: CreateTask :one
 INSERT INTO tasks (
-    user_id, name, state, description, tool_definition, context, message_id, message_body, context_ids
+    user_id, name, state, description, tool_definition, context, message_id, message_body, context_ids, connection_id
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 )
 RETURNING *;
 
@@ -72,17 +57,18 @@ UPDATE tasks
 set conversation_id = $2
 WHERE id = $1;
 
--- name: UpdateTask :exec
+-- name: UpdateTask :execrows
 UPDATE tasks
-SET name = $2,
-    description = $3,
-    context = $4,
-    context_ids = $5
-WHERE id = $1;
+SET name = $3,
+    description = $4,
+    context = $5,
+    context_ids = $6,
+    connection_id = COALESCE(sqlc.narg(connection_id), connection_id)
+WHERE id = $1 AND user_id = $2;
 
--- name: DeleteTask :exec
+-- name: DeleteTask :execrows
 DELETE FROM tasks
-WHERE id = $1;
+WHERE id = $1 AND user_id = $2;
 
 -- name: CreateMessage :exec
 INSERT INTO messages (
@@ -92,6 +78,13 @@ INSERT INTO messages (
 )
 RETURNING *;
 
+-- name: CreateApprovalMessage :exec
+INSERT INTO messages (
+    task_id, content, user_id, approval
+) VALUES (
+    $1, $2, $3, $4
+);
+
 -- name: GetMessageFromMessageID :one
 SELECT * FROM messages
 WHERE message_id = $1 LIMIT 1;
@@ -104,51 +97,735 @@ WHERE user_id = $1 ORDER BY created_at DESC;
 SELECT * FROM messages
 WHERE user_id = $1 and task_id = $2 ORDER BY created_at DESC;
 
--- name: UpdateMessageRead :exec
+-- name: UpdateMessageRead :execrows
 UPDATE messages
-set read = $2
-WHERE id = $1;
+set read = $3
+WHERE id = $1 AND user_id = $2;
 
 -- name: CreateContext :one
 INSERT INTO contexts (
-    name, description, content, user_id
+    name, description, content, user_id, organization_id
 ) VALUES (
-    $1, $2, $3, $4
+    $1, $2, $3, $4, $5
 )
 RETURNING *;
 
 -- name: ListContextsForUser :many
-SELECT * FROM contexts WHERE user_id = $1 ORDER BY created_at DESC;
+SELECT * FROM contexts
+WHERE contexts.user_id = $1 OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $1)
+ORDER BY created_at DESC;
 
--- name: UpdateContext :exec
+-- name: UpdateContext :execrows
 UPDATE contexts
-SET name = $2,
-    description = $3,
-    content = $4
-WHERE id = $1;
+SET name = $3,
+    description = $4,
+    content = $5
+WHERE id = $1 AND (
+    contexts.user_id = $2
+    OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $2 AND m.role = 'owner')
+);
 
--- name: DeleteContext :exec
+-- name: SetContextOrganization :execrows
+UPDATE contexts
+SET organization_id = $3
+WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteContext :execrows
 DELETE FROM contexts
-WHERE id = $1;
+WHERE id = $1 AND (
+    contexts.user_id = $2
+    OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $2 AND m.role = 'owner')
+);
 
 -- name: GetContext :one
 SELECT * FROM contexts
-WHERE id = $1 LIMIT 1;
+WHERE id = $1 AND (
+    contexts.user_id = $2 OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $2)
+) LIMIT 1;
+
+-- name: CountContextsForUser :one
+SELECT count(*) FROM contexts
+WHERE id = ANY(sqlc.arg(ids)::uuid[]) AND (
+    contexts.user_id = $1 OR contexts.organization_id IN (SELECT m.organization_id FROM organization_members m WHERE m.user_id = $1)
+);
 
--- name: CreateSpamEmailRecord :exec
+-- name: CreateSpamEmailRecord :one
 INSERT INTO spam_emails (
-    subject, email_body, user_id, message_id
+    subject, email_body, user_id, message_id, internet_message_id, connection_id, inbox_message_id
 ) VALUES (
-    $1, $2, $3, $4
-);
+    $1, $2, $3, $4, $5, $6, $7
+)
+RETURNING *;
+
+-- name: GetSpamEmailFromInboxMessageID :one
+SELECT * FROM spam_emails WHERE connection_id = $1 AND inbox_message_id = $2;
+
+-- name: CompleteSpamEmail :exec
+WITH spam AS (
+    UPDATE spam_emails SET message_id = sqlc.arg(message_id)
+    WHERE spam_emails.id = sqlc.arg(id)
+    RETURNING spam_emails.user_id, spam_emails.message_id
+)
+INSERT INTO messages (message_id, content, user_id)
+SELECT spam.message_id, sqlc.arg(content), spam.user_id FROM spam;
+
+-- name: GetSpamEmailFromInternetMessageID :one
+SELECT * FROM spam_emails
+WHERE connection_id = $1 AND internet_message_id = $2 AND message_id IS NOT NULL LIMIT 1;
+
+-- name: DeleteMovedBackSpamEmail :exec
+WITH spam AS (
+    DELETE FROM spam_emails WHERE spam_emails.id = sqlc.arg(id)
+    RETURNING spam_emails.user_id
+), skip AS (
+    INSERT INTO spam_check_skips (user_id, message_id)
+    SELECT spam.user_id, sqlc.arg(message_id) FROM spam
+    ON CONFLICT DO NOTHING
+)
+INSERT INTO messages (content, user_id)
+SELECT sqlc.arg(content), spam.user_id FROM spam;
 
 -- name: ListSpamEmails :many
 SELECT * FROM spam_emails WHERE user_id = $1;
 
 -- name: GetSpamEmail :one
-SELECT * FROM spam_emails WHERE id = $1;
+SELECT * FROM spam_emails WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteSpamEmail :execrows
+DELETE FROM spam_emails WHERE id = $1 AND user_id = $2;
+
+-- name: EnqueueWebhookJob :exec
+INSERT INTO webhook_jobs (
+    user_id, connection_id, message_id, kind, change_type
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+ON CONFLICT (connection_id, kind, change_type, message_id) DO UPDATE
+SET status = 'pending',
+    attempts = 0,
+    run_at = now(),
+    last_error = null,
+    updated_at = now()
+WHERE sqlc.arg(rearm)::boolean AND webhook_jobs.status IN ('done', 'dead');
+
+-- name: CancelWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'done',
+    updated_at = now()
+WHERE connection_id = $1 AND kind = $2 AND change_type = $3 AND message_id = $4 AND status = 'pending';
+
+-- name: IsMessageProcessed :one
+SELECT (EXISTS (
+    SELECT 1 FROM webhook_jobs j
+    WHERE j.connection_id = sqlc.arg(connection_id) AND j.kind = 'inbox' AND j.change_type = 'created' AND j.message_id = sqlc.arg(message_id)::text
+) OR EXISTS (
+    SELECT 1 FROM messages m WHERE m.user_id = sqlc.arg(user_id) AND m.message_id = sqlc.arg(message_id)::text
+) OR EXISTS (
+    SELECT 1 FROM tasks t WHERE t.connection_id = sqlc.arg(connection_id) AND t.message_id = sqlc.arg(message_id)::text
+) OR EXISTS (
+    SELECT 1 FROM spam_emails s WHERE s.connection_id = sqlc.arg(connection_id)
+        AND (s.message_id = sqlc.arg(message_id)::text OR s.inbox_message_id = sqlc.arg(message_id)::text)
+))::boolean AS processed;
+
+-- name: ClaimWebhookJob :one
+UPDATE webhook_jobs
+SET status = 'running',
+    attempts = attempts + 1,
+    locked_until = $1,
+    updated_at = now()
+WHERE id = (
+    SELECT id FROM webhook_jobs
+    WHERE (status = 'pending' AND run_at <= now())
+       OR (status = 'running' AND locked_until < now())
+    ORDER BY run_at
+    FOR UPDATE SKIP LOCKED
+    LIMIT 1
+)
+RETURNING *;
+
+-- name: CompleteWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'done',
+    locked_until = null,
+    updated_at = now()
+WHERE id = $1;
+
+-- name: RetryWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'pending',
+    run_at = $2,
+    last_error = $3,
+    locked_until = null,
+    updated_at = now()
+WHERE id = $1;
+
+-- name: FailWebhookJob :exec
+UPDATE webhook_jobs
+SET status = 'dead',
+    last_error = $2,
+    locked_until = null,
+    updated_at = now()
+WHERE id = $1;
+
+-- name: DeleteFinishedWebhookJobs :exec
+DELETE FROM webhook_jobs
+WHERE status = 'done' AND updated_at < $1;
 
--- name: DeleteSpamEmail :exec
-DELETE FROM spam_emails WHERE id = $1;
+-- name: UpsertSubscription :exec
+INSERT INTO subscriptions (
+    id, user_id, connection_id, kind, expire_at
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+ON CONFLICT (connection_id, kind) DO UPDATE
+SET id = excluded.id,
+    expire_at = excluded.expire_at,
+    created_at = CURRENT_TIMESTAMP,
+    renewed_at = null,
+    status = 'active',
+    last_error = null;
+
+-- name: GetSubscription :one
+SELECT * FROM subscriptions WHERE id = $1 LIMIT 1;
+
+-- name: ListSubscriptionsForUser :many
+SELECT * FROM subscriptions WHERE user_id = $1 ORDER BY kind;
+
+-- name: ListSubscriptionsForConnection :many
+SELECT * FROM subscriptions WHERE connection_id = $1 ORDER BY kind;
+
+-- name: RenewSubscription :exec
+UPDATE subscriptions
+SET expire_at = $2,
+    renewed_at = now(),
+    status = 'active',
+    last_error = null
+WHERE id = $1;
+
+-- name: UpdateSubscriptionStatus :exec
+UPDATE subscriptions
+SET status = $2,
+    last_error = $3
+WHERE id = $1;
+
+-- name: RecordSubscriptionLifecycleEvent :exec
+UPDATE subscriptions
+SET last_lifecycle_event = $2,
+    last_lifecycle_event_at = now()
+WHERE id = $1;
+
+-- name: DeleteSubscription :exec
+DELETE FROM subscriptions WHERE id = $1;
+
+-- name: GetTaskFromMessageID :one
+SELECT * FROM tasks
+WHERE connection_id = $1 AND message_id = $2 LIMIT 1;
+
+-- name: CreateTaskFromMessage :exec
+WITH task AS (
+    INSERT INTO tasks (
+        user_id, name, description, tool_definition, message_id, message_body, connection_id
+    ) VALUES (
+        sqlc.arg(user_id), sqlc.arg(name), sqlc.arg(description), sqlc.arg(tool_definition),
+        sqlc.arg(message_id), sqlc.arg(message_body), sqlc.arg(connection_id)
+    )
+    RETURNING tasks.id, tasks.message_id, tasks.user_id
+)
+INSERT INTO messages (message_id, task_id, content, user_id)
+SELECT task.message_id, task.id, sqlc.arg(content), task.user_id FROM task;
+
+-- name: AddTaskReply :execrows
+WITH notification AS (
+    INSERT INTO messages (message_id, task_id, content, user_id)
+    VALUES (sqlc.arg(message_id), sqlc.arg(task_id), sqlc.arg(content), sqlc.arg(user_id))
+    ON CONFLICT (message_id) DO NOTHING
+    RETURNING messages.task_id
+)
+UPDATE tasks SET state = sqlc.arg(state)
+FROM notification WHERE tasks.id = notification.task_id;
+
+-- name: ClearTaskMessageID :exec
+UPDATE tasks
+SET message_id = NULL
+WHERE id = $1;
+
+-- name: ClearMessagesMessageID :exec
+UPDATE messages
+SET message_id = NULL
+WHERE user_id = $1 AND message_id = $2;
+
+-- name: DeleteSpamEmailFromMessageID :exec
+DELETE FROM spam_emails WHERE connection_id = $1 AND message_id = $2;
+
+-- name: UpdateTaskMessageBody :exec
+UPDATE tasks
+set message_body = $2
+WHERE id = $1;
+
+-- name: CreateOAuthState :exec
+INSERT INTO oauth_states (
+    state, expire_at, provider, code_verifier, nonce, user_id
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+);
+
+-- name: DeleteExpiredOAuthStates :exec
+DELETE FROM oauth_states WHERE expire_at < now();
+
+-- name: ConsumeOAuthState :one
+DELETE FROM oauth_states WHERE state = $1 AND expire_at > now()
+RETURNING *;
+
+-- name: CreateSpamCheckSkip :exec
+INSERT INTO spam_check_skips (
+    user_id, message_id
+) VALUES (
+    $1, $2
+)
+ON CONFLICT DO NOTHING;
+
+-- name: IsSpamCheckSkipped :one
+SELECT EXISTS (
+    SELECT 1 FROM spam_check_skips WHERE user_id = $1 AND message_id = $2
+);
+
+-- name: DeleteSpamCheckSkipsBefore :exec
+DELETE FROM spam_check_skips WHERE created_at < $1;
+
+-- name: NotifyCloseConn :exec
+SELECT pg_notify('close_conn', sqlc.arg(task_id)::text);
+
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (
+    user_id, name, token_hash, scopes, expire_at
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+RETURNING *;
+
+-- name: ListAPITokensForUser :many
+SELECT * FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC;
+
+-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens WHERE id = $1 AND user_id = $2;
+
+-- name: GetAPITokenUser :one
+SELECT api_tokens.id AS token_id, api_tokens.scopes, users.id, users.name, users.email
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+WHERE api_tokens.token_hash = $1 AND api_tokens.expire_at > now();
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens SET last_used_at = now()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute');
+
+-- name: CreateSession :one
+INSERT INTO sessions (
+    user_id, refresh_token_hash, user_agent, expire_at
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING *;
 
+-- name: GetActiveSession :one
+SELECT * FROM sessions
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expire_at > now();
+
+-- name: ListActiveSessionsForUser :many
+SELECT * FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expire_at > now()
+ORDER BY created_at DESC;
+
+-- name: RotateSession :one
+UPDATE sessions
+SET previous_refresh_token_hash = refresh_token_hash,
+    refresh_token_hash = sqlc.arg(new_hash),
+    expire_at = sqlc.arg(expire_at),
+    refreshed_at = now()
+WHERE refresh_token_hash = sqlc.arg(old_hash) AND revoked_at IS NULL AND expire_at > now()
+RETURNING *;
+
+-- name: GetSessionByPreviousRefreshToken :one
+SELECT * FROM sessions WHERE previous_refresh_token_hash = sqlc.arg(hash)::text;
+
+-- name: RevokeSession :execrows
+UPDATE sessions SET revoked_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeSessionByRefreshToken :exec
+UPDATE sessions SET revoked_at = now()
+WHERE (refresh_token_hash = $1 OR previous_refresh_token_hash = $1) AND revoked_at IS NULL;
+
+-- name: RevokeSessionsForUser :exec
+UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions WHERE expire_at < now() - interval '7 days';
+
+-- name: ClearMailboxConnectionRefreshTokens :exec
+UPDATE mailbox_connections SET refresh_token = NULL WHERE user_id = $1;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities WHERE issuer = $1 AND subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (
+    issuer, subject, user_id, email, last_login_at
+) VALUES (
+    $1, $2, $3, $4, now()
+);
+
+-- name: CountUserIdentities :one
+SELECT COUNT(*) FROM user_identities WHERE user_id = $1;
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities SET last_login_at = now(), email = $3
+WHERE issuer = $1 AND subject = $2;
+
+-- name: CreateMailboxConnection :one
+INSERT INTO mailbox_connections (
+    user_id, provider, email, token, refresh_token, expire_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+ON CONFLICT (email) WHERE delegation_id IS NULL DO UPDATE
+SET provider = excluded.provider,
+    token = excluded.token,
+    refresh_token = excluded.refresh_token,
+    expire_at = excluded.expire_at
+WHERE mailbox_connections.user_id = excluded.user_id
+RETURNING *;
+
+-- name: GetMailboxConnection :one
+SELECT * FROM mailbox_connections WHERE id = $1;
+
+-- name: GetMailboxConnectionForUser :one
+SELECT * FROM mailbox_connections WHERE id = $1 AND user_id = $2;
+
+-- name: GetMailboxConnectionFromEmail :one
+-- The connection of the mailbox's owner, not one a delegate has for it.
+SELECT * FROM mailbox_connections WHERE email = $1 AND delegation_id IS NULL;
+
+-- name: GetDelegatedMailboxConnectionFromEmail :one
+SELECT * FROM mailbox_connections WHERE email = $1 AND delegation_id IS NOT NULL;
+
+-- name: HasOwnerMailboxConnection :one
+SELECT EXISTS (SELECT 1 FROM mailbox_connections WHERE email = $1 AND delegation_id IS NULL);
+
+-- name: GetDefaultMailboxConnection :one
+SELECT * FROM mailbox_connections WHERE user_id = $1 ORDER BY created_at, id LIMIT 1;
+
+-- name: GetDefaultGraphMailboxConnection :one
+SELECT * FROM mailbox_connections
+WHERE user_id = $1 AND provider = 'graph' AND delegation_id IS NULL
+ORDER BY created_at, id LIMIT 1;
+
+-- name: ListMailboxConnections :many
+SELECT * FROM mailbox_connections ORDER BY created_at, id;
+
+-- name: ListMailboxConnectionsForUser :many
+SELECT * FROM mailbox_connections WHERE user_id = $1 ORDER BY created_at, id;
+
+-- name: UpdateMailboxConnectionSettings :one
+UPDATE mailbox_connections
+SET subscription_disabled = $3,
+    check_spam = $4
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: UpdateMailboxConnectionToken :exec
+UPDATE mailbox_connections
+SET token = $2,
+    refresh_token = $3,
+    expire_at = $4
+WHERE id = $1;
+
+-- name: UpdateMailboxConnectionSubscriptionClientState :exec
+UPDATE mailbox_connections
+SET subscription_client_state = $2
+WHERE id = $1;
+
+-- name: UpdateMailboxConnectionInboxDeltaToken :exec
+UPDATE mailbox_connections
+SET inbox_delta_token = $2
+WHERE id = $1;
+
+-- name: ListMailboxConnectionRawTokens :many
+SELECT id, token::text AS raw_token, COALESCE(refresh_token, '')::text AS raw_refresh_token FROM mailbox_connections;
+
+-- name: UpdateMailboxConnectionTokens :exec
+UPDATE mailbox_connections
+SET token = $2,
+    refresh_token = $3
+WHERE id = $1;
+
+-- name: DeleteMailboxConnection :execrows
+DELETE FROM mailbox_connections WHERE id = $1 AND user_id = $2;
+
+-- name: AssignTask :execrows
+UPDATE tasks
+SET assignee_id = $3,
+    organization_id = $4
+WHERE id = $1 AND user_id = $2;
+
+-- name: CreateOrganization :one
+INSERT INTO organizations (name) VALUES ($1) RETURNING *;
+
+-- name: GetOrganizationForMember :one
+SELECT sqlc.embed(organizations), organization_members.role FROM organizations
+JOIN organization_members ON organization_members.organization_id = organizations.id
+WHERE organizations.id = $1 AND organization_members.user_id = $2;
+
+-- name: ListOrganizationsForUser :many
+SELECT sqlc.embed(organizations), organization_members.role FROM organizations
+JOIN organization_members ON organization_members.organization_id = organizations.id
+WHERE organization_members.user_id = $1
+ORDER BY organizations.created_at, organizations.id;
+
+-- name: UpdateOrganization :one
+UPDATE organizations SET name = $2 WHERE id = $1 RETURNING *;
+
+-- name: DeleteOrganization :execrows
+DELETE FROM organizations WHERE id = $1;
+
+-- name: UpsertOrganizationMember :one
+INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)
+ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role
+RETURNING *;
+
+-- name: GetOrganizationMember :one
+SELECT * FROM organization_members WHERE organization_id = $1 AND user_id = $2;
+
+-- name: ListOrganizationMembers :many
+SELECT sqlc.embed(organization_members), users.name, users.email FROM organization_members
+JOIN users ON users.id = organization_members.user_id
+WHERE organization_members.organization_id = $1
+ORDER BY organization_members.created_at, users.email;
+
+-- name: CountOrganizationOwners :one
+SELECT count(*) FROM organization_members WHERE organization_id = $1 AND role = 'owner';
+
+-- name: DeleteOrganizationMember :execrows
+DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2;
+
+-- name: UpsertOrganizationInvitation :one
+INSERT INTO organization_invitations (organization_id, user_id, role, invited_by) VALUES ($1, $2, $3, $4)
+ON CONFLICT (organization_id, user_id) DO UPDATE
+SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, created_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: ListOrganizationInvitations :many
+SELECT sqlc.embed(organization_invitations), organizations.name AS organization_name, users.name, users.email,
+       COALESCE(inviters.name, '') AS inviter_name
+FROM organization_invitations
+JOIN organizations ON organizations.id = organization_invitations.organization_id
+JOIN users ON users.id = organization_invitations.user_id
+LEFT JOIN users inviters ON inviters.id = organization_invitations.invited_by
+WHERE organization_invitations.organization_id = $1
+ORDER BY organization_invitations.created_at, users.email;
+
+-- name: ListOrganizationInvitationsForUser :many
+SELECT sqlc.embed(organization_invitations), organizations.name AS organization_name, users.name, users.email,
+       COALESCE(inviters.name, '') AS inviter_name
+FROM organization_invitations
+JOIN organizations ON organizations.id = organization_invitations.organization_id
+JOIN users ON users.id = organization_invitations.user_id
+LEFT JOIN users inviters ON inviters.id = organization_invitations.invited_by
+WHERE organization_invitations.user_id = $1
+ORDER BY organization_invitations.created_at DESC, organization_invitations.id;
+
+-- name: DeleteOrganizationInvitation :execrows
+DELETE FROM organization_invitations WHERE id = $1 AND organization_id = $2;
+
+-- name: DeleteOrganizationInvitationForUser :execrows
+DELETE FROM organization_invitations WHERE id = $1 AND user_id = $2;
+
+-- name: AcceptOrganizationInvitation :one
+-- The invited user becomes a member with the role of the invitation, which is used up.
+WITH invitation AS (
+    DELETE FROM organization_invitations
+    WHERE organization_invitations.id = sqlc.arg(id) AND organization_invitations.user_id = sqlc.arg(user_id)
+    RETURNING organization_invitations.organization_id, organization_invitations.user_id, organization_invitations.role
+)
+INSERT INTO organization_members (organization_id, user_id, role)
+SELECT invitation.organization_id, invitation.user_id, invitation.role FROM invitation
+ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role
+RETURNING *;
+
+-- name: ClearTaskAssignmentsForMember :exec
+UPDATE tasks
+SET assignee_id = NULL,
+    organization_id = NULL
+WHERE organization_id = $1 AND (user_id = $2 OR assignee_id = $2);
+
+-- name: UnshareContextsOfMember :exec
+UPDATE contexts SET organization_id = NULL WHERE organization_id = $1 AND user_id = $2;
+
+-- name: UpsertDelegationGrant :one
+INSERT INTO delegation_grants (principal_id, delegate_id, mailbox, permissions) VALUES ($1, $2, $3, $4)
+ON CONFLICT (principal_id, delegate_id, mailbox) DO UPDATE SET permissions = EXCLUDED.permissions
+RETURNING *;
+
+-- name: GetDelegationGrant :one
+SELECT sqlc.embed(delegation_grants), principals.name AS principal_name, delegates.name AS delegate_name,
+       delegates.email AS delegate_email, mailbox_connections.id AS mailbox_id
+FROM delegation_grants
+JOIN users principals ON principals.id = delegation_grants.principal_id
+JOIN users delegates ON delegates.id = delegation_grants.delegate_id
+LEFT JOIN mailbox_connections ON mailbox_connections.delegation_id = delegation_grants.id
+WHERE delegation_grants.id = $1
+  AND (delegation_grants.principal_id = sqlc.arg(user_id) OR delegation_grants.delegate_id = sqlc.arg(user_id));
+
+-- name: ListDelegationGrantsForUser :many
+SELECT sqlc.embed(delegation_grants), principals.name AS principal_name, delegates.name AS delegate_name,
+       delegates.email AS delegate_email, mailbox_connections.id AS mailbox_id
+FROM delegation_grants
+JOIN users principals ON principals.id = delegation_grants.principal_id
+JOIN users delegates ON delegates.id = delegation_grants.delegate_id
+LEFT JOIN mailbox_connections ON mailbox_connections.delegation_id = delegation_grants.id
+WHERE delegation_grants.principal_id = sqlc.arg(user_id) OR delegation_grants.delegate_id = sqlc.arg(user_id)
+ORDER BY delegation_grants.created_at, delegation_grants.id;
+
+-- name: DeleteDelegationGrant :execrows
+DELETE FROM delegation_grants WHERE id = $1 AND (principal_id = sqlc.arg(user_id) OR delegate_id = sqlc.arg(user_id));
+
+-- name: CreateDelegatedMailboxConnection :one
+INSERT INTO mailbox_connections (
+    user_id, provider, email, token, expire_at, delegation_id, credential_id, delegated_permissions
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8
+)
+ON CONFLICT (email) WHERE delegation_id IS NOT NULL DO UPDATE
+SET token = excluded.token,
+    expire_at = excluded.expire_at,
+    credential_id = excluded.credential_id,
+    delegated_permissions = excluded.delegated_permissions
+WHERE mailbox_connections.delegation_id = excluded.delegation_id
+RETURNING *;
+
+-- name: UpdateDelegatedMailboxConnectionTokens :exec
+UPDATE mailbox_connections
+SET token = $2,
+    expire_at = $3
+WHERE credential_id = $1;
+
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (user_id, actor, task_id, mailbox, tool, arguments, response_ids, status, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: ListAuditEvents :many
+-- A user sees the actions taken for them, those taken on their own mailboxes, such as by a delegate, and those of
+-- the runs of their tasks.
+SELECT * FROM audit_events
+WHERE (audit_events.user_id = sqlc.arg(user_id)
+       OR audit_events.mailbox IN (
+           SELECT users.email FROM users WHERE users.id = sqlc.arg(user_id)
+           UNION
+           SELECT mailbox_connections.email FROM mailbox_connections
+           WHERE mailbox_connections.user_id = sqlc.arg(user_id) AND mailbox_connections.delegation_id IS NULL)
+       OR audit_events.task_id IN (SELECT tasks.id FROM tasks WHERE tasks.user_id = sqlc.arg(user_id)))
+  AND (sqlc.narg(task_id)::uuid IS NULL OR audit_events.task_id = sqlc.narg(task_id))
+  AND (sqlc.narg(tool)::text IS NULL OR audit_events.tool = sqlc.narg(tool))
+  AND (sqlc.narg(actor)::text IS NULL OR audit_events.actor = sqlc.narg(actor))
+  AND (sqlc.narg(mailbox)::text IS NULL OR audit_events.mailbox = sqlc.narg(mailbox))
+  AND (sqlc.narg(since)::timestamptz IS NULL OR audit_events.created_at >= sqlc.narg(since))
+  AND (sqlc.narg(until)::timestamptz IS NULL OR audit_events.created_at < sqlc.narg(until))
+ORDER BY audit_events.created_at DESC, audit_events.id
+LIMIT sqlc.arg(max_events);
+
+-- name: CreatePendingAction :one
+INSERT INTO pending_actions (user_id, task_id, connection_id, tool, arguments, link_token_hash)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetPendingAction :one
+SELECT * FROM pending_actions WHERE id = $1 AND user_id = $2;
+
+-- name: GetPendingActionByLinkToken :one
+SELECT * FROM pending_actions WHERE id = $1 AND link_token_hash = $2;
+
+-- name: ListPendingActions :many
+SELECT * FROM pending_actions
+WHERE user_id = sqlc.arg(user_id)
+  AND (sqlc.narg(task_id)::uuid IS NULL OR task_id = sqlc.narg(task_id))
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+ORDER BY created_at DESC, id;
+
+-- name: ListPendingActionsForTask :many
+SELECT * FROM pending_actions WHERE task_id = $1 AND status = 'pending' ORDER BY created_at, id;
+
+-- name: DecidePendingAction :one
+-- Only a pending action can be decided, so concurrent decisions run it once.
+UPDATE pending_actions
+SET status = sqlc.arg(status),
+    arguments = COALESCE(sqlc.narg(arguments), arguments),
+    decided_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND status = 'pending'
+RETURNING *;
+
+-- name: CompletePendingAction :one
+UPDATE pending_actions
+SET status = $2,
+    result = $3,
+    error = $4
+WHERE id = $1
+RETURNING *;
+
+-- name: ReportPendingActions :many
+-- Marks the decided actions of the task as passed on to the assistant and returns them.
+UPDATE pending_actions
+SET reported = true
+WHERE task_id = $1 AND status IN ('succeeded', 'failed', 'rejected') AND NOT reported
+RETURNING *;
+
+-- name: ReportPendingAction :exec
+-- Marks the decided action as passed on to the assistant by the run that waited for it.
+UPDATE pending_actions
+SET reported = true
+WHERE id = $1;
+
+-- name: NotifyPendingActionDecided :exec
+SELECT pg_notify('pending_action_decided', sqlc.arg(task_id)::text);
+
+-- name: SetTaskConversationIDIfUnset :exec
+UPDATE tasks
+SET conversation_id = $2
+WHERE id = $1 AND conversation_id IS NULL;
+
+-- name: CreateTaskDraft :one
+INSERT INTO task_drafts (user_id, task_id, connection_id, message_id, conversation_id, web_link, subject, recipients)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: ListTaskDrafts :many
+SELECT * FROM task_drafts WHERE task_id = $1 ORDER BY created_at, id;
+
+-- name: GetTaskDraft :one
+SELECT * FROM task_drafts WHERE id = $1 AND task_id = $2;
+
+-- name: UpdateTaskDraft :one
+UPDATE task_drafts
+SET subject = $2,
+    recipients = $3,
+    web_link = COALESCE(sqlc.narg(web_link), web_link),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND status = 'draft'
+RETURNING *;
+
+-- name: MarkTaskDraftSent :one
+-- Only a draft can be marked sent, so concurrent sends of the same draft send it once.
+UPDATE task_drafts
+SET status = 'sent',
+    sent_at = CURRENT_TIMESTAMP,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND status = 'draft'
+RETURNING *;
+
+-- name: RevertTaskDraftSent :exec
+UPDATE task_drafts
+SET status = 'draft',
+    sent_at = NULL
+WHERE id = $1;
 
+-- name: DeleteTaskDraft :exec
+DELETE FROM task_drafts WHERE id = $1;
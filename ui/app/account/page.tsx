@@ -6,63 +6,59 @@ import Avatar from '@mui/material/Avatar';
 import Card from '@mui/material/Card';
 import CardContent from '@mui/material/CardContent';
 
+import { Mailbox } from '@/types/mailbox';
 import { User } from '@/types/user';
 import { getUser } from '@/utils/getUser';
 import { useEffect, useState } from 'react';
 import Box from '@mui/material/Box';
 import Tooltip from '@mui/material/Tooltip';
-import { Alert, Snackbar, Switch } from '@mui/material';
+import { Alert, Button, Snackbar, Switch } from '@mui/material';
 
 const Account: React.FC = () => {
     const [open, setOpen] = useState(false);
     const [user, setUser] = useState<User | null>(null);
-    const [subscriptionEnabled, setSubscriptionEnabled] = useState(false);
-    const [checkSpam, setCheckSpam] = useState(false);
+    const [mailboxes, setMailboxes] = useState<Mailbox[]>([]);
 
     useEffect(() => {
         getUser()
             .then((user) => {
                 setUser(user);
-                setSubscriptionEnabled(!user.subscriptionDisabled);
-                setCheckSpam(user.checkSpam ?? false);
+                setMailboxes(user.mailboxes ?? []);
             })
             .catch();
     }, []);
 
-    const handleToggleSubscription = async (
-        event: React.ChangeEvent<HTMLInputElement>,
-        checked: boolean
+    const updateMailbox = async (
+        mailbox: Mailbox,
+        settings: { subscriptionDisabled?: boolean; checkSpam?: boolean }
     ) => {
-        const response = await fetch('/api/me', {
+        const response = await fetch(`/api/mailboxes/${mailbox.ID}`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
             },
-            body: JSON.stringify({
-                subscriptionDisabled: !checked,
-                checkSpam: checkSpam,
-            }),
+            body: JSON.stringify(settings),
         });
-        setSubscriptionEnabled(checked);
+        if (!response.ok) {
+            return;
+        }
+        const updated: Mailbox = await response.json();
+        setMailboxes((mailboxes) =>
+            mailboxes.map((m) => (m.ID === updated.ID ? updated : m))
+        );
         setOpen(true);
     };
 
-    const handleToggleCheckSpam = async (
-        event: React.ChangeEvent<HTMLInputElement>,
-        checked: boolean
-    ) => {
-        const response = await fetch('/api/me', {
-            method: 'POST',
-            headers: {
-                'Content-Type': 'application/json',
-            },
-            body: JSON.stringify({
-                subscriptionDisabled: !subscriptionEnabled,
-                checkSpam: checked,
-            }),
+    const disconnectMailbox = async (mailbox: Mailbox) => {
+        const response = await fetch(`/api/mailboxes/${mailbox.ID}`, {
+            method: 'DELETE',
         });
-        setCheckSpam(checked);
-        setOpen(true);
+        if (!response.ok) {
+            return;
+        }
+        setMailboxes((mailboxes) =>
+            mailboxes.filter((m) => m.ID !== mailbox.ID)
+        );
     };
 
     const handleClose = (
@@ -127,46 +123,97 @@ const Account: React.FC = () => {
                                         >
                                             {user?.email}
                                         </Typography>
-                                        <Tooltip title="Disabling will stop watching all your messages from inbox">
+                                        {mailboxes.map((mailbox) => (
                                             <Stack
-                                                direction="row"
-                                                alignItems="center"
-                                                justifyContent="space-between"
+                                                key={mailbox.ID}
+                                                spacing={1}
                                                 width="100%"
                                             >
-                                                <Typography variant="h6">
-                                                    Subscription Enabled
-                                                </Typography>
-                                                <Switch
-                                                    checked={
-                                                        subscriptionEnabled
-                                                    }
-                                                    onChange={
-                                                        handleToggleSubscription
-                                                    }
-                                                    color="primary"
-                                                />
+                                                <Stack
+                                                    direction="row"
+                                                    alignItems="center"
+                                                    justifyContent="space-between"
+                                                >
+                                                    <Typography variant="h6">
+                                                        {mailbox.Email}
+                                                    </Typography>
+                                                    <Button
+                                                        color="error"
+                                                        onClick={() =>
+                                                            disconnectMailbox(
+                                                                mailbox
+                                                            )
+                                                        }
+                                                    >
+                                                        Disconnect
+                                                    </Button>
+                                                </Stack>
+                                                <Tooltip title="Disabling will stop watching all messages from the inbox of this mailbox">
+                                                    <Stack
+                                                        direction="row"
+                                                        alignItems="center"
+                                                        justifyContent="space-between"
+                                                    >
+                                                        <Typography variant="body1">
+                                                            Subscription Enabled
+                                                        </Typography>
+                                                        <Switch
+                                                            checked={
+                                                                !mailbox.SubscriptionDisabled
+                                                            }
+                                                            onChange={(
+                                                                event,
+                                                                checked
+                                                            ) =>
+                                                                updateMailbox(
+                                                                    mailbox,
+                                                                    {
+                                                                        subscriptionDisabled:
+                                                                            !checked,
+                                                                    }
+                                                                )
+                                                            }
+                                                            color="primary"
+                                                        />
+                                                    </Stack>
+                                                </Tooltip>
+                                                <Tooltip title="Enable cold email dectection and move it to SPAM">
+                                                    <Stack
+                                                        direction="row"
+                                                        alignItems="center"
+                                                        justifyContent="space-between"
+                                                    >
+                                                        <Typography variant="body1">
+                                                            Cold Email Detection
+                                                        </Typography>
+                                                        <Switch
+                                                            checked={
+                                                                mailbox.CheckSpam
+                                                            }
+                                                            onChange={(
+                                                                event,
+                                                                checked
+                                                            ) =>
+                                                                updateMailbox(
+                                                                    mailbox,
+                                                                    {
+                                                                        checkSpam:
+                                                                            checked,
+                                                                    }
+                                                                )
+                                                            }
+                                                            color="primary"
+                                                        />
+                                                    </Stack>
+                                                </Tooltip>
                                             </Stack>
-                                        </Tooltip>
-                                        <Tooltip title="Enable cold email dectection and move it to SPAM">
-                                            <Stack
-                                                direction="row"
-                                                alignItems="center"
-                                                justifyContent="space-between"
-                                                width="100%"
-                                            >
-                                                <Typography variant="h6">
-                                                    Cold Email Detection
-                                                </Typography>
-                                                <Switch
-                                                    checked={checkSpam}
-                                                    onChange={
-                                                        handleToggleCheckSpam
-                                                    }
-                                                    color="primary"
-                                                />
-                                            </Stack>
-                                        </Tooltip>
+                                        ))}
+                                        <Button
+                                            variant="outlined"
+                                            href="/api/mailboxes/microsoft"
+                                        >
+                                            Connect Microsoft 365 mailbox
+                                        </Button>
                                     </Stack>
                                 </Stack>
                             </CardContent>
@@ -1,6 +1,6 @@
 'use client';
 
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import Grid from '@mui/material/Grid';
 import Box from '@mui/material/Box';
 import { useTheme } from '@mui/material/styles';
@@ -15,7 +15,24 @@ import Image from 'next/image';
 import AuthWrapper from '@/components/auth/AuthWrapper';
 import ThemeCustomization from '@/themes';
 
+type LoginProvider = {
+    ID: string;
+    Name: string;
+    LoginURL: string;
+};
+
 const Page = () => {
+    const [providers, setProviders] = useState<LoginProvider[]>([]);
+
+    useEffect(() => {
+        fetch('/api/login/providers')
+            .then((res) => (res.ok ? res.json() : []))
+            .then((data: LoginProvider[]) =>
+                setProviders(data.filter((p) => p.ID !== 'microsoft'))
+            )
+            .catch(() => setProviders([]));
+    }, []);
+
     const handleSignIn = () => {
         window.location.href = '/api/login';
     };
@@ -72,6 +89,18 @@ const Page = () => {
                             >
                                 {'Microsoft'}
                             </Button>
+                            {providers.map((p) => (
+                                <Button
+                                    key={p.ID}
+                                    variant="outlined"
+                                    color="secondary"
+                                    onClick={() => {
+                                        window.location.href = p.LoginURL;
+                                    }}
+                                >
+                                    {p.Name}
+                                </Button>
+                            ))}
                         </Stack>
                     </Grid>
                 </Grid>
@@ -9,6 +9,8 @@ import { CallFrame } from '@gptscript-ai/gptscript';
 import { Note } from '@phosphor-icons/react/dist/ssr/Note';
 import { Task } from '@/types/task';
 import Tooltip from '@mui/material/Tooltip';
+import Approval from '@/components/message/approval';
+import { PendingAction } from '@/types/approval';
 
 interface TaskFormModalProps {
     id: string;
@@ -44,6 +46,9 @@ export const Run: React.FC<TaskFormModalProps> = ({ id }) => {
     const [userMessages, setUserMessages] = useState<string[]>([]);
     const [messageIndex, setMessageIndex] = useState(-1);
     const [rows, setRows] = useState(1);
+    const [approvals, setApprovals] = useState<Record<string, PendingAction>>(
+        {}
+    );
 
     const scrollToBottom = () => {
         if (messagesEndRef.current) {
@@ -249,6 +254,11 @@ export const Run: React.FC<TaskFormModalProps> = ({ id }) => {
 
             s.onmessage = (m) => {
                 let data = JSON.parse(m.data);
+                if (data.approval) {
+                    const action: PendingAction = data.approval;
+                    setApprovals((prev) => ({ ...prev, [action.ID]: action }));
+                    return;
+                }
                 handleProgress({
                     frame: data.frame,
                     state: data.state,
@@ -374,6 +384,19 @@ export const Run: React.FC<TaskFormModalProps> = ({ id }) => {
                             }}
                         >
                             <Messages messages={messages} />
+                            {Object.values(approvals).map((action) => (
+                                <Approval
+                                    key={action.ID}
+                                    action={action}
+                                    onDecided={(decided) =>
+                                        setApprovals((prev) => {
+                                            const next = { ...prev };
+                                            delete next[decided.ID];
+                                            return next;
+                                        })
+                                    }
+                                />
+                            ))}
                             <div ref={messagesEndRef} />
                         </Card>
                         <Box
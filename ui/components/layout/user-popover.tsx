@@ -27,6 +27,13 @@ export function UserPopover({
     const router = useRouter();
 
     const handleSignOut = React.useCallback(async (): Promise<void> => {
+        await fetch('/api/logout', { method: 'POST' });
+        document.cookie = `jwt-token=; Max-Age=0; path=/; domain=${window.location.hostname}; expires=${new Date(0).toUTCString()};`;
+        router.push('/signout');
+    }, [router]);
+
+    const handleSignOutEverywhere = React.useCallback(async (): Promise<void> => {
+        await fetch('/api/logout/all', { method: 'POST' });
         document.cookie = `jwt-token=; Max-Age=0; path=/; domain=${window.location.hostname}; expires=${new Date(0).toUTCString()};`;
         router.push('/signout');
     }, [router]);
@@ -56,6 +63,12 @@ export function UserPopover({
                     </ListItemIcon>
                     Sign out
                 </MenuItem>
+                <MenuItem onClick={handleSignOutEverywhere}>
+                    <ListItemIcon>
+                        <SignOutIcon fontSize="var(--icon-fontSize-md)" />
+                    </ListItemIcon>
+                    Sign out of all devices
+                </MenuItem>
             </MenuList>
         </Popover>
     );
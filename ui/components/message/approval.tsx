@@ -0,0 +1,179 @@
+import React, { useState } from 'react';
+import { Box, Button, TextField, Typography } from '@mui/material';
+import Stack from '@mui/material/Stack';
+import Card from '@mui/material/Card';
+import { ApprovalEmail, ApprovalEvent, PendingAction } from '@/types/approval';
+
+const splitList = (s: string): string[] =>
+    s
+        .split(',')
+        .map((part) => part.trim())
+        .filter((part) => part !== '');
+
+// Approval shows an email or event the assistant wants to send or create, and
+// lets the user approve, edit or reject it.
+const Approval = ({
+    action,
+    onDecided,
+}: {
+    action: PendingAction;
+    onDecided: (action: PendingAction) => void;
+}) => {
+    const isEmail = action.Tool === 'send-email';
+    const email = action.Arguments as ApprovalEmail;
+    const event = action.Arguments as ApprovalEvent;
+    const [editing, setEditing] = useState(false);
+    const [subject, setSubject] = useState(action.Arguments.subject);
+    const [body, setBody] = useState(isEmail ? email.body : event.content);
+    const [people, setPeople] = useState(
+        (isEmail ? email.to : event.attendees).join(', ')
+    );
+    const [start, setStart] = useState(isEmail ? '' : event.start);
+    const [end, setEnd] = useState(isEmail ? '' : event.end);
+    const [error, setError] = useState('');
+    const [deciding, setDeciding] = useState(false);
+
+    const decide = async (decision: 'approve' | 'edit' | 'reject') => {
+        const request: Record<string, unknown> = { decision };
+        if (decision === 'edit') {
+            if (isEmail) {
+                request.email = {
+                    ...email,
+                    subject,
+                    body,
+                    to: splitList(people),
+                };
+            } else {
+                request.event = {
+                    ...event,
+                    subject,
+                    content: body,
+                    start,
+                    end,
+                    attendees: splitList(people),
+                };
+            }
+        }
+        setDeciding(true);
+        try {
+            const response = await fetch(`/api/approvals/${action.ID}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(request),
+            });
+            const result = await response.json();
+            if (!response.ok) {
+                setError(result.message ?? 'Failed to decide');
+                return;
+            }
+            onDecided(result);
+        } finally {
+            setDeciding(false);
+        }
+    };
+
+    return (
+        <Card sx={{ m: 2, p: 2 }}>
+            <Stack spacing={1}>
+                <Typography variant="h6">
+                    {isEmail
+                        ? 'Approve sending this email?'
+                        : 'Approve creating this event?'}
+                </Typography>
+                {editing ? (
+                    <>
+                        <TextField
+                            label={isEmail ? 'To' : 'Attendees'}
+                            value={people}
+                            onChange={(e) => setPeople(e.target.value)}
+                        />
+                        <TextField
+                            label="Subject"
+                            value={subject}
+                            onChange={(e) => setSubject(e.target.value)}
+                        />
+                        {!isEmail && (
+                            <Stack direction="row" spacing={1}>
+                                <TextField
+                                    label="Start"
+                                    value={start}
+                                    onChange={(e) => setStart(e.target.value)}
+                                />
+                                <TextField
+                                    label="End"
+                                    value={end}
+                                    onChange={(e) => setEnd(e.target.value)}
+                                />
+                            </Stack>
+                        )}
+                        <TextField
+                            label={isEmail ? 'Body' : 'Content'}
+                            multiline
+                            minRows={4}
+                            value={body}
+                            onChange={(e) => setBody(e.target.value)}
+                        />
+                    </>
+                ) : (
+                    <Box>
+                        <Typography variant="body2">
+                            <b>{isEmail ? 'To' : 'Attendees'}:</b> {people}
+                        </Typography>
+                        {isEmail && email.cc && email.cc.length > 0 && (
+                            <Typography variant="body2">
+                                <b>Cc:</b> {email.cc.join(', ')}
+                            </Typography>
+                        )}
+                        <Typography variant="body2">
+                            <b>Subject:</b> {subject}
+                        </Typography>
+                        {!isEmail && (
+                            <Typography variant="body2">
+                                <b>When:</b> {start} to {end} ({event.timeZone})
+                            </Typography>
+                        )}
+                        <Typography
+                            variant="body2"
+                            sx={{ whiteSpace: 'pre-wrap', mt: 1 }}
+                        >
+                            {body}
+                        </Typography>
+                    </Box>
+                )}
+                {error && (
+                    <Typography variant="body2" color="error">
+                        {error}
+                    </Typography>
+                )}
+                <Stack direction="row" spacing={1}>
+                    <Button
+                        variant="contained"
+                        disabled={deciding}
+                        onClick={() => decide(editing ? 'edit' : 'approve')}
+                    >
+                        {editing ? 'Approve edited' : 'Approve'}
+                    </Button>
+                    {!editing && (
+                        <Button
+                            variant="outlined"
+                            disabled={deciding}
+                            onClick={() => setEditing(true)}
+                        >
+                            Edit
+                        </Button>
+                    )}
+                    <Button
+                        variant="outlined"
+                        color="error"
+                        disabled={deciding}
+                        onClick={() => decide('reject')}
+                    >
+                        Reject
+                    </Button>
+                </Stack>
+            </Stack>
+        </Card>
+    );
+};
+
+export default Approval;
@@ -0,0 +1,29 @@
+export interface ApprovalEmail {
+    subject: string;
+    body: string;
+    to: string[];
+    cc: string[] | null;
+    bcc: string[] | null;
+}
+
+export interface ApprovalEvent {
+    subject: string;
+    content: string;
+    start: string;
+    end: string;
+    timeZone: string;
+    attendees: string[];
+}
+
+export interface PendingAction {
+    ID: string;
+    TaskID: string;
+    MailboxID: string;
+    Tool: 'send-email' | 'schedule';
+    Arguments: ApprovalEmail | ApprovalEvent;
+    Status: 'pending' | 'approved' | 'succeeded' | 'failed' | 'rejected';
+    Result: Record<string, string> | null;
+    Error: string | null;
+    CreatedAt: string | null;
+    DecidedAt: string | null;
+}
@@ -0,0 +1,9 @@
+export interface Mailbox {
+    ID: string;
+    Provider: string;
+    Email: string;
+    SubscriptionDisabled: boolean;
+    CheckSpam: boolean;
+    DelegationID: string | null;
+    Permissions: string[] | null;
+}
@@ -1,5 +1,6 @@
 import { ReactNode } from 'react';
 import { CallFrame } from '@gptscript-ai/gptscript';
+import { PendingAction } from '@/types/approval';
 
 export enum MessageType {
     User,
@@ -23,4 +24,5 @@ export interface Message {
     CreatedAt: Date;
     Content: string;
     Read: boolean;
+    Approval?: PendingAction;
 }
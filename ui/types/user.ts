@@ -1,10 +1,11 @@
+import { Mailbox } from '@/types/mailbox';
+
 export interface User {
     id: string;
     name?: string;
     avatar?: string;
     email?: string;
-    subscriptionDisabled?: boolean;
-    checkSpam?: boolean;
+    mailboxes?: Mailbox[];
 
     [key: string]: unknown;
 }